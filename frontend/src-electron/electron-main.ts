@@ -58,7 +58,9 @@ initialize();
 let sseClient;
 function sseClientInit() {
   if (sdkIsRun) {
-    sseClient = new EventSource(`http://127.0.0.1:${backendPort}/stream`, { withCredentials: false });
+    sseClient = new EventSource(`http://127.0.0.1:${backendPort}/stream?token=${backendToken}`, {
+      withCredentials: false,
+    });
     sseClient.addEventListener(
       'message',
       function (e) {
@@ -120,6 +122,16 @@ if (!fs.existsSync(logsDir)) {
 
 import cp from 'child_process';
 
+// 渲染进程页面的来源, 通过环境变量 KEYTONE_ALLOWED_ORIGINS 传给 SDK, 使其 CORS 仅放行本应用的页面。
+// * dev 模式下为 quasar 开发服务器(如 http://localhost:9300); 打包后以 file:// 协议加载。
+function rendererOrigin(): string {
+  const appUrl = process.env.APP_URL || '';
+  if (appUrl.startsWith('http://') || appUrl.startsWith('https://')) {
+    return new URL(appUrl).origin;
+  }
+  return 'file://';
+}
+
 function runChildProcess(
   command: string,
   parameter: Array<string>,
@@ -134,7 +146,7 @@ function runChildProcess(
     detached: false,
     stdio: ['pipe', 'pipe', 'pipe'],
     // 使用 { ...process.env, 新变量: '值' } 确保子进程继承父进程的所有环境变量，并添加或覆盖特定变量。
-    env: { ...process.env, ...customEnvVar },
+    env: { ...process.env, KEYTONE_ALLOWED_ORIGINS: rendererOrigin(), ...customEnvVar },
   });
   // 监听子进程的 stdout
   sdkProcess.stdout.on('data', (data) => {
@@ -145,11 +157,18 @@ function runChildProcess(
       // TIPS: 注意这里使用的match()的返回值是一个数组或null, 如果匹配成功则返回一个数组
       // * [0]: 完整的匹配文本
       // * [1]: 第一个捕获组的内容
+      // SDK 会先输出 KEYTONE_TOKEN=, 再输出 KEYTONE_PORT=, 因此在获取到端口时令牌已就绪
+      const tokenMatch = line.match(/KEYTONE_TOKEN=([0-9a-f]+)/);
+      if (tokenMatch) {
+        backendToken = tokenMatch[1];
+        return; // 令牌不输出到终端日志
+      }
+
       const portMatch = line.match(/KEYTONE_PORT=(\d+)/);
       if (portMatch) {
         backendPort = parseInt(portMatch[1], 10);
         // 这里用于更新axios中的port。(由于electron主进程不受quasar或者说前端渲染进程boot函数逻辑内的端口修改影响, 因此这里需要自行修改用于electron主进程的端口号)
-        UpdateApi(backendPort); // 目前只有这里有可能造成api的端口变更, 因此对于node端仅在此处更新即可。
+        UpdateApi(backendPort, backendToken); // 目前只有这里有可能造成api的端口变更, 因此对于node端仅在此处更新即可。
         process.stdout.write(`[SDK] Using port: ${backendPort}\n`);
         sdkIsRun = true;
       }
@@ -751,3 +770,11 @@ let backendPort = 38888;
 ipcMain.on('get-backend-port', (event) => {
   event.returnValue = backendPort;
 });
+
+// 存储后端本次启动生成的 API 令牌(除 /ping 外的所有请求都需携带)
+let backendToken = '';
+
+// 处理获取令牌的IPC请求
+ipcMain.on('get-backend-token', (event) => {
+  event.returnValue = backendToken;
+});
@@ -67,6 +67,9 @@ contextBridge.exposeInMainWorld('myWindowAPI', {
   // 添加获取后端端口的方法
   getBackendPort: () => ipcRenderer.sendSync('get-backend-port'),
 
+  // 获取后端 API 令牌(SDK 每次启动时随机生成)
+  getBackendToken: () => ipcRenderer.sendSync('get-backend-token'),
+
   // 获取MacOS状态
   getMacOSStatus() {
     return process.platform === 'darwin' || os.platform() === 'darwin';
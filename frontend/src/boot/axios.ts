@@ -12,30 +12,42 @@ declare module 'vue' {
 }
 
 let port = 38888;
+// SDK 每次启动生成的 API 令牌(由 SDK 输出 KEYTONE_TOKEN=, 经 electron 主进程获取), 除 /ping 外的所有请求都需携带
+let token = '';
 // Be careful when using SSR for cross-request state pollution
 // due to creating a Singleton instance here;
 // If any client changes this (global) instance, it might be a
 // good idea to move this instance creation inside of the
 // "export default () => {}" function below (which runs individually
 // for each client)
+const createApi = () =>
+  axios.create({
+    baseURL: `http://127.0.0.1:${port}`,
+    headers: token ? { Authorization: `Bearer ${token}` } : {},
+  });
+
 // 初始化api实例(默认以端口38888为基准(前后端统一))
-let api: AxiosInstance = axios.create({ baseURL: `http://127.0.0.1:${port}` });
+let api: AxiosInstance = createApi();
 
-// 用于更新api实例(当端口发生变化时)
-export const UpdateApi = (newPort: number) => {
-  if (newPort !== port) {
+// 用于更新api实例(当端口或令牌发生变化时)
+export const UpdateApi = (newPort: number, newToken: string = token) => {
+  if (newPort !== port || newToken !== token) {
     port = newPort;
-    api = axios.create({ baseURL: `http://127.0.0.1:${port}` });
+    token = newToken;
+    api = createApi();
   }
 };
 
+// 供无法设置请求头的场景(EventSource、<audio> 等)以查询参数 ?token= 携带令牌
+export const getApiToken = () => token;
+
 // 如果您需要访问Object中的参数以链接应用程序的其它部分, 则将内容写在boot的回调中, boot函数会由quasar在vue的main.ts中自动调用。(如果不需要访问Object中的参数, 则无需在boot内部处理(当然, 写进去也无可厚非)。)
 export default boot(({ app }) => {
   // 由于前端对于端口的变更时不确定的, 因此需要利用ipc持续监听端口变化，来更新api实例。(TIPS: 虽然本项目不涉及spa, 但后续有必要思考, spa中如何监听端口变化以做到前后端统一的应对。比如使用go启动某个spa时, 是否能做到向spa中传递一些参数这种事情。)
   if (process.env.MODE === 'electron') {
     // 这个逻辑我们在启动时先即时的调用一次, 以避免端口不一致时造成启动瞬间仍使用旧的38888端口。(后续的setInterval()存在1s后才执行第一次的问题(尤其在macos中), 不会即时调用, 这会影响获取实际端口的及时性。)(而且, 由于新架构下是一定可以第一时间及时获取到真实端口的, 因此后续setInterval中的6s监听也没有必要, 但保留它做个保障也行, 多几行无用代码也无可厚非。)
     const currentPort = window.myWindowAPI.getBackendPort(); // 这个逻辑放入boot的回调中恰到好处, 因为electron项目中我们无需在nodejs的主进程使用它。
-    UpdateApi(currentPort);
+    UpdateApi(currentPort, window.myWindowAPI.getBackendToken());
     if (currentPort !== port) {
       // 刷新重启整个应用(以刷新sse的端口)
       window.location.reload(); // 在此处可用
@@ -47,7 +59,7 @@ export default boot(({ app }) => {
     const intervalId = setInterval(async () => {
       count++;
       const currentPort = window.myWindowAPI.getBackendPort(); // 这个逻辑放入boot的回调中恰到好处, 因为electron项目中我们无需在nodejs的主进程使用它。
-      UpdateApi(currentPort);
+      UpdateApi(currentPort, window.myWindowAPI.getBackendToken());
       if (currentPort !== port) {
         // 刷新重启整个应用(以刷新sse的端口)
         window.location.reload(); // 在此处可用
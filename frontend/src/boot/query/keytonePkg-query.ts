@@ -380,7 +380,8 @@ export async function PlaySound(
   startTime: number,
   endTime: number,
   volume: number,
-  isPreviewMode = false
+  isPreviewMode = false,
+  fade: { fadeInMs?: number; fadeOutMs?: number; fadeCurve?: string } = {}
 ) {
   return await api
     .post('/keytone_pkg/play_sound', {
@@ -390,6 +391,9 @@ export async function PlaySound(
       endTime: endTime,
       volume: volume,
       isPreviewMode: isPreviewMode,
+      fadeInMs: fade.fadeInMs ?? 0,
+      fadeOutMs: fade.fadeOutMs ?? 0,
+      fadeCurve: fade.fadeCurve ?? 'linear',
     })
     .then((req) => {
       console.debug('status=', req.status, '->PlaySound 请求已成功执行并返回->', req.data);
@@ -95,6 +95,10 @@ export interface SoundCutParams {
   start_time: number;
   end_time: number;
   volume: number;
+  /** 可选的淡入/淡出包络(毫秒), 缺省为硬切 */
+  fade_in_ms?: number;
+  fade_out_ms?: number;
+  fade_curve?: 'linear' | 'exponential';
 }
 
 /** 声音源文件引用 */
@@ -121,7 +125,7 @@ export interface SoundEntry {
 }
 
 /** 按键音播放模式 */
-export type PlayMode = 'single' | 'random' | 'loop';
+export type PlayMode = 'single' | 'random' | 'loop' | 'weighted' | 'shuffle' | 'layer';
 
 /** 按键音按压/释放配置 */
 export interface KeySoundTriggerConfig {
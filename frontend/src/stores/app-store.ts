@@ -15,11 +15,16 @@ export const useAppStore = defineStore('app', () => {
   //       => 毕竟手动关闭当前sse链接并重新建立新的sse链接涉及到的变更太多了, 几乎所有监听的回调都需重新调用一遍, 而回调的监听逻辑有可能分布在不同的文件中。
   //       => 当然, 如果我们能够将所有监听的回调都集中在一个文件中(或是封装逻辑后再暴露出去的方式将监听集中到某一个函数内方便重新建立), 那么我们只需在该文件中手动关闭当前sse链接并重新建立新的sse链接即可, 但这种方式的可维护性和可读性都不高。
   let port = 38888;
+  let token = '';
   if (process.env.MODE === 'electron') {
     port = window.myWindowAPI.getBackendPort();
+    token = window.myWindowAPI.getBackendToken();
   }
 
-  const eventSource = new EventSource('http://' + IPV4 + `:${port}/stream`, { withCredentials: false });
+  // EventSource 无法设置请求头, 因此令牌以查询参数携带
+  const eventSource = new EventSource('http://' + IPV4 + `:${port}/stream?token=${token}`, {
+    withCredentials: false,
+  });
 
   eventSource.onerror = function (event) {
     q.notify({
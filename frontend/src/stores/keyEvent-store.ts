@@ -9,6 +9,9 @@ export const useKeyEventStore = defineStore('keyEvent', () => {
 
   let previous_keyCodeState: Map<number, string>;
 
+  // 打字速度(由 SDK 随键盘按下事件推送): 距上一次按下的间隔(ms, -1 表示尚无)、滚动 WPM 与命中的速度档位
+  const typingSpeed = ref<{ intervalMs: number; wpm: number; tier: string }>({ intervalMs: -1, wpm: 0, tier: '' });
+
   // TIPS: 这里我们不使用newVal和oldVal, 因为涉及到引用对象时, 它们是相等的(vue官网中有相关说明)。
   watch(keyCodeState, (newVal, oldVal) => {
     // 触发手动开启记录, 否则不会执行这些涉及记录的逻辑
@@ -400,6 +403,7 @@ export const useKeyEventStore = defineStore('keyEvent', () => {
 
   return {
     keyCodeState,
+    typingSpeed,
     frontendKeyEventStateBool,
     isOpeningTheRecord,
     setKeyStateCallback_Record,
@@ -0,0 +1,166 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+/*
+render - KeyTone 专辑离线渲染工具
+
+用途：
+	不需要声卡与真实按键, 将专辑中的一次按键或一段按键脚本渲染为 WAV 文件(44100Hz 16bit 立体声)。
+	渲染使用与实时播放完全相同的按键解析与音量处理链(见 keySound/render_sequence.go),
+	可用于发布专辑试听, 或将渲染结果作为 golden 文件对专辑行为做回归测试。
+
+构建方式：
+	假设在 sdk/ 目录下, 执行该命令, 会在 sdk/ 目录下生成可执行文件 render。
+	加密专辑的解密密钥说明与 printconfig 相同(私有构建需通过 -ldflags 注入 FixedSecret)。
+
+	go build ./audioPackage/cmd/render
+
+直接运行（不落地构建产物）：
+
+	go run ./audioPackage/cmd/render --path /path/to/album/uuid --key 30 --out key30.wav
+
+使用方法：
+	render --path <albumDir> --out <file.wav> (--key <keycode> [--state down|up|hold] [--modifiers ctrl+shift] | --script <events.jsonl>) [--seed N] [--config <dir>]
+
+参数：
+	--path       专辑目录路径（包含 package.json 或 stub + core 文件）
+	--out        输出的 WAV 文件路径
+	--key        渲染一次按键的 keycode（与实时播放一致, 鼠标按键为负数, 如 -1）
+	--state      按键状态, 默认为 down
+	--modifiers  按键时按住的修饰键, 如 ctrl+shift
+	--script     按键脚本（JSONL, 与输入事件录制文件格式一致）, 提供时忽略 --key
+	--seed       随机种子, 相同的种子得到相同的渲染结果（默认 0）
+	--config     KeyTone 设置目录（包含 KeyToneSetting.json）, 用于套用该设置中的音量等播放设置;
+	             该设置文件只会被读取: 渲染使用的是它在临时目录中的副本; 不提供时使用默认设置
+
+示例：
+	# 渲染按键 A(30) 按下的声音
+	render --path /path/to/album/uuid --key 30 --out a_down.wav
+
+	# 按录制的按键脚本渲染一段打字
+	render --path /path/to/album/uuid --script typing.jsonl --seed 1 --out typing.wav
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"KeyTone/audioPackage/mechvibes"
+	"KeyTone/config"
+	"KeyTone/keySound"
+	"KeyTone/logger"
+)
+
+// settingFileName 为 KeyTone 设置目录中的设置文件名(见 config.ConfigRun)。
+const settingFileName = "KeyToneSetting.json"
+
+// errUsage 表示命令行参数不完整或无效, 此时输出用法并以状态码 2 退出。
+var errUsage = errors.New("invalid arguments")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: render --path <albumDir> --out <file.wav> (--key <keycode> [--state down|up|hold] [--modifiers ctrl+shift] | --script <events.jsonl>) [--seed N] [--config <dir>]\n")
+}
+
+func main() {
+	if err := run(); err != nil {
+		if errors.Is(err, errUsage) {
+			usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// run 执行一次渲染; 所有清理都通过 defer 完成, 因此只在 main 中退出进程。
+func run() error {
+	var albumPath, outPath, keycode, state, modifiersValue, scriptPath, configPath string
+	var seed int64
+	flag.StringVar(&albumPath, "path", "", "album directory path containing package.json")
+	flag.StringVar(&outPath, "out", "", "output wav file path")
+	flag.StringVar(&keycode, "key", "", "keycode to render once (mouse buttons are negative)")
+	flag.StringVar(&state, "state", keySound.KeyStateDown, "key state: down, up or hold")
+	flag.StringVar(&modifiersValue, "modifiers", "", "held modifiers, e.g. ctrl+shift")
+	flag.StringVar(&scriptPath, "script", "", "JSONL key event script (same format as input recordings)")
+	flag.Int64Var(&seed, "seed", 0, "random seed")
+	flag.StringVar(&configPath, "config", "", "KeyTone settings directory to take playback settings from (read only)")
+	flag.Parse()
+
+	if albumPath == "" || outPath == "" || (keycode == "" && scriptPath == "") {
+		return errUsage
+	}
+	modifiers, err := keySound.ParseModifiers(modifiersValue)
+	if err != nil {
+		return fmt.Errorf("%w: modifiers: %v", errUsage, err)
+	}
+
+	// 播放链中的日志只输出警告与错误到 stderr
+	logger.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	// 播放链读取用户设置, 缺失的设置会以默认值写回设置文件, 因此始终在临时目录中运行;
+	// 指定了设置目录时只复制其中的设置文件, 不会修改用户的设置
+	tempDir, err := os.MkdirTemp("", "keytone_render_config_*")
+	if err != nil {
+		return fmt.Errorf("create temp config error: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	if configPath != "" {
+		data, err := os.ReadFile(filepath.Join(configPath, settingFileName))
+		if err != nil {
+			return fmt.Errorf("read config error: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, settingFileName), data, 0644); err != nil {
+			return fmt.Errorf("copy config error: %w", err)
+		}
+	}
+	config.ConfigRun(tempDir)
+
+	options := keySound.RenderOptions{Seed: seed}
+	var samples [][2]float64
+	if scriptPath != "" {
+		file, err := os.Open(scriptPath)
+		if err != nil {
+			return fmt.Errorf("read script error: %w", err)
+		}
+		events, err := keySound.ReadRenderEvents(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("parse script error: %w", err)
+		}
+		samples, err = keySound.RenderKeyEvents(albumPath, events, options)
+		if err != nil {
+			return fmt.Errorf("render error: %w", err)
+		}
+	} else {
+		samples, err = keySound.RenderKeyState(albumPath, keycode, state, modifiers, options)
+		if err != nil {
+			return fmt.Errorf("render error: %w", err)
+		}
+	}
+
+	if err := mechvibes.WriteWAV(outPath, samples, keySound.RenderSampleRate()); err != nil {
+		return fmt.Errorf("write error: %w", err)
+	}
+	fmt.Printf("rendered %.1f ms to %s\n", float64(len(samples))*1000/float64(keySound.RenderSampleRate()), outPath)
+	return nil
+}
@@ -54,6 +54,28 @@ var once_stores sync.Once
 
 var viperRWMutex sync.RWMutex
 
+// valueChangeListeners 保存配置项变更后的回调(如播放侧的解码缓存失效)。
+// 回调在配置写锁释放后执行, 回调内部可以安全地调用 GetValue。
+var valueChangeListeners []func(key string)
+var valueChangeListenersMutex sync.RWMutex
+
+// OnValueChange 注册一个配置项变更回调, SetValue / DeleteValue 完成后以被修改的 key 调用。
+// 由于 keySound 依赖本包, 反向通知只能通过注册回调的方式完成, 以避免循环引用。
+func OnValueChange(listener func(key string)) {
+	valueChangeListenersMutex.Lock()
+	defer valueChangeListenersMutex.Unlock()
+	valueChangeListeners = append(valueChangeListeners, listener)
+}
+
+func notifyValueChange(key string) {
+	valueChangeListenersMutex.RLock()
+	listeners := valueChangeListeners
+	valueChangeListenersMutex.RUnlock()
+	for _, listener := range listeners {
+		listener(key)
+	}
+}
+
 const (
 	encryptionModePlain     = "plain"
 	encryptionModeLegacyHex = "legacy-hex"
@@ -374,6 +396,8 @@ func GetValue(key string) any {
 
 // 设置新配置值, 并将设置的值保存到配置文件
 func SetValue(key string, value any) {
+	// 先注册通知, 保证其在写锁释放之后执行(defer 后进先出)。
+	defer notifyValueChange(key)
 	viperRWMutex.Lock()
 	defer viperRWMutex.Unlock()
 	if Viper == nil {
@@ -509,6 +533,7 @@ func SetValue(key string, value any) {
 
 // 删除某个配置项
 func DeleteValue(key string) {
+	defer notifyValueChange(key)
 	viperRWMutex.Lock()
 	defer viperRWMutex.Unlock()
 	if Viper == nil {
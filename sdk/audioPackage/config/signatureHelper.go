@@ -441,6 +441,34 @@ func CheckSignatureAuthorization(albumPath string, encryptedSignatureID string)
 	return false, true, qualificationCode, nil
 }
 
+// CheckExportAuthorization 检查是否允许导出专辑内容（用于 .ktalbum 以外的导出格式，如 Mechvibes 音效包）
+//
+// 功能说明：
+//   - 提供签名时，与 CheckSignatureAuthorization 的判定完全一致
+//   - 未提供签名时，只有专辑不要求授权才允许导出（无法证明导出者在授权列表中）
+//
+// 返回值：
+//   - bool: 是否允许导出
+//   - error: 错误信息
+func CheckExportAuthorization(albumPath string, encryptedSignatureID string) (bool, error) {
+	if encryptedSignatureID != "" {
+		isAuthorized, _, _, err := CheckSignatureAuthorization(albumPath, encryptedSignatureID)
+		return isAuthorized, err
+	}
+
+	signatureInfo, err := GetAlbumSignatureInfo(albumPath)
+	if err != nil {
+		return false, fmt.Errorf("获取专辑签名信息失败: %w", err)
+	}
+	if !signatureInfo.HasSignature {
+		return true, nil
+	}
+	if signatureInfo.OriginalAuthor == nil {
+		return false, fmt.Errorf("专辑缺少原始作者签名")
+	}
+	return !signatureInfo.OriginalAuthor.RequireAuthorization, nil
+}
+
 // GetAvailableSignaturesForExport 获取可用于导出的签名列表
 //
 // 功能说明：
@@ -0,0 +1,414 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mechvibes
+
+// =============================
+// 导出为 Mechvibes 音效包
+// =============================
+//
+// 导出是导入的逆过程:
+//   1. 为每个按键的 down/up 解析出一个"代表声音"(SoundRef):
+//      - sounds      : 直接使用该声音(含裁剪);
+//      - audio_files : 整段音频文件;
+//      - key_sounds  : 沿 single/random/loop 链取第一个成员, 直到落到 sounds 或 audio_files。
+//      Mechvibes 每个按键只能有一个固定声音, 因此 random/loop 只能取其代表。
+//   2. 由调用方提供的 Renderer 将每个代表声音渲染为 PCM(裁剪、音量均已应用);
+//   3. single 模式拼接为一个 sound.wav 精灵并以 [开始, 时长] 切片引用; multi 模式每个声音各自一个文件。
+//   4. 写出 config.json。抬起音使用 "<keycode>-up" 键, 与导入端兼容。
+//
+// 本包不依赖音频解码库, 渲染由调用方(SDK 中为 keySound)完成。
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 代表声音的类型(与专辑配置中的 type 取值一致)
+const (
+	RefSounds     = "sounds"
+	RefAudioFiles = "audio_files"
+)
+
+// maxKeySoundDepth 为 key_sounds 嵌套解析的上限, 与播放端保持一致, 防止循环引用。
+const maxKeySoundDepth = 1000
+
+// spriteGapMS 为精灵音频中相邻切片之间的静音间隔, 避免切片边界处相互串音。
+const spriteGapMS = 10
+
+// SoundRef 为按键最终要播放的代表声音。
+type SoundRef struct {
+	Kind     string
+	SoundID  string // Kind 为 sounds 时有效
+	Sha256   string // Kind 为 audio_files 时有效
+	FileType string // Kind 为 audio_files 时有效
+}
+
+func (r SoundRef) key() string {
+	if r.Kind == RefSounds {
+		return RefSounds + ":" + r.SoundID
+	}
+	return RefAudioFiles + ":" + r.Sha256 + r.FileType
+}
+
+// Renderer 将代表声音渲染为双声道 PCM 样本(采样率为 ExportOptions.SampleRate)。
+type Renderer func(ref SoundRef) ([][2]float64, error)
+
+// ExportOptions 为导出参数。
+type ExportOptions struct {
+	// Mode 为 DefineTypeSingle(默认) 或 DefineTypeMulti。
+	Mode       string
+	SampleRate int
+	Render     Renderer
+}
+
+// ExportResult 为一次导出的摘要。
+type ExportResult struct {
+	Name   string `json:"name"`
+	Mode   string `json:"mode"`
+	Sounds int    `json:"sounds"`
+	Keys   int    `json:"keys"`
+	// Skipped 记录无法导出的按键及原因(如鼠标按键、无法渲染的声音)。
+	Skipped []string `json:"skipped"`
+}
+
+// standardKeycodes 为 Mechvibes 默认音效包覆盖的按键(iohook 键码), 用于展开专辑的全局(global)键音。
+var standardKeycodes = []uint16{
+	1, 59, 60, 61, 62, 63, 64, 65, 66, 67, 68, 87, 88, // Esc, F1-F12
+	41, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, // `1-0 - = Backspace
+	15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 43, // Tab Q-P [ ] \
+	58, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 28, // CapsLock A-L ; ' Enter
+	42, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 54, // Shift Z-M , . / Shift
+	29, 3675, 56, 57, 3640, 3676, 3677, 3613, // Ctrl Meta Alt Space Alt Meta Menu Ctrl
+	3639, 70, 3653, // PrintScreen ScrollLock Pause
+	3655, 3657, 3663, 3665, 3666, 3667, // Home PageUp End PageDown Insert Delete
+	57416, 57419, 57421, 57424, // Up Left Right Down
+	69, 3637, 55, 3658, 3662, 3612, // NumLock / * - + Enter(小键盘)
+	71, 72, 73, 75, 76, 77, 79, 80, 81, 82, 83, // 小键盘 7 8 9 4 5 6 1 2 3 0 .
+}
+
+// reverseKeycodeTable 为 keycodeTable 的逆映射: KeyTone 扩展键码 -> Mechvibes 键码。
+var reverseKeycodeTable = func() map[int]uint16 {
+	table := make(map[int]uint16, len(keycodeTable))
+	for mechvibesCode, codes := range keycodeTable {
+		table[int(codes[0])] = mechvibesCode
+	}
+	return table
+}()
+
+// ReverseKeycode 将 KeyTone 键码翻译为 Mechvibes 键码。
+// 第二个返回值表示是否可导出(鼠标按键等 Mechvibes 不支持的键码返回 false);
+// 第三个返回值表示该键码是否为编辑区/方向键的扩展码, 与小键盘键码冲突时扩展码优先。
+func ReverseKeycode(keycode int) (uint16, bool, bool) {
+	if code, ok := reverseKeycodeTable[keycode]; ok {
+		return code, true, true
+	}
+	if keycode <= 0 || keycode > math.MaxUint16 {
+		return 0, false, false
+	}
+	return uint16(keycode), true, false
+}
+
+// ResolveEffect 将 key_tone 中的一个声效(如 key_tone.single.30.down)解析为代表声音。
+//   - get: 读取专辑配置的函数(编辑器 Viper 或只读快照);
+//   - effectKey: 声效在配置中的完整键名;
+//   - state: "down" 或 "up", 用于解析 key_sounds 时选择对应状态的链。
+func ResolveEffect(get func(string) any, effectKey string, state string) (SoundRef, bool) {
+	effectType, _ := get(effectKey + ".type").(string)
+	return resolveValue(get, effectType, get(effectKey+".value"), state, 0)
+}
+
+func resolveValue(get func(string) any, effectType string, value any, state string, depth int) (SoundRef, bool) {
+	if depth > maxKeySoundDepth {
+		return SoundRef{}, false
+	}
+	switch effectType {
+	case RefSounds:
+		soundID, ok := value.(string)
+		if !ok || soundID == "" {
+			return SoundRef{}, false
+		}
+		return SoundRef{Kind: RefSounds, SoundID: soundID}, true
+	case RefAudioFiles:
+		valueMap, ok := value.(map[string]any)
+		if !ok {
+			return SoundRef{}, false
+		}
+		sha, shaOK := valueMap["sha256"].(string)
+		fileType, typeOK := valueMap["type"].(string)
+		if !shaOK || !typeOK || sha == "" {
+			return SoundRef{}, false
+		}
+		return SoundRef{Kind: RefAudioFiles, Sha256: sha, FileType: fileType}, true
+	case "key_sounds":
+		keySoundID, ok := value.(string)
+		if !ok || keySoundID == "" {
+			return SoundRef{}, false
+		}
+		// single/random/loop 均取第一个成员作为代表(random 取第一个可保证多次导出结果一致)。
+		members, _ := get("key_sounds." + keySoundID + "." + state + ".value").([]any)
+		for _, member := range members {
+			memberMap, ok := member.(map[string]any)
+			if !ok {
+				continue
+			}
+			memberType, _ := memberMap["type"].(string)
+			if ref, ok := resolveValue(get, memberType, memberMap["value"], state, depth+1); ok {
+				return ref, true
+			}
+		}
+	}
+	return SoundRef{}, false
+}
+
+// keyAssignment 为一个 Mechvibes define 的导出来源。
+type keyAssignment struct {
+	ref      SoundRef
+	extended bool
+}
+
+// collectAssignments 收集所有可导出的 define(键名为 Mechvibes 键码, 抬起音带 -up 后缀)。
+func collectAssignments(get func(string) any, skip func(string, string)) map[string]keyAssignment {
+	assignments := map[string]keyAssignment{}
+	assign := func(defineKey string, assignment keyAssignment) {
+		// 编辑区扩展码优先于与之冲突的小键盘键码
+		if existing, ok := assignments[defineKey]; ok && existing.extended && !assignment.extended {
+			return
+		}
+		assignments[defineKey] = assignment
+	}
+
+	single, _ := get("key_tone.single").(map[string]any)
+	keycodes := make([]string, 0, len(single))
+	for keycode := range single {
+		keycodes = append(keycodes, keycode)
+	}
+	sort.Strings(keycodes)
+
+	for _, state := range []string{"down", "up"} {
+		suffix := ""
+		if state == "up" {
+			suffix = upSuffix
+		}
+
+		// 全局声效先展开到标准按键, 再由具体按键的配置覆盖(与播放端 single 优先于 global 一致)。
+		if globalRef, ok := ResolveEffect(get, "key_tone.global."+state, state); ok {
+			for _, code := range standardKeycodes {
+				assignments[strconv.Itoa(int(code))+suffix] = keyAssignment{ref: globalRef}
+			}
+		}
+
+		for _, keycode := range keycodes {
+			if get("key_tone.single."+keycode+"."+state) == nil {
+				continue
+			}
+			ref, ok := ResolveEffect(get, "key_tone.single."+keycode+"."+state, state)
+			if !ok {
+				skip(keycode+"."+state, "unresolvable sound effect")
+				continue
+			}
+			code, err := strconv.Atoi(keycode)
+			if err != nil {
+				skip(keycode+"."+state, "unrecognized keycode")
+				continue
+			}
+			mechvibesCode, ok, extended := ReverseKeycode(code)
+			if !ok {
+				skip(keycode+"."+state, "keycode not supported by mechvibes")
+				continue
+			}
+			assign(strconv.Itoa(int(mechvibesCode))+suffix, keyAssignment{ref: ref, extended: extended})
+		}
+	}
+	return assignments
+}
+
+// Export 将专辑配置导出为 outDir 下的 Mechvibes 音效包(config.json 与音频文件)。
+func Export(get func(string) any, outDir string, opts ExportOptions) (*ExportResult, error) {
+	if opts.Render == nil || opts.SampleRate <= 0 {
+		return nil, errors.New("export requires a renderer and a sample rate")
+	}
+	mode := strings.ToLower(strings.TrimSpace(opts.Mode))
+	if mode == "" {
+		mode = DefineTypeSingle
+	}
+	if mode != DefineTypeSingle && mode != DefineTypeMulti {
+		return nil, fmt.Errorf("unsupported key_define_type: %s", opts.Mode)
+	}
+
+	name, _ := get("package_name").(string)
+	albumUUID, _ := get("audio_pkg_uuid").(string)
+	if strings.TrimSpace(name) == "" {
+		name = albumUUID
+	}
+	result := &ExportResult{Name: name, Mode: mode, Skipped: []string{}}
+	skip := func(key string, reason string) {
+		result.Skipped = append(result.Skipped, key+": "+reason)
+	}
+
+	assignments := collectAssignments(get, skip)
+	defineKeys := make([]string, 0, len(assignments))
+	for defineKey := range assignments {
+		defineKeys = append(defineKeys, defineKey)
+	}
+	sort.Strings(defineKeys)
+
+	// 渲染每个被引用的代表声音(同一声音只渲染一次)
+	rendered := map[string][][2]float64{}
+	failed := map[string]bool{}
+	order := make([]string, 0)
+	refs := map[string]SoundRef{}
+	for _, defineKey := range defineKeys {
+		ref := assignments[defineKey].ref
+		refKey := ref.key()
+		if _, ok := rendered[refKey]; ok || failed[refKey] {
+			continue
+		}
+		samples, err := opts.Render(ref)
+		if err != nil || len(samples) == 0 {
+			failed[refKey] = true
+			reason := "empty sound"
+			if err != nil {
+				reason = err.Error()
+			}
+			skip(refKey, reason)
+			continue
+		}
+		rendered[refKey] = samples
+		refs[refKey] = ref
+		order = append(order, refKey)
+	}
+	if len(order) == 0 {
+		return nil, errors.New("album has no exportable key sounds")
+	}
+
+	if err := os.MkdirAll(outDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	defines := map[string]any{}
+	pack := map[string]any{
+		"id":              albumUUID,
+		"name":            name,
+		"key_define_type": mode,
+		"defines":         defines,
+	}
+
+	if mode == DefineTypeSingle {
+		const spriteName = "sound.wav"
+		var sprite [][2]float64
+		slices := map[string][2]int64{}
+		gap := opts.SampleRate * spriteGapMS / 1000
+		for _, refKey := range order {
+			// 每个切片从整毫秒处开始, 使 [开始, 时长] 能精确描述切片范围
+			startMS := int64(math.Ceil(float64(len(sprite)) * 1000 / float64(opts.SampleRate)))
+			startFrame := int(startMS * int64(opts.SampleRate) / 1000)
+			for len(sprite) < startFrame {
+				sprite = append(sprite, [2]float64{})
+			}
+			samples := rendered[refKey]
+			sprite = append(sprite, samples...)
+			durationMS := int64(math.Ceil(float64(len(samples)) * 1000 / float64(opts.SampleRate)))
+			slices[refKey] = [2]int64{startMS, durationMS}
+			sprite = append(sprite, make([][2]float64, gap)...)
+		}
+		if err := WriteWAV(filepath.Join(outDir, spriteName), sprite, opts.SampleRate); err != nil {
+			return nil, err
+		}
+		pack["sound"] = spriteName
+		for _, defineKey := range defineKeys {
+			if slice, ok := slices[assignments[defineKey].ref.key()]; ok {
+				defines[defineKey] = []int64{slice[0], slice[1]}
+			}
+		}
+	} else {
+		fileNames := map[string]string{}
+		for index, refKey := range order {
+			fileName := fmt.Sprintf("%03d.wav", index+1)
+			if err := WriteWAV(filepath.Join(outDir, fileName), rendered[refKey], opts.SampleRate); err != nil {
+				return nil, err
+			}
+			fileNames[refKey] = fileName
+		}
+		for _, defineKey := range defineKeys {
+			if fileName, ok := fileNames[assignments[defineKey].ref.key()]; ok {
+				defines[defineKey] = fileName
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(pack, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, ConfigFileName), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write mechvibes config: %w", err)
+	}
+
+	result.Sounds = len(order)
+	result.Keys = len(defines)
+	return result, nil
+}
+
+// WriteWAV 将双声道样本以 16-bit PCM WAV 格式写入 path。
+func WriteWAV(path string, samples [][2]float64, sampleRate int) error {
+	if err := os.WriteFile(path, EncodeWAV(samples, sampleRate), 0644); err != nil {
+		return fmt.Errorf("failed to write wav file: %w", err)
+	}
+	return nil
+}
+
+// EncodeWAV 将双声道样本编码为 16-bit PCM WAV 文件内容。
+func EncodeWAV(samples [][2]float64, sampleRate int) []byte {
+	const channels, bytesPerSample = 2, 2
+	dataSize := len(samples) * channels * bytesPerSample
+
+	buffer := make([]byte, 44+dataSize)
+	copy(buffer[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buffer[4:], uint32(36+dataSize))
+	copy(buffer[8:], "WAVE")
+	copy(buffer[12:], "fmt ")
+	binary.LittleEndian.PutUint32(buffer[16:], 16)
+	binary.LittleEndian.PutUint16(buffer[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(buffer[22:], channels)
+	binary.LittleEndian.PutUint32(buffer[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buffer[28:], uint32(sampleRate*channels*bytesPerSample))
+	binary.LittleEndian.PutUint16(buffer[32:], channels*bytesPerSample)
+	binary.LittleEndian.PutUint16(buffer[34:], bytesPerSample*8)
+	copy(buffer[36:], "data")
+	binary.LittleEndian.PutUint32(buffer[40:], uint32(dataSize))
+
+	offset := 44
+	for _, frame := range samples {
+		for _, value := range frame {
+			value = math.Max(-1, math.Min(1, value))
+			binary.LittleEndian.PutUint16(buffer[offset:], uint16(int16(math.Round(value*math.MaxInt16))))
+			offset += bytesPerSample
+		}
+	}
+	return buffer
+}
@@ -0,0 +1,183 @@
+package mechvibes
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mapGetter 以 "a.b.c" 形式的键读取嵌套 map, 模拟 viper 的 Get。
+func mapGetter(config map[string]any) func(string) any {
+	return func(key string) any {
+		var current any = config
+		for _, part := range strings.Split(key, ".") {
+			node, ok := current.(map[string]any)
+			if !ok {
+				return nil
+			}
+			current = node[part]
+		}
+		return current
+	}
+}
+
+// testAlbumConfig 构造一个包含 sounds、audio_files、key_sounds 与鼠标按键的专辑配置。
+func testAlbumConfig() map[string]any {
+	return map[string]any{
+		"package_name":   "Album",
+		"audio_pkg_uuid": "album-id",
+		"key_sounds": map[string]any{
+			"ks-1": map[string]any{
+				"down": map[string]any{"mode": "random", "value": []any{
+					map[string]any{"type": "sounds", "value": "snd-b"},
+					map[string]any{"type": "sounds", "value": "snd-a"},
+				}},
+			},
+		},
+		"key_tone": map[string]any{
+			"single": map[string]any{
+				"30":    map[string]any{"down": map[string]any{"type": "sounds", "value": "snd-a"}},
+				"31":    map[string]any{"down": map[string]any{"type": "key_sounds", "value": "ks-1"}},
+				"61000": map[string]any{"down": map[string]any{"type": "audio_files", "value": map[string]any{"sha256": "abc", "name_id": "n", "type": ".wav"}}},
+				"-1":    map[string]any{"down": map[string]any{"type": "sounds", "value": "snd-a"}},
+			},
+		},
+	}
+}
+
+// fakeRenderer 为每个声音返回固定长度的样本, 并记录渲染次数。
+func fakeRenderer(frames map[string]int, calls map[string]int) Renderer {
+	return func(ref SoundRef) ([][2]float64, error) {
+		calls[ref.key()]++
+		count, ok := frames[ref.key()]
+		if !ok {
+			return nil, errors.New("unknown sound")
+		}
+		samples := make([][2]float64, count)
+		for i := range samples {
+			samples[i] = [2]float64{0.5, -0.5}
+		}
+		return samples, nil
+	}
+}
+
+func readPackConfig(t *testing.T, dir string) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, ConfigFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack map[string]any
+	if err := json.Unmarshal(data, &pack); err != nil {
+		t.Fatal(err)
+	}
+	return pack
+}
+
+func TestReverseKeycode(t *testing.T) {
+	if code, ok, extended := ReverseKeycode(61000); !ok || !extended || code != 57416 {
+		t.Fatalf("expected Up to map back to 57416, got %d ok=%v extended=%v", code, ok, extended)
+	}
+	if code, ok, extended := ReverseKeycode(30); !ok || extended || code != 30 {
+		t.Fatalf("expected A to stay 30, got %d ok=%v extended=%v", code, ok, extended)
+	}
+	if _, ok, _ := ReverseKeycode(-1); ok {
+		t.Fatal("expected mouse buttons to be rejected")
+	}
+}
+
+// TestExportSingleSprite 验证 single 模式: 声音去重后拼接为精灵, 切片从整毫秒开始且互不重叠。
+func TestExportSingleSprite(t *testing.T) {
+	outDir := t.TempDir()
+	calls := map[string]int{}
+	render := fakeRenderer(map[string]int{"sounds:snd-a": 441, "sounds:snd-b": 100, "audio_files:abc.wav": 882}, calls)
+
+	result, err := Export(mapGetter(testAlbumConfig()), outDir, ExportOptions{SampleRate: 44100, Render: render})
+	if err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	if result.Keys != 3 || result.Sounds != 3 || len(result.Skipped) != 1 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	for key, count := range calls {
+		if count != 1 {
+			t.Fatalf("expected %s rendered once, got %d", key, count)
+		}
+	}
+
+	pack := readPackConfig(t, outDir)
+	if pack["key_define_type"] != DefineTypeSingle || pack["sound"] != "sound.wav" || pack["name"] != "Album" {
+		t.Fatalf("unexpected pack header: %v", pack)
+	}
+	defines := pack["defines"].(map[string]any)
+	// key_sounds 取链中第一个成员(snd-b)
+	if defines["30"].([]any)[1] != 10.0 || defines["31"].([]any)[1] != 3.0 {
+		t.Fatalf("unexpected slice durations: %v", defines)
+	}
+	up := defines["57416"].([]any)
+	if up[1] != 20.0 {
+		t.Fatalf("expected Up arrow exported under its mechvibes keycode: %v", defines)
+	}
+
+	// 切片按渲染顺序依次排列, 后一个切片开始于前一个切片结束之后
+	var previousEnd float64
+	for _, key := range []string{"30", "31", "57416"} {
+		slice := defines[key].([]any)
+		if slice[0].(float64) < previousEnd {
+			t.Fatalf("slice %s overlaps the previous one: %v", key, defines)
+		}
+		previousEnd = slice[0].(float64) + slice[1].(float64)
+	}
+
+	info, err := os.Stat(filepath.Join(outDir, "sound.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() <= 44+int64(441+100+882)*4 {
+		t.Fatalf("sprite too small: %d bytes", info.Size())
+	}
+}
+
+// TestExportMultiRoundTrip 验证 multi 模式导出的音效包可被 Convert 重新导入。
+func TestExportMultiRoundTrip(t *testing.T) {
+	config := testAlbumConfig()
+	config["key_tone"].(map[string]any)["global"] = map[string]any{
+		"up": map[string]any{"type": "sounds", "value": "snd-b"},
+	}
+	outDir := t.TempDir()
+	render := fakeRenderer(map[string]int{"sounds:snd-a": 441, "sounds:snd-b": 100, "audio_files:abc.wav": 882}, map[string]int{})
+
+	result, err := Export(mapGetter(config), outDir, ExportOptions{Mode: DefineTypeMulti, SampleRate: 44100, Render: render})
+	if err != nil {
+		t.Fatalf("Export returned error: %v", err)
+	}
+	if result.Keys != 3+len(standardKeycodes) {
+		t.Fatalf("expected global up sound expanded over standard keys: %+v", result)
+	}
+
+	defines := readPackConfig(t, outDir)["defines"].(map[string]any)
+	// 31 的 key_sounds 代表声音与全局抬起音同为 snd-b, 应共用同一个文件
+	if defines["31"] != defines["30-up"] || defines["30"] == defines["31"] {
+		t.Fatalf("unexpected multi defines: %v", defines)
+	}
+
+	albumDir := filepath.Join(t.TempDir(), "reimported")
+	imported, err := Convert(outDir, albumDir)
+	if err != nil {
+		t.Fatalf("Convert of exported pack failed: %v", err)
+	}
+	if imported.AudioFiles != 3 || len(imported.Skipped) != 0 {
+		t.Fatalf("unexpected re-import result: %+v", imported)
+	}
+}
+
+func TestExportRejectsAlbumWithoutSounds(t *testing.T) {
+	config := map[string]any{"key_tone": map[string]any{"single": map[string]any{}}}
+	render := fakeRenderer(map[string]int{}, map[string]int{})
+	if _, err := Export(mapGetter(config), t.TempDir(), ExportOptions{SampleRate: 44100, Render: render}); err == nil {
+		t.Fatal("expected error for album without key sounds")
+	}
+}
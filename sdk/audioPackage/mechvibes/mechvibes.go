@@ -0,0 +1,458 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+Package mechvibes 将 Mechvibes 音效包转换为 KeyTone 原生键音专辑。
+
+Mechvibes 音效包由一个 config.json 与若干音频文件组成:
+
+	{
+	  "id": "custom-sound-pack-1",
+	  "name": "My Pack",
+	  "key_define_type": "single",   // single: 单个精灵音频 + 切片; multi: 每个按键独立文件
+	  "sound": "sound.ogg",          // single 模式下的精灵音频
+	  "defines": {
+	    "1":  [2894, 226],           // single: [开始毫秒, 持续毫秒]
+	    "57": [0, 120]
+	  }
+	}
+
+multi 模式下 defines 的值为文件名(或 null 表示无声)。
+兼容部分衍生版本的抬起音: defines 中形如 "30-up" 的键表示抬起音; single 模式下若存在 "soundup" 则从其切片。
+
+转换结果与 KeyTone 编辑器产生的专辑结构完全一致:
+  - 音频文件按 sha256 命名保存到 audioFiles/, 并在 audio_files.<sha256>.name.<name_id> 中登记别名;
+  - single 模式的每个切片生成一个 sounds.<id>, 裁剪参数写入 cut.start_time / cut.end_time;
+  - 每个按键写入 key_tone.single.<keycode>.down / up。
+*/
+package mechvibes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"KeyTone/keySound/audio"
+)
+
+// ConfigFileName 为 Mechvibes 音效包的配置文件名。
+const ConfigFileName = "config.json"
+
+// 支持的 key_define_type
+const (
+	DefineTypeSingle = "single"
+	DefineTypeMulti  = "multi"
+)
+
+// upSuffix 为抬起音定义的键名后缀。
+const upSuffix = "-up"
+
+// Pack 为 Mechvibes config.json 的内容。
+type Pack struct {
+	ID            string                     `json:"id"`
+	Name          string                     `json:"name"`
+	KeyDefineType string                     `json:"key_define_type"`
+	Sound         string                     `json:"sound"`
+	SoundUp       string                     `json:"soundup"`
+	Defines       map[string]json.RawMessage `json:"defines"`
+}
+
+// Result 为一次转换的摘要。
+type Result struct {
+	AlbumUUID  string `json:"albumUUID"`
+	AlbumPath  string `json:"albumPath"`
+	Name       string `json:"name"`
+	AudioFiles int    `json:"audioFiles"`
+	Sounds     int    `json:"sounds"`
+	Keys       int    `json:"keys"`
+	// Skipped 记录被跳过的定义及原因(如无法识别的键码、缺失的文件)。
+	Skipped []string `json:"skipped"`
+}
+
+// keycodeTable 为 Mechvibes(iohook) 键码到 KeyTone(gohook) 键码的翻译表。
+//
+// 二者均源自 libuiohook 的 VC_* 定义, 绝大部分键码完全一致, 无需翻译。
+// 差异集中在编辑区/方向键: Mechvibes 使用 VC_* 原值(如 Home=3655, Up=57416),
+// 而 KeyTone 实际收到的是带 0xEE00 前缀的扩展码(如 Home=60999, Up=61000), 原值在 KeyTone 中对应数字小键盘按键。
+// 因此这些键会同时写入两个键码, 使独立编辑区与(NumLock 关闭时的)小键盘均能发声。
+var keycodeTable = map[uint16][]uint16{
+	3655:  {60999, 3655},  // Home
+	3657:  {61001, 3657},  // PageUp
+	3663:  {61007, 3663},  // End
+	3665:  {61009, 3665},  // PageDown
+	3666:  {61010, 3666},  // Insert
+	3667:  {61011, 3667},  // Delete
+	57416: {61000, 57416}, // Up
+	57419: {61003, 57419}, // Left
+	57421: {61005, 57421}, // Right
+	57424: {61008, 57424}, // Down
+}
+
+// TranslateKeycode 将 Mechvibes 键码翻译为 KeyTone 键码(可能对应多个)。
+func TranslateKeycode(code uint16) []uint16 {
+	if codes, ok := keycodeTable[code]; ok {
+		return codes
+	}
+	return []uint16{code}
+}
+
+// FindPackRoot 在 dir 及其唯一的子目录中查找 config.json(压缩包通常会多包一层目录)。
+func FindPackRoot(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ConfigFileName)); err == nil {
+		return dir, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var subDirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), "__MACOSX") {
+			subDirs = append(subDirs, entry.Name())
+		}
+	}
+	if len(subDirs) == 1 {
+		root := filepath.Join(dir, subDirs[0])
+		if _, err := os.Stat(filepath.Join(root, ConfigFileName)); err == nil {
+			return root, nil
+		}
+	}
+	return "", errors.New("mechvibes config.json not found")
+}
+
+// LoadPack 读取并校验 packDir 下的 config.json。
+func LoadPack(packDir string) (*Pack, error) {
+	data, err := os.ReadFile(filepath.Join(packDir, ConfigFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mechvibes config: %w", err)
+	}
+	var pack Pack
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("invalid mechvibes config: %w", err)
+	}
+	pack.KeyDefineType = strings.ToLower(strings.TrimSpace(pack.KeyDefineType))
+	if pack.KeyDefineType == "" {
+		pack.KeyDefineType = DefineTypeSingle
+	}
+	if pack.KeyDefineType != DefineTypeSingle && pack.KeyDefineType != DefineTypeMulti {
+		return nil, fmt.Errorf("unsupported key_define_type: %s", pack.KeyDefineType)
+	}
+	if pack.KeyDefineType == DefineTypeSingle && strings.TrimSpace(pack.Sound) == "" {
+		return nil, errors.New("mechvibes single pack requires a sound file")
+	}
+	if len(pack.Defines) == 0 {
+		return nil, errors.New("mechvibes config has no defines")
+	}
+	return &pack, nil
+}
+
+// converter 保存一次转换过程中的中间状态。
+type converter struct {
+	packDir  string
+	albumDir string
+	result   *Result
+
+	audioFiles map[string]any
+	sounds     map[string]any
+	single     map[string]any
+
+	// fileRefs 缓存 包内文件名 -> source_file_for_sound, 同一文件只导入一次。
+	fileRefs map[string]map[string]any
+	// soundIDs 缓存 切片 -> sound ID, 相同切片只生成一个 sound。
+	soundIDs map[string]string
+}
+
+// Convert 将 packDir 中的 Mechvibes 音效包转换为 albumDir 下的 KeyTone 专辑。
+// albumDir 的目录名即专辑 UUID; 目录若不存在会被创建, 但不允许已存在 package.json。
+func Convert(packDir string, albumDir string) (result *Result, err error) {
+	pack, err := LoadPack(packDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(albumDir, "package.json")); err == nil {
+		return nil, errors.New("target album already exists")
+	}
+	// 仅当专辑目录由本次转换创建时, 才在失败后将其清理, 避免误删调用方已有的目录。
+	if _, statErr := os.Stat(albumDir); os.IsNotExist(statErr) {
+		defer func() {
+			if err != nil {
+				os.RemoveAll(albumDir)
+			}
+		}()
+	}
+	if err := os.MkdirAll(filepath.Join(albumDir, "audioFiles"), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create album directory: %w", err)
+	}
+
+	albumUUID := filepath.Base(albumDir)
+	name := strings.TrimSpace(pack.Name)
+	if name == "" {
+		name = strings.TrimSpace(pack.ID)
+	}
+	if name == "" {
+		name = albumUUID
+	}
+
+	c := &converter{
+		packDir:    packDir,
+		albumDir:   albumDir,
+		result:     &Result{AlbumUUID: albumUUID, AlbumPath: albumDir, Name: name, Skipped: []string{}},
+		audioFiles: map[string]any{},
+		sounds:     map[string]any{},
+		single:     map[string]any{},
+		fileRefs:   map[string]map[string]any{},
+		soundIDs:   map[string]string{},
+	}
+
+	// 按键名排序, 保证同一音效包多次转换得到相同的结构(便于比对与测试)。
+	defineKeys := make([]string, 0, len(pack.Defines))
+	for key := range pack.Defines {
+		defineKeys = append(defineKeys, key)
+	}
+	sort.Strings(defineKeys)
+
+	for _, defineKey := range defineKeys {
+		state := "down"
+		codeText := defineKey
+		if strings.HasSuffix(defineKey, upSuffix) {
+			state = "up"
+			codeText = strings.TrimSuffix(defineKey, upSuffix)
+		}
+		code, err := strconv.ParseUint(codeText, 10, 16)
+		if err != nil || code == 0 {
+			c.skip(defineKey, "unrecognized keycode")
+			continue
+		}
+
+		effect, err := c.convertDefine(pack, state, pack.Defines[defineKey])
+		if err != nil {
+			c.skip(defineKey, err.Error())
+			continue
+		}
+		if effect == nil {
+			continue
+		}
+		for _, keycode := range TranslateKeycode(uint16(code)) {
+			c.setKeyEffect(strconv.Itoa(int(keycode)), state, effect)
+		}
+	}
+
+	if len(c.single) == 0 {
+		return nil, errors.New("no playable key definitions in mechvibes pack")
+	}
+
+	config := map[string]any{
+		"package_name":   name,
+		"audio_pkg_uuid": albumUUID,
+		"audio_files":    c.audioFiles,
+		"sounds":         c.sounds,
+		"key_tone": map[string]any{
+			// Mechvibes 中未定义的按键不发声, 因此关闭内嵌测试音, 避免未映射的按键/抬起时播放测试音。
+			"is_enable_embedded_test_sound": map[string]any{"down": false, "up": false},
+			"single":                        c.single,
+		},
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(albumDir, "package.json"), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write album config: %w", err)
+	}
+
+	c.result.AudioFiles = len(c.audioFiles)
+	c.result.Sounds = len(c.sounds)
+	c.result.Keys = len(c.single)
+	return c.result, nil
+}
+
+func (c *converter) skip(defineKey string, reason string) {
+	c.result.Skipped = append(c.result.Skipped, defineKey+": "+reason)
+}
+
+// convertDefine 将单个 define 转换为 key_tone.single.<keycode>.<state> 的值; 返回 nil 表示该键无声。
+func (c *converter) convertDefine(pack *Pack, state string, raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	if pack.KeyDefineType == DefineTypeMulti {
+		var fileName string
+		if err := json.Unmarshal(raw, &fileName); err != nil {
+			return nil, errors.New("multi define must be a file name")
+		}
+		if strings.TrimSpace(fileName) == "" {
+			return nil, nil
+		}
+		ref, err := c.importAudioFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		// multi 模式下整段播放, 直接引用音频文件即可, 无需裁剪。
+		return map[string]any{"type": "audio_files", "value": copyMap(ref)}, nil
+	}
+
+	var slice []float64
+	if err := json.Unmarshal(raw, &slice); err != nil || len(slice) != 2 {
+		return nil, errors.New("single define must be [start, duration]")
+	}
+	start, duration := slice[0], slice[1]
+	if start < 0 || duration <= 0 {
+		return nil, errors.New("invalid slice range")
+	}
+
+	sprite := pack.Sound
+	if state == "up" && strings.TrimSpace(pack.SoundUp) != "" {
+		sprite = pack.SoundUp
+	}
+	ref, err := c.importAudioFile(sprite)
+	if err != nil {
+		return nil, err
+	}
+
+	sliceKey := fmt.Sprintf("%s|%v|%v", sprite, start, duration)
+	soundID, ok := c.soundIDs[sliceKey]
+	if !ok {
+		soundID, err = newID()
+		if err != nil {
+			return nil, err
+		}
+		c.soundIDs[sliceKey] = soundID
+		c.sounds[soundID] = map[string]any{
+			"name":                  fmt.Sprintf("%s %v-%vms", strings.TrimSuffix(filepath.Base(sprite), filepath.Ext(sprite)), start, start+duration),
+			"source_file_for_sound": copyMap(ref),
+			"cut": map[string]any{
+				"start_time": start,
+				"end_time":   start + duration,
+				"volume":     0.0,
+			},
+		}
+	}
+	return map[string]any{"type": "sounds", "value": soundID}, nil
+}
+
+// importAudioFile 按 sha256 将包内文件复制到专辑的 audioFiles 目录, 并登记别名(与 add_new_sound_file 一致)。
+func (c *converter) importAudioFile(fileName string) (map[string]any, error) {
+	if ref, ok := c.fileRefs[fileName]; ok {
+		return ref, nil
+	}
+
+	cleanName := filepath.FromSlash(strings.TrimSpace(fileName))
+	if !filepath.IsLocal(cleanName) {
+		return nil, fmt.Errorf("invalid sound file path: %s", fileName)
+	}
+	ext := filepath.Ext(cleanName)
+	if !audio.IsSupported(ext) {
+		return nil, fmt.Errorf("unsupported audio type: %s", fileName)
+	}
+
+	srcPath := filepath.Join(c.packDir, cleanName)
+	hashString, err := hashFile(srcPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sound file %s: %w", fileName, err)
+	}
+
+	destPath := filepath.Join(c.albumDir, "audioFiles", hashString+ext)
+	if _, err := os.Stat(destPath); os.IsNotExist(err) {
+		if err := copyFile(srcPath, destPath); err != nil {
+			return nil, fmt.Errorf("failed to copy sound file %s: %w", fileName, err)
+		}
+	}
+
+	nameID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := c.audioFiles[hashString].(map[string]any)
+	if !ok {
+		entry = map[string]any{"name": map[string]any{}, "type": ext}
+		c.audioFiles[hashString] = entry
+	}
+	entry["name"].(map[string]any)[nameID] = strings.TrimSuffix(filepath.Base(cleanName), ext)
+
+	ref := map[string]any{"sha256": hashString, "name_id": nameID, "type": ext}
+	c.fileRefs[fileName] = ref
+	return ref, nil
+}
+
+func (c *converter) setKeyEffect(keycode string, state string, effect map[string]any) {
+	keyEntry, ok := c.single[keycode].(map[string]any)
+	if !ok {
+		keyEntry = map[string]any{}
+		c.single[keycode] = keyEntry
+	}
+	keyEntry[state] = effect
+}
+
+func copyMap(source map[string]any) map[string]any {
+	target := make(map[string]any, len(source))
+	for key, value := range source {
+		target[key] = value
+	}
+	return target
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// newID 生成 UUID v4 字符串, 用作 name_id 与 sound ID(与 SDK 中 generateAudioSourceNameID 的规则一致)。
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,159 @@
+package mechvibes
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestPack 在临时目录中写入一个 Mechvibes 音效包(音频内容无需可解码, 转换只按字节处理)。
+func writeTestPack(t *testing.T, config string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ConfigFileName), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readAlbumConfig 读取转换生成的 package.json。
+func readAlbumConfig(t *testing.T, albumDir string) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(albumDir, "package.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestTranslateKeycode(t *testing.T) {
+	if got := TranslateKeycode(30); !reflect.DeepEqual(got, []uint16{30}) {
+		t.Fatalf("expected identical keycode for A, got %v", got)
+	}
+	if got := TranslateKeycode(57416); !reflect.DeepEqual(got, []uint16{61000, 57416}) {
+		t.Fatalf("expected Up to map to extended and numpad codes, got %v", got)
+	}
+}
+
+// TestConvertSinglePack 验证 single 模式: 精灵音频只导入一次, 相同切片共用一个 sound, 并写入裁剪参数。
+func TestConvertSinglePack(t *testing.T) {
+	packDir := writeTestPack(t, `{
+		"id": "pack-1",
+		"name": "Test Pack",
+		"key_define_type": "single",
+		"sound": "sprite.ogg",
+		"defines": {"30": [100, 50], "31": [100, 50], "57416": [200, 40], "30-up": [300, 20], "abc": [0, 1], "32": null}
+	}`, map[string]string{"sprite.ogg": "sprite-bytes"})
+	albumDir := filepath.Join(t.TempDir(), "album-id")
+
+	result, err := Convert(packDir, albumDir)
+	if err != nil {
+		t.Fatalf("Convert returned error: %v", err)
+	}
+	if result.AlbumUUID != "album-id" || result.Name != "Test Pack" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if result.AudioFiles != 1 || result.Sounds != 3 || result.Keys != 4 || len(result.Skipped) != 1 {
+		t.Fatalf("unexpected counters: %+v", result)
+	}
+
+	config := readAlbumConfig(t, albumDir)
+	if config["audio_pkg_uuid"] != "album-id" || config["package_name"] != "Test Pack" {
+		t.Fatalf("unexpected album identity: %v", config)
+	}
+
+	audioFiles := config["audio_files"].(map[string]any)
+	for sha, entry := range audioFiles {
+		if entry.(map[string]any)["type"] != ".ogg" {
+			t.Fatalf("unexpected audio type: %v", entry)
+		}
+		if _, err := os.Stat(filepath.Join(albumDir, "audioFiles", sha+".ogg")); err != nil {
+			t.Fatalf("expected audio file copied by sha256: %v", err)
+		}
+	}
+
+	single := config["key_tone"].(map[string]any)["single"].(map[string]any)
+	down30 := single["30"].(map[string]any)["down"].(map[string]any)
+	down31 := single["31"].(map[string]any)["down"].(map[string]any)
+	if down30["type"] != "sounds" || down30["value"] != down31["value"] {
+		t.Fatalf("expected identical slices to share one sound: %v %v", down30, down31)
+	}
+	if _, ok := single["30"].(map[string]any)["up"]; !ok {
+		t.Fatal("expected up sound for key 30")
+	}
+	if _, ok := single["61000"]; !ok {
+		t.Fatal("expected Up arrow to be written under its extended keycode")
+	}
+
+	sound := config["sounds"].(map[string]any)[down30["value"].(string)].(map[string]any)
+	cut := sound["cut"].(map[string]any)
+	if cut["start_time"] != 100.0 || cut["end_time"] != 150.0 {
+		t.Fatalf("unexpected cut: %v", cut)
+	}
+}
+
+// TestConvertMultiPack 验证 multi 模式直接引用音频文件, 且 null 定义不生成按键。
+func TestConvertMultiPack(t *testing.T) {
+	packDir := writeTestPack(t, `{
+		"name": "Multi",
+		"key_define_type": "multi",
+		"defines": {"30": "a.wav", "31": "a.wav", "32": null, "33": "missing.wav"}
+	}`, map[string]string{"a.wav": "a-bytes"})
+	albumDir := filepath.Join(t.TempDir(), "album-id")
+
+	result, err := Convert(packDir, albumDir)
+	if err != nil {
+		t.Fatalf("Convert returned error: %v", err)
+	}
+	if result.AudioFiles != 1 || result.Sounds != 0 || result.Keys != 2 || len(result.Skipped) != 1 {
+		t.Fatalf("unexpected counters: %+v", result)
+	}
+
+	config := readAlbumConfig(t, albumDir)
+	single := config["key_tone"].(map[string]any)["single"].(map[string]any)
+	down := single["30"].(map[string]any)["down"].(map[string]any)
+	if down["type"] != "audio_files" {
+		t.Fatalf("unexpected key effect: %v", down)
+	}
+	value := down["value"].(map[string]any)
+	if value["type"] != ".wav" || value["name_id"] == "" {
+		t.Fatalf("unexpected audio file reference: %v", value)
+	}
+}
+
+// TestConvertRejectsEmptyPackAndCleansUp 验证无可用定义时返回错误, 并清理本次创建的专辑目录。
+func TestConvertRejectsEmptyPackAndCleansUp(t *testing.T) {
+	packDir := writeTestPack(t, `{"key_define_type": "single", "sound": "sprite.ogg", "defines": {}}`, nil)
+	albumDir := filepath.Join(t.TempDir(), "album-id")
+
+	if _, err := Convert(packDir, albumDir); err == nil {
+		t.Fatal("expected error for pack without definitions")
+	}
+	if _, err := os.Stat(albumDir); !os.IsNotExist(err) {
+		t.Fatalf("expected album directory to be removed, stat err: %v", err)
+	}
+}
+
+func TestFindPackRootNestedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "my-pack")
+	os.MkdirAll(filepath.Join(dir, "__MACOSX"), 0755)
+	os.MkdirAll(nested, 0755)
+	os.WriteFile(filepath.Join(nested, ConfigFileName), []byte("{}"), 0644)
+
+	root, err := FindPackRoot(dir)
+	if err != nil || root != nested {
+		t.Fatalf("unexpected pack root: %q err=%v", root, err)
+	}
+}
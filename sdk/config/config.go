@@ -251,6 +251,52 @@ const Main_home___press_release_random_volume_processing___split_mouse_down_max_
 const Main_home___press_release_random_volume_processing___split_mouse_up_is_enabled = false
 const Main_home___press_release_random_volume_processing___split_mouse_up_max_reduce_ratio = 3.0
 
+// 主页面随机音高(音高/速度)默认设置
+// * 以音分(cents, 1200 音分 = 1 个八度)为单位, 每次播放在 [-max_cents_down, +max_cents_up] 内随机偏移, 通过重采样比率实现。
+const Main_home___random_pitch_processing___is_enabled = false
+const Main_home___random_pitch_processing___max_cents_up = 30.0
+const Main_home___random_pitch_processing___max_cents_down = 30.0
+
+// 主页面按下/抬起随机音高单独控制默认设置
+const Main_home___press_release_random_pitch_processing___is_enabled = false
+const Main_home___press_release_random_pitch_processing___down_is_enabled = false
+const Main_home___press_release_random_pitch_processing___down_max_cents_up = 30.0
+const Main_home___press_release_random_pitch_processing___down_max_cents_down = 30.0
+const Main_home___press_release_random_pitch_processing___up_is_enabled = false
+const Main_home___press_release_random_pitch_processing___up_max_cents_up = 30.0
+const Main_home___press_release_random_pitch_processing___up_max_cents_down = 30.0
+
+// 播放解码缓存(PCM cache)默认设置
+// * 缓存已解码、已裁剪、已重采样的音频片段, 使按键热路径不再重复打开文件与解码。
+const Playback___pcm_cache___is_enabled = true
+const Playback___pcm_cache___max_memory_mb = 64.0 // 缓存占用内存上限(MiB), 超出后按 LRU 淘汰
+
+// 播放复音(同时发声数)限制默认设置
+// * 快速连击或长尾音时, 限制同时播放的声音数量, 超出时淘汰最早的声音(短淡出), 避免无限叠加造成的 CPU 占用与削波。
+const Playback___voices___max_voices = 32.0        // 全局同时发声数上限(0 表示不限制)
+const Playback___voices___max_voices_per_key = 4.0 // 单个按键同时发声数上限(0 表示不限制), 超出时切断该按键最早的声音
+const Playback___voices___steal_fade_ms = 8.0      // 被淘汰声音的淡出时长(毫秒)
+
+// 按键位置声像(空间化)默认设置
+// * 开启后按所选键盘布局中按键的水平位置, 将声音向左/右声道偏移。
+const Playback___spatial_pan___is_enabled = false
+const Playback___spatial_pan___layout = "ansi" // 可选 ansi / iso / jis(全尺寸) / tkl / 60
+const Playback___spatial_pan___width = 0.6     // 声像宽度(0~1), 即最左/最右侧按键的偏移量
+// * 鼠标按键的声像(-1 最左 ~ 1 最右), 以负数按键码区分各按键, 默认均位于键盘右侧; 未配置的按键保持居中。
+const Playback___spatial_pan___mouse_pan___left = 0.8   // 左键(-1)
+const Playback___spatial_pan___mouse_pan___right = 0.8  // 右键(-2)
+const Playback___spatial_pan___mouse_pan___middle = 0.8 // 中键(-3)
+
+// 专辑导入解压限制默认设置
+// * 防止恶意的 .ktalbum / 音效包通过超大、超多文件或极高压缩比(zip 炸弹)耗尽磁盘。
+const Album_import___max_total_size_mb = 4096.0    // 解压后总大小上限(MiB)
+const Album_import___max_file_count = 10000.0      // 条目数量上限
+const Album_import___max_compression_ratio = 200.0 // 压缩比上限(仅对解压后大于 1MiB 的条目及整体生效)
+
+// 本地服务默认设置
+// * 允许跨域访问本地服务的来源列表(如 "http://localhost:9000")。壳程序自身的来源由其通过环境变量 KEYTONE_ALLOWED_ORIGINS 传入, 无需写入此处。
+var Server___allowed_origins = []string{}
+
 func settingDefaultConfig() {
 	// 手动打开应用时的默认设置
 	viper.SetDefault("startup.is_hide_windows", Startup___is_hide_windows)
@@ -281,6 +327,32 @@ func settingDefaultConfig() {
 	// 默认 false：彻底分离，鼠标无专辑则无声；用户可在设置中开启回退。
 	viper.SetDefault("playback.routing.mouse_fallback_to_keyboard", false)
 
+	// 播放解码缓存默认设置
+	viper.SetDefault("playback.pcm_cache.is_enabled", Playback___pcm_cache___is_enabled)
+	viper.SetDefault("playback.pcm_cache.max_memory_mb", Playback___pcm_cache___max_memory_mb)
+
+	// 播放复音限制默认设置
+	viper.SetDefault("playback.voices.max_voices", Playback___voices___max_voices)
+	viper.SetDefault("playback.voices.max_voices_per_key", Playback___voices___max_voices_per_key)
+	viper.SetDefault("playback.voices.steal_fade_ms", Playback___voices___steal_fade_ms)
+
+	viper.SetDefault("playback.spatial_pan.is_enabled", Playback___spatial_pan___is_enabled)
+	viper.SetDefault("playback.spatial_pan.layout", Playback___spatial_pan___layout)
+	viper.SetDefault("playback.spatial_pan.width", Playback___spatial_pan___width)
+	viper.SetDefault("playback.spatial_pan.mouse_pan", map[string]any{
+		"-1": Playback___spatial_pan___mouse_pan___left,
+		"-2": Playback___spatial_pan___mouse_pan___right,
+		"-3": Playback___spatial_pan___mouse_pan___middle,
+	})
+
+	// 专辑导入解压限制默认设置
+	viper.SetDefault("album_import.max_total_size_mb", Album_import___max_total_size_mb)
+	viper.SetDefault("album_import.max_file_count", Album_import___max_file_count)
+	viper.SetDefault("album_import.max_compression_ratio", Album_import___max_compression_ratio)
+
+	// 本地服务默认设置
+	viper.SetDefault("server.allowed_origins", Server___allowed_origins)
+
 	// 键音专辑页 - 波形滚动行为偏好
 	// 默认值：paged-jump（分页式跳转），符合传统剪辑软件习惯
 	viper.SetDefault("keytone_album_page.scroll_behavior", "paged-jump")
@@ -344,6 +416,18 @@ func settingDefaultConfig() {
 	viper.SetDefault("main_home.press_release_random_volume_processing.split.mouse.up.is_enabled", Main_home___press_release_random_volume_processing___split_mouse_up_is_enabled)
 	viper.SetDefault("main_home.press_release_random_volume_processing.split.mouse.up.max_reduce_ratio", Main_home___press_release_random_volume_processing___split_mouse_up_max_reduce_ratio)
 
+	viper.SetDefault("main_home.random_pitch_processing.is_enabled", Main_home___random_pitch_processing___is_enabled)
+	viper.SetDefault("main_home.random_pitch_processing.max_cents_up", Main_home___random_pitch_processing___max_cents_up)
+	viper.SetDefault("main_home.random_pitch_processing.max_cents_down", Main_home___random_pitch_processing___max_cents_down)
+
+	viper.SetDefault("main_home.press_release_random_pitch_processing.is_enabled", Main_home___press_release_random_pitch_processing___is_enabled)
+	viper.SetDefault("main_home.press_release_random_pitch_processing.down.is_enabled", Main_home___press_release_random_pitch_processing___down_is_enabled)
+	viper.SetDefault("main_home.press_release_random_pitch_processing.down.max_cents_up", Main_home___press_release_random_pitch_processing___down_max_cents_up)
+	viper.SetDefault("main_home.press_release_random_pitch_processing.down.max_cents_down", Main_home___press_release_random_pitch_processing___down_max_cents_down)
+	viper.SetDefault("main_home.press_release_random_pitch_processing.up.is_enabled", Main_home___press_release_random_pitch_processing___up_is_enabled)
+	viper.SetDefault("main_home.press_release_random_pitch_processing.up.max_cents_up", Main_home___press_release_random_pitch_processing___up_max_cents_up)
+	viper.SetDefault("main_home.press_release_random_pitch_processing.up.max_cents_down", Main_home___press_release_random_pitch_processing___up_max_cents_down)
+
 }
 
 func createDefaultConfig() {
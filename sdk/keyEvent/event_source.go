@@ -0,0 +1,263 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keyEvent
+
+// =============================
+// 输入事件源（EventSource）说明
+// =============================
+//
+// KeyEventListen 不再直接读取 hook.Start(), 而是从一个或多个事件源合并读取 hook.Event:
+//   - HookSource    : 基于 gohook 的真实键盘/鼠标事件(与旧行为一致);
+//   - InjectSource  : 进程内注入器, 由 POST /input/inject 与 JSONL 回放(Replayer)驱动。
+//
+// 事件源之后的处理流程(按 keycode/button 分发到专属 goroutine、macOS 鼠标侧键的切换兼容、SSE 广播)
+// 对所有事件源完全一致, 因此注入的事件与真实硬件事件走的是同一条路径。
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	hook "github.com/robotn/gohook"
+)
+
+// EventSource 为输入事件源。
+type EventSource interface {
+	// Start 开始产生事件; 返回的通道在事件源停止后关闭。
+	Start() <-chan hook.Event
+	// Stop 停止事件源。
+	Stop()
+}
+
+// 输入事件源名称(与 main.go 中 -inputSource 参数取值一致)
+const (
+	// InputSourceHook 同时启用 gohook 与注入器(默认)。
+	InputSourceHook = "hook"
+	// InputSourceInject 仅启用注入器, 用于无输入设备/无图形环境的机器(如 CI)。
+	InputSourceInject = "inject"
+)
+
+// gohook 中与本模块相关的事件类型, 详见 KeyEventListen 中的说明。
+const (
+	kindKeyHold   uint8 = hook.KeyHold
+	kindKeyUp     uint8 = hook.KeyUp
+	kindMouseHold uint8 = hook.MouseHold
+	kindMouseDown uint8 = hook.MouseDown
+)
+
+//region gohook 事件源
+
+// HookSource 基于 gohook 读取真实的键盘/鼠标事件。
+type HookSource struct{}
+
+func (HookSource) Start() <-chan hook.Event {
+	return hook.Start()
+}
+
+func (HookSource) Stop() {
+	hook.End()
+}
+
+//endregion gohook 事件源
+
+//region 注入事件源
+
+// InjectSource 是进程内的事件注入器, 注入的事件与 gohook 产生的事件格式完全一致。
+type InjectSource struct {
+	mutex   sync.Mutex
+	events  chan hook.Event
+	stopped bool
+}
+
+func NewInjectSource() *InjectSource {
+	return &InjectSource{events: make(chan hook.Event, 256)}
+}
+
+func (s *InjectSource) Start() <-chan hook.Event {
+	return s.events
+}
+
+func (s *InjectSource) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.events)
+	}
+}
+
+// InjectRaw 注入一个原始的 gohook 事件。
+func (s *InjectSource) InjectRaw(ev hook.Event) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return errors.New("inject source has been stopped")
+	}
+	s.events <- ev
+	return nil
+}
+
+// Inject 注入一个高层输入事件。
+func (s *InjectSource) Inject(event InputEvent) error {
+	ev, err := event.ToHookEvent()
+	if err != nil {
+		return err
+	}
+	return s.InjectRaw(ev)
+}
+
+// Injector 为全局注入器, 由 KeyEventListen 自动接入, 供 /input/inject 与回放使用。
+var Injector = NewInjectSource()
+
+//endregion 注入事件源
+
+//region 高层输入事件
+
+// 输入设备类型
+const (
+	DeviceKeyboard = "keyboard"
+	DeviceMouse    = "mouse"
+)
+
+// InputEvent 为与 gohook 解耦的高层输入事件, 同时也是 JSONL 录制文件中每一行的格式。
+//   - 键盘: {"device":"keyboard","keycode":30,"state":"down"}
+//   - 鼠标: {"device":"mouse","button":1,"state":"up"}
+//
+// Kind 可选, 非 0 时直接作为 gohook 的事件类型使用(忽略 State),
+// 用于精确复现平台相关的原始事件序列(如 macOS 上鼠标侧键按下与抬起都只产生 MouseHold)。
+type InputEvent struct {
+	// TimeMS 为相对录制开始时刻的毫秒数, 仅在录制/回放时使用。
+	TimeMS  int64  `json:"t_ms,omitempty"`
+	Device  string `json:"device"`
+	Keycode uint16 `json:"keycode,omitempty"`
+	Button  uint16 `json:"button,omitempty"`
+	State   string `json:"state,omitempty"`
+	Kind    uint8  `json:"kind,omitempty"`
+}
+
+// ToHookEvent 将高层输入事件转换为 gohook 事件。
+func (e InputEvent) ToHookEvent() (hook.Event, error) {
+	device := strings.ToLower(strings.TrimSpace(e.Device))
+	if device == "" {
+		if e.Button != 0 && e.Keycode == 0 {
+			device = DeviceMouse
+		} else {
+			device = DeviceKeyboard
+		}
+	}
+
+	ev := hook.Event{Kind: e.Kind}
+	switch device {
+	case DeviceKeyboard:
+		// Keycode 为 0 的按键会被 KeyEventListen 视为鼠标事件, 因此必须拒绝。
+		if e.Keycode == 0 {
+			return hook.Event{}, errors.New("keyboard event requires a non-zero keycode")
+		}
+		ev.Keycode = e.Keycode
+		if ev.Kind == 0 {
+			switch e.State {
+			case "down":
+				ev.Kind = kindKeyHold
+			case "up":
+				ev.Kind = kindKeyUp
+			default:
+				return hook.Event{}, fmt.Errorf("unsupported keyboard state: %q", e.State)
+			}
+		}
+	case DeviceMouse:
+		if e.Button == 0 {
+			return hook.Event{}, errors.New("mouse event requires a non-zero button")
+		}
+		ev.Button = e.Button
+		if ev.Kind == 0 {
+			switch e.State {
+			case "down":
+				ev.Kind = kindMouseHold
+			case "up":
+				ev.Kind = kindMouseDown
+			default:
+				return hook.Event{}, fmt.Errorf("unsupported mouse state: %q", e.State)
+			}
+		}
+	default:
+		return hook.Event{}, fmt.Errorf("unsupported input device: %q", e.Device)
+	}
+	return ev, nil
+}
+
+// inputEventFromHook 将 KeyEventListen 实际分发的 gohook 事件转换为高层输入事件(供录制使用)。
+// 原始事件类型保存在 Kind 中, 以便回放时完全复现。
+func inputEventFromHook(ev hook.Event) InputEvent {
+	event := InputEvent{Kind: ev.Kind}
+	if ev.Keycode != 0 {
+		event.Device = DeviceKeyboard
+		event.Keycode = ev.Keycode
+		if ev.Kind == kindKeyUp {
+			event.State = "up"
+		} else {
+			event.State = "down"
+		}
+	} else {
+		event.Device = DeviceMouse
+		event.Button = ev.Button
+		if ev.Kind == kindMouseDown {
+			event.State = "up"
+		} else {
+			event.State = "down"
+		}
+	}
+	return event
+}
+
+//endregion 高层输入事件
+
+// mergeEventSources 启动所有事件源, 并将它们的事件合并到同一个通道。
+// 所有事件源停止后, 合并通道关闭。
+func mergeEventSources(sources ...EventSource) <-chan hook.Event {
+	merged := make(chan hook.Event)
+	var wait sync.WaitGroup
+	for _, source := range sources {
+		wait.Add(1)
+		go func(events <-chan hook.Event) {
+			defer wait.Done()
+			for ev := range events {
+				merged <- ev
+			}
+		}(source.Start())
+	}
+	go func() {
+		wait.Wait()
+		close(merged)
+	}()
+	return merged
+}
+
+// EventSourcesByName 按名称返回 KeyEventListenWith 所需的事件源列表。
+func EventSourcesByName(name string) ([]EventSource, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", InputSourceHook:
+		return []EventSource{HookSource{}, Injector}, nil
+	case InputSourceInject:
+		return []EventSource{Injector}, nil
+	default:
+		return nil, fmt.Errorf("unsupported input source: %s", name)
+	}
+}
@@ -0,0 +1,230 @@
+package keyEvent
+
+import (
+	"KeyTone/keySound"
+	"bytes"
+	"context"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	hook "github.com/robotn/gohook"
+)
+
+// handledEvent 记录一次 keySoundHandler 调用。
+type handledEvent struct {
+	State     string
+	Keycode   string
+	Modifiers keySound.Modifiers
+}
+
+// captureKeySoundHandler 返回记录每次 keySoundHandler 调用的依赖(未配置 hold, 不统计打字速度),
+// 以及读取已记录调用的函数。
+func captureKeySoundHandler() (keyEventDeps, func() []handledEvent) {
+	var mutex sync.Mutex
+	handled := make([]handledEvent, 0)
+	deps := keyEventDeps{
+		keySoundHandler: func(keyState string, keycode string, modifiers keySound.Modifiers, speed keySound.TypingSpeed) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			handled = append(handled, handledEvent{State: keyState, Keycode: keycode, Modifiers: modifiers})
+		},
+		holdConfigResolver: func(string) (keySound.HoldConfig, bool) { return keySound.HoldConfig{}, false },
+		typingSpeedRecorder: func(string, keySound.Modifiers, time.Time) keySound.TypingSpeed {
+			return keySound.TypingSpeed{IntervalMS: -1}
+		},
+		typingSpeedReader: func() keySound.TypingSpeed { return keySound.TypingSpeed{IntervalMS: -1} },
+	}
+	return deps, func() []handledEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]handledEvent(nil), handled...)
+	}
+}
+
+// waitHandled 等待处理函数被调用指定次数(处理在独立 goroutine 中异步完成)。
+func waitHandled(t *testing.T, handled func() []handledEvent, count int) []handledEvent {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if events := handled(); len(events) >= count {
+			return events
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d handled events, got %v", count, handled())
+	return nil
+}
+
+// TestInputEventToHookEvent 验证高层输入事件到 gohook 事件类型的映射。
+func TestInputEventToHookEvent(t *testing.T) {
+	cases := []struct {
+		event InputEvent
+		want  hook.Event
+	}{
+		{InputEvent{Device: DeviceKeyboard, Keycode: 30, State: "down"}, hook.Event{Kind: hook.KeyHold, Keycode: 30}},
+		{InputEvent{Device: DeviceKeyboard, Keycode: 30, State: "up"}, hook.Event{Kind: hook.KeyUp, Keycode: 30}},
+		{InputEvent{Device: DeviceMouse, Button: 1, State: "down"}, hook.Event{Kind: hook.MouseHold, Button: 1}},
+		{InputEvent{Button: 4, State: "up"}, hook.Event{Kind: hook.MouseDown, Button: 4}},
+		{InputEvent{Device: DeviceMouse, Button: 4, Kind: hook.MouseHold, State: "up"}, hook.Event{Kind: hook.MouseHold, Button: 4}},
+	}
+	for _, testCase := range cases {
+		got, err := testCase.event.ToHookEvent()
+		if err != nil {
+			t.Fatalf("ToHookEvent(%+v) returned error: %v", testCase.event, err)
+		}
+		if !reflect.DeepEqual(got, testCase.want) {
+			t.Fatalf("ToHookEvent(%+v) = %+v, want %+v", testCase.event, got, testCase.want)
+		}
+	}
+
+	for _, invalid := range []InputEvent{
+		{Device: DeviceKeyboard, State: "down"},
+		{Device: DeviceKeyboard, Keycode: 30, State: "press"},
+		{Device: "pen", Keycode: 30, State: "down"},
+	} {
+		if _, err := invalid.ToHookEvent(); err == nil {
+			t.Fatalf("expected error for %+v", invalid)
+		}
+	}
+}
+
+// TestInjectedEventsFollowHardwarePath 验证注入事件走与硬件事件相同的分发逻辑:
+// 按住期间重复的 KeyHold 只触发一次 down, 鼠标按键以负数 keycode 分发。
+func TestInjectedEventsFollowHardwarePath(t *testing.T) {
+	deps, handled := captureKeySoundHandler()
+	source := NewInjectSource()
+	done := make(chan struct{})
+	go func() {
+		listenKeyEvents(deps, source)
+		close(done)
+	}()
+
+	for _, event := range []InputEvent{
+		{Device: DeviceKeyboard, Keycode: 30, State: "down"},
+		{Device: DeviceKeyboard, Keycode: 30, State: "down"},
+		{Device: DeviceKeyboard, Keycode: 30, State: "up"},
+	} {
+		if err := source.Inject(event); err != nil {
+			t.Fatalf("Inject returned error: %v", err)
+		}
+	}
+	keyboard := waitHandled(t, handled, 2)
+
+	if err := source.Inject(InputEvent{Device: DeviceMouse, Button: 1, State: "down"}); err != nil {
+		t.Fatalf("Inject returned error: %v", err)
+	}
+	events := waitHandled(t, handled, 3)
+	source.Stop()
+	<-done
+
+	// 同一按键的事件在其专属 goroutine 中按顺序处理, 但 handler 本身异步调用, 因此只比较集合。
+	if len(keyboard) != 2 || len(events) != 3 {
+		t.Fatalf("unexpected handled events: %v", events)
+	}
+	want := map[handledEvent]bool{
+		{State: "down", Keycode: "30"}: true,
+		{State: "up", Keycode: "30"}:   true,
+		{State: "down", Keycode: "-1"}: true,
+	}
+	for _, event := range events {
+		if !want[event] {
+			t.Fatalf("unexpected handled event: %+v", event)
+		}
+		delete(want, event)
+	}
+}
+
+// TestRecordingRoundTrip 验证录制写出的 JSONL 能被读回, 且保留原始事件类型。
+func TestRecordingRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.jsonl")
+	if err := StartRecording(path); err != nil {
+		t.Fatalf("StartRecording returned error: %v", err)
+	}
+	if err := StartRecording(path); err == nil {
+		t.Fatal("expected error when starting a second recording")
+	}
+	recordEvent(hook.Event{Kind: hook.KeyHold, Keycode: 30})
+	recordEvent(hook.Event{Kind: hook.MouseHold, Button: 4})
+	count, err := StopRecording()
+	if err != nil || count != 2 {
+		t.Fatalf("StopRecording = %d, %v", count, err)
+	}
+
+	events, err := LoadRecording(path)
+	if err != nil {
+		t.Fatalf("LoadRecording returned error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unexpected event count: %d", len(events))
+	}
+	if events[0].Device != DeviceKeyboard || events[0].Keycode != 30 || events[0].State != "down" {
+		t.Fatalf("unexpected keyboard event: %+v", events[0])
+	}
+	ev, err := events[1].ToHookEvent()
+	if err != nil || ev.Kind != hook.MouseHold || ev.Button != 4 {
+		t.Fatalf("unexpected mouse event: %+v, %v", ev, err)
+	}
+}
+
+// TestRecordingPathStaysInRecordingDir 验证录制文件名只能解析到录制目录下。
+func TestRecordingPathStaysInRecordingDir(t *testing.T) {
+	SetRecordingDir("")
+	if _, err := RecordingPath("input.jsonl"); err == nil {
+		t.Fatal("expected error without a recording directory")
+	}
+
+	dir := t.TempDir()
+	SetRecordingDir(dir)
+	t.Cleanup(func() { SetRecordingDir("") })
+	path, err := RecordingPath(" input.jsonl ")
+	if err != nil || path != filepath.Join(dir, "input.jsonl") {
+		t.Fatalf("RecordingPath = %q, %v", path, err)
+	}
+	for _, name := range []string{"", "..", "../input.jsonl", "a/input.jsonl", `a\input.jsonl`, "/etc/passwd", "C:input.jsonl", "a..jsonl"} {
+		if _, err := RecordingPath(name); err == nil {
+			t.Fatalf("expected %q to be rejected", name)
+		}
+	}
+}
+
+// TestReplayHonoursSpeedAndCancellation 验证回放按倍速缩放时间间隔, 并可被取消。
+func TestReplayHonoursSpeedAndCancellation(t *testing.T) {
+	events, err := ReadRecording(bytes.NewBufferString(
+		`{"t_ms":0,"keycode":30,"state":"down"}` + "\n\n" +
+			`{"t_ms":200,"keycode":30,"state":"up"}` + "\n"))
+	if err != nil {
+		t.Fatalf("ReadRecording returned error: %v", err)
+	}
+
+	emitted := 0
+	start := time.Now()
+	err = Replay(context.Background(), events, 4, func(InputEvent) error {
+		emitted++
+		return nil
+	})
+	elapsed := time.Since(start)
+	if err != nil || emitted != 2 {
+		t.Fatalf("Replay = %v, emitted %d", err, emitted)
+	}
+	if elapsed < 40*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("unexpected replay duration at 4x speed: %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	emitted = 0
+	err = Replay(ctx, events, 1, func(InputEvent) error {
+		emitted++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled || emitted != 1 {
+		t.Fatalf("expected cancellation after first event, got %v, emitted %d", err, emitted)
+	}
+
+	if _, err := ReadRecording(bytes.NewBufferString(`{"t_ms":0,"device":"keyboard","state":"down"}`)); err == nil {
+		t.Fatal("expected error for invalid recording line")
+	}
+}
@@ -24,25 +24,78 @@ import (
 	"fmt"
 	"runtime"
 	"sync"
+	"time"
 
 	hook "github.com/robotn/gohook"
 )
 
 // 定义sse相关变量
 type Store struct {
-	Keycode any `json:"keycode"`
+	Keycode any    `json:"keycode"`
 	State   string `json:"state"`
+	// TypingSpeed 仅随键盘按下事件推送: 当前打字速度与命中的速度档位(见 keySound/typing_speed.go)
+	TypingSpeed *keySound.TypingSpeed `json:"typingSpeed,omitempty"`
+	// Modifiers 为事件发生时按住的修饰键(不含按键自身), 如 ["ctrl", "shift"]
+	Modifiers []string `json:"modifiers,omitempty"`
 }
 
 var Clients_sse_stores sync.Map
 var once_stores sync.Once
 
+// keyEventDeps 为按键事件处理所依赖的 keySound 函数。
+// 监听开始时取得一份, 随后传给每个按键的 goroutine, 处理过程中不读取任何可替换的包级变量;
+// 测试因此可以为每次监听注入独立的替身, 而不必在其他 goroutine 仍在运行时替换全局函数。
+type keyEventDeps struct {
+	// keySoundHandler 为实际触发键音播放的函数, speed 为分发事件时的打字速度(按下事件为本次按下更新后的速度)
+	keySoundHandler func(keyState string, keycode string, modifiers keySound.Modifiers, speed keySound.TypingSpeed)
+	// holdConfigResolver 返回按键当前生效的 hold 配置
+	holdConfigResolver func(keycode string) (keySound.HoldConfig, bool)
+	// typingSpeedRecorder 记录键盘按下的时间戳以更新打字速度
+	typingSpeedRecorder func(keycode string, modifiers keySound.Modifiers, at time.Time) keySound.TypingSpeed
+	// typingSpeedReader 返回当前的打字速度, 供抬起、hold 与鼠标事件选择速度档位
+	typingSpeedReader func() keySound.TypingSpeed
+}
+
+// liveKeyEventDeps 为实际监听使用的依赖。
+var liveKeyEventDeps = keyEventDeps{
+	keySoundHandler:     keySound.KeySoundHandlerWith,
+	holdConfigResolver:  keySound.ResolveHoldConfig,
+	typingSpeedRecorder: keySound.RecordTypingKeyDown,
+	typingSpeedReader:   keySound.GetTypingSpeed,
+}
+
+// KeyEventListen 使用默认事件源(gohook + 注入器)监听输入事件。
 func KeyEventListen() {
-	evChan := hook.Start()
-	defer hook.End()
+	sources, _ := EventSourcesByName(InputSourceHook)
+	KeyEventListenWith(sources...)
+}
 
-	keycode_keycodeChan_map := make(map[uint16]chan hook.Event)
-	keycode_buttonChan_map := make(map[uint16]chan hook.Event)
+// KeyEventListenWith 从给定的事件源合并读取输入事件, 并分发给各按键专属的 goroutine。
+// 所有事件源停止后返回。
+func KeyEventListenWith(sources ...EventSource) {
+	listenKeyEvents(liveKeyEventDeps, sources...)
+}
+
+// keyDispatch 为分发给按键专属 goroutine 的事件, 附带读取该事件时按住的修饰键。
+type keyDispatch struct {
+	hook.Event
+	modifiers keySound.Modifiers
+}
+
+// listenKeyEvents 为 KeyEventListenWith 的实现, deps 会传给每个按键的 goroutine。
+func listenKeyEvents(deps keyEventDeps, sources ...EventSource) {
+	evChan := mergeEventSources(sources...)
+	defer func() {
+		for _, source := range sources {
+			source.Stop()
+		}
+	}()
+
+	// 修饰键状态只属于本次监听, 读取循环之外没有任何 goroutine 访问它
+	modifierState := newModifierTracker()
+
+	keycode_keycodeChan_map := make(map[uint16]chan keyDispatch)
+	keycode_buttonChan_map := make(map[uint16]chan keyDispatch)
 
 	for ev := range evChan {
 		// println("keyAll=", ev.String(), "|||||", ev.Keycode)
@@ -70,17 +123,21 @@ func KeyEventListen() {
 				// 	println("down")
 				// 	println(ev.Keycode) // 按下时, 由于goHook的bug, 故无法判断实际的Keycode, 因此我们不使用这个事件。
 				// }
+				recordEvent(ev)
+				// 先于分发更新修饰键状态, 并在此刻取得快照随事件传递, 使随后到达的按键能读到它
+				modifierState.update(ev)
+				dispatch := keyDispatch{Event: ev, modifiers: modifierState.snapshot(fmt.Sprint(ev.Keycode))}
 				if _, exists := keycode_keycodeChan_map[ev.Keycode]; exists {
 					// logger.Debug("此时已经有了处理此按键发音的通道与其专用的goroutine, 因此无需进行任何创建操作, 只需要向其传递最新的事件信号即可")
-					keycode_keycodeChan_map[ev.Keycode] <- ev
+					keycode_keycodeChan_map[ev.Keycode] <- dispatch
 				} else {
 					// logger.Debug("此时还没有处理此按键发音的通道与其专用的goroutine, 因此需进行相关的创建操作, 并在创建后向其传递最新的事件信号")
 					// 创建此按键的专属通道channel
-					keycode_keycodeChan_map[ev.Keycode] = make(chan hook.Event)
+					keycode_keycodeChan_map[ev.Keycode] = make(chan keyDispatch)
 					// 创建此按键专属 按键事件处理 的 goroutine
-					go handleKeyEvent(keycode_keycodeChan_map[ev.Keycode])
+					go handleKeyEvent(keycode_keycodeChan_map[ev.Keycode], deps)
 					// 将本次按键事件传递至相关通道channel
-					keycode_keycodeChan_map[ev.Keycode] <- ev
+					keycode_keycodeChan_map[ev.Keycode] <- dispatch
 
 				}
 			}
@@ -89,14 +146,16 @@ func KeyEventListen() {
 			if ev.Kind == 7 || ev.Kind == 8 {
 
 				// println("keyAll=", ev.String(), "|||||", ev.Keycode)
+				recordEvent(ev)
+				dispatch := keyDispatch{Event: ev, modifiers: modifierState.snapshot("-" + fmt.Sprint(ev.Button))}
 				if _, exists := keycode_buttonChan_map[ev.Button]; exists {
-					keycode_buttonChan_map[ev.Button] <- ev
+					keycode_buttonChan_map[ev.Button] <- dispatch
 				} else {
-					keycode_buttonChan_map[ev.Button] = make(chan hook.Event)
+					keycode_buttonChan_map[ev.Button] = make(chan keyDispatch)
 					// 创建此按键专属 按键事件处理 的 goroutine
-					go handleKeyEvent(keycode_buttonChan_map[ev.Button])
+					go handleKeyEvent(keycode_buttonChan_map[ev.Button], deps)
 					// 将本次按键事件传递至相关通道channel
-					keycode_buttonChan_map[ev.Button] <- ev
+					keycode_buttonChan_map[ev.Button] <- dispatch
 				}
 
 			}
@@ -105,9 +164,11 @@ func KeyEventListen() {
 	}
 }
 
-func handleKeyEvent(evChan chan hook.Event) {
+func handleKeyEvent(evChan chan keyDispatch, deps keyEventDeps) {
 
 	var key_down_soundIsRun bool = false
+	// 合成重复(hold.interval_ms > 0)进行中时非 nil, 抬起时关闭以停止重复
+	var holdStop chan struct{}
 
 	var mouse_button_down bool = false
 
@@ -132,12 +193,28 @@ func handleKeyEvent(evChan chan hook.Event) {
 				// }, nil)
 				// go keySound.KeyDownSoundPlay()
 
-				go keySound.KeySoundHandler(keySound.KeyStateDown, fmt.Sprint(ev.Keycode))
+				// 先更新打字速度, 并将其随事件传给处理函数, 使本次按下的音效按刚算出的速度档位选择
+				modifiers := ev.modifiers
+				speed := deps.typingSpeedRecorder(fmt.Sprint(ev.Keycode), modifiers, time.Now())
+				go deps.keySoundHandler(keySound.KeyStateDown, fmt.Sprint(ev.Keycode), modifiers, speed)
 				key_down_soundIsRun = true
 				go sseBroadcast(&Clients_sse_stores, &Store{
-					Keycode: ev.Keycode,
-					State:   keySound.KeyStateDown,
+					Keycode:     ev.Keycode,
+					State:       keySound.KeyStateDown,
+					TypingSpeed: &speed,
+					Modifiers:   modifiers.Names(),
 				})
+
+				// 配置了合成重复间隔时, 不再依赖系统自动重复(其延迟与频率由操作系统决定), 而是自行按间隔触发 hold
+				if hold, ok := deps.holdConfigResolver(fmt.Sprint(ev.Keycode)); ok && hold.Interval > 0 {
+					holdStop = make(chan struct{})
+					go key_hold_repeat(ev.Keycode, modifiers, hold.Interval, holdStop, deps)
+				}
+			} else if holdStop == nil {
+				// 按住期间系统自动重复产生的 KeyHold: 仅在专辑配置了 hold 且未使用合成重复时播放 hold 声音
+				if _, ok := deps.holdConfigResolver(fmt.Sprint(ev.Keycode)); ok {
+					key_hold(ev.Keycode, ev.modifiers, deps)
+				}
 			}
 		}
 
@@ -163,13 +240,19 @@ func handleKeyEvent(evChan chan hook.Event) {
 			// 	SS: "test_up.MP3",
 			// }, nil) // 注意, 若第二个参数为nil, 则不论多长的音频, 都会全量播放
 			// go keySound.KeyUpSoundPlay()
-			go keySound.KeySoundHandler(keySound.KeyStateUp, fmt.Sprint(ev.Keycode))
+			modifiers := ev.modifiers
+			go deps.keySoundHandler(keySound.KeyStateUp, fmt.Sprint(ev.Keycode), modifiers, deps.typingSpeedReader())
 
 			key_down_soundIsRun = false
+			if holdStop != nil {
+				close(holdStop)
+				holdStop = nil
+			}
 
 			go sseBroadcast(&Clients_sse_stores, &Store{
-				Keycode: ev.Keycode,
-				State:   keySound.KeyStateUp,
+				Keycode:   ev.Keycode,
+				State:     keySound.KeyStateUp,
+				Modifiers: modifiers.Names(),
 			})
 		}
 
@@ -180,20 +263,20 @@ func handleKeyEvent(evChan chan hook.Event) {
 
 			if os := runtime.GOOS; os == "darwin" && ev.Button > 2 {
 				if mouse_button_down {
-					mouse_up(ev)
+					mouse_up(ev, deps)
 					mouse_button_down = false
 				} else {
-					mouse_down(ev)
+					mouse_down(ev, deps)
 					mouse_button_down = true
 				}
 			} else {
-				mouse_down(ev)
+				mouse_down(ev, deps)
 			}
 		}
 
 		if ev.Kind == 8 {
 			// println("buttonUp=", ev.Button)
-			mouse_up(ev)
+			mouse_up(ev, deps)
 
 		}
 	}
@@ -215,7 +298,31 @@ func sseBroadcast(Clients_sse_stores *sync.Map, store *Store) {
 	})
 }
 
-func mouse_down(ev hook.Event) {
+// key_hold 触发一次按键的 hold 声音, 并向前端广播 hold 状态。
+func key_hold(keycode uint16, modifiers keySound.Modifiers, deps keyEventDeps) {
+	go deps.keySoundHandler(keySound.KeyStateHold, fmt.Sprint(keycode), modifiers, deps.typingSpeedReader())
+	go sseBroadcast(&Clients_sse_stores, &Store{
+		Keycode:   keycode,
+		State:     keySound.KeyStateHold,
+		Modifiers: modifiers.Names(),
+	})
+}
+
+// key_hold_repeat 按 interval 合成 hold 重复, 直至 stop 被关闭(按键抬起); 每次重复沿用按下时的修饰键。
+func key_hold_repeat(keycode uint16, modifiers keySound.Modifiers, interval time.Duration, stop chan struct{}, deps keyEventDeps) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			key_hold(keycode, modifiers, deps)
+		}
+	}
+}
+
+func mouse_down(ev keyDispatch, deps keyEventDeps) {
 	println("")
 	println("")
 	println("=====down=====")
@@ -226,15 +333,17 @@ func mouse_down(ev hook.Event) {
 	println("=====down=====")
 	println("")
 
-	go keySound.KeySoundHandler(keySound.KeyStateDown, "-"+fmt.Sprint(ev.Button))
+	modifiers := ev.modifiers
+	go deps.keySoundHandler(keySound.KeyStateDown, "-"+fmt.Sprint(ev.Button), modifiers, deps.typingSpeedReader())
 	// mouse_key_down_soundIsRun = true
 	go sseBroadcast(&Clients_sse_stores, &Store{
-		Keycode: -int32(ev.Button),
-		State:   keySound.KeyStateDown,
+		Keycode:   -int32(ev.Button),
+		State:     keySound.KeyStateDown,
+		Modifiers: modifiers.Names(),
 	})
 }
 
-func mouse_up(ev hook.Event) {
+func mouse_up(ev keyDispatch, deps keyEventDeps) {
 	println("")
 	println("")
 	println("======up======")
@@ -245,10 +354,12 @@ func mouse_up(ev hook.Event) {
 	println("======up======")
 	println("")
 
-	go keySound.KeySoundHandler(keySound.KeyStateUp, "-"+fmt.Sprint(ev.Button))
+	modifiers := ev.modifiers
+	go deps.keySoundHandler(keySound.KeyStateUp, "-"+fmt.Sprint(ev.Button), modifiers, deps.typingSpeedReader())
 
 	go sseBroadcast(&Clients_sse_stores, &Store{
-		Keycode: -int32(ev.Button),
-		State:   keySound.KeyStateUp,
+		Keycode:   -int32(ev.Button),
+		State:     keySound.KeyStateUp,
+		Modifiers: modifiers.Names(),
 	})
 }
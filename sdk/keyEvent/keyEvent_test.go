@@ -0,0 +1,182 @@
+package keyEvent
+
+import (
+	"KeyTone/keySound"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	hook "github.com/robotn/gohook"
+)
+
+// withHoldConfig 返回使所有按键都配置了 hold 的依赖。
+func withHoldConfig(deps keyEventDeps, interval time.Duration) keyEventDeps {
+	deps.holdConfigResolver = func(string) (keySound.HoldConfig, bool) {
+		return keySound.HoldConfig{Interval: interval}, true
+	}
+	return deps
+}
+
+func countStates(events []handledEvent) map[string]int {
+	counts := map[string]int{}
+	for _, event := range events {
+		counts[event.State]++
+	}
+	return counts
+}
+
+// TestHoldFollowsAutoRepeat 验证未设置合成间隔时, 按住期间的每次系统自动重复都会触发一次 hold。
+func TestHoldFollowsAutoRepeat(t *testing.T) {
+	deps, handled := captureKeySoundHandler()
+	evChan := make(chan keyDispatch)
+	go handleKeyEvent(evChan, withHoldConfig(deps, 0))
+
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyUp, Keycode: 30}}
+	close(evChan)
+
+	counts := countStates(waitHandled(t, handled, 4))
+	if counts[keySound.KeyStateDown] != 1 || counts[keySound.KeyStateHold] != 2 || counts[keySound.KeyStateUp] != 1 {
+		t.Fatalf("unexpected handled states: %v", counts)
+	}
+}
+
+// TestHoldSyntheticRepeat 验证设置合成间隔后忽略系统自动重复, 按间隔触发 hold, 并在抬起后停止。
+func TestHoldSyntheticRepeat(t *testing.T) {
+	deps, handled := captureKeySoundHandler()
+	evChan := make(chan keyDispatch)
+	go handleKeyEvent(evChan, withHoldConfig(deps, 20*time.Millisecond))
+	defer close(evChan)
+
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	for i := 0; i < 5; i++ {
+		evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	}
+	waitHandled(t, handled, 4)
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyUp, Keycode: 30}}
+
+	// up 之前可能还有已触发的 hold, 因此等待 up 出现后再取快照
+	deadline := time.Now().Add(2 * time.Second)
+	events := handled()
+	for countStates(events)[keySound.KeyStateUp] == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		events = handled()
+	}
+	time.Sleep(80 * time.Millisecond)
+	after := handled()
+	if countStates(after)[keySound.KeyStateHold] != countStates(events)[keySound.KeyStateHold] {
+		t.Fatalf("hold kept repeating after key up: %v -> %v", countStates(events), countStates(after))
+	}
+	if counts := countStates(after); counts[keySound.KeyStateDown] != 1 || counts[keySound.KeyStateHold] < 3 {
+		t.Fatalf("unexpected handled states: %v", counts)
+	}
+}
+
+// TestHoldDisabledByDefault 验证专辑未配置 hold 时, 按住期间的重复事件不会触发任何声音(保持旧行为)。
+func TestHoldDisabledByDefault(t *testing.T) {
+	deps, handled := captureKeySoundHandler()
+	evChan := make(chan keyDispatch)
+	go handleKeyEvent(evChan, deps)
+
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	evChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyUp, Keycode: 30}}
+	close(evChan)
+
+	time.Sleep(50 * time.Millisecond)
+	if counts := countStates(waitHandled(t, handled, 2)); counts[keySound.KeyStateHold] != 0 || len(handled()) != 2 {
+		t.Fatalf("unexpected handled states: %v", counts)
+	}
+}
+
+// TestTypingSpeedRecordedOnKeyboardDown 验证打字速度仅在键盘按下时记录, 自动重复、抬起与鼠标按键均不计入。
+func TestTypingSpeedRecordedOnKeyboardDown(t *testing.T) {
+	deps, handled := captureKeySoundHandler()
+	deps = withHoldConfig(deps, 0)
+	var mutex sync.Mutex
+	var recorded []string
+	deps.typingSpeedRecorder = func(keycode string, modifiers keySound.Modifiers, at time.Time) keySound.TypingSpeed {
+		mutex.Lock()
+		defer mutex.Unlock()
+		recorded = append(recorded, keycode)
+		return keySound.TypingSpeed{IntervalMS: 42, Tier: "fast"}
+	}
+	// 记录处理函数收到的速度档位, 键盘按下应使用记录时算出的档位, 其余事件使用当前速度
+	var tiers []string
+	capture := deps.keySoundHandler
+	deps.keySoundHandler = func(keyState string, keycode string, modifiers keySound.Modifiers, speed keySound.TypingSpeed) {
+		mutex.Lock()
+		tiers = append(tiers, keyState+":"+keycode+":"+speed.Tier)
+		mutex.Unlock()
+		capture(keyState, keycode, modifiers, speed)
+	}
+
+	keyChan := make(chan keyDispatch)
+	mouseChan := make(chan keyDispatch)
+	go handleKeyEvent(keyChan, deps)
+	go handleKeyEvent(mouseChan, deps)
+
+	keyChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	keyChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	keyChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyUp, Keycode: 30}}
+	keyChan <- keyDispatch{Event: hook.Event{Kind: hook.KeyHold, Keycode: 30}}
+	mouseChan <- keyDispatch{Event: hook.Event{Kind: hook.MouseHold, Button: 1}}
+	close(keyChan)
+	close(mouseChan)
+
+	waitHandled(t, handled, 5)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(recorded) != 2 || recorded[0] != "30" || recorded[1] != "30" {
+		t.Fatalf("unexpected recorded key downs: %v", recorded)
+	}
+	sort.Strings(tiers)
+	if want := []string{"down:-1:", "down:30:fast", "down:30:fast", "hold:30:", "up:30:"}; !reflect.DeepEqual(tiers, want) {
+		t.Fatalf("unexpected typing speed tiers %v, want %v", tiers, want)
+	}
+}
+
+// TestModifiersFollowInjectedEvents 验证修饰键状态在分发前更新: Ctrl 按住期间的 S 携带 ctrl,
+// 修饰键自身不计入, 抬起后不再携带。
+func TestModifiersFollowInjectedEvents(t *testing.T) {
+	deps, handled := captureKeySoundHandler()
+	source := NewInjectSource()
+	done := make(chan struct{})
+	go func() {
+		listenKeyEvents(deps, source)
+		close(done)
+	}()
+
+	for _, event := range []InputEvent{
+		{Device: DeviceKeyboard, Keycode: 29, State: "down"},
+		{Device: DeviceKeyboard, Keycode: 31, State: "down"},
+		{Device: DeviceKeyboard, Keycode: 31, State: "up"},
+		{Device: DeviceKeyboard, Keycode: 29, State: "up"},
+		{Device: DeviceKeyboard, Keycode: 31, State: "down"},
+	} {
+		if err := source.Inject(event); err != nil {
+			t.Fatalf("Inject returned error: %v", err)
+		}
+	}
+	events := waitHandled(t, handled, 5)
+	source.Stop()
+	<-done
+
+	want := map[handledEvent]bool{
+		{State: "down", Keycode: "29"}:                                   true,
+		{State: "down", Keycode: "31", Modifiers: keySound.ModifierCtrl}: true,
+		{State: "up", Keycode: "31", Modifiers: keySound.ModifierCtrl}:   true,
+		{State: "up", Keycode: "29"}:                                     true,
+		{State: "down", Keycode: "31"}:                                   true,
+	}
+	for _, event := range events {
+		if !want[event] {
+			t.Fatalf("unexpected handled event: %+v (all: %+v)", event, events)
+		}
+		delete(want, event)
+	}
+}
@@ -0,0 +1,65 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keyEvent
+
+import (
+	"KeyTone/keySound"
+	"fmt"
+
+	hook "github.com/robotn/gohook"
+)
+
+// modifierTracker 记录当前按住的修饰键(按 keycode 区分左右两侧)。
+//
+// 修饰键状态只在 listenKeyEvents 的读取循环中更新与读取: 事件源按顺序读取, 每个事件在更新状态后立即取得快照,
+// 随事件一起交给各按键的 goroutine(见 keyDispatch), 因此 Ctrl 的按下一定先于 S 的按下被记录,
+// 而 S 携带的修饰键也不会受之后到达的事件影响。
+type modifierTracker struct {
+	held map[string]bool
+}
+
+func newModifierTracker() *modifierTracker {
+	return &modifierTracker{held: map[string]bool{}}
+}
+
+// update 根据一次键盘事件更新修饰键状态, 非修饰键被忽略。
+func (m *modifierTracker) update(ev hook.Event) {
+	keycode := fmt.Sprint(ev.Keycode)
+	if keySound.ModifierForKeycode(keycode) == 0 {
+		return
+	}
+	switch ev.Kind {
+	case hook.KeyHold:
+		m.held[keycode] = true
+	case hook.KeyUp:
+		delete(m.held, keycode)
+	}
+}
+
+// snapshot 返回当前按住的修饰键; keycode 自身是修饰键时不计入它自己(另一侧的同名修饰键仍会计入)。
+func (m *modifierTracker) snapshot(keycode string) keySound.Modifiers {
+	var modifiers keySound.Modifiers
+	for held := range m.held {
+		if held != keycode {
+			modifiers |= keySound.ModifierForKeycode(held)
+		}
+	}
+	return modifiers
+}
@@ -0,0 +1,283 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keyEvent
+
+// =============================
+// 输入事件录制与回放说明
+// =============================
+//
+// 录制文件为 JSONL 格式, 每行一个 InputEvent, 其中 t_ms 为相对录制开始时刻的毫秒数:
+//   {"t_ms":0,"device":"keyboard","keycode":30,"state":"down","kind":4}
+//   {"t_ms":86,"device":"keyboard","keycode":30,"state":"up","kind":5}
+//
+// 录制发生在事件合并之后、分发之前, 因此无论事件来自 gohook 还是注入器, 都会被原样记录。
+// 回放时按 t_ms 的间隔(可按倍速缩放)将事件注入 Injector, 与真实按键走同一条处理路径。
+//
+// 通过 HTTP 接口录制/回放时只接受文件名, 文件统一位于录制目录(SetRecordingDir)下, 见 RecordingPath。
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	hook "github.com/robotn/gohook"
+)
+
+//region 录制
+
+// Recorder 将分发的输入事件以 JSONL 格式写入文件。
+type Recorder struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+	count  int
+}
+
+// 当前正在进行的录制(同一时刻最多一个)。
+var (
+	activeRecorder      *Recorder
+	activeRecorderMutex sync.Mutex
+)
+
+// 录制目录(由 main 设置为配置目录下的 recordings)。
+var (
+	recordingDir      string
+	recordingDirMutex sync.Mutex
+)
+
+// SetRecordingDir 设置通过文件名访问的录制文件所在目录。
+func SetRecordingDir(dir string) {
+	recordingDirMutex.Lock()
+	defer recordingDirMutex.Unlock()
+	recordingDir = dir
+}
+
+// RecordingPath 将录制文件名解析为录制目录下的路径。
+// 名称中不允许出现路径分隔符与 "..", 以保证解析结果不会离开录制目录。
+func RecordingPath(name string) (string, error) {
+	recordingDirMutex.Lock()
+	dir := recordingDir
+	recordingDirMutex.Unlock()
+	if dir == "" {
+		return "", errors.New("recording directory is not configured")
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, `/\:`) || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid recording file name: %q", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// StartRecording 开始将输入事件录制到 path(文件已存在时会被覆盖, 所在目录不存在时会被创建)。
+func StartRecording(path string) error {
+	activeRecorderMutex.Lock()
+	defer activeRecorderMutex.Unlock()
+	if activeRecorder != nil {
+		return errors.New("a recording is already in progress")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create recording file: %w", err)
+	}
+	activeRecorder = &Recorder{file: file, writer: bufio.NewWriter(file), start: time.Now()}
+	return nil
+}
+
+// StopRecording 结束当前录制并返回录制的事件数量。
+func StopRecording() (int, error) {
+	activeRecorderMutex.Lock()
+	recorder := activeRecorder
+	activeRecorder = nil
+	activeRecorderMutex.Unlock()
+	if recorder == nil {
+		return 0, errors.New("no recording in progress")
+	}
+	return recorder.close()
+}
+
+// IsRecording 返回当前是否正在录制。
+func IsRecording() bool {
+	activeRecorderMutex.Lock()
+	defer activeRecorderMutex.Unlock()
+	return activeRecorder != nil
+}
+
+// recordEvent 在分发前被调用; 未在录制时不做任何事。
+func recordEvent(ev hook.Event) {
+	activeRecorderMutex.Lock()
+	recorder := activeRecorder
+	activeRecorderMutex.Unlock()
+	if recorder != nil {
+		recorder.write(ev)
+	}
+}
+
+func (r *Recorder) write(ev hook.Event) {
+	event := inputEventFromHook(ev)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.writer == nil {
+		return
+	}
+	event.TimeMS = time.Since(r.start).Milliseconds()
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	r.writer.Write(line)
+	r.writer.WriteByte('\n')
+	r.count++
+}
+
+func (r *Recorder) close() (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	flushErr := r.writer.Flush()
+	closeErr := r.file.Close()
+	r.writer = nil
+	if flushErr != nil {
+		return r.count, fmt.Errorf("failed to flush recording file: %w", flushErr)
+	}
+	if closeErr != nil {
+		return r.count, fmt.Errorf("failed to close recording file: %w", closeErr)
+	}
+	return r.count, nil
+}
+
+//endregion 录制
+
+//region 回放
+
+// ReadRecording 解析 JSONL 录制内容; 空行会被忽略。
+func ReadRecording(reader io.Reader) ([]InputEvent, error) {
+	events := make([]InputEvent, 0)
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Bytes()
+		if len(text) == 0 {
+			continue
+		}
+		var event InputEvent
+		if err := json.Unmarshal(text, &event); err != nil {
+			return nil, fmt.Errorf("invalid recording line %d: %w", line, err)
+		}
+		if _, err := event.ToHookEvent(); err != nil {
+			return nil, fmt.Errorf("invalid recording line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// LoadRecording 从文件读取 JSONL 录制。
+func LoadRecording(path string) ([]InputEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadRecording(file)
+}
+
+// Replay 按录制的时间间隔依次调用 emit。
+//   - speed: 回放倍速, 1 为原速, 2 为两倍速; 小于等于 0 时不等待, 立即依次发送。
+//
+// ctx 取消时立即停止并返回 ctx.Err()。
+func Replay(ctx context.Context, events []InputEvent, speed float64, emit func(InputEvent) error) error {
+	start := time.Now()
+	for _, event := range events {
+		if speed > 0 {
+			due := time.Duration(float64(event.TimeMS) * float64(time.Millisecond) / speed)
+			if wait := due - time.Since(start); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return ctx.Err()
+				case <-timer.C:
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 当前正在进行的回放(同一时刻最多一个)。
+var (
+	activeReplayCancel context.CancelFunc
+	activeReplayMutex  sync.Mutex
+)
+
+// StartReplay 在后台将录制事件按倍速注入 Injector; 若已有回放在进行, 会先将其停止。
+func StartReplay(events []InputEvent, speed float64) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	activeReplayMutex.Lock()
+	if activeReplayCancel != nil {
+		activeReplayCancel()
+	}
+	activeReplayCancel = cancel
+	activeReplayMutex.Unlock()
+
+	go func() {
+		Replay(ctx, events, speed, Injector.Inject)
+		cancel()
+	}()
+}
+
+// StopReplay 停止当前回放; 没有回放在进行时返回 false。
+func StopReplay() bool {
+	activeReplayMutex.Lock()
+	defer activeReplayMutex.Unlock()
+	if activeReplayCancel == nil {
+		return false
+	}
+	activeReplayCancel()
+	activeReplayCancel = nil
+	return true
+}
+
+//endregion 回放
@@ -0,0 +1,671 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 波形峰值与频谱图(供编辑器绘制)
+// =============================
+//
+// 编辑器原先通过 get_audio_stream 下载整个音频文件再在前端解码绘制, 长录音会很慢。
+// 这里在服务端用与播放完全相同的解码器(audio.Decode)计算峰值与频谱图, 时间到样本的换算也与
+// audio.PrepareCut 一致(原始采样率下的 SampleRate.N), 因此绘制结果与裁剪按样本对齐。
+//
+// 磁盘缓存(见 SetAnalysisCacheDir)按音频文件名(<sha256><type> 或处理后的文件)分目录:
+//
+//   <cacheDir>/<fileName>/stamp                 源文件的大小与修改时间, 不一致时整个目录失效
+//   <cacheDir>/<fileName>/peaks.bin             以 peaksBucketSize 个样本为一桶的逐声道 min/max
+//   <cacheDir>/<fileName>/spectrogram-*.json    每组请求参数一份, 最多保留 maxCachedSpectrograms 份
+//
+// samples_per_pixel 为 peaksBucketSize 的整数倍时由 peaks.bin 聚合(区间两端对齐到桶边界, 并在返回中给出实际区间);
+// 否则直接解码请求区间精确计算。两种方式的结果都量化到 16 位, 同一区间的结果完全一致。
+
+import (
+	"KeyTone/keySound/audio"
+	"KeyTone/logger"
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+const (
+	// peaksBucketSize 为缓存峰值的桶大小(样本数)
+	peaksBucketSize = 32
+	// maxPeaksPixels / maxSpectrogramColumns 限制单次请求的数据量
+	maxPeaksPixels        = 1 << 17
+	maxSpectrogramColumns = 4096
+	maxSpectrogramBins    = 1024
+	// maxSpectrogramFramesPerColumn 为每列参与平均的 FFT 帧数上限
+	maxSpectrogramFramesPerColumn = 4
+	maxCachedSpectrograms         = 32
+	// spectrogramMinDB 为频谱图的下限, 更小的值钳制到该值
+	spectrogramMinDB = -120.0
+
+	defaultSpectrogramColumns = 512
+	defaultSpectrogramBins    = 128
+	defaultSpectrogramFFTSize = 1024
+)
+
+var (
+	analysisCacheMutex sync.Mutex
+	analysisCacheDir   string
+)
+
+// SetAnalysisCacheDir 设置峰值与频谱图的磁盘缓存目录; 为空时不使用磁盘缓存。
+func SetAnalysisCacheDir(dir string) {
+	analysisCacheMutex.Lock()
+	defer analysisCacheMutex.Unlock()
+	analysisCacheDir = dir
+}
+
+// RemoveAnalysisCache 删除某个音频文件的分析缓存(音频文件被删除时调用)。
+func RemoveAnalysisCache(fileName string) {
+	analysisCacheMutex.Lock()
+	defer analysisCacheMutex.Unlock()
+	if analysisCacheDir == "" || fileName == "" || strings.ContainsAny(fileName, `/\`) {
+		return
+	}
+	os.RemoveAll(filepath.Join(analysisCacheDir, fileName))
+}
+
+// AnalysisRange 为请求的时间范围(毫秒); EndMS 为 0 表示到文件结尾。
+type AnalysisRange struct {
+	StartMS int64
+	EndMS   int64
+}
+
+// AudioPeaks 为一段音频的波形峰值。Peaks 每个声道一组, 按像素交替存放 min、max。
+type AudioPeaks struct {
+	SampleRate      int         `json:"sampleRate"`
+	Channels        int         `json:"channels"`
+	Length          int         `json:"length"`
+	SamplesPerPixel int         `json:"samplesPerPixel"`
+	Start           int         `json:"start"`
+	End             int         `json:"end"`
+	Peaks           [][]float64 `json:"peaks"`
+}
+
+// SpectrogramOptions 为频谱图参数, 零值字段使用默认值。
+type SpectrogramOptions struct {
+	Columns int
+	Bins    int
+	FFTSize int
+}
+
+// AudioSpectrogram 为降采样后的幅度频谱图: Data[column][bin] 为 dB 值(满幅正弦为 0dB), 频率按线性均分。
+type AudioSpectrogram struct {
+	SampleRate   int         `json:"sampleRate"`
+	Start        int         `json:"start"`
+	End          int         `json:"end"`
+	Columns      int         `json:"columns"`
+	Bins         int         `json:"bins"`
+	FFTSize      int         `json:"fftSize"`
+	MaxFrequency float64     `json:"maxFrequency"`
+	MinDB        float64     `json:"minDb"`
+	Data         [][]float32 `json:"data"`
+}
+
+// analysisSource 为分析的目标文件。
+type analysisSource struct {
+	path string
+	info os.FileInfo
+}
+
+func openAnalysisSource(get ConfigGetter, albumPath string, sha256 string) (analysisSource, error) {
+	fileType, ok := getValue(get, "audio_files."+sha256+".type").(string)
+	if !ok || fileType == "" {
+		return analysisSource{}, fmt.Errorf("audio file %s not found", sha256)
+	}
+	path := albumAudioFile(get, albumPath, sha256, fileType).Global
+	info, err := os.Stat(path)
+	if err != nil {
+		return analysisSource{}, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	return analysisSource{path: path, info: info}, nil
+}
+
+// decode 打开并解码源文件; 调用方负责关闭返回的流。
+func (s analysisSource) decode() (beep.StreamSeekCloser, beep.Format, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	streamer, format, err := audio.Decode(file, filepath.Ext(s.path))
+	if err != nil {
+		file.Close()
+		return nil, beep.Format{}, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+	return streamer, format, nil
+}
+
+// sampleRange 按与 audio.PrepareCut 相同的方式把毫秒换算为样本区间, 并钳制到文件长度。
+func sampleRange(sampleRate beep.SampleRate, length int, r AnalysisRange) (int, int, error) {
+	start := max(0, sampleRate.N(time.Millisecond*time.Duration(r.StartMS)))
+	end := length
+	if r.EndMS > 0 {
+		end = min(length, sampleRate.N(time.Millisecond*time.Duration(r.EndMS)))
+	}
+	if start >= end {
+		return 0, 0, audio.ErrEmptyCut
+	}
+	return start, end, nil
+}
+
+// quantizePeak 将峰值量化到 16 位, 使缓存与精确计算的结果一致。
+func quantizePeak(v float64) int16 {
+	return int16(math.Round(math.Max(-1, math.Min(1, v)) * math.MaxInt16))
+}
+
+// AudioFilePeaks 计算专辑中音频文件在 r 范围内、每像素 samplesPerPixel 个样本的波形峰值。
+func AudioFilePeaks(get ConfigGetter, albumPath string, sha256 string, samplesPerPixel int, r AnalysisRange) (*AudioPeaks, error) {
+	if samplesPerPixel < 1 {
+		return nil, errors.New("samples per pixel must be positive")
+	}
+	source, err := openAnalysisSource(get, albumPath, sha256)
+	if err != nil {
+		return nil, err
+	}
+
+	if samplesPerPixel%peaksBucketSize == 0 {
+		cache, err := loadOrBuildPeaksCache(source)
+		if err == nil {
+			return cache.aggregate(samplesPerPixel, r)
+		}
+		logger.Error("message", "error: 波形峰值缓存不可用, 改为直接计算", "path", source.path, "err", err.Error())
+	}
+
+	streamer, format, err := source.decode()
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+	start, end, err := sampleRange(format.SampleRate, streamer.Len(), r)
+	if err != nil {
+		return nil, err
+	}
+	if (end-start+samplesPerPixel-1)/samplesPerPixel > maxPeaksPixels {
+		return nil, fmt.Errorf("too many pixels requested (max %d)", maxPeaksPixels)
+	}
+	if err := streamer.Seek(start); err != nil {
+		return nil, fmt.Errorf("seek start sample %d failed: %w", start, err)
+	}
+	channels := min(2, max(1, format.NumChannels))
+	peaks := make([][]float64, channels)
+	err = scanPeaks(beep.Take(end-start, streamer), samplesPerPixel, channels, func(bucket [2][2]float64) {
+		for ch := 0; ch < channels; ch++ {
+			peaks[ch] = append(peaks[ch], float64(quantizePeak(bucket[ch][0]))/math.MaxInt16, float64(quantizePeak(bucket[ch][1]))/math.MaxInt16)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &AudioPeaks{
+		SampleRate:      int(format.SampleRate),
+		Channels:        channels,
+		Length:          streamer.Len(),
+		SamplesPerPixel: samplesPerPixel,
+		Start:           start,
+		End:             end,
+		Peaks:           peaks,
+	}, nil
+}
+
+// scanPeaks 以 size 个样本为一组读尽 streamer, 对每组回调逐声道的 [min, max](最后一组可能不足 size)。
+func scanPeaks(streamer beep.Streamer, size int, channels int, emit func([2][2]float64)) error {
+	buf := make([][2]float64, 4096)
+	var bucket [2][2]float64
+	filled := 0
+	for {
+		n, ok := streamer.Stream(buf)
+		for _, s := range buf[:n] {
+			for ch := 0; ch < channels; ch++ {
+				if filled == 0 || s[ch] < bucket[ch][0] {
+					bucket[ch][0] = s[ch]
+				}
+				if filled == 0 || s[ch] > bucket[ch][1] {
+					bucket[ch][1] = s[ch]
+				}
+			}
+			filled++
+			if filled == size {
+				emit(bucket)
+				filled = 0
+			}
+		}
+		if !ok {
+			break
+		}
+	}
+	if filled > 0 {
+		emit(bucket)
+	}
+	return streamer.Err()
+}
+
+// peaksCache 为 peaks.bin 的内容。
+type peaksCache struct {
+	sampleRate int
+	channels   int
+	length     int
+	// buckets[ch] 交替存放每桶的 min、max
+	buckets [][]int16
+}
+
+const peaksCacheMagic = "KTPK"
+
+func (c *peaksCache) aggregate(samplesPerPixel int, r AnalysisRange) (*AudioPeaks, error) {
+	start, end, err := sampleRange(beep.SampleRate(c.sampleRate), c.length, r)
+	if err != nil {
+		return nil, err
+	}
+	// 区间两端对齐到桶边界, 实际区间在返回值中给出
+	start -= start % peaksBucketSize
+	end = min(c.length, (end+peaksBucketSize-1)/peaksBucketSize*peaksBucketSize)
+	if (end-start+samplesPerPixel-1)/samplesPerPixel > maxPeaksPixels {
+		return nil, fmt.Errorf("too many pixels requested (max %d)", maxPeaksPixels)
+	}
+	perPixel := samplesPerPixel / peaksBucketSize
+	firstBucket := start / peaksBucketSize
+	lastBucket := (end + peaksBucketSize - 1) / peaksBucketSize
+
+	peaks := make([][]float64, c.channels)
+	for ch := 0; ch < c.channels; ch++ {
+		for b := firstBucket; b < lastBucket; b += perPixel {
+			low, high := int16(math.MaxInt16), int16(math.MinInt16)
+			for i := b; i < min(b+perPixel, lastBucket); i++ {
+				low = min(low, c.buckets[ch][2*i])
+				high = max(high, c.buckets[ch][2*i+1])
+			}
+			peaks[ch] = append(peaks[ch], float64(low)/math.MaxInt16, float64(high)/math.MaxInt16)
+		}
+	}
+	return &AudioPeaks{
+		SampleRate:      c.sampleRate,
+		Channels:        c.channels,
+		Length:          c.length,
+		SamplesPerPixel: samplesPerPixel,
+		Start:           start,
+		End:             end,
+		Peaks:           peaks,
+	}, nil
+}
+
+// analysisCacheEntryDir 返回源文件的缓存目录; 源文件的大小或修改时间变化时清空该目录。未设置缓存目录时返回空字符串。
+func analysisCacheEntryDir(source analysisSource) (string, error) {
+	analysisCacheMutex.Lock()
+	defer analysisCacheMutex.Unlock()
+	if analysisCacheDir == "" {
+		return "", nil
+	}
+	dir := filepath.Join(analysisCacheDir, filepath.Base(source.path))
+	stamp, _ := json.Marshal(map[string]int64{"size": source.info.Size(), "mod_time": source.info.ModTime().UnixNano()})
+	if existing, err := os.ReadFile(filepath.Join(dir, "stamp")); err == nil && string(existing) == string(stamp) {
+		return dir, nil
+	}
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stamp"), stamp, 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// writeCacheFile 先写临时文件再改名, 避免并发读取到写了一半的缓存。
+func writeCacheFile(path string, write func(io.Writer) error) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(temp)
+	if err := write(writer); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	temp.Close()
+	if err := os.Rename(temp.Name(), path); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+func loadOrBuildPeaksCache(source analysisSource) (*peaksCache, error) {
+	dir, err := analysisCacheEntryDir(source)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if cache, err := readPeaksCache(filepath.Join(dir, "peaks.bin")); err == nil {
+			return cache, nil
+		}
+	}
+
+	streamer, format, err := source.decode()
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+	cache := &peaksCache{sampleRate: int(format.SampleRate), channels: min(2, max(1, format.NumChannels))}
+	cache.buckets = make([][]int16, cache.channels)
+	length := 0
+	counter := beep.StreamerFunc(func(samples [][2]float64) (int, bool) {
+		n, ok := streamer.Stream(samples)
+		length += n
+		return n, ok
+	})
+	err = scanPeaks(counter, peaksBucketSize, cache.channels, func(bucket [2][2]float64) {
+		for ch := 0; ch < cache.channels; ch++ {
+			cache.buckets[ch] = append(cache.buckets[ch], quantizePeak(bucket[ch][0]), quantizePeak(bucket[ch][1]))
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+	if err := streamer.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+	cache.length = length
+
+	if dir != "" {
+		if err := writeCacheFile(filepath.Join(dir, "peaks.bin"), cache.write); err != nil {
+			logger.Error("message", "error: 写入波形峰值缓存失败", "err", err.Error())
+		}
+	}
+	return cache, nil
+}
+
+func (c *peaksCache) write(w io.Writer) error {
+	header := []any{[]byte(peaksCacheMagic), uint32(c.sampleRate), uint16(c.channels), uint64(c.length), uint32(peaksBucketSize)}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	for _, buckets := range c.buckets {
+		if err := binary.Write(w, binary.LittleEndian, buckets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPeaksCache(path string) (*peaksCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	const headerSize = 4 + 4 + 2 + 8 + 4
+	if len(data) < headerSize || string(data[0:4]) != peaksCacheMagic {
+		return nil, errors.New("invalid peaks cache")
+	}
+	cache := &peaksCache{
+		sampleRate: int(binary.LittleEndian.Uint32(data[4:8])),
+		channels:   int(binary.LittleEndian.Uint16(data[8:10])),
+		length:     int(binary.LittleEndian.Uint64(data[10:18])),
+	}
+	if binary.LittleEndian.Uint32(data[18:22]) != peaksBucketSize || cache.channels < 1 || cache.channels > 2 || cache.sampleRate <= 0 {
+		return nil, errors.New("invalid peaks cache")
+	}
+	count := 2 * ((cache.length + peaksBucketSize - 1) / peaksBucketSize)
+	if len(data) != headerSize+cache.channels*count*2 {
+		return nil, errors.New("invalid peaks cache")
+	}
+	offset := headerSize
+	cache.buckets = make([][]int16, cache.channels)
+	for ch := range cache.buckets {
+		cache.buckets[ch] = make([]int16, count)
+		for i := range cache.buckets[ch] {
+			cache.buckets[ch][i] = int16(binary.LittleEndian.Uint16(data[offset:]))
+			offset += 2
+		}
+	}
+	return cache, nil
+}
+
+func (o SpectrogramOptions) withDefaults() (SpectrogramOptions, error) {
+	if o.Columns == 0 {
+		o.Columns = defaultSpectrogramColumns
+	}
+	if o.Bins == 0 {
+		o.Bins = defaultSpectrogramBins
+	}
+	if o.FFTSize == 0 {
+		o.FFTSize = defaultSpectrogramFFTSize
+	}
+	if o.FFTSize < 64 || o.FFTSize > 16384 || o.FFTSize&(o.FFTSize-1) != 0 {
+		return o, fmt.Errorf("fft size must be a power of two within [64, 16384], got %d", o.FFTSize)
+	}
+	if o.Columns < 1 || o.Columns > maxSpectrogramColumns {
+		return o, fmt.Errorf("columns must be within [1, %d], got %d", maxSpectrogramColumns, o.Columns)
+	}
+	if o.Bins < 1 || o.Bins > min(maxSpectrogramBins, o.FFTSize/2+1) {
+		return o, fmt.Errorf("bins must be within [1, %d], got %d", min(maxSpectrogramBins, o.FFTSize/2+1), o.Bins)
+	}
+	return o, nil
+}
+
+// Validate 检查参数取值是否合理。
+func (o SpectrogramOptions) Validate() error {
+	_, err := o.withDefaults()
+	return err
+}
+
+// AudioFileSpectrogram 计算专辑中音频文件在 r 范围内的频谱图。
+// 每列覆盖 (end-start)/columns 个样本, 在其中均匀取至多 4 帧(Hann 窗)做功率平均; 频率 bin 按最大值合并为 bins 组。
+func AudioFileSpectrogram(get ConfigGetter, albumPath string, sha256 string, opts SpectrogramOptions, r AnalysisRange) (*AudioSpectrogram, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	source, err := openAnalysisSource(get, albumPath, sha256)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := analysisCacheEntryDir(source)
+	if err != nil {
+		logger.Error("message", "error: 频谱图缓存不可用", "err", err.Error())
+		dir = ""
+	}
+	cacheName := fmt.Sprintf("spectrogram-%d-%d-%d-%d-%d.json", opts.FFTSize, opts.Columns, opts.Bins, r.StartMS, r.EndMS)
+	if dir != "" {
+		if data, err := os.ReadFile(filepath.Join(dir, cacheName)); err == nil {
+			var cached AudioSpectrogram
+			if json.Unmarshal(data, &cached) == nil {
+				now := time.Now()
+				os.Chtimes(filepath.Join(dir, cacheName), now, now)
+				return &cached, nil
+			}
+		}
+	}
+
+	streamer, format, err := source.decode()
+	if err != nil {
+		return nil, err
+	}
+	defer streamer.Close()
+	start, end, err := sampleRange(format.SampleRate, streamer.Len(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	// 各列参与计算的帧起点(可能为负或越过结尾, 越界部分补零), 按时间升序
+	fftSize := opts.FFTSize
+	span := float64(end-start) / float64(opts.Columns)
+	frames := max(1, min(maxSpectrogramFramesPerColumn, int(span)/(fftSize/2)))
+	frameStarts := make([]int, 0, opts.Columns*frames)
+	for column := 0; column < opts.Columns; column++ {
+		for f := 0; f < frames; f++ {
+			center := float64(start) + span*(float64(column)+(float64(f)+0.5)/float64(frames))
+			frameStarts = append(frameStarts, int(center)-fftSize/2)
+		}
+	}
+
+	window := audio.HannWindow(fftSize)
+	windowSum := 0.0
+	for _, w := range window {
+		windowSum += w
+	}
+	reader := &monoWindowReader{streamer: streamer}
+	if first := max(0, frameStarts[0]); first > 0 {
+		if err := streamer.Seek(first); err != nil {
+			return nil, fmt.Errorf("seek start sample %d failed: %w", first, err)
+		}
+		reader.offset = first
+	}
+
+	spectrum := make([]complex128, fftSize)
+	power := make([]float64, fftSize/2+1)
+	result := &AudioSpectrogram{
+		SampleRate:   int(format.SampleRate),
+		Start:        start,
+		End:          end,
+		Columns:      opts.Columns,
+		Bins:         opts.Bins,
+		FFTSize:      fftSize,
+		MaxFrequency: float64(format.SampleRate) / 2,
+		MinDB:        spectrogramMinDB,
+		Data:         make([][]float32, opts.Columns),
+	}
+	for column := 0; column < opts.Columns; column++ {
+		for k := range power {
+			power[k] = 0
+		}
+		for f := 0; f < frames; f++ {
+			samples := reader.window(frameStarts[column*frames+f], fftSize)
+			for i, v := range samples {
+				spectrum[i] = complex(v*window[i], 0)
+			}
+			audio.FFT(spectrum)
+			for k := range power {
+				// 归一化使满幅正弦的峰值为 0dB
+				magnitude := 2 * cmplx.Abs(spectrum[k]) / windowSum
+				power[k] += magnitude * magnitude / float64(frames)
+			}
+		}
+		row := make([]float32, opts.Bins)
+		for bin := range row {
+			from := bin * len(power) / opts.Bins
+			to := max(from+1, (bin+1)*len(power)/opts.Bins)
+			peak := 0.0
+			for _, p := range power[from:to] {
+				peak = math.Max(peak, p)
+			}
+			db := math.Max(spectrogramMinDB, 10*math.Log10(peak+1e-30))
+			row[bin] = float32(math.Round(db*10) / 10)
+		}
+		result.Data[column] = row
+	}
+	if err := streamer.Err(); err != nil {
+		return nil, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+
+	if dir != "" {
+		err := writeCacheFile(filepath.Join(dir, cacheName), func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(result)
+		})
+		if err != nil {
+			logger.Error("message", "error: 写入频谱图缓存失败", "err", err.Error())
+		}
+		pruneSpectrogramCache(dir)
+	}
+	return result, nil
+}
+
+// monoWindowReader 顺序读取单声道样本, 仅保留后续帧还会用到的部分, 使整段频谱图无需把整个文件放入内存。
+type monoWindowReader struct {
+	streamer beep.Streamer
+	// offset 为 buffer[0] 对应的样本位置
+	offset int
+	buffer []float64
+	done   bool
+}
+
+// window 返回从 start 开始的 size 个样本(越界部分为 0)。start 必须单调不减。
+func (r *monoWindowReader) window(start int, size int) []float64 {
+	if drop := min(len(r.buffer), max(0, start-r.offset)); drop > 0 {
+		r.buffer = append(r.buffer[:0], r.buffer[drop:]...)
+		r.offset += drop
+	}
+	chunk := make([][2]float64, 4096)
+	for !r.done && r.offset+len(r.buffer) < start+size {
+		n, ok := r.streamer.Stream(chunk)
+		for _, s := range chunk[:n] {
+			r.buffer = append(r.buffer, (s[0]+s[1])/2)
+		}
+		if !ok {
+			r.done = true
+		}
+	}
+	out := make([]float64, size)
+	for i := range out {
+		if index := start + i - r.offset; index >= 0 && index < len(r.buffer) {
+			out[i] = r.buffer[index]
+		}
+	}
+	return out
+}
+
+// pruneSpectrogramCache 只保留最近使用的 maxCachedSpectrograms 份频谱图缓存。
+func pruneSpectrogramCache(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		name    string
+		modTime time.Time
+	}
+	var files []cached
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "spectrogram-") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, cached{entry.Name(), info.ModTime()})
+		}
+	}
+	if len(files) <= maxCachedSpectrograms {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, file := range files[maxCachedSpectrograms:] {
+		os.Remove(filepath.Join(dir, file.name))
+	}
+}
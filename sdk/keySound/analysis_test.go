@@ -0,0 +1,186 @@
+package keySound
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"KeyTone/keySound/audio"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// writeAnalysisAlbum 在临时专辑中写入 audioFiles/abc.wav, 并启用临时的分析缓存目录。
+func writeAnalysisAlbum(t *testing.T, samples [][2]float64) (string, ConfigGetter) {
+	t.Helper()
+	albumPath := filepath.Join(t.TempDir(), "album-uuid")
+	writeAnalysisWAV(t, filepath.Join(albumPath, "audioFiles", "abc.wav"), samples)
+	SetAnalysisCacheDir(filepath.Join(t.TempDir(), "analysis_cache"))
+	t.Cleanup(func() { SetAnalysisCacheDir("") })
+	values := map[string]any{"audio_files.abc.type": ".wav"}
+	return albumPath, func(key string) any { return values[key] }
+}
+
+func writeAnalysisWAV(t *testing.T, path string, samples [][2]float64) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	format := beep.Format{SampleRate: 44100, NumChannels: 2, Precision: 2}
+	if err := wav.Encode(file, audio.NewMemoryStreamer(samples), format); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decodedSamples 读回写入的 wav, 得到 16 位量化后的样本。
+func decodedSamples(t *testing.T, path string) [][2]float64 {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamer, _, err := audio.Decode(file, ".wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	samples, err := audio.ReadAll(streamer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return samples
+}
+
+// expectedPeaks 直接计算 [start, end) 内的逐像素 min/max。
+func expectedPeaks(samples [][2]float64, start, end, samplesPerPixel, ch int) []float64 {
+	var peaks []float64
+	for p := start; p < end; p += samplesPerPixel {
+		low, high := math.Inf(1), math.Inf(-1)
+		for i := p; i < min(end, p+samplesPerPixel); i++ {
+			low, high = math.Min(low, samples[i][ch]), math.Max(high, samples[i][ch])
+		}
+		peaks = append(peaks, float64(quantizePeak(low))/math.MaxInt16, float64(quantizePeak(high))/math.MaxInt16)
+	}
+	return peaks
+}
+
+// TestAudioFilePeaks 验证缓存聚合与精确计算两条路径的结果都与直接计算一致, 且区间与裁剪的毫秒换算对齐。
+func TestAudioFilePeaks(t *testing.T) {
+	samples := make([][2]float64, 44100)
+	for i := range samples {
+		v := math.Sin(float64(i)*0.37) * (float64(i%1000) / 1000)
+		samples[i] = [2]float64{v, -v / 2}
+	}
+	albumPath, get := writeAnalysisAlbum(t, samples)
+	decoded := decodedSamples(t, filepath.Join(albumPath, "audioFiles", "abc.wav"))
+
+	// 32 的整数倍: 由缓存聚合, 区间两端对齐到桶边界(10ms = 441 -> 416, 100ms = 4410 -> 4416)
+	cached, err := AudioFilePeaks(get, albumPath, "abc", 64, AnalysisRange{StartMS: 10, EndMS: 100})
+	if err != nil {
+		t.Fatalf("AudioFilePeaks returned error: %v", err)
+	}
+	if cached.Start != 416 || cached.End != 4416 || cached.Length != len(samples) || cached.Channels != 2 || cached.SampleRate != 44100 {
+		t.Fatalf("unexpected cached peaks header: %+v", cached)
+	}
+	if _, err := os.Stat(filepath.Join(analysisCacheDir, "abc.wav", "peaks.bin")); err != nil {
+		t.Fatalf("expected peaks cache on disk: %v", err)
+	}
+
+	// 非 32 的整数倍: 直接解码, 起点与 audio.PrepareCut 的换算一致(10ms = 441)
+	exact, err := AudioFilePeaks(get, albumPath, "abc", 50, AnalysisRange{StartMS: 10, EndMS: 100})
+	if err != nil {
+		t.Fatalf("AudioFilePeaks returned error: %v", err)
+	}
+	if exact.Start != 441 || exact.End != 4410 {
+		t.Fatalf("unexpected exact peaks range: %+v", exact)
+	}
+
+	for _, peaks := range []*AudioPeaks{cached, exact} {
+		for ch := 0; ch < 2; ch++ {
+			want := expectedPeaks(decoded, peaks.Start, peaks.End, peaks.SamplesPerPixel, ch)
+			if len(peaks.Peaks[ch]) != len(want) {
+				t.Fatalf("channel %d: got %d values, want %d", ch, len(peaks.Peaks[ch]), len(want))
+			}
+			for i := range want {
+				if peaks.Peaks[ch][i] != want[i] {
+					t.Fatalf("spp %d channel %d value %d: got %v, want %v", peaks.SamplesPerPixel, ch, i, peaks.Peaks[ch][i], want[i])
+				}
+			}
+		}
+	}
+
+	// 再次请求命中缓存; 源文件改变后缓存失效
+	writeAnalysisWAV(t, filepath.Join(albumPath, "audioFiles", "abc.wav"), samples[:22050])
+	changed, err := AudioFilePeaks(get, albumPath, "abc", 64, AnalysisRange{})
+	if err != nil {
+		t.Fatalf("AudioFilePeaks returned error: %v", err)
+	}
+	if changed.Length != 22050 || changed.End != 22050 || len(changed.Peaks[0]) != 2*((22050+63)/64) {
+		t.Fatalf("stale peaks cache after the file changed: %+v", changed.Length)
+	}
+
+	if _, err := AudioFilePeaks(get, albumPath, "abc", 64, AnalysisRange{StartMS: 900}); err == nil {
+		t.Fatal("expected an empty range to be rejected")
+	}
+	if _, err := AudioFilePeaks(get, albumPath, "missing", 64, AnalysisRange{}); err == nil {
+		t.Fatal("expected a missing audio file to be rejected")
+	}
+}
+
+// TestAudioFileSpectrogram 验证 1kHz 正弦的能量落在对应的频率组, 电平与幅度一致, 并写入磁盘缓存。
+func TestAudioFileSpectrogram(t *testing.T) {
+	samples := make([][2]float64, 44100/2)
+	for i := range samples {
+		v := 0.5 * math.Sin(2*math.Pi*1000*float64(i)/44100)
+		samples[i] = [2]float64{v, v}
+	}
+	albumPath, get := writeAnalysisAlbum(t, samples)
+
+	spectrogram, err := AudioFileSpectrogram(get, albumPath, "abc", SpectrogramOptions{Columns: 16, Bins: 64}, AnalysisRange{StartMS: 100, EndMS: 400})
+	if err != nil {
+		t.Fatalf("AudioFileSpectrogram returned error: %v", err)
+	}
+	if spectrogram.Start != 4410 || spectrogram.End != 17640 || len(spectrogram.Data) != 16 || spectrogram.FFTSize != defaultSpectrogramFFTSize {
+		t.Fatalf("unexpected spectrogram header: %+v", spectrogram)
+	}
+	binWidth := spectrogram.MaxFrequency / float64(spectrogram.Bins)
+	for column, row := range spectrogram.Data {
+		loudest := 0
+		for bin := range row {
+			if row[bin] > row[loudest] {
+				loudest = bin
+			}
+		}
+		if frequency := (float64(loudest) + 0.5) * binWidth; math.Abs(frequency-1000) > binWidth {
+			t.Fatalf("column %d: loudest bin at %vHz, want about 1000Hz", column, frequency)
+		}
+		// 0.5 幅度约为 -6dB, Hann 窗的扇贝损失不超过 1.5dB
+		if level := float64(row[loudest]); level > -5.5 || level < -8 {
+			t.Fatalf("column %d: unexpected level %vdB", column, level)
+		}
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(analysisCacheDir, "abc.wav"))
+	found := false
+	for _, entry := range entries {
+		found = found || filepath.Ext(entry.Name()) == ".json"
+	}
+	if !found {
+		t.Fatal("expected spectrogram cache on disk")
+	}
+	cached, err := AudioFileSpectrogram(get, albumPath, "abc", SpectrogramOptions{Columns: 16, Bins: 64}, AnalysisRange{StartMS: 100, EndMS: 400})
+	if err != nil || cached.Data[3][10] != spectrogram.Data[3][10] {
+		t.Fatalf("cached spectrogram differs: %v", err)
+	}
+
+	if err := (SpectrogramOptions{FFTSize: 1000}).Validate(); err == nil {
+		t.Fatal("expected a non power of two fft size to be rejected")
+	}
+}
@@ -0,0 +1,163 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// decodeAIFF 将 AIFF/AIFF-C 文件完整解码到内存(键音素材通常很短, 无需流式解码)。
+// 支持 8-32 位整数 PCM(AIFF 与 AIFF-C 的 NONE/twos/sowt)以及 AIFF-C 的 fl32/fl64 浮点。
+// 多于两个声道时只保留前两个声道。
+func decodeAIFF(r io.ReadCloser) (beep.StreamSeekCloser, beep.Format, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "FORM" || (string(data[8:12]) != "AIFF" && string(data[8:12]) != "AIFC") {
+		return nil, beep.Format{}, errors.New("aiff: missing FORM/AIFF header")
+	}
+	isAIFC := string(data[8:12]) == "AIFC"
+
+	var (
+		channels    int
+		frames      int
+		sampleSize  int
+		sampleRate  float64
+		compression = "NONE"
+		sound       []byte
+		hasComm     bool
+	)
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		body := data[offset+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+
+		switch id {
+		case "COMM":
+			if len(body) < 18 {
+				return nil, beep.Format{}, errors.New("aiff: COMM chunk too short")
+			}
+			channels = int(int16(binary.BigEndian.Uint16(body[0:2])))
+			frames = int(binary.BigEndian.Uint32(body[2:6]))
+			sampleSize = int(int16(binary.BigEndian.Uint16(body[6:8])))
+			sampleRate = extendedToFloat64(body[8:18])
+			if isAIFC && len(body) >= 22 {
+				compression = string(body[18:22])
+			}
+			hasComm = true
+		case "SSND":
+			if len(body) < 8 {
+				return nil, beep.Format{}, errors.New("aiff: SSND chunk too short")
+			}
+			start := 8 + int(binary.BigEndian.Uint32(body[0:4]))
+			if start > len(body) {
+				return nil, beep.Format{}, errors.New("aiff: invalid SSND offset")
+			}
+			sound = body[start:]
+		}
+		// 块长度为奇数时有 1 字节填充
+		offset += 8 + size + size%2
+	}
+	if !hasComm || sound == nil {
+		return nil, beep.Format{}, errors.New("aiff: missing COMM or SSND chunk")
+	}
+	if channels < 1 || sampleRate < 1 {
+		return nil, beep.Format{}, fmt.Errorf("aiff: invalid format (%d channels, %v Hz)", channels, sampleRate)
+	}
+
+	var sampleAt func([]byte) float64
+	bytesPerSample := (sampleSize + 7) / 8
+	switch compression {
+	case "NONE", "twos", "sowt":
+		if bytesPerSample < 1 || bytesPerSample > 4 {
+			return nil, beep.Format{}, fmt.Errorf("aiff: unsupported sample size %d", sampleSize)
+		}
+		littleEndian := compression == "sowt"
+		scale := math.Ldexp(1, bytesPerSample*8-1)
+		sampleAt = func(b []byte) float64 {
+			// 样本左对齐存放, 按完整字节宽度的有符号整数读取即可
+			var v int32
+			for i := 0; i < bytesPerSample; i++ {
+				index := i
+				if littleEndian {
+					index = bytesPerSample - 1 - i
+				}
+				v = v<<8 | int32(b[index])
+			}
+			shift := 32 - bytesPerSample*8
+			return float64(v<<shift>>shift) / scale
+		}
+	case "fl32", "FL32":
+		bytesPerSample = 4
+		sampleAt = func(b []byte) float64 { return float64(math.Float32frombits(binary.BigEndian.Uint32(b))) }
+	case "fl64", "FL64":
+		bytesPerSample = 8
+		sampleAt = func(b []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(b)) }
+	default:
+		return nil, beep.Format{}, fmt.Errorf("aiff: unsupported compression %q", compression)
+	}
+
+	frameSize := bytesPerSample * channels
+	if available := len(sound) / frameSize; frames > available {
+		frames = available
+	}
+	samples := make([][2]float64, frames)
+	for i := range samples {
+		frame := sound[i*frameSize:]
+		samples[i][0] = sampleAt(frame)
+		samples[i][1] = samples[i][0]
+		if channels > 1 {
+			samples[i][1] = sampleAt(frame[bytesPerSample:])
+		}
+	}
+
+	format := beep.Format{
+		SampleRate:  beep.SampleRate(math.Round(sampleRate)),
+		NumChannels: min(channels, 2),
+		Precision:   min(bytesPerSample, 3),
+	}
+	return &memoryStreamer{samples: samples, closer: r}, format, nil
+}
+
+// extendedToFloat64 将 AIFF 使用的 80 位 IEEE 754 扩展精度浮点数(采样率)转换为 float64。
+func extendedToFloat64(b []byte) float64 {
+	exponent := int(binary.BigEndian.Uint16(b[0:2]))
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	sign := 1.0
+	if exponent&0x8000 != 0 {
+		sign = -1
+		exponent &= 0x7FFF
+	}
+	if exponent == 0 && mantissa == 0 {
+		return 0
+	}
+	return sign * math.Ldexp(float64(mantissa), exponent-16383-63)
+}
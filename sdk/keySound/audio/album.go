@@ -0,0 +1,142 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+// =============================
+// 专辑内音频的定位说明
+// =============================
+//
+// 专辑配置以 ConfigGetter 按点分路径读取(如 "sounds.<uuid>.cut.start_time"), 音频文件位于 <专辑>/audioFiles/ 下:
+//   - 原始文件: <sha256><type>;
+//   - 导入时处理后的文件(见 keySound/normalize.go): <sha256>.processed<processed.type>, 存在时播放与编辑都使用它;
+//   - 导入时测得的响度增益 audio_files.<sha256>.loudness.gain_db 叠加在声音自身的音量上。
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigGetter 按点分路径读取专辑配置, 不存在时返回 nil。
+type ConfigGetter func(string) any
+
+// getValue 对 ConfigGetter 进行统一封装，避免 nil 调用。
+func getValue(get ConfigGetter, key string) any {
+	if get == nil {
+		return nil
+	}
+	return get(key)
+}
+
+// ProcessedAudioSuffix 为处理后文件名中 sha256 与扩展名之间的标记(<sha256>.processed.wav)。
+const ProcessedAudioSuffix = ".processed"
+
+// ProcessedAudioType 为处理后文件的保存格式。
+const ProcessedAudioType = FormatWAV
+
+// ProcessedAudioFileName 返回 sha256 对应的处理后文件名。
+func ProcessedAudioFileName(sha256 string) string {
+	return sha256 + ProcessedAudioSuffix + ProcessedAudioType
+}
+
+// PlaybackAudioFileName 返回 sha256 实际用于播放与编辑的文件名: 存在处理后的文件时返回它, 否则返回原始文件名。
+func PlaybackAudioFileName(get ConfigGetter, sha256 string, fileType string) string {
+	if processedType, ok := getValue(get, "audio_files."+sha256+".processed.type").(string); ok && processedType != "" {
+		return sha256 + ProcessedAudioSuffix + processedType
+	}
+	return sha256 + fileType
+}
+
+// AlbumAudioFile 返回专辑内音频文件实际用于播放的路径(优先使用处理后的文件)。
+func AlbumAudioFile(get ConfigGetter, albumPath string, sha256 string, fileType string) string {
+	return filepath.Join(albumPath, "audioFiles", PlaybackAudioFileName(get, sha256, fileType))
+}
+
+// LoudnessGainDB 返回导入时为 sha256 测得的响度增益, 未测量时为 0。
+func LoudnessGainDB(get ConfigGetter, sha256 string) float64 {
+	gain, _ := getValue(get, "audio_files."+sha256+".loudness.gain_db").(float64)
+	return gain
+}
+
+// LoudnessGainVolume 将 dB 增益换算为 effects.Volume(Base 1.6)的音量值, 以便直接叠加到声音自身的音量上。
+func LoudnessGainVolume(gainDB float64) float64 {
+	if gainDB == 0 {
+		return 0
+	}
+	return gainDB / 20 * math.Ln10 / math.Log(1.6)
+}
+
+// FileAliasExists 严格校验音频源别名是否存在。
+//
+// 校验维度：
+// 1) `audio_files.<sha256>` 节点存在；
+// 2) 节点内 `type` 与引用的 `fileType` 一致；
+// 3) 节点内 `name.<nameID>` 真实存在。
+//
+// 设计目的：
+// - 彻底避免“只按 sha256+type 就能播放”的隐式回连；
+// - 让运行时播放语义与前端依赖检测语义保持一致（都基于 sha256 + name_id + type 三元组）。
+func FileAliasExists(get ConfigGetter, sha256 string, nameID string, fileType string) bool {
+	if strings.TrimSpace(sha256) == "" || strings.TrimSpace(nameID) == "" || strings.TrimSpace(fileType) == "" {
+		return false
+	}
+
+	storedType, ok := getValue(get, "audio_files."+sha256+".type").(string)
+	if !ok || strings.TrimSpace(storedType) == "" || storedType != fileType {
+		return false
+	}
+
+	alias := getValue(get, "audio_files."+sha256+".name."+nameID)
+	if alias == nil {
+		return false
+	}
+
+	if aliasText, ok := alias.(string); ok {
+		return strings.TrimSpace(aliasText) != ""
+	}
+
+	return true
+}
+
+// SoundCut 解析 sounds.<soundID> 引用的音频文件与裁剪参数(含淡入/淡出包络)。
+// 与播放端一致: 仅当三元引用(sha256 + name_id + type)真实存在、且配置了裁剪范围时才返回结果。
+func SoundCut(get ConfigGetter, soundID string) (string, string, *Cut, error) {
+	sha256, shaOK := getValue(get, "sounds."+soundID+".source_file_for_sound.sha256").(string)
+	nameID, idOK := getValue(get, "sounds."+soundID+".source_file_for_sound.name_id").(string)
+	fileType, typeOK := getValue(get, "sounds."+soundID+".source_file_for_sound.type").(string)
+	if !shaOK || !idOK || !typeOK {
+		return "", "", nil, fmt.Errorf("sound %s has no source file", soundID)
+	}
+	if !FileAliasExists(get, sha256, nameID, fileType) {
+		return "", "", nil, fmt.Errorf("sound %s references a missing audio file", soundID)
+	}
+
+	startTime, startOK := getValue(get, "sounds."+soundID+".cut.start_time").(float64)
+	endTime, endOK := getValue(get, "sounds."+soundID+".cut.end_time").(float64)
+	if !startOK || !endOK {
+		return "", "", nil, fmt.Errorf("sound %s has no cut range", soundID)
+	}
+	volume, _ := getValue(get, "sounds."+soundID+".cut.volume").(float64)
+
+	cut := &Cut{StartMS: int64(startTime), EndMS: int64(endTime), Volume: volume}
+	ReadCutFade(get, soundID, cut)
+	return sha256, fileType, cut, nil
+}
@@ -0,0 +1,127 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// Cut 描述一次裁剪: 原始音频中的起止毫秒、声音自身的音量以及可选的淡入/淡出包络。
+type Cut struct {
+	StartMS int64
+	EndMS   int64 // 当 EndMS 小于或等于 StartMS  时, 不会播放任何声音
+	Volume  float64
+	// 可选的淡入/淡出包络(见 envelope.go), 为 0 时保持原有的硬切
+	FadeInMS  int64
+	FadeOutMS int64
+	FadeCurve string // FadeCurveLinear(默认) 或 FadeCurveExponential
+}
+
+// ErrEmptyCut 表示“这次裁剪在逻辑上不应该播放任何声音”。
+// 这不是异常故障, 而是一个明确的业务分支:
+// 1. 用户把结束时间拖到了开始时间之前/相同位置;
+// 2. 裁剪区间完全落在音频总长度之外;
+// 3. 音频长度本身异常, 无法得到任何可播放样本。
+//
+// 之所以单独定义这个错误, 是为了在调用方区分:
+// - 真正需要记录日志的故障(如 Seek 失败、解码器异常);
+// - 合法但不应发声的“空片段”情况。
+var ErrEmptyCut = errors.New("audio cut does not contain playable samples")
+
+// PrepareCut 将“裁剪描述 Cut”转换成一个真正可播放的 Streamer。
+//
+// 这是这次修复的核心: 旧实现是先 Resample, 再在播放期间轮询 Position(),
+// 一旦发现到达结束点就直接 Close 原始流。这个做法的问题在于:
+// 1. Resampler 为了插值会预读未来的一段原始样本;
+// 2. 因此“原始流当前位置”并不等于“已经真正播出的声音位置”;
+// 3. 尤其在很短的裁剪片段中, 预读进来的区间外样本会被插值带入输出;
+// 4. 最终表现就是: 明明选中的片段没有声音, 实际却还能听到片段外的声音。
+//
+// 新方案改成两步:
+// 1. 先在原始采样率的 StreamSeekCloser 上 Seek 到 startSample;
+// 2. 再用 beep.Take 严格限制最多只能读取 end-start 个样本;
+//
+// 这样重采样器拿到的输入源本身就是一个“已经裁好长度”的只读片段,
+// 它即便预读, 也只能在这个受限区间内预读, 不可能再越界读到片段外的声音。
+//
+// 返回值:
+// - beep.Streamer: 供后续重采样与播放使用的源流;
+// - float64: 初始音量偏移, 直接继承自 cut.Volume;
+// - error: 区分空片段(ErrEmptyCut)与真正故障。
+func PrepareCut(audioStreamer beep.StreamSeekCloser, sampleRate beep.SampleRate, cut *Cut) (beep.Streamer, float64, error) {
+	initVolume := 0.0
+	// 没有 cut 代表“播放整段音频”, 这时直接把原始流返回即可。
+	if cut == nil {
+		return audioStreamer, initVolume, nil
+	}
+
+	// 结束时间小于等于开始时间时, 语义上就是空区间, 必须明确无声返回。
+	if cut.EndMS <= cut.StartMS {
+		return nil, 0, ErrEmptyCut
+	}
+
+	// 配置中的裁剪时间单位是毫秒, 这里统一转换为解码后“原始采样率”下的样本索引。
+	// 注意必须使用原始采样率, 不能使用全局播放采样率, 否则时间轴会错位。
+	startSample := sampleRate.N(time.Millisecond * time.Duration(cut.StartMS))
+	endSample := sampleRate.N(time.Millisecond * time.Duration(cut.EndMS))
+	totalSamples := audioStreamer.Len()
+
+	// 没有任何可用样本时, 直接视为空片段。
+	if totalSamples <= 0 {
+		return nil, 0, ErrEmptyCut
+	}
+	// 负值裁剪时间统一钳制到 0, 防止配置异常导致 Seek 到负位置。
+	if startSample < 0 {
+		startSample = 0
+	}
+	if endSample < 0 {
+		endSample = 0
+	}
+	// 如果起点已经在文件末尾或更后面, 就没有任何可播放内容。
+	if startSample >= totalSamples {
+		return nil, 0, ErrEmptyCut
+	}
+	// 结束位置允许越界, 但要钳制到文件真实长度, 等价于“播放到文件结尾”。
+	if endSample > totalSamples {
+		endSample = totalSamples
+	}
+	// 钳制后若仍然没有有效区间, 说明最终结果仍是空片段。
+	if endSample <= startSample {
+		return nil, 0, ErrEmptyCut
+	}
+
+	// Seek 是必须检查错误的。
+	// 旧逻辑忽略了 Seek 返回值, 一旦解码器拒绝 Seek 或位置异常, 播放可能回退到文件开头,
+	// 这正是“选中的是静音段, 却听到别处声音”的另一个来源。
+	if err := audioStreamer.Seek(startSample); err != nil {
+		return nil, 0, fmt.Errorf("seek start sample %d failed: %w", startSample, err)
+	}
+
+	initVolume = cut.Volume
+	// Take 会把源流严格截断为指定样本数。
+	// 后续即便交给 Resample, Resample 也只能在这个已裁好的窗口中读取数据,
+	// 不会再接触到区间外的原始样本。
+	// 淡入/淡出包络同样作用在原始采样率的裁剪窗口上(裁剪之后、重采样之前), 以保证按样本精确。
+	return withCutEnvelope(beep.Take(endSample-startSample, audioStreamer), endSample-startSample, sampleRate, cut), initVolume, nil
+}
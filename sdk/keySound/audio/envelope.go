@@ -0,0 +1,139 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+// =============================
+// 裁剪片段的淡入/淡出包络说明
+// =============================
+//
+// 从录音中截取的短片段, 在边缘处硬切时常会产生"咔哒"声。因此 sounds.<uuid>.cut 支持可选的包络参数:
+//
+//	"cut": {
+//	    "start_time": 0, "end_time": 120, "volume": 0,
+//	    "fade_in_ms": 0,          // 可选, 片段开头的淡入时长
+//	    "fade_out_ms": 0,         // 可选, 片段结尾的淡出时长
+//	    "fade_curve": "linear"    // 可选, "linear"(默认) 或 "exponential"
+//	}
+//
+// 包络在裁剪之后、重采样之前, 以原始采样率逐样本施加:
+//   - 淡入: 第 i 个样本的增益为 curve(i / fadeIn), 即首个样本为 0, 第 fadeIn 个样本起恢复为 1;
+//   - 淡出: 倒数第 j 个样本(j 从 0 计)的增益为 curve(j / fadeOut), 即最后一个样本为 0;
+//   - 淡入/淡出区间重叠(片段过短)时, 两者增益相乘。
+
+import (
+	"math"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+)
+
+const (
+	FadeCurveLinear      = "linear"
+	FadeCurveExponential = "exponential"
+)
+
+// fadeExponentialSteepness 为指数曲线的陡峭程度, 越大则越贴近"先缓后急"的听感。
+const fadeExponentialSteepness = 4.0
+
+// NormalizeFadeCurve 将未知或为空的曲线类型归一为线性。
+func NormalizeFadeCurve(curve string) string {
+	if curve == FadeCurveExponential {
+		return FadeCurveExponential
+	}
+	return FadeCurveLinear
+}
+
+// fadeCurveGain 返回曲线在进度 x(0~1) 处的增益, 两端分别为 0 与 1。
+func fadeCurveGain(curve string, x float64) float64 {
+	x = min(max(x, 0), 1)
+	if curve == FadeCurveExponential {
+		return (math.Exp(fadeExponentialSteepness*x) - 1) / (math.Exp(fadeExponentialSteepness) - 1)
+	}
+	return x
+}
+
+// ReadCutFade 从 sounds.<uuid>.cut 中读取可选的包络参数写入 cut(缺省时保持为 0, 即硬切)。
+func ReadCutFade(get ConfigGetter, sound_UUID string, cut *Cut) {
+	if fadeIn, ok := getValue(get, "sounds."+sound_UUID+".cut.fade_in_ms").(float64); ok {
+		cut.FadeInMS = int64(fadeIn)
+	}
+	if fadeOut, ok := getValue(get, "sounds."+sound_UUID+".cut.fade_out_ms").(float64); ok {
+		cut.FadeOutMS = int64(fadeOut)
+	}
+	if curve, ok := getValue(get, "sounds."+sound_UUID+".cut.fade_curve").(string); ok {
+		cut.FadeCurve = curve
+	}
+}
+
+// cutEnvelope 为长度已知的裁剪片段逐样本施加淡入/淡出增益。
+type cutEnvelope struct {
+	streamer beep.Streamer
+	length   int
+	position int
+	fadeIn   int
+	fadeOut  int
+	curve    string
+}
+
+// withCutEnvelope 按 cut 的包络参数包装长度为 length 个样本的片段; 未配置包络时原样返回。
+func withCutEnvelope(streamer beep.Streamer, length int, sampleRate beep.SampleRate, cut *Cut) beep.Streamer {
+	if cut == nil || (cut.FadeInMS <= 0 && cut.FadeOutMS <= 0) {
+		return streamer
+	}
+	fadeIn := min(max(sampleRate.N(time.Millisecond*time.Duration(cut.FadeInMS)), 0), length)
+	fadeOut := min(max(sampleRate.N(time.Millisecond*time.Duration(cut.FadeOutMS)), 0), length)
+	if fadeIn == 0 && fadeOut == 0 {
+		return streamer
+	}
+	return &cutEnvelope{
+		streamer: streamer,
+		length:   length,
+		fadeIn:   fadeIn,
+		fadeOut:  fadeOut,
+		curve:    NormalizeFadeCurve(cut.FadeCurve),
+	}
+}
+
+// gainAt 返回片段内第 position 个样本的增益。
+func (e *cutEnvelope) gainAt(position int) float64 {
+	gain := 1.0
+	if position < e.fadeIn {
+		gain *= fadeCurveGain(e.curve, float64(position)/float64(e.fadeIn))
+	}
+	if remaining := e.length - 1 - position; remaining < e.fadeOut {
+		gain *= fadeCurveGain(e.curve, float64(remaining)/float64(e.fadeOut))
+	}
+	return gain
+}
+
+func (e *cutEnvelope) Stream(samples [][2]float64) (int, bool) {
+	n, ok := e.streamer.Stream(samples)
+	for i := 0; i < n; i++ {
+		gain := e.gainAt(e.position + i)
+		samples[i][0] *= gain
+		samples[i][1] *= gain
+	}
+	e.position += n
+	return n, ok
+}
+
+func (e *cutEnvelope) Err() error {
+	return e.streamer.Err()
+}
@@ -0,0 +1,296 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package audio 为键音的纯音频处理部分: 格式识别与解码、裁剪与离线渲染、起音检测。
+// 它不依赖播放设备与用户设置, 因此 SDK(keySound)与 ktalbum-tools 共用同一份实现。
+package audio
+
+// =============================
+// 音频格式说明
+// =============================
+//
+// 支持的格式见 formatTable: WAV、MP3、Ogg Vorbis、Ogg Opus、FLAC、AIFF/AIFF-C。
+//
+// 导入音频时不信任文件扩展名, 而是由 SniffHeader 按文件内容识别格式, 并以识别出的规范扩展名保存;
+// 随后 Probe 会完整解码一次, 无法解码的文件直接拒绝导入, 避免其在播放时才静默失败。
+//
+// 播放时仍按扩展名选择解码器(audioFiles/<sha256><type>), 但 .ogg/.opus 会先检查首个数据包,
+// 以兼容以 .ogg 扩展名保存的 Opus 文件(如 Mechvibes 音效包中的文件)。
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/flac"
+	"github.com/gopxl/beep/v2/mp3"
+	"github.com/gopxl/beep/v2/vorbis"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// 识别出的规范扩展名。
+const (
+	FormatWAV  = ".wav"
+	FormatMP3  = ".mp3"
+	FormatOgg  = ".ogg"
+	FormatOpus = ".opus"
+	FormatFLAC = ".flac"
+	FormatAIFF = ".aiff"
+)
+
+// ErrUnknownFormat 表示无法从文件内容识别出支持的音频格式。
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// formatTable 为全部支持的格式: 规范扩展名、按扩展名解码时接受的全部扩展名, 以及对应的解码器。
+// 解码器的 r 必须可寻址(Seek); 与 beep 的解码器一致, 返回的流关闭时会一并关闭 r。
+var formatTable = []struct {
+	format     string
+	extensions []string
+	decode     func(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error)
+}{
+	{FormatWAV, []string{".wav"}, func(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error) { return wav.Decode(r) }},
+	{FormatMP3, []string{".mp3"}, func(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error) { return mp3.Decode(r) }},
+	{FormatOgg, []string{".ogg"}, decodeOgg},
+	{FormatOpus, []string{".opus"}, decodeOgg},
+	{FormatFLAC, []string{".flac"}, func(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error) { return flac.Decode(r) }},
+	{FormatAIFF, []string{".aiff", ".aif", ".aifc"}, func(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error) { return decodeAIFF(r) }},
+}
+
+// IsSupported 判断扩展名(不区分大小写)是否可由 Decode 解码。
+func IsSupported(ext string) bool {
+	_, ok := decoderFor(ext)
+	return ok
+}
+
+// decoderFor 在 formatTable 中查找扩展名(不区分大小写)对应的解码器。
+func decoderFor(ext string) (func(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error), bool) {
+	ext = strings.ToLower(ext)
+	for _, entry := range formatTable {
+		for _, extension := range entry.extensions {
+			if extension == ext {
+				return entry.decode, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// sniffHeaderSize 为识别格式时读取的文件头长度, 足以覆盖 Ogg 首页与 ID3 标签头。
+const sniffHeaderSize = 512
+
+// SniffHeader 按文件头内容识别音频格式, 返回规范扩展名。
+func SniffHeader(header []byte) (string, error) {
+	switch {
+	case len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return FormatWAV, nil
+	case len(header) >= 4 && string(header[0:4]) == "fLaC":
+		return FormatFLAC, nil
+	case len(header) >= 12 && string(header[0:4]) == "FORM" && (string(header[8:12]) == "AIFF" || string(header[8:12]) == "AIFC"):
+		return FormatAIFF, nil
+	case len(header) >= 4 && string(header[0:4]) == "OggS":
+		return sniffOggCodec(header)
+	case len(header) >= 3 && string(header[0:3]) == "ID3":
+		return FormatMP3, nil
+	// 无 ID3 标签的 MP3 以帧同步字(11 个连续的 1)开头, 并排除保留的 MPEG 版本与 layer
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x18 != 0x08 && header[1]&0x06 != 0:
+		return FormatMP3, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// sniffOggCodec 读取 Ogg 首页中的第一个数据包, 区分 Vorbis 与 Opus。
+func sniffOggCodec(header []byte) (string, error) {
+	if len(header) < 27 {
+		return "", ErrUnknownFormat
+	}
+	packet := header[27:]
+	segments := int(header[26])
+	if len(packet) < segments {
+		return "", ErrUnknownFormat
+	}
+	packet = packet[segments:]
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		return FormatOgg, nil
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		return FormatOpus, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// Sniff 读取 r 的文件头识别格式, 并将读取位置恢复到开头。
+func Sniff(r io.ReadSeeker) (string, error) {
+	header := make([]byte, sniffHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return SniffHeader(header[:n])
+}
+
+// decodeOgg 按首个数据包选择 Vorbis 或 Opus 解码器: Ogg 容器中可能是两者之一, 以内容为准。
+func decodeOgg(r io.ReadSeekCloser) (beep.StreamSeekCloser, beep.Format, error) {
+	format, err := Sniff(r)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+	if format == FormatOpus {
+		return decodeOggOpus(r)
+	}
+	return vorbis.Decode(r)
+}
+
+// Decode 按扩展名(不区分大小写)从 formatTable 中选择解码器。r 必须可寻址(Seek);
+// 与 beep 的解码器一致, 返回的流关闭时会一并关闭 r。
+func Decode(r io.ReadSeekCloser, ext string) (beep.StreamSeekCloser, beep.Format, error) {
+	decode, ok := decoderFor(ext)
+	if !ok {
+		return nil, beep.Format{}, errors.New("unsupported audio format: " + strings.ToLower(ext))
+	}
+	return decode(r)
+}
+
+// ReadAll 将 streamer 读尽到内存。
+func ReadAll(streamer beep.Streamer) ([][2]float64, error) {
+	var samples [][2]float64
+	buf := make([][2]float64, 4096)
+	for {
+		n, ok := streamer.Stream(buf)
+		samples = append(samples, buf[:n]...)
+		if !ok {
+			break
+		}
+	}
+	return samples, streamer.Err()
+}
+
+// ReadFile 按扩展名解码 path 并将全部样本读入内存。
+func ReadFile(path string) ([][2]float64, beep.Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	streamer, format, err := Decode(file, filepath.Ext(path))
+	if err != nil {
+		file.Close()
+		return nil, beep.Format{}, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+	defer streamer.Close()
+	samples, err := ReadAll(streamer)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+	return samples, format, nil
+}
+
+// Info 为一次完整解码得到的音频信息。
+type Info struct {
+	// Format 为识别出的规范扩展名
+	Format     string  `json:"format"`
+	DurationMS float64 `json:"durationMs"`
+	SampleRate int     `json:"sampleRate"`
+	Channels   int     `json:"channels"`
+}
+
+// Probe 识别 r 的格式并完整解码一次, 返回时长、采样率与声道数; 无法识别或解码失败时返回错误。
+// 完整解码而不是只解析文件头, 是为了在导入时就发现损坏或编码参数不受支持的文件。
+func Probe(r io.ReadSeekCloser) (Info, error) {
+	ext, err := Sniff(r)
+	if err != nil {
+		return Info{}, err
+	}
+	streamer, format, err := Decode(r, ext)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to decode %s audio: %w", ext, err)
+	}
+	defer streamer.Close()
+
+	samples := 0
+	buf := make([][2]float64, 4096)
+	for {
+		n, ok := streamer.Stream(buf)
+		samples += n
+		if !ok {
+			break
+		}
+	}
+	if err := streamer.Err(); err != nil {
+		return Info{}, fmt.Errorf("failed to decode %s audio: %w", ext, err)
+	}
+	if samples == 0 {
+		return Info{}, fmt.Errorf("failed to decode %s audio: no samples", ext)
+	}
+
+	return Info{
+		Format:     ext,
+		DurationMS: float64(format.SampleRate.D(samples)) / float64(time.Millisecond),
+		SampleRate: int(format.SampleRate),
+		Channels:   format.NumChannels,
+	}, nil
+}
+
+// NewMemoryStreamer 返回播放内存中 samples 的可寻址流(如用于 wav.Encode)。
+func NewMemoryStreamer(samples [][2]float64) beep.StreamSeekCloser {
+	return &memoryStreamer{samples: samples}
+}
+
+// memoryStreamer 为完整解码到内存中的音频流, 供不支持流式解码的格式(AIFF、Opus)使用。
+type memoryStreamer struct {
+	samples [][2]float64
+	pos     int
+	// closer 为解码来源, 与 beep 的解码器一致, 在 Close 时一并关闭
+	closer io.Closer
+}
+
+func (m *memoryStreamer) Stream(samples [][2]float64) (int, bool) {
+	if m.pos >= len(m.samples) {
+		return 0, false
+	}
+	n := copy(samples, m.samples[m.pos:])
+	m.pos += n
+	return n, true
+}
+
+func (m *memoryStreamer) Err() error    { return nil }
+func (m *memoryStreamer) Len() int      { return len(m.samples) }
+func (m *memoryStreamer) Position() int { return m.pos }
+
+func (m *memoryStreamer) Close() error {
+	if m.closer == nil {
+		return nil
+	}
+	return m.closer.Close()
+}
+
+func (m *memoryStreamer) Seek(p int) error {
+	if p < 0 || p > len(m.samples) {
+		return fmt.Errorf("seek position %d out of range [0, %d]", p, len(m.samples))
+	}
+	m.pos = p
+	return nil
+}
@@ -0,0 +1,17 @@
+package audio
+
+import "testing"
+
+// TestIsSupported 验证扩展名按 formatTable 判断且不区分大小写。
+func TestIsSupported(t *testing.T) {
+	for _, ext := range []string{".wav", ".MP3", ".ogg", ".opus", ".flac", ".aiff", ".aif", ".AIFC"} {
+		if !IsSupported(ext) {
+			t.Fatalf("expected %s to be supported", ext)
+		}
+	}
+	for _, ext := range []string{"", ".txt", "wav", ".m4a"} {
+		if IsSupported(ext) {
+			t.Fatalf("expected %q to be unsupported", ext)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+// =============================
+// 起音检测(一段打字录音 -> 多个裁剪声音)
+// =============================
+//
+// 常见的制作方式是录一整段打字, 再在波形编辑器中逐个放置 sounds.<uuid>.cut。DetectOnsets 自动给出候选裁剪范围:
+//
+//  1. 以约 5ms 的步长分帧, 计算新颖度曲线: flux(默认, 对数幅度谱的正向谱通量)或 energy(对数能量的正向差分);
+//  2. 将曲线按最大值归一化, 取局部最大且高于"局部均值 + delta"的帧为起音; sensitivity 同时决定 delta
+//     与电平门限(比最响的帧低 20dB + 40dB*sensitivity 以内), 对数域的新颖度与音量无关, 需要后者才能过滤过轻的声音;
+//  3. 在命中的帧内按 1ms 包络从峰值向前回溯, 找到包络首次低于峰值 1/4 处, 作为精确的起音时刻;
+//  4. 每个范围从起音前 pre_roll_ms 开始, 到包络衰减 decay_db 处结束, 且不超过 max_length_ms 与下一个范围的起点。
+//
+// SDK(keySound.DetectAudioFileOnsets)与 ktalbum-tools 的 detect-onsets 命令均调用这里的实现。
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// 新颖度曲线的计算方法。
+const (
+	OnsetMethodFlux   = "flux"
+	OnsetMethodEnergy = "energy"
+)
+
+const (
+	defaultOnsetSensitivity = 0.5
+	defaultOnsetMinGapMS    = 60.0
+	defaultOnsetPreRollMS   = 5.0
+	defaultOnsetMaxLengthMS = 300.0
+	defaultOnsetDecayDB     = 40.0
+	// onsetSilenceDB 为起音帧的最低电平(dBFS), 低于它的"起音"视为底噪波动
+	onsetSilenceDB = -60.0
+	// onsetLocalWindowMS 为自适应阈值计算局部均值的半窗长
+	onsetLocalWindowMS = 100.0
+)
+
+// OnsetOptions 为起音检测的可调参数, 零值字段使用默认值。
+type OnsetOptions struct {
+	// Method 为 flux(默认) 或 energy
+	Method string `json:"method"`
+	// Sensitivity 取值 (0, 1], 越大越容易判定为起音, 默认 0.5
+	Sensitivity float64 `json:"sensitivity"`
+	// MinGapMS 为相邻起音的最小间隔
+	MinGapMS float64 `json:"min_gap_ms"`
+	// PreRollMS 为裁剪起点相对起音提前的时长, 避免切掉起音的前沿
+	PreRollMS float64 `json:"pre_roll_ms"`
+	// MaxLengthMS 为单个裁剪范围的最大时长
+	MaxLengthMS float64 `json:"max_length_ms"`
+	// DecayDB 为判定声音结束的衰减量(相对该声音的峰值)
+	DecayDB float64 `json:"decay_db"`
+}
+
+// withDefaults 校验选项并填充默认值。
+func (o OnsetOptions) withDefaults() (OnsetOptions, error) {
+	o.Method = strings.ToLower(strings.TrimSpace(o.Method))
+	if o.Method == "" {
+		o.Method = OnsetMethodFlux
+	}
+	if o.Method != OnsetMethodFlux && o.Method != OnsetMethodEnergy {
+		return o, fmt.Errorf("unsupported onset method %q", o.Method)
+	}
+	if o.Sensitivity < 0 || o.Sensitivity > 1 {
+		return o, fmt.Errorf("sensitivity must be within (0, 1], got %v", o.Sensitivity)
+	}
+	if o.MinGapMS < 0 || o.PreRollMS < 0 || o.MaxLengthMS < 0 || o.DecayDB < 0 {
+		return o, errors.New("min_gap_ms, pre_roll_ms, max_length_ms and decay_db must not be negative")
+	}
+	if o.Sensitivity == 0 {
+		o.Sensitivity = defaultOnsetSensitivity
+	}
+	if o.MinGapMS == 0 {
+		o.MinGapMS = defaultOnsetMinGapMS
+	}
+	if o.PreRollMS == 0 {
+		o.PreRollMS = defaultOnsetPreRollMS
+	}
+	if o.MaxLengthMS == 0 {
+		o.MaxLengthMS = defaultOnsetMaxLengthMS
+	}
+	if o.DecayDB == 0 {
+		o.DecayDB = defaultOnsetDecayDB
+	}
+	return o, nil
+}
+
+// Validate 检查选项取值是否合理。
+func (o OnsetOptions) Validate() error {
+	_, err := o.withDefaults()
+	return err
+}
+
+// OnsetRange 为一个候选裁剪范围, 时间单位均为毫秒, 可直接写入 sounds.<uuid>.cut。
+type OnsetRange struct {
+	OnsetMS float64 `json:"onsetMs"`
+	StartMS float64 `json:"startMs"`
+	EndMS   float64 `json:"endMs"`
+	// Strength 为归一化后的新颖度(0-1), 便于前端按强弱筛选
+	Strength float64 `json:"strength"`
+}
+
+// DetectOnsets 在 samples 中检测起音并返回按时间排序、互不重叠的候选裁剪范围。
+func DetectOnsets(samples [][2]float64, sampleRate beep.SampleRate, opts OnsetOptions) ([]OnsetRange, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	if sampleRate <= 0 {
+		return nil, errors.New("invalid sample rate")
+	}
+	if len(samples) == 0 {
+		return []OnsetRange{}, nil
+	}
+
+	mono := make([]float64, len(samples))
+	for i, s := range samples {
+		mono[i] = (s[0] + s[1]) / 2
+	}
+	rate := float64(sampleRate)
+	msToSamples := func(ms float64) int { return int(math.Round(ms * rate / 1000)) }
+
+	hop := max(1, msToSamples(5))
+	frameSize := 1
+	for float64(frameSize) < rate*0.023 {
+		frameSize *= 2
+	}
+	frameCount := (len(mono) + hop - 1) / hop
+	frame := func(n int) []float64 {
+		out := make([]float64, frameSize)
+		start := n * hop
+		if start < len(mono) {
+			copy(out, mono[start:min(len(mono), start+frameSize)])
+		}
+		return out
+	}
+
+	// 1. 新颖度曲线与每帧电平
+	novelty := make([]float64, frameCount)
+	levels := make([]float64, frameCount)
+	var previous []float64
+	window := HannWindow(frameSize)
+	for n := 0; n < frameCount; n++ {
+		x := frame(n)
+		energy := 0.0
+		for _, v := range x {
+			energy += v * v
+		}
+		energy /= float64(frameSize)
+		levels[n] = 10 * math.Log10(energy+1e-12)
+
+		var current []float64
+		if opts.Method == OnsetMethodEnergy {
+			current = []float64{math.Log10(energy + 1e-10)}
+		} else {
+			current = logMagnitudeSpectrum(x, window)
+		}
+		if previous != nil {
+			for k := range current {
+				novelty[n] += math.Max(0, current[k]-previous[k])
+			}
+		}
+		previous = current
+	}
+	peak := 0.0
+	for _, v := range novelty {
+		peak = math.Max(peak, v)
+	}
+	if peak == 0 {
+		return []OnsetRange{}, nil
+	}
+	for n := range novelty {
+		novelty[n] /= peak
+	}
+
+	// 2. 自适应阈值 + 电平门限 + 局部最大 + 最小间隔
+	delta := 0.05 + 0.45*(1-opts.Sensitivity)
+	loudest := math.Inf(-1)
+	for _, level := range levels {
+		loudest = math.Max(loudest, level)
+	}
+	gate := math.Max(onsetSilenceDB, loudest-(20+40*opts.Sensitivity))
+	localFrames := max(1, msToSamples(onsetLocalWindowMS)/hop)
+	gapFrames := max(1, msToSamples(opts.MinGapMS)/hop)
+	var picked []int
+	for n := 1; n < frameCount; n++ {
+		if novelty[n] == 0 || levels[n] < gate {
+			continue
+		}
+		sum, count := 0.0, 0
+		isLocalMax := true
+		for m := max(0, n-localFrames); m <= min(frameCount-1, n+localFrames); m++ {
+			sum += novelty[m]
+			count++
+			if m != n && absInt(m-n) <= gapFrames && (novelty[m] > novelty[n] || novelty[m] == novelty[n] && m < n) {
+				isLocalMax = false
+			}
+		}
+		if !isLocalMax || novelty[n] < sum/float64(count)+delta {
+			continue
+		}
+		if len(picked) > 0 && n-picked[len(picked)-1] < gapFrames {
+			if novelty[n] > novelty[picked[len(picked)-1]] {
+				picked[len(picked)-1] = n
+			}
+			continue
+		}
+		picked = append(picked, n)
+	}
+
+	// 3. 精确起音时刻(按 1ms 包络回溯)
+	block := max(1, msToSamples(1))
+	envelope := make([]float64, (len(mono)+block-1)/block)
+	for i, v := range mono {
+		envelope[i/block] = math.Max(envelope[i/block], math.Abs(v))
+	}
+	type onset struct {
+		sample   int
+		strength float64
+	}
+	onsets := make([]onset, 0, len(picked))
+	for _, n := range picked {
+		first := n * hop / block
+		last := min(len(envelope), (n*hop+frameSize+block-1)/block)
+		peakBlock := first
+		for b := first; b < last; b++ {
+			if envelope[b] > envelope[peakBlock] {
+				peakBlock = b
+			}
+		}
+		onsetBlock := peakBlock
+		for onsetBlock > 0 && envelope[onsetBlock-1] >= envelope[peakBlock]/4 {
+			onsetBlock--
+		}
+		if len(onsets) > 0 && onsetBlock*block <= onsets[len(onsets)-1].sample {
+			continue
+		}
+		onsets = append(onsets, onset{sample: onsetBlock * block, strength: novelty[n]})
+	}
+
+	// 4. 裁剪范围
+	ranges := make([]OnsetRange, 0, len(onsets))
+	toMS := func(sample int) float64 { return float64(sample) * 1000 / rate }
+	decay := math.Pow(10, -opts.DecayDB/20)
+	floor := math.Pow(10, onsetSilenceDB/20)
+	previousEnd := 0
+	for i, current := range onsets {
+		start := max(previousEnd, current.sample-msToSamples(opts.PreRollMS))
+		limit := min(len(mono), current.sample+msToSamples(opts.MaxLengthMS))
+		if i+1 < len(onsets) {
+			limit = min(limit, max(current.sample+1, onsets[i+1].sample-msToSamples(opts.PreRollMS)))
+		}
+
+		end := limit
+		peakLevel := 0.0
+		for b := current.sample / block; b*block < limit; b++ {
+			peakLevel = math.Max(peakLevel, envelope[b])
+			if envelope[b] < math.Max(peakLevel*decay, floor) && peakLevel > 0 {
+				end = min(limit, (b+1)*block)
+				break
+			}
+		}
+
+		ranges = append(ranges, OnsetRange{
+			OnsetMS:  math.Round(toMS(current.sample)),
+			StartMS:  math.Floor(toMS(start)),
+			EndMS:    math.Ceil(toMS(end)),
+			Strength: current.strength,
+		})
+		previousEnd = end
+	}
+	return ranges, nil
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// HannWindow 返回长度为 size 的 Hann 窗(起音检测与频谱图共用)。
+func HannWindow(size int) []float64 {
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size))
+	}
+	return window
+}
+
+// logMagnitudeSpectrum 返回加窗后一帧的对数压缩幅度谱(仅正频率部分)。
+func logMagnitudeSpectrum(frame []float64, window []float64) []float64 {
+	spectrum := make([]complex128, len(frame))
+	for i, v := range frame {
+		spectrum[i] = complex(v*window[i], 0)
+	}
+	FFT(spectrum)
+	magnitudes := make([]float64, len(frame)/2+1)
+	for k := range magnitudes {
+		magnitudes[k] = math.Log1p(100 * cmplx.Abs(spectrum[k]))
+	}
+	return magnitudes
+}
+
+// FFT 为原地的基 2 迭代 FFT, len(x) 必须是 2 的幂。
+func FFT(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
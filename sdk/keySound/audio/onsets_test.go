@@ -0,0 +1,82 @@
+package audio
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// typingRecording 生成一段模拟的打字录音: 低电平底噪上叠加若干指数衰减的噪声脉冲(每个约 60ms 后衰减 50dB 以上)。
+func typingRecording(sampleRate int, durationMS int, onsetsMS []int, amplitudes []float64) [][2]float64 {
+	random := rand.New(rand.NewSource(1))
+	samples := make([][2]float64, sampleRate*durationMS/1000)
+	for i := range samples {
+		v := (random.Float64()*2 - 1) * 0.0002
+		samples[i] = [2]float64{v, v}
+	}
+	for index, onsetMS := range onsetsMS {
+		start := sampleRate * onsetMS / 1000
+		for i := start; i < len(samples) && i < start+sampleRate/5; i++ {
+			t := float64(i-start) / float64(sampleRate)
+			v := (random.Float64()*2 - 1) * amplitudes[index] * math.Exp(-t/0.01)
+			samples[i][0] += v
+			samples[i][1] += v
+		}
+	}
+	return samples
+}
+
+// TestDetectOnsets 验证两种方法都能在 ±3ms 内找到每个起音, 范围互不重叠, 且结束于声音衰减处而非下一个起音。
+func TestDetectOnsets(t *testing.T) {
+	onsetsMS := []int{200, 520, 830, 1150}
+	samples := typingRecording(44100, 1500, onsetsMS, []float64{0.6, 0.3, 0.8, 0.4})
+
+	for _, method := range []string{OnsetMethodFlux, OnsetMethodEnergy} {
+		ranges, err := DetectOnsets(samples, 44100, OnsetOptions{Method: method})
+		if err != nil {
+			t.Fatalf("%s: DetectOnsets returned error: %v", method, err)
+		}
+		if len(ranges) != len(onsetsMS) {
+			t.Fatalf("%s: got %d onsets, want %d: %+v", method, len(ranges), len(onsetsMS), ranges)
+		}
+		for i, r := range ranges {
+			if math.Abs(r.OnsetMS-float64(onsetsMS[i])) > 3 {
+				t.Fatalf("%s: onset %d at %vms, want %dms", method, i, r.OnsetMS, onsetsMS[i])
+			}
+			if r.StartMS > r.OnsetMS || r.OnsetMS-r.StartMS > defaultOnsetPreRollMS+1 {
+				t.Fatalf("%s: unexpected pre-roll: %+v", method, r)
+			}
+			// 10ms 时间常数下衰减 40dB 约需 46ms
+			if length := r.EndMS - r.OnsetMS; length < 30 || length > 120 {
+				t.Fatalf("%s: unexpected range length %vms: %+v", method, length, r)
+			}
+			if i > 0 && r.StartMS < ranges[i-1].EndMS {
+				t.Fatalf("%s: overlapping ranges: %+v %+v", method, ranges[i-1], r)
+			}
+		}
+	}
+}
+
+// TestDetectOnsetsSensitivity 验证灵敏度: 明显偏弱的脉冲仅在高灵敏度下被检测到; 纯底噪不产生起音。
+func TestDetectOnsetsSensitivity(t *testing.T) {
+	samples := typingRecording(44100, 1000, []int{200, 600}, []float64{0.8, 0.02})
+
+	low, err := DetectOnsets(samples, 44100, OnsetOptions{Method: OnsetMethodEnergy, Sensitivity: 0.1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	high, err := DetectOnsets(samples, 44100, OnsetOptions{Method: OnsetMethodEnergy, Sensitivity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(low) != 1 || len(high) != 2 {
+		t.Fatalf("unexpected onset counts: low %+v, high %+v", low, high)
+	}
+
+	if ranges, err := DetectOnsets(typingRecording(44100, 500, nil, nil), 44100, OnsetOptions{}); err != nil || len(ranges) != 0 {
+		t.Fatalf("expected no onsets in background noise: %+v (%v)", ranges, err)
+	}
+	if _, err := DetectOnsets(samples, 44100, OnsetOptions{Method: "unknown"}); err == nil {
+		t.Fatal("expected an unknown method to be rejected")
+	}
+}
@@ -0,0 +1,99 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/pion/opus"
+	"github.com/pion/opus/pkg/oggreader"
+)
+
+// opusSampleRate 为 Opus 的解码采样率(Ogg Opus 的粒度位置也以 48kHz 计)。
+const opusSampleRate = 48000
+
+// opusMaxFrameSamples 为单个 Opus 数据包最多包含的每声道样本数(120ms)。
+const opusMaxFrameSamples = opusSampleRate * 120 / 1000
+
+// decodeOggOpus 将 Ogg 封装的 Opus 完整解码到内存(RFC 7845), 按 pre-skip 与末页粒度位置裁掉编码器填充,
+// 并应用头部的输出增益。仅支持单声道与立体声(channel mapping family 0)。
+func decodeOggOpus(r io.ReadCloser) (beep.StreamSeekCloser, beep.Format, error) {
+	reader, header, err := oggreader.NewWith(r)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("opus: %w", err)
+	}
+	channels := int(header.Channels)
+	if header.ChannelMap != 0 || channels < 1 || channels > 2 {
+		return nil, beep.Format{}, fmt.Errorf("opus: unsupported channel layout (mapping %d, %d channels)", header.ChannelMap, channels)
+	}
+	decoder, err := opus.NewDecoderWithOutput(opusSampleRate, channels)
+	if err != nil {
+		return nil, beep.Format{}, fmt.Errorf("opus: %w", err)
+	}
+	gain := math.Pow(10, float64(int16(header.OutputGain))/(20*256))
+
+	pcm := make([]float32, opusMaxFrameSamples*channels)
+	samples := make([][2]float64, 0, opusSampleRate)
+	var lastGranule uint64
+	for {
+		packet, pageHeader, err := reader.ParseNextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, beep.Format{}, fmt.Errorf("opus: %w", err)
+		}
+		// 第二个头部数据包(OpusTags)不含音频
+		if bytes.HasPrefix(packet, []byte("OpusTags")) {
+			continue
+		}
+		if pageHeader != nil {
+			lastGranule = pageHeader.GranulePosition
+		}
+
+		n, err := decoder.DecodeToFloat32(packet, pcm)
+		if err != nil {
+			return nil, beep.Format{}, fmt.Errorf("opus: %w", err)
+		}
+		for i := 0; i < n; i++ {
+			left := float64(pcm[i*channels]) * gain
+			right := left
+			if channels == 2 {
+				right = float64(pcm[i*channels+1]) * gain
+			}
+			samples = append(samples, [2]float64{left, right})
+		}
+	}
+
+	// 开头的 pre-skip 为编码器预热样本; 末页的粒度位置(含 pre-skip)给出了有效样本的终点
+	preSkip := min(int(header.PreSkip), len(samples))
+	if lastGranule > 0 && lastGranule < uint64(len(samples)) {
+		samples = samples[:lastGranule]
+	}
+	samples = samples[preSkip:]
+
+	format := beep.Format{SampleRate: opusSampleRate, NumChannels: channels, Precision: 2}
+	return &memoryStreamer{samples: samples, closer: r}, format, nil
+}
@@ -0,0 +1,96 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package audio
+
+// =============================
+// 离线渲染说明
+// =============================
+//
+// Render 与 KeyTone 的试听(预览模式)完全一致: 解码 -> 裁剪(含淡入/淡出包络) -> 重采样 -> 声音自身的音量与响度增益,
+// 但不叠加任何全局/路由/随机音量, 因为这些属于播放端设置而非专辑内容。
+// keySound 的离线渲染与 ktalbum-tools 的 Mechvibes 导出都通过这里渲染。
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/effects"
+)
+
+// Render 渲染 path 中由 cut 指定的片段(cut 为 nil 时渲染整个文件), 输出 sampleRate 下最长 maxDuration 的双声道样本。
+// gainDB 为该文件的响度增益(见 LoudnessGainDB); 空裁剪渲染为空结果而不是错误。
+func Render(path string, cut *Cut, gainDB float64, sampleRate beep.SampleRate, maxDuration time.Duration) ([][2]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	streamer, format, err := Decode(file, filepath.Ext(path))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+	defer streamer.Close()
+
+	// 先裁剪(原始采样率), 再重采样, 避免重采样器预读到裁剪区间外的数据
+	source, initVolume, err := PrepareCut(streamer, format.SampleRate, cut)
+	if err != nil {
+		if errors.Is(err, ErrEmptyCut) {
+			return [][2]float64{}, nil
+		}
+		return nil, fmt.Errorf("failed to prepare playback source: %w", err)
+	}
+	output := &effects.Volume{
+		Streamer: beep.Resample(4, format.SampleRate, sampleRate, source),
+		Base:     1.6,
+		Volume:   initVolume + LoudnessGainVolume(gainDB),
+		Silent:   false,
+	}
+
+	samples := make([][2]float64, 0)
+	buffer := make([][2]float64, 512)
+	remaining := sampleRate.N(maxDuration)
+	for remaining > 0 {
+		count, ok := output.Stream(buffer[:min(len(buffer), remaining)])
+		samples = append(samples, buffer[:count]...)
+		remaining -= count
+		if !ok || count == 0 {
+			break
+		}
+	}
+	return samples, nil
+}
+
+// RenderSound 渲染专辑中的一个声音(sounds.<soundID>), 裁剪、包络与音量均已应用。
+func RenderSound(get ConfigGetter, albumPath string, soundID string, sampleRate beep.SampleRate, maxDuration time.Duration) ([][2]float64, error) {
+	sha256, fileType, cut, err := SoundCut(get, soundID)
+	if err != nil {
+		return nil, err
+	}
+	return Render(AlbumAudioFile(get, albumPath, sha256, fileType), cut, LoudnessGainDB(get, sha256), sampleRate, maxDuration)
+}
+
+// RenderAudioFile 渲染专辑中的一个完整音频文件(audioFiles/<sha256><type>, 存在处理后的文件时使用处理后的文件)。
+func RenderAudioFile(get ConfigGetter, albumPath string, sha256 string, fileType string, sampleRate beep.SampleRate, maxDuration time.Duration) ([][2]float64, error) {
+	return Render(AlbumAudioFile(get, albumPath, sha256, fileType), nil, LoudnessGainDB(get, sha256), sampleRate, maxDuration)
+}
@@ -0,0 +1,363 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 音频输出后端（AudioOutput）说明
+// =============================
+//
+// PlayKeySound 不再直接调用 speaker, 而是把最终的 Streamer 交给当前的输出后端:
+//   - speaker: 默认后端, 通过 beep/speaker 输出到系统声卡(与旧行为一致);
+//   - memory : 不访问声卡, 将每次播放的样本按触发时刻混入内存, 供测试/CI 断言;
+//   - file   : 在 memory 的基础上, 于 Close 时把混音结果写为 WAV 文件;
+//   - null   : 立即消费并丢弃所有样本, 用于无声卡的机器上运行 SDK。
+//
+// 后端由 main.go 中的 -audioBackend 参数选择, 并通过 InitAudioOutput 初始化。
+// 若从未显式初始化, 首次播放时会按 speaker 后端懒加载; speaker 初始化失败时回退到 null, 不再 panic。
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"KeyTone/logger"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/speaker"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// AudioOutput 是键音播放的输出后端。
+// Play 可以是异步的(如 speaker), 但必须保证每个 Streamer 最终被完整消费, 使 beep.Callback 得以触发。
+type AudioOutput interface {
+	// Name 返回后端名称(与 -audioBackend 参数取值一致)。
+	Name() string
+	// Play 播放(或消费)给定的 Streamer, 采样率固定为 formatGlobalSampleRate。
+	Play(streamers ...beep.Streamer)
+	// Close 释放后端资源; file 后端在此时写出 WAV 文件。
+	Close() error
+}
+
+// 音频输出后端名称
+const (
+	AudioBackendSpeaker = "speaker"
+	AudioBackendMemory  = "memory"
+	AudioBackendFile    = "file"
+	AudioBackendNull    = "null"
+)
+
+// maxCapturedDuration 为 memory/file/null 后端单次消费的最长时长。
+// 防止无限长的 Streamer(如 beep.Silence(-1))在同步消费时卡死调用方。
+const maxCapturedDuration = time.Minute
+
+var (
+	audioOutput      AudioOutput
+	audioOutputMutex sync.RWMutex
+)
+
+// InitAudioOutput 按名称创建并设置输出后端。
+//   - backend: speaker | memory | file | null, 为空时使用 speaker;
+//   - filePath: file 后端写出的 WAV 路径, 其它后端忽略该参数。
+func InitAudioOutput(backend string, filePath string) error {
+	output, err := NewAudioOutput(backend, filePath)
+	if err != nil {
+		return err
+	}
+	SetAudioOutput(output)
+	return nil
+}
+
+// NewAudioOutput 按名称创建输出后端, 但不替换当前使用的后端。
+func NewAudioOutput(backend string, filePath string) (AudioOutput, error) {
+	switch strings.ToLower(strings.TrimSpace(backend)) {
+	case "", AudioBackendSpeaker:
+		return newSpeakerOutput()
+	case AudioBackendMemory:
+		return NewMemoryOutput(), nil
+	case AudioBackendFile:
+		if strings.TrimSpace(filePath) == "" {
+			return nil, errors.New("audio backend 'file' requires an output file path")
+		}
+		return NewFileOutput(filePath), nil
+	case AudioBackendNull:
+		return nullOutput{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported audio backend: %s", backend)
+	}
+}
+
+// SetAudioOutput 替换当前输出后端, 旧后端会被关闭。
+func SetAudioOutput(output AudioOutput) {
+	audioOutputMutex.Lock()
+	previous := audioOutput
+	audioOutput = output
+	audioOutputMutex.Unlock()
+
+	if previous != nil && previous != output {
+		if err := previous.Close(); err != nil {
+			logger.Error("关闭音频输出后端时发生错误", "backend", previous.Name(), "err", err.Error())
+		}
+	}
+}
+
+// CurrentAudioOutput 返回当前输出后端; 尚未初始化时按 speaker 后端懒加载。
+func CurrentAudioOutput() AudioOutput {
+	audioOutputMutex.RLock()
+	output := audioOutput
+	audioOutputMutex.RUnlock()
+	if output != nil {
+		return output
+	}
+
+	audioOutputMutex.Lock()
+	defer audioOutputMutex.Unlock()
+	if audioOutput == nil {
+		speakerOutput, err := newSpeakerOutput()
+		if err != nil {
+			// 没有可用声卡时不再 panic, 而是静默消费样本, 保证其余功能(如编辑器、导入导出)可用。
+			logger.Error("初始化 speaker 失败, 已回退到 null 音频输出后端", "err", err.Error())
+			audioOutput = nullOutput{}
+		} else {
+			audioOutput = speakerOutput
+		}
+	}
+	return audioOutput
+}
+
+// CloseAudioOutput 关闭当前输出后端(如 file 后端需要在进程退出前写出文件)。
+func CloseAudioOutput() error {
+	audioOutputMutex.Lock()
+	output := audioOutput
+	audioOutput = nil
+	audioOutputMutex.Unlock()
+	if output == nil {
+		return nil
+	}
+	return output.Close()
+}
+
+//region speaker 后端
+
+type speakerOutput struct{}
+
+// speaker 只能初始化一次(重复 Init 会重建设备), 因此在包级别记录其初始化结果。
+var (
+	speakerInitOnce sync.Once
+	speakerInitErr  error
+)
+
+func newSpeakerOutput() (AudioOutput, error) {
+	speakerInitOnce.Do(func() {
+		// 初始化speaker。
+		// 第二个参数的值, 不会对音质产生影响, 它只是缓冲区的大小。
+		// > bufferSize参数指定扬声器缓冲区的样本数。更大的缓冲区大小意味着更低的CPU使用率和更可靠的播放。较低的缓冲区大小意味着更好的响应性和更少的延迟。
+		// > * 缓冲区越大, cpu压力越小, 播放的整个过程崩溃率也会降低。(个人理解)
+		// > * 缓冲区越小, cpu压力越大, 会得到更快的响应性和更少的延时。(个人理解)
+		// > 鉴于个人的以上理解, 这个数值对我们KeyTone项目来说, 缓冲区设置的越小越好。
+		// > * 但实际测试下来, 缓冲区无论如何设置, 其响应到播放完毕的用时都只有最大20ms作用的波动, 而且绝大部分时候, 波动仅有1ms左右。因此给其一个固定的值即可
+		speakerInitErr = speaker.Init(formatGlobalSampleRate, formatGlobalSampleRate.N(time.Second/36))
+	})
+	if speakerInitErr != nil {
+		return nil, speakerInitErr
+	}
+	return speakerOutput{}, nil
+}
+
+func (speakerOutput) Name() string { return AudioBackendSpeaker }
+
+func (speakerOutput) Play(streamers ...beep.Streamer) { speaker.Play(streamers...) }
+
+// Close 不关闭 speaker 设备本身: speaker 在进程内只初始化一次, 关闭后无法再次使用。
+func (speakerOutput) Close() error { return nil }
+
+//endregion speaker 后端
+
+//region null 后端
+
+type nullOutput struct{}
+
+func (nullOutput) Name() string { return AudioBackendNull }
+
+func (nullOutput) Play(streamers ...beep.Streamer) {
+	for _, streamer := range streamers {
+		drainStreamer(streamer, nil)
+	}
+}
+
+func (nullOutput) Close() error { return nil }
+
+//endregion null 后端
+
+//region memory 后端
+
+// CapturedSound 为 memory 后端捕获到的一次播放。
+type CapturedSound struct {
+	// Offset 为本次播放相对于后端创建时刻的偏移(以 formatGlobalSampleRate 计的样本数)。
+	Offset int
+	// Samples 为本次播放输出的全部样本(已经过音量处理链)。
+	Samples [][2]float64
+}
+
+// MemoryOutput 将每次播放同步消费到内存中, 既保留逐次捕获的结果, 也可按触发时刻混音。
+type MemoryOutput struct {
+	mutex    sync.Mutex
+	start    time.Time
+	captures []CapturedSound
+	// now 返回当前时间, 测试中可替换以获得确定的 Offset。
+	now func() time.Time
+}
+
+func NewMemoryOutput() *MemoryOutput {
+	return &MemoryOutput{start: time.Now(), now: time.Now}
+}
+
+func (m *MemoryOutput) Name() string { return AudioBackendMemory }
+
+func (m *MemoryOutput) Play(streamers ...beep.Streamer) {
+	// 四舍五入到最近的样本, 避免时长换算的浮点误差使 Offset 少一个样本。
+	m.mutex.Lock()
+	offset := int(math.Round(m.now().Sub(m.start).Seconds() * float64(formatGlobalSampleRate)))
+	m.mutex.Unlock()
+	for _, streamer := range streamers {
+		samples := make([][2]float64, 0, 4096)
+		drainStreamer(streamer, func(chunk [][2]float64) {
+			samples = append(samples, chunk...)
+		})
+
+		m.mutex.Lock()
+		m.captures = append(m.captures, CapturedSound{Offset: offset, Samples: samples})
+		m.mutex.Unlock()
+	}
+}
+
+func (m *MemoryOutput) Close() error { return nil }
+
+// Captures 返回到目前为止捕获的全部播放(按调用顺序)。
+func (m *MemoryOutput) Captures() []CapturedSound {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	captures := make([]CapturedSound, len(m.captures))
+	copy(captures, m.captures)
+	return captures
+}
+
+// Mixdown 将所有捕获按各自的 Offset 叠加为一条连续的样本序列。
+func (m *MemoryOutput) Mixdown() [][2]float64 {
+	captures := m.Captures()
+	length := 0
+	for _, capture := range captures {
+		if end := capture.Offset + len(capture.Samples); end > length {
+			length = end
+		}
+	}
+	mixed := make([][2]float64, length)
+	for _, capture := range captures {
+		for index, sample := range capture.Samples {
+			mixed[capture.Offset+index][0] += sample[0]
+			mixed[capture.Offset+index][1] += sample[1]
+		}
+	}
+	return mixed
+}
+
+// Reset 清空已捕获的内容, 并以当前时刻作为新的 Offset 起点。
+func (m *MemoryOutput) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.captures = nil
+	m.start = m.now()
+}
+
+//endregion memory 后端
+
+//region file 后端
+
+// FileOutput 在 MemoryOutput 的基础上, 于 Close 时把混音结果写出为 16bit 立体声 WAV。
+type FileOutput struct {
+	*MemoryOutput
+	path string
+}
+
+func NewFileOutput(path string) *FileOutput {
+	return &FileOutput{MemoryOutput: NewMemoryOutput(), path: path}
+}
+
+func (f *FileOutput) Name() string { return AudioBackendFile }
+
+// Path 返回 WAV 文件的写出路径。
+func (f *FileOutput) Path() string { return f.path }
+
+func (f *FileOutput) Close() error {
+	file, err := os.Create(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to create output wav file: %w", err)
+	}
+	defer file.Close()
+
+	format := beep.Format{SampleRate: formatGlobalSampleRate, NumChannels: 2, Precision: 2}
+	if err := wav.Encode(file, &sampleSliceStreamer{samples: f.Mixdown()}, format); err != nil {
+		return fmt.Errorf("failed to encode output wav file: %w", err)
+	}
+	return nil
+}
+
+//endregion file 后端
+
+// sampleSliceStreamer 以内存中的样本切片作为 Streamer(用于写出 WAV)。
+type sampleSliceStreamer struct {
+	samples  [][2]float64
+	position int
+}
+
+func (s *sampleSliceStreamer) Stream(samples [][2]float64) (int, bool) {
+	if s.position >= len(s.samples) {
+		return 0, false
+	}
+	count := copy(samples, s.samples[s.position:])
+	s.position += count
+	return count, true
+}
+
+func (s *sampleSliceStreamer) Err() error { return nil }
+
+// drainStreamer 同步消费 streamer 直至结束(或达到 maxCapturedDuration), 每读到一块样本即回调 onChunk。
+// 读到 0 个样本时同样视为结束, 以免不推进的 streamer(始终返回 0, true)使调用方永远阻塞。
+func drainStreamer(streamer beep.Streamer, onChunk func([][2]float64)) {
+	buffer := make([][2]float64, 512)
+	remaining := formatGlobalSampleRate.N(maxCapturedDuration)
+	for remaining > 0 {
+		size := len(buffer)
+		if size > remaining {
+			size = remaining
+		}
+		count, ok := streamer.Stream(buffer[:size])
+		if count > 0 && onChunk != nil {
+			onChunk(buffer[:count])
+		}
+		remaining -= count
+		if !ok || count == 0 {
+			return
+		}
+	}
+}
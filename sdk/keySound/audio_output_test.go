@@ -0,0 +1,142 @@
+package keySound
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// useTestAudioOutput 在测试期间替换当前输出后端, 测试结束后恢复。
+func useTestAudioOutput(t *testing.T, output AudioOutput) {
+	t.Helper()
+	audioOutputMutex.Lock()
+	original := audioOutput
+	audioOutput = output
+	audioOutputMutex.Unlock()
+	t.Cleanup(func() {
+		audioOutputMutex.Lock()
+		audioOutput = original
+		audioOutputMutex.Unlock()
+	})
+}
+
+// TestNewAudioOutputRejectsUnknownBackend 验证后端名称的解析与参数校验。
+func TestNewAudioOutputRejectsUnknownBackend(t *testing.T) {
+	if _, err := NewAudioOutput("alsa", ""); err == nil {
+		t.Fatal("expected error for unknown backend")
+	}
+	if _, err := NewAudioOutput(AudioBackendFile, ""); err == nil {
+		t.Fatal("expected error for file backend without path")
+	}
+	output, err := NewAudioOutput("Memory", "")
+	if err != nil || output.Name() != AudioBackendMemory {
+		t.Fatalf("unexpected memory backend: %v, %v", output, err)
+	}
+}
+
+// TestMemoryOutputMixdownHonoursOffsets 验证 memory 后端按触发时刻叠加多次播放。
+func TestMemoryOutputMixdownHonoursOffsets(t *testing.T) {
+	output := NewMemoryOutput()
+	current := output.start
+	output.now = func() time.Time { return current }
+
+	output.Play(newFakeStreamSeekCloser([]float64{1, 1, 1}))
+	current = current.Add(formatGlobalSampleRate.D(2))
+	output.Play(newFakeStreamSeekCloser([]float64{0.5, 0.5}))
+
+	captures := output.Captures()
+	if len(captures) != 2 || captures[1].Offset != 2 {
+		t.Fatalf("unexpected captures: %+v", captures)
+	}
+	mixed := output.Mixdown()
+	want := []float64{1, 1, 1.5, 0.5}
+	if len(mixed) != len(want) {
+		t.Fatalf("unexpected mixdown length: got %d want %d", len(mixed), len(want))
+	}
+	for index, value := range want {
+		if mixed[index][0] != value || mixed[index][1] != value {
+			t.Fatalf("unexpected sample at %d: got %v want %v", index, mixed[index], value)
+		}
+	}
+}
+
+// TestPlayKeySoundWritesToMemoryOutput 端到端验证: 一次模拟播放经完整的播放链后, 被 memory 后端完整捕获。
+func TestPlayKeySoundWritesToMemoryOutput(t *testing.T) {
+	useTestConfig(t, pcmCacheTestConfig)
+	useTestConfig(t, voiceTestConfig)
+	output := NewMemoryOutput()
+	useTestAudioOutput(t, output)
+
+	audioFilePath := &AudioFilePath{SS: "test_down.MP3"}
+	cut := &Cut{StartMS: 0, EndMS: 100}
+	PlayKeySound(audioFilePath, cut, "30", KeyStateDown, true)
+
+	captures := output.Captures()
+	if len(captures) != 1 {
+		t.Fatalf("unexpected capture count: got %d want 1", len(captures))
+	}
+
+	// 预览模式下音量为 0(倍率为 1), 捕获结果应与裁剪重采样后的源样本完全一致。
+	expected, _, release, err := preparePlaybackStreamer(audioFilePath, cut)
+	if err != nil {
+		t.Fatalf("preparePlaybackStreamer returned error: %v", err)
+	}
+	defer release()
+	want := collectLeftChannel(expected)
+	got := captures[0].Samples
+	if len(got) == 0 || len(got) != len(want) {
+		t.Fatalf("unexpected captured length: got %d want %d", len(got), len(want))
+	}
+	for index := range want {
+		if got[index][0] != want[index] {
+			t.Fatalf("unexpected captured sample at %d: got %v want %v", index, got[index][0], want[index])
+		}
+	}
+}
+
+// TestFileOutputWritesWav 验证 file 后端在 Close 时写出可被解码的 WAV 文件。
+func TestFileOutputWritesWav(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	output := NewFileOutput(path)
+	output.Play(beep.Take(441, beep.Silence(-1)))
+	if err := output.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open written wav: %v", err)
+	}
+	defer file.Close()
+	streamer, format, err := wav.Decode(file)
+	if err != nil {
+		t.Fatalf("failed to decode written wav: %v", err)
+	}
+	if format.SampleRate != formatGlobalSampleRate || streamer.Len() != 441 {
+		t.Fatalf("unexpected wav: rate=%d len=%d", format.SampleRate, streamer.Len())
+	}
+}
+
+// stalledStreamer 始终返回 (0, true), 模拟一个不再推进的 streamer。
+type stalledStreamer struct{}
+
+func (stalledStreamer) Stream(samples [][2]float64) (int, bool) { return 0, true }
+func (stalledStreamer) Err() error                              { return nil }
+
+// TestDrainStreamerStopsOnEmptyRead 验证不推进的 streamer 不会使 drainStreamer 陷入死循环。
+func TestDrainStreamerStopsOnEmptyRead(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		drainStreamer(stalledStreamer{}, nil)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drainStreamer did not return for a stalled streamer")
+	}
+}
@@ -0,0 +1,125 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 组合键(chords)说明
+// =============================
+//
+// 专辑可以在 key_tone.chords 下定义组合键触发的声音, 如 Ctrl+S、Ctrl+Z:
+//
+//	"chords": [
+//	  { "name": "save", "modifiers": "ctrl", "keycode": "31", "down": { "type": "sounds", "value": "<uuid>" } },
+//	  { "name": "undo", "modifiers": "ctrl", "keycode": 44, "mode": "overlay", "down": {...} }
+//	]
+//
+//   - modifiers: 修饰键组合(写法见 ParseModifiers), 必须与当前按住的修饰键完全一致, 且不能为空;
+//   - keycode: 触发键的 gohook keycode, 字符串或数字均可;
+//   - mode: replace(默认, 代替该按键原有的声音) 或 overlay(与原有的声音同时播放);
+//   - down/up/hold: 与 single/global 相同的 {type, value}; 未配置的状态不受组合键影响。
+//
+// 按顺序取第一个命中的组合键。
+
+import (
+	"KeyTone/logger"
+	"fmt"
+	"strings"
+)
+
+const (
+	ChordModeReplace = "replace"
+	ChordModeOverlay = "overlay"
+)
+
+// chordEffectPath 返回当前按键与修饰键命中的组合键在 keyState 下的配置路径, 以及是否为 overlay 模式;
+// 未命中时 path 为空。
+func chordEffectPath(get ConfigGetter, keycode string, keyState string, modifiers Modifiers) (string, bool) {
+	if modifiers == 0 {
+		return "", false
+	}
+	chords, _ := getValue(get, "key_tone.chords").([]interface{})
+	for index, chord := range chords {
+		chordMap, ok := chord.(map[string]interface{})
+		if !ok || strings.TrimSpace(fmt.Sprint(chordMap["keycode"])) != keycode {
+			continue
+		}
+		// 修饰键既可以写作 "ctrl+shift", 也可以写作 ["ctrl", "shift"]
+		var want Modifiers
+		var err error
+		switch value := chordMap["modifiers"].(type) {
+		case string:
+			want, err = ParseModifiers(value)
+		case []interface{}:
+			parts := make([]string, 0, len(value))
+			for _, part := range value {
+				parts = append(parts, fmt.Sprint(part))
+			}
+			want, err = ParseModifiers(strings.Join(parts, "+"))
+		}
+		if err != nil || want == 0 || want != modifiers {
+			continue
+		}
+
+		path := fmt.Sprintf("key_tone.chords.%d.%s", index, keyState)
+		if getValue(get, path) == nil {
+			return "", false
+		}
+		mode, _ := chordMap["mode"].(string)
+		return path, mode == ChordModeOverlay
+	}
+	return "", false
+}
+
+// playKeyToneEffect 播放 path 处的 {type, value} 配置, 配置无效时返回 false。
+func (p *keyPlayback) playKeyToneEffect(path string) bool {
+	if p.audioPkgUUID == "" {
+		logger.Error("message", "error: 获取音频包UUID失败")
+		return false
+	}
+
+	switch getValue(p.get, path+".type") {
+	case "audio_files":
+		sha256, ok := getValue(p.get, path+".value.sha256").(string)
+		if !ok {
+			return false
+		}
+		fileType, ok := getValue(p.get, path+".value.type").(string)
+		if !ok {
+			return false
+		}
+		p.playSound(albumAudioFile(p.get, p.albumPath(), sha256, fileType), nil, false)
+		return true
+	case "sounds":
+		sound_UUID, ok := getValue(p.get, path+".value").(string)
+		if !ok {
+			return false
+		}
+		p.playSoundUUID(sound_UUID)
+		return true
+	case "key_sounds":
+		key_sound_UUID, ok := getValue(p.get, path+".value").(string)
+		if !ok {
+			return false
+		}
+		p.playKeySoundUUID(key_sound_UUID, true)
+		return true
+	}
+	return false
+}
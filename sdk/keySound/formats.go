@@ -0,0 +1,55 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// 支持的音频格式、格式识别与解码均由 KeyTone/keySound/audio 实现(与 ktalbum-tools 共用, 说明见该包的 formats.go),
+// 这里保留 keySound 原有的导出名称, 供 server 等调用方使用。
+
+import (
+	"io"
+
+	"KeyTone/keySound/audio"
+)
+
+// 识别出的规范扩展名。
+const (
+	AudioFormatWAV  = audio.FormatWAV
+	AudioFormatMP3  = audio.FormatMP3
+	AudioFormatOgg  = audio.FormatOgg
+	AudioFormatOpus = audio.FormatOpus
+	AudioFormatFLAC = audio.FormatFLAC
+	AudioFormatAIFF = audio.FormatAIFF
+)
+
+// ErrUnknownAudioFormat 表示无法从文件内容识别出支持的音频格式。
+var ErrUnknownAudioFormat = audio.ErrUnknownFormat
+
+// AudioInfo 为一次完整解码得到的音频信息。
+type AudioInfo = audio.Info
+
+// SniffAudioFormat 按文件头内容识别音频格式, 返回规范扩展名。
+func SniffAudioFormat(header []byte) (string, error) {
+	return audio.SniffHeader(header)
+}
+
+// ProbeAudio 识别 r 的格式并完整解码一次, 返回时长、采样率与声道数; 无法识别或解码失败时返回错误。
+func ProbeAudio(r io.ReadSeekCloser) (AudioInfo, error) {
+	return audio.Probe(r)
+}
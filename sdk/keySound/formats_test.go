@@ -0,0 +1,212 @@
+package keySound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"KeyTone/keySound/audio"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// readSeekNopCloser 将内存数据包装为解码所需的 io.ReadSeekCloser。
+type readSeekNopCloser struct{ *bytes.Reader }
+
+func (readSeekNopCloser) Close() error { return nil }
+
+func memoryFile(data []byte) readSeekNopCloser { return readSeekNopCloser{bytes.NewReader(data)} }
+
+// tinyOggOpus 为 github.com/pion/opus 的 testdata/tiny.ogg(MIT): 单声道, pre-skip 312, 末页粒度位置 591。
+var tinyOggOpus, _ = hex.DecodeString(
+	"4f676753000200000000000000007962efee00000000d7165d6c01134f707573486561640101380180bb00000000004f" +
+		"676753000000000000000000007962efee010000006afd4f1a013e4f707573546167730d0000004c61766635392e3136" +
+		"2e313030010000001d000000656e636f6465723d4c61766335392e31382e313030206c69626f7075734f67675300044f" +
+		"020000000000007962efee020000006e455946010f4883cade8ae567d51caca254faffbf",
+)
+
+// buildAIFF 构造一个 AIFF(compression 为空)或 AIFF-C 文件, 采样率固定为 44100Hz。
+func buildAIFF(compression string, channels, sampleSize int, sound []byte) []byte {
+	frameSize := (sampleSize + 7) / 8 * channels
+	comm := new(bytes.Buffer)
+	binary.Write(comm, binary.BigEndian, int16(channels))
+	binary.Write(comm, binary.BigEndian, uint32(len(sound)/frameSize))
+	binary.Write(comm, binary.BigEndian, int16(sampleSize))
+	// 44100 的 80 位扩展精度表示
+	comm.Write([]byte{0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0})
+	formType := "AIFF"
+	if compression != "" {
+		formType = "AIFC"
+		comm.WriteString(compression)
+		comm.Write([]byte{0, 0})
+	}
+
+	body := new(bytes.Buffer)
+	body.WriteString(formType)
+	body.WriteString("COMM")
+	binary.Write(body, binary.BigEndian, uint32(comm.Len()))
+	body.Write(comm.Bytes())
+	body.WriteString("SSND")
+	binary.Write(body, binary.BigEndian, uint32(8+len(sound)))
+	body.Write(make([]byte, 8))
+	body.Write(sound)
+
+	file := new(bytes.Buffer)
+	file.WriteString("FORM")
+	binary.Write(file, binary.BigEndian, uint32(body.Len()))
+	file.Write(body.Bytes())
+	return file.Bytes()
+}
+
+// buildFLAC 以 verbatim 子帧编码一段 16 位单声道 PCM。
+func buildFLAC(t *testing.T, samples []int32) []byte {
+	t.Helper()
+	info := &meta.StreamInfo{
+		BlockSizeMin:  uint16(len(samples)),
+		BlockSizeMax:  uint16(len(samples)),
+		SampleRate:    22050,
+		NChannels:     1,
+		BitsPerSample: 16,
+		NSamples:      uint64(len(samples)),
+	}
+	out := new(bytes.Buffer)
+	encoder, err := flac.NewEncoder(out, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encoder.WriteFrame(&frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(len(samples)),
+			SampleRate:        22050,
+			Channels:          frame.ChannelsMono,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{{
+			SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+			Samples:   samples,
+			NSamples:  len(samples),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// TestSniffAudioFormat 验证按文件内容识别格式, 且不依赖扩展名。
+func TestSniffAudioFormat(t *testing.T) {
+	vorbisPage := append([]byte("OggS\x00\x02"), make([]byte, 20)...)
+	vorbisPage = append(vorbisPage, 1, 30)
+	vorbisPage = append(vorbisPage, []byte("\x01vorbis")...)
+
+	cases := []struct {
+		header []byte
+		want   string
+	}{
+		{[]byte("RIFF\x24\x00\x00\x00WAVEfmt "), AudioFormatWAV},
+		{[]byte("fLaC\x00\x00\x00\x22"), AudioFormatFLAC},
+		{buildAIFF("", 1, 16, []byte{0, 0}), AudioFormatAIFF},
+		{buildAIFF("sowt", 1, 16, []byte{0, 0}), AudioFormatAIFF},
+		{tinyOggOpus, AudioFormatOpus},
+		{vorbisPage, AudioFormatOgg},
+		{[]byte("ID3\x04\x00"), AudioFormatMP3},
+		{[]byte{0xFF, 0xFB, 0x90, 0x64}, AudioFormatMP3},
+	}
+	for _, c := range cases {
+		if got, err := SniffAudioFormat(c.header); err != nil || got != c.want {
+			t.Fatalf("%q: got %q, %v want %q", c.header[:4], got, err, c.want)
+		}
+	}
+	for _, header := range [][]byte{nil, []byte("hello world"), []byte("OggS"), {0xFF, 0xE9}} {
+		if _, err := SniffAudioFormat(header); !errors.Is(err, ErrUnknownAudioFormat) {
+			t.Fatalf("%q: expected ErrUnknownAudioFormat, got %v", header, err)
+		}
+	}
+}
+
+// TestDecodeAIFF 验证大端/小端整数 PCM、24 位样本与单声道复制。
+func TestDecodeAIFF(t *testing.T) {
+	// 16 位立体声大端: (0.5, -0.5), (-1, 0.25)
+	stereo := buildAIFF("", 2, 16, []byte{0x40, 0x00, 0xC0, 0x00, 0x80, 0x00, 0x20, 0x00})
+	streamer, format, err := audio.Decode(memoryFile(stereo), AudioFormatAIFF)
+	if err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if format.SampleRate != 44100 || format.NumChannels != 2 || streamer.Len() != 2 {
+		t.Fatalf("unexpected format: %+v len=%d", format, streamer.Len())
+	}
+	samples := make([][2]float64, 4)
+	if n, _ := streamer.Stream(samples); n != 2 || samples[0] != [2]float64{0.5, -0.5} || samples[1] != [2]float64{-1, 0.25} {
+		t.Fatalf("unexpected samples: %v", samples[:2])
+	}
+
+	// 24 位单声道小端(sowt): -0.5
+	mono := buildAIFF("sowt", 1, 24, []byte{0x00, 0x00, 0xC0})
+	streamer, _, err = audio.Decode(memoryFile(mono), AudioFormatAIFF)
+	if err != nil {
+		t.Fatalf("Decode returned error: %v", err)
+	}
+	if n, _ := streamer.Stream(samples); n != 1 || samples[0] != [2]float64{-0.5, -0.5} {
+		t.Fatalf("unexpected mono samples: %v", samples[:1])
+	}
+
+	if _, _, err := audio.Decode(memoryFile(buildAIFF("ima4", 1, 16, []byte{0, 0})), AudioFormatAIFF); err == nil {
+		t.Fatal("expected compressed AIFF-C to be rejected")
+	}
+}
+
+// TestProbeAudio 验证各格式的完整解码探测结果, 以及无法解码的文件被拒绝。
+func TestProbeAudio(t *testing.T) {
+	samples := make([]int32, 2205)
+	for i := range samples {
+		samples[i] = int32(8000 * math.Sin(float64(i)/10))
+	}
+
+	cases := []struct {
+		name       string
+		data       []byte
+		format     string
+		durationMS float64
+		sampleRate int
+		channels   int
+	}{
+		{"flac", buildFLAC(t, samples), AudioFormatFLAC, 100, 22050, 1},
+		{"aiff", buildAIFF("", 2, 16, make([]byte, 4*441)), AudioFormatAIFF, 10, 44100, 2},
+		// 591 - 312 = 279 个 48kHz 样本
+		{"opus", tinyOggOpus, AudioFormatOpus, 279.0 / 48, 48000, 1},
+	}
+	for _, c := range cases {
+		info, err := ProbeAudio(memoryFile(c.data))
+		if err != nil {
+			t.Fatalf("%s: ProbeAudio returned error: %v", c.name, err)
+		}
+		if info.Format != c.format || math.Abs(info.DurationMS-c.durationMS) > 1e-6 || info.SampleRate != c.sampleRate || info.Channels != c.channels {
+			t.Fatalf("%s: unexpected info: %+v", c.name, info)
+		}
+	}
+
+	mp3File, err := sounds.Open("sounds/test_down.MP3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := ProbeAudio(mp3File.(io.ReadSeekCloser)); err != nil || info.Format != AudioFormatMP3 || info.DurationMS <= 0 {
+		t.Fatalf("unexpected mp3 probe: %+v %v", info, err)
+	}
+
+	// 文件头可以识别但内容损坏, 以及完全无法识别的内容
+	for _, data := range [][]byte{[]byte("fLaC\x00\x00\x00\x22garbage"), []byte("not an audio file")} {
+		if _, err := ProbeAudio(memoryFile(data)); err == nil {
+			t.Fatalf("%q: expected ProbeAudio to fail", data)
+		}
+	}
+}
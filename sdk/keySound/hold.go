@@ -0,0 +1,106 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// hold(按住重复)状态说明
+// =============================
+//
+// 专辑配置中, hold 与 down/up 并列, 是可选的第三种按键状态:
+//
+//	key_tone.single.<keycode>.hold / key_tone.global.hold = {
+//	    "type": "audio_files" | "sounds" | "key_sounds",   // 与 down/up 相同
+//	    "value": ...,                                        // 与 down/up 相同; key_sounds 读取 key_sounds.<uuid>.hold
+//	    "volume": 0.0,                                       // 可选, hold 的独立音量(与 cut.volume 同一刻度)
+//	    "interval_ms": 0                                     // 可选, >0 时按此间隔合成重复, 否则跟随系统自动重复
+//	}
+//
+// 单键配置优先于全局配置。专辑未配置 hold 时, 按住按键仍只在按下时发声一次(与旧行为一致)。
+
+import (
+	"time"
+
+	"github.com/gopxl/beep/v2/effects"
+)
+
+// holdMinRepeatInterval 为合成重复的最小间隔, 防止配置异常时过于密集地触发播放。
+const holdMinRepeatInterval = 20 * time.Millisecond
+
+// HoldConfig 为 hold 状态的附加配置。
+type HoldConfig struct {
+	// Interval 为合成重复的间隔, 0 表示跟随系统自动重复
+	Interval time.Duration
+	// Volume 为 hold 的独立音量
+	Volume float64
+}
+
+// holdStateKey 返回 keycode 生效的 hold 配置节点(单键优先于全局), 未配置时返回 false。
+func holdStateKey(get ConfigGetter, keycode string) (string, bool) {
+	for _, key := range []string{"key_tone.single." + keycode + "." + KeyStateHold, "key_tone.global." + KeyStateHold} {
+		if getValue(get, key) != nil {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// holdConfigWith 从给定配置中读取 keycode 的 hold 配置。
+func holdConfigWith(get ConfigGetter, keycode string) (HoldConfig, bool) {
+	key, ok := holdStateKey(get, keycode)
+	if !ok {
+		return HoldConfig{}, false
+	}
+
+	hold := HoldConfig{}
+	if intervalMS, ok := getValue(get, key+".interval_ms").(float64); ok && intervalMS > 0 {
+		hold.Interval = max(time.Duration(intervalMS*float64(time.Millisecond)), holdMinRepeatInterval)
+	}
+	if volume, ok := getValue(get, key+".volume").(float64); ok {
+		hold.Volume = volume
+	}
+	return hold, true
+}
+
+// ResolveHoldConfig 返回 keycode 在当前播放来源(编辑器或路由快照)下的 hold 配置;
+// 未加载专辑或专辑未配置 hold 状态时 ok 为 false。
+func ResolveHoldConfig(keycode string) (HoldConfig, bool) {
+	configGetter, _ := resolvePlaybackConfig(keycode)
+	if configGetter == nil {
+		return HoldConfig{}, false
+	}
+	return holdConfigWith(configGetter, keycode)
+}
+
+// holdAudioVolumeProcessing 为 hold 状态叠加专辑内配置的独立音量; get 为本次按键事件的专辑配置。
+func holdAudioVolumeProcessing(audioVolume *effects.Volume, get ConfigGetter, keycode string, keyState string) *effects.Volume {
+	if audioVolume == nil || keyState != KeyStateHold {
+		return audioVolume
+	}
+	hold, ok := holdConfigWith(get, keycode)
+	if !ok || hold.Volume == 0 {
+		return audioVolume
+	}
+	return &effects.Volume{
+		Streamer: audioVolume,
+		Base:     1.6,
+		Volume:   hold.Volume,
+		Silent:   false,
+	}
+}
@@ -0,0 +1,59 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHoldConfigWith 验证 hold 配置的读取: 单键优先于全局、合成间隔下限、未配置时不启用。
+func TestHoldConfigWith(t *testing.T) {
+	values := map[string]any{
+		"key_tone.global.hold":                map[string]any{"type": "sounds", "value": "s1"},
+		"key_tone.global.hold.volume":         -1.5,
+		"key_tone.single.30.hold":             map[string]any{"type": "sounds", "value": "s2"},
+		"key_tone.single.30.hold.interval_ms": 5.0,
+		"key_tone.single.31.hold":             map[string]any{"type": "sounds", "value": "s3"},
+		"key_tone.single.31.hold.interval_ms": 80.0,
+	}
+	get := func(key string) any { return values[key] }
+
+	hold, ok := holdConfigWith(get, "30")
+	if !ok || hold.Interval != holdMinRepeatInterval || hold.Volume != 0 {
+		t.Fatalf("expected single config with clamped interval, got %+v, %v", hold, ok)
+	}
+	hold, ok = holdConfigWith(get, "31")
+	if !ok || hold.Interval != 80*time.Millisecond {
+		t.Fatalf("expected 80ms interval, got %+v, %v", hold, ok)
+	}
+	hold, ok = holdConfigWith(get, "32")
+	if !ok || hold.Interval != 0 || hold.Volume != -1.5 {
+		t.Fatalf("expected global config following auto-repeat, got %+v, %v", hold, ok)
+	}
+
+	delete(values, "key_tone.global.hold")
+	if _, ok := holdConfigWith(get, "32"); ok {
+		t.Fatal("expected hold to be disabled when neither single nor global is configured")
+	}
+	if _, ok := holdConfigWith(nil, "30"); ok {
+		t.Fatal("expected hold to be disabled without album config")
+	}
+}
@@ -21,10 +21,12 @@ package keySound
 
 import (
 	"KeyTone/config"
+	"KeyTone/keySound/audio"
 	"KeyTone/logger"
 	"embed"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"math/rand"
 	"os"
@@ -37,9 +39,6 @@ import (
 
 	"github.com/gopxl/beep/v2"
 	"github.com/gopxl/beep/v2/effects"
-	"github.com/gopxl/beep/v2/mp3"
-	"github.com/gopxl/beep/v2/speaker"
-	"github.com/gopxl/beep/v2/vorbis"
 	"github.com/gopxl/beep/v2/wav"
 )
 
@@ -49,28 +48,9 @@ var sounds embed.FS
 // 播放器的采样率为44100 Hz
 var formatGlobalSampleRate beep.SampleRate = beep.SampleRate(44100)
 
-// 在文件顶部添加变量
-var (
-	// 在文件顶部添加一个包级别的变量来存储循环播放的索引
-	loopCurrentIndex map[string]uint
-	// 添加一个静态变量来跟踪上一次的音频包UUID, 以便在切换音频包时重置loopCurrentIndex这个循环索引变量
-	lastAudioPkgUUID string
-)
-
 func init() {
-
-	// 初始化speaker。
-	// 第二个参数的值, 不会对音质产生影响, 它只是缓冲区的大小。
-	// > bufferSize参数指定扬声器缓冲区的样本数。更大的缓冲区大小意味着更低的CPU使用率和更可靠的播放。较低的缓冲区大小意味着更好的响应性和更少的延迟。
-	// > * 缓冲区越大, cpu压力越小, 播放的整个过程崩溃率也会降低。(个人理解)
-	// > * 缓冲区越小, cpu压力越大, 会得到更快的响应性和更少的延时。(个人理解)
-	// > 鉴于个人的以上理解, 这个数值对我们KeyTone项目来说, 缓冲区设置的越小越好。
-	// > * 但实际测试下来, 缓冲区无论如何设置, 其响应到播放完毕的用时都只有最大20ms作用的波动, 而且绝大部分时候, 波动仅有1ms左右。因此给其一个固定的值即可
-	// starTime := time.Now()
-	err := speaker.Init(formatGlobalSampleRate, formatGlobalSampleRate.N(time.Second/36))
-	if err != nil {
-		panic(err)
-	}
+	// 音频输出设备(speaker 等)的初始化已移至 audio_output.go, 由 InitAudioOutput 或首次播放时完成。
+	// 这样在没有声卡的机器上加载本包不会再 panic。
 
 	// 使用新的随机数生成器
 	rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -119,6 +99,8 @@ var activeStreamsAllDeleteFlag bool = false
 // 它不需要 id 字段。
 type managedStream struct {
 	beep.StreamSeekCloser
+	// path 为流式读取的音频文件路径(见 CloseFileStreams), 缓存与内嵌音频的流为空
+	path string
 }
 
 // Stream 重写了内嵌的 Streamer 的 Stream 方法。
@@ -174,6 +156,16 @@ func CloseStream(stream beep.StreamCloser) bool {
 	return false
 }
 
+// CloseFileStreams 关闭正在流式读取 path 的音频流, 释放其文件句柄(如该文件即将被覆盖时), 其余播放不受影响。
+func CloseFileStreams(path string) {
+	activeStreams.Range(func(key, value interface{}) bool {
+		if stream, ok := key.(*managedStream); ok && stream.path != "" && stream.path == path {
+			stream.Close()
+		}
+		return true
+	})
+}
+
 // CloseAllStreams 会关闭当前所有正在管理的音频流。
 func CloseAllStreams() {
 	activeStreamsAllDeleteFlag = true
@@ -198,100 +190,12 @@ type AudioFilePath struct {
 	SS     string // 优先级最低
 	Global string // 优先级仅次于Part
 	Part   string // 优先级最高
+	// LoudnessGainDB 为导入时测得的响度增益(见 normalize.go), 叠加在播放音量上, 不影响解码缓存
+	LoudnessGainDB float64
 }
 
-type Cut struct {
-	StartMS int64
-	EndMS   int64 // 当 EndMS 小于或等于 StartMS  时, 不会播放任何声音
-	Volume  float64
-}
-
-// errEmptyAudioCut 表示“这次裁剪在逻辑上不应该播放任何声音”。
-// 这不是异常故障, 而是一个明确的业务分支:
-// 1. 用户把结束时间拖到了开始时间之前/相同位置;
-// 2. 裁剪区间完全落在音频总长度之外;
-// 3. 音频长度本身异常, 无法得到任何可播放样本。
-//
-// 之所以单独定义这个错误, 是为了在调用方区分:
-// - 真正需要记录日志的故障(如 Seek 失败、解码器异常);
-// - 合法但不应发声的“空片段”情况。
-var errEmptyAudioCut = errors.New("audio cut does not contain playable samples")
-
-// preparePlaybackSource 将“裁剪描述 Cut”转换成一个真正可播放的 Streamer。
-//
-// 这是这次修复的核心: 旧实现是先 Resample, 再在播放期间轮询 Position(),
-// 一旦发现到达结束点就直接 Close 原始流。这个做法的问题在于:
-// 1. Resampler 为了插值会预读未来的一段原始样本;
-// 2. 因此“原始流当前位置”并不等于“已经真正播出的声音位置”;
-// 3. 尤其在很短的裁剪片段中, 预读进来的区间外样本会被插值带入输出;
-// 4. 最终表现就是: 明明选中的片段没有声音, 实际却还能听到片段外的声音。
-//
-// 新方案改成两步:
-// 1. 先在原始采样率的 StreamSeekCloser 上 Seek 到 startSample;
-// 2. 再用 beep.Take 严格限制最多只能读取 end-start 个样本;
-//
-// 这样重采样器拿到的输入源本身就是一个“已经裁好长度”的只读片段,
-// 它即便预读, 也只能在这个受限区间内预读, 不可能再越界读到片段外的声音。
-//
-// 返回值:
-// - beep.Streamer: 供后续重采样与播放使用的源流;
-// - float64: 初始音量偏移, 直接继承自 cut.Volume;
-// - error: 区分空片段(errEmptyAudioCut)与真正故障。
-func preparePlaybackSource(audioStreamer beep.StreamSeekCloser, sampleRate beep.SampleRate, cut *Cut) (beep.Streamer, float64, error) {
-	initVolume := 0.0
-	// 没有 cut 代表“播放整段音频”, 这时直接把原始流返回即可。
-	if cut == nil {
-		return audioStreamer, initVolume, nil
-	}
-
-	// 结束时间小于等于开始时间时, 语义上就是空区间, 必须明确无声返回。
-	if cut.EndMS <= cut.StartMS {
-		return nil, 0, errEmptyAudioCut
-	}
-
-	// 配置中的裁剪时间单位是毫秒, 这里统一转换为解码后“原始采样率”下的样本索引。
-	// 注意必须使用原始采样率, 不能使用全局播放采样率, 否则时间轴会错位。
-	startSample := sampleRate.N(time.Millisecond * time.Duration(cut.StartMS))
-	endSample := sampleRate.N(time.Millisecond * time.Duration(cut.EndMS))
-	totalSamples := audioStreamer.Len()
-
-	// 没有任何可用样本时, 直接视为空片段。
-	if totalSamples <= 0 {
-		return nil, 0, errEmptyAudioCut
-	}
-	// 负值裁剪时间统一钳制到 0, 防止配置异常导致 Seek 到负位置。
-	if startSample < 0 {
-		startSample = 0
-	}
-	if endSample < 0 {
-		endSample = 0
-	}
-	// 如果起点已经在文件末尾或更后面, 就没有任何可播放内容。
-	if startSample >= totalSamples {
-		return nil, 0, errEmptyAudioCut
-	}
-	// 结束位置允许越界, 但要钳制到文件真实长度, 等价于“播放到文件结尾”。
-	if endSample > totalSamples {
-		endSample = totalSamples
-	}
-	// 钳制后若仍然没有有效区间, 说明最终结果仍是空片段。
-	if endSample <= startSample {
-		return nil, 0, errEmptyAudioCut
-	}
-
-	// Seek 是必须检查错误的。
-	// 旧逻辑忽略了 Seek 返回值, 一旦解码器拒绝 Seek 或位置异常, 播放可能回退到文件开头,
-	// 这正是“选中的是静音段, 却听到别处声音”的另一个来源。
-	if err := audioStreamer.Seek(startSample); err != nil {
-		return nil, 0, fmt.Errorf("seek start sample %d failed: %w", startSample, err)
-	}
-
-	initVolume = cut.Volume
-	// Take 会把源流严格截断为指定样本数。
-	// 后续即便交给 Resample, Resample 也只能在这个已裁好的窗口中读取数据,
-	// 不会再接触到区间外的原始样本。
-	return beep.Take(endSample-startSample, audioStreamer), initVolume, nil
-}
+// Cut 描述一次裁剪(起止毫秒、音量与淡入/淡出包络), 与 ktalbum-tools 共用, 见 KeyTone/keySound/audio 的 cut.go。
+type Cut = audio.Cut
 
 // 键音播放器
 //
@@ -304,6 +208,16 @@ func preparePlaybackSource(audioStreamer beep.StreamSeekCloser, sampleRate beep.
 // Returns:
 //   - void
 func PlayKeySound(audioFilePath *AudioFilePath, cut *Cut, keycode string, keyState string, isPreviewMode ...bool) {
+	// 检查是否为预览模式（使用原始音量）
+	shouldUseRawVolume := false
+	if len(isPreviewMode) > 0 && isPreviewMode[0] {
+		shouldUseRawVolume = true
+	}
+	newKeyPlayback(keycode, keyState).playSound(audioFilePath, cut, shouldUseRawVolume)
+}
+
+// playSound 为 PlayKeySound 的实现, 使用按键事件开始时解析的播放上下文。
+func (p *keyPlayback) playSound(audioFilePath *AudioFilePath, cut *Cut, shouldUseRawVolume bool) {
 	// 保证在删除全部活动流期间, 不新增任何播放项
 	if activeStreamsAllDeleteFlag {
 		return
@@ -313,52 +227,30 @@ func PlayKeySound(audioFilePath *AudioFilePath, cut *Cut, keycode string, keySta
 		return
 	}
 
-	var audioFile fs.File
-	var err error  // 注意, 这里一定要同时带上err。 否则在if else 内部, 和已声明的audioFile一起取返回值而临时创建的err, 会造成已声明的audioFile被重新声明并定义, 从而发生作用域问题。
-	var ext string // 用于判断音频类型
-	if audioFilePath.Part != "" {
-		audioFile, err = os.Open(audioFilePath.Part)
-		ext = strings.ToLower(filepath.Ext(audioFilePath.Part))
-	} else if audioFilePath.Global != "" {
-		audioFile, err = os.Open(audioFilePath.Global)
-		ext = strings.ToLower(filepath.Ext(audioFilePath.Global))
-	} else {
-		audioFile, err = sounds.Open("sounds/" + audioFilePath.SS)
-		ext = strings.ToLower(filepath.Ext(audioFilePath.SS))
-	}
-
-	if err != nil {
-		logger.Error("message", fmt.Sprintf("error: failed to open audio file: %v", err))
-		return
-	}
-	defer audioFile.Close()
-
-	// 对文件进行解码
-	audioStreamer, format, err := decodeAudioFile(audioFile, ext)
-	if err != nil {
-		logger.Error("message", fmt.Sprintf("error: failed to decode audio file: %v", err))
-		return
-	}
-	audioStreamer = JoinManage(audioStreamer)
-	defer audioStreamer.Close()
-
-	// 先把“文件 + cut 配置”整理成一个真正可播放的源流。
-	// 这里的关键原则是: 先裁剪, 再重采样。
-	// 如果顺序反过来, 重采样器内部的预读行为就可能把裁剪区间外的内容提前读进来。
-	playbackSource, initVolume, err := preparePlaybackSource(audioStreamer, format.SampleRate, cut)
+	// 解码、裁剪、重采样统一交给 preparePlaybackStreamer, 命中解码缓存时不会产生任何文件 IO。
+	reStreamer, initVolume, release, err := preparePlaybackStreamer(audioFilePath, cut)
 	if err != nil {
 		// 空片段不记错误日志, 直接静默返回, 这是符合用户配置语义的结果。
-		if errors.Is(err, errEmptyAudioCut) {
+		if errors.Is(err, audio.ErrEmptyCut) {
 			return
 		}
-		// 只有真正的异常才记录日志, 便于后续排查具体音频文件或解码器问题。
-		logger.Error("message", fmt.Sprintf("error: failed to prepare playback source: %v", err))
+		logger.Error("message", fmt.Sprintf("error: %v", err))
 		return
 	}
+	defer release()
 
-	// 将文件的采样率, 设置成与播放器一致。
-	// 裁剪必须先作用在原始采样率的流上, 再交给重采样器, 否则重采样器的预读会把裁剪区间外的数据带入播放。
-	reStreamer := beep.Resample(4, format.SampleRate, formatGlobalSampleRate, playbackSource)
+	p.playPreparedStreamer(reStreamer, initVolume, shouldUseRawVolume)
+}
+
+// playPreparedStreamer 对已重采样到 formatGlobalSampleRate 的播放源依次应用音高/音量/声像处理,
+// 申请复音名额后播放, 并阻塞到播放结束。
+// shouldUseRawVolume 为 true(预览模式)时仅保留 initVolume。
+func (p *keyPlayback) playPreparedStreamer(reStreamer beep.Streamer, initVolume float64, shouldUseRawVolume bool) {
+	keycode, keyState := p.keycode, p.keyState
+	// 随机音高/速度（默认关闭）, 与随机音量一样仅在非预览模式时生效
+	if !shouldUseRawVolume {
+		reStreamer = randomPitchProcessing(reStreamer, p.get, keyState, p.randomFloat64)
+	}
 
 	// 处理音量
 	volume := &effects.Volume{
@@ -368,31 +260,45 @@ func PlayKeySound(audioFilePath *AudioFilePath, cut *Cut, keycode string, keySta
 		Silent:   false,
 	}
 
-	// 检查是否为预览模式（使用原始音量）
-	shouldUseRawVolume := false
-	if len(isPreviewMode) > 0 && isPreviewMode[0] {
-		shouldUseRawVolume = true
-	}
-
 	// 仅在非预览模式时应用全局音量处理
 	if !shouldUseRawVolume {
+		// hold 的专辑内独立音量(与 cut.volume 同属专辑配置, 先于用户设置叠加)
+		volume = holdAudioVolumeProcessing(volume, p.get, keycode, keyState)
+		// hold 仍处于按下期间, 因此按下/抬起相关的用户设置按 down 处理
+		pressReleaseState := keyState
+		if keyState == KeyStateHold {
+			pressReleaseState = KeyStateDown
+		}
+
 		volume = globalAudioVolumeAmplifyProcessing(volume)
 		volume = globalAudioVolumeNormalProcessing(volume)
 		// 分离模式下的键盘/鼠标独立音量叠加（在全局音量基础上再次叠加）
 		volume = splitRouteAudioVolumeNormalProcessing(volume, keycode)
 		// 按下/抬起音量单独控制叠加（默认关闭）
-		volume = pressReleaseAudioVolumeNormalProcessing(volume, keycode, keyState)
+		volume = pressReleaseAudioVolumeNormalProcessing(volume, keycode, pressReleaseState)
 		// 随机音量叠加（默认关闭，且仅做衰减）
-		volume = randomAudioVolumeProcessing(volume)
+		volume = randomAudioVolumeProcessing(volume, p.randomFloat64)
 		// 按下/抬起随机音量单独控制叠加（默认关闭，可与全局随机音量叠加）
-		volume = pressReleaseRandomAudioVolumeProcessing(volume, keycode, keyState)
+		volume = pressReleaseRandomAudioVolumeProcessing(volume, keycode, pressReleaseState, p.randomFloat64)
 	}
 
 	// ctrl := &beep.Ctrl{Streamer: volume, Paused: false}
 
+	// 按键位置声像（默认关闭, 见 spatial.go）, 预览模式保持居中
+	var output beep.Streamer = volume
+	if !shouldUseRawVolume {
+		output = spatialPanProcessing(volume, p.get, keycode)
+	}
+
+	// 申请复音名额(见 voice.go), 超出上限时由较早的 voice 淡出让位
+	maxVoices, maxVoicesPerKey, stealFade := voiceSettings()
+	target, voices := p.output()
+	playing := voices.acquire(keycode, output, maxVoices, maxVoicesPerKey, formatGlobalSampleRate.N(stealFade))
+	defer playing.release()
+
 	// 播放音乐
 	// 这里使用一个带 1 个缓冲的 done 通道等待播放完成:
-	// 1. speaker.Play 是异步的, 不会阻塞当前 goroutine;
+	// 1. 输出后端的 Play 可能是异步的(如 speaker), 不会阻塞当前 goroutine;
 	// 2. beep.Callback 会在整段流真正播放结束后触发;
 	// 3. 带缓冲是为了防止极端调度下, 回调先于接收方执行时发生阻塞;
 	// 4. select + default 则保证回调至多投递一次完成信号。
@@ -401,7 +307,7 @@ func PlayKeySound(audioFilePath *AudioFilePath, cut *Cut, keycode string, keySta
 	// 现在裁剪已经由 Take 在数据源层面完成, 这里就只需要等待自然播放结束即可。
 	done := make(chan struct{}, 1)
 	// speaker.Play(beep.Seq(ctrl, beep.Callback(func() {
-	speaker.Play(beep.Seq(volume, beep.Callback(func() {
+	target.Play(beep.Seq(playing, beep.Callback(func() {
 		select {
 		case done <- struct{}{}:
 		default:
@@ -414,17 +320,111 @@ func PlayKeySound(audioFilePath *AudioFilePath, cut *Cut, keycode string, keySta
 	<-done
 }
 
+// preparePlaybackStreamer 返回已裁剪并重采样到 formatGlobalSampleRate 的播放源。
+//
+// 处理顺序与缓存策略:
+//  1. 解码缓存命中时, 直接以缓存 Buffer 的独立游标作为播放源, 不再打开文件;
+//  2. 未命中时, 解码 -> audio.PrepareCut(先裁剪) -> Resample(后重采样);
+//  3. 片段大小在缓存允许范围内时, 将重采样结果完整写入 Buffer 并放入缓存, 随即关闭文件;
+//  4. 片段过大(或缓存关闭)时保持原有的流式播放, 文件在 release 中关闭。
+//
+// 无论哪种路径, 播放源都会经过 JoinManage 纳入 activeStreams 管理, 以保证 CloseAllStreams 依然能够中止播放。
+// 调用方必须在播放结束后调用 release。
+func preparePlaybackStreamer(audioFilePath *AudioFilePath, cut *Cut) (beep.Streamer, float64, func(), error) {
+	cacheKey, isCacheable := newPCMCacheKey(audioFilePath, cut)
+	isCacheEnabled, maxBytes := pcmCacheSettings()
+	isCacheable = isCacheable && isCacheEnabled
+
+	if isCacheable {
+		if buffer, ok := playbackPCMCache.get(cacheKey); ok {
+			initVolume := 0.0
+			if cut != nil {
+				initVolume = cut.Volume
+			}
+			initVolume += loudnessGainVolume(audioFilePath)
+			managed := JoinManage(newPCMBufferStreamer(buffer))
+			return managed, initVolume, func() { managed.Close() }, nil
+		}
+	}
+
+	var audioFile fs.File
+	var err error  // 注意, 这里一定要同时带上err。 否则在if else 内部, 和已声明的audioFile一起取返回值而临时创建的err, 会造成已声明的audioFile被重新声明并定义, 从而发生作用域问题。
+	var ext string // 用于判断音频类型
+	var path string
+	if audioFilePath.Part != "" {
+		path = audioFilePath.Part
+		audioFile, err = os.Open(audioFilePath.Part)
+		ext = strings.ToLower(filepath.Ext(audioFilePath.Part))
+	} else if audioFilePath.Global != "" {
+		path = audioFilePath.Global
+		audioFile, err = os.Open(audioFilePath.Global)
+		ext = strings.ToLower(filepath.Ext(audioFilePath.Global))
+	} else {
+		audioFile, err = sounds.Open("sounds/" + audioFilePath.SS)
+		ext = strings.ToLower(filepath.Ext(audioFilePath.SS))
+	}
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to open audio file: %w", err)
+	}
+
+	// 对文件进行解码
+	audioStreamer, format, err := decodeAudioFile(audioFile, ext)
+	if err != nil {
+		audioFile.Close()
+		return nil, 0, nil, fmt.Errorf("failed to decode audio file: %w", err)
+	}
+
+	// 先估算重采样后的片段大小, 再决定是写入缓存还是走流式播放。
+	isCacheable = isCacheable && playbackPCMCache.admits(estimatePCMBytes(audioStreamer.Len(), format.SampleRate, cut), maxBytes)
+	if !isCacheable {
+		managed := &managedStream{StreamSeekCloser: audioStreamer, path: path}
+		activeStreams.Store(managed, struct{}{})
+		audioStreamer = managed
+	}
+	release := func() {
+		audioStreamer.Close()
+		audioFile.Close()
+	}
+
+	// 先把“文件 + cut 配置”整理成一个真正可播放的源流。
+	// 这里的关键原则是: 先裁剪, 再重采样。
+	// 如果顺序反过来, 重采样器内部的预读行为就可能把裁剪区间外的内容提前读进来。
+	playbackSource, initVolume, err := audio.PrepareCut(audioStreamer, format.SampleRate, cut)
+	if err != nil {
+		release()
+		if errors.Is(err, audio.ErrEmptyCut) {
+			return nil, 0, nil, err
+		}
+		return nil, 0, nil, fmt.Errorf("failed to prepare playback source: %w", err)
+	}
+	initVolume += loudnessGainVolume(audioFilePath)
+
+	// 将文件的采样率, 设置成与播放器一致。
+	// 裁剪必须先作用在原始采样率的流上, 再交给重采样器, 否则重采样器的预读会把裁剪区间外的数据带入播放。
+	reStreamer := beep.Resample(4, format.SampleRate, formatGlobalSampleRate, playbackSource)
+	if !isCacheable {
+		return reStreamer, initVolume, release, nil
+	}
+
+	buffer := beep.NewBuffer(pcmBufferFormat)
+	buffer.Append(reStreamer)
+	release()
+	if buffer.Len() == 0 {
+		return nil, 0, nil, audio.ErrEmptyCut
+	}
+	playbackPCMCache.put(cacheKey, buffer, maxBytes)
+
+	managed := JoinManage(newPCMBufferStreamer(buffer))
+	return managed, initVolume, func() { managed.Close() }, nil
+}
+
 func decodeAudioFile(file fs.File, ext string) (beep.StreamSeekCloser, beep.Format, error) {
-	switch ext {
-	case ".wav":
-		return wav.Decode(file)
-	case ".mp3":
-		return mp3.Decode(file)
-	case ".ogg":
-		return vorbis.Decode(file)
-	default:
-		return nil, beep.Format{}, errors.New("unsupported audio format: " + ext)
+	// os.File 与 embed.FS 打开的文件均可寻址; 支持的格式见 formats.go
+	readSeeker, ok := file.(io.ReadSeekCloser)
+	if !ok {
+		return nil, beep.Format{}, errors.New("audio file is not seekable")
 	}
+	return audio.Decode(readSeeker, ext)
 }
 
 func globalAudioVolumeAmplifyProcessing(audioStreamer beep.Streamer) *effects.Volume {
@@ -651,7 +651,7 @@ func pressReleaseAudioVolumeNormalProcessing(audioVolume *effects.Volume, keycod
 // randomAudioVolumeProcessing 在开启“随机音量”时追加随机衰减层。
 // 衰减模型：在当前 volume 体系上减去随机值，deltaVolume = -random(0, maxReduceRatio)。
 // 该模型不限制 maxReduceRatio 上限，但始终只会衰减（不放大）。
-func randomAudioVolumeProcessing(audioVolume *effects.Volume) *effects.Volume {
+func randomAudioVolumeProcessing(audioVolume *effects.Volume, random func() float64) *effects.Volume {
 	if audioVolume == nil {
 		return audioVolume
 	}
@@ -681,7 +681,7 @@ func randomAudioVolumeProcessing(audioVolume *effects.Volume) *effects.Volume {
 		return audioVolume
 	}
 
-	randomReduce := rand.New(rand.NewSource(time.Now().UnixNano())).Float64() * maxReduceRatio
+	randomReduce := random() * maxReduceRatio
 	deltaVolume := -randomReduce
 
 	return &effects.Volume{
@@ -694,7 +694,7 @@ func randomAudioVolumeProcessing(audioVolume *effects.Volume) *effects.Volume {
 
 // pressReleaseRandomAudioVolumeProcessing 在开启“按下/抬起随机音量单独控制”时叠加事件态随机层。
 // 叠加顺序（调用链）为：全局随机层 -> 按下/抬起随机层（若开启）。
-func pressReleaseRandomAudioVolumeProcessing(audioVolume *effects.Volume, keycode string, keyState string) *effects.Volume {
+func pressReleaseRandomAudioVolumeProcessing(audioVolume *effects.Volume, keycode string, keyState string, random func() float64) *effects.Volume {
 	if audioVolume == nil {
 		return audioVolume
 	}
@@ -736,7 +736,7 @@ func pressReleaseRandomAudioVolumeProcessing(audioVolume *effects.Volume, keycod
 			return &effects.Volume{Streamer: streamer, Base: 1.6, Volume: 0, Silent: false}
 		}
 
-		randomReduce := rand.New(rand.NewSource(time.Now().UnixNano())).Float64() * maxReduceRatio
+		randomReduce := random() * maxReduceRatio
 		deltaVolume := -randomReduce
 
 		return &effects.Volume{Streamer: streamer, Base: 1.6, Volume: deltaVolume, Silent: false}
@@ -823,7 +823,6 @@ func initKeyDownSoundBuffer() {
 
 	bufferKeyDownSound = beep.NewBuffer(format)
 	bufferKeyDownSound.Append(audioStreamer)
-	speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/36))
 }
 
 func initKeyUpSoundBuffer() {
@@ -842,12 +841,11 @@ func initKeyUpSoundBuffer() {
 
 	bufferKeyUpSound = beep.NewBuffer(format)
 	bufferKeyUpSound.Append(audioStreamer)
-	speaker.Init(format.SampleRate, format.SampleRate.N(time.Second/36))
 }
 
 func KeyDownSoundPlay() {
 	shot := bufferKeyDownSound.Streamer(0, bufferKeyDownSound.Len())
-	speaker.Play(shot)
+	CurrentAudioOutput().Play(shot)
 
 	// // 播放音乐
 	// done := make(chan bool)
@@ -861,7 +859,7 @@ func KeyDownSoundPlay() {
 
 func KeyUpSoundPlay() {
 	shot := bufferKeyUpSound.Streamer(0, bufferKeyUpSound.Len())
-	speaker.Play(shot)
+	CurrentAudioOutput().Play(shot)
 
 	// // 播放音乐
 	// done := make(chan bool)
@@ -877,10 +875,12 @@ func KeyUpSoundPlay() {
 const (
 	KeyStateDown = "down"
 	KeyStateUp   = "up"
+	// KeyStateHold 为按住期间的重复状态(可选, 见 hold.go), 仅在专辑配置了 hold 时触发
+	KeyStateHold = "hold"
 )
 
 // ConfigGetter 用于抽象读取配置的函数（支持 editor 模式的 Viper 与 route 模式的只读快照）。
-type ConfigGetter func(string) any
+type ConfigGetter = audio.ConfigGetter
 
 // getValue 对 ConfigGetter 进行统一封装，避免 nil 调用。
 func getValue(get ConfigGetter, key string) any {
@@ -905,7 +905,70 @@ func getAudioPkgUUID(get ConfigGetter, fallback string) (string, bool) {
 	return "", false
 }
 
+// keyPlayback 为一次按键事件的播放上下文。
+// 播放来源在事件开始时解析一次, 随后沿解析与播放链传递: 随机音高、声像与 hold 音量等依赖专辑配置的处理
+// 都读取同一个来源, 既不重复解析, 也不会因播放途中切换了专辑而混用两张专辑的配置。
+type keyPlayback struct {
+	// get 为播放来源的专辑配置, 未选择专辑时为 nil(播放内嵌测试音)
+	get          ConfigGetter
+	audioPkgUUID string
+	selection    *keySoundSelection
+	keycode      string
+	keyState     string
+	// render 为离线渲染的播放环境(见 render_sequence.go), 实时播放时为 nil
+	render *renderSession
+}
+
+// newKeyPlayback 解析 keycode 当前的播放来源, 创建本次按键事件的播放上下文。
+func newKeyPlayback(keycode string, keyState string) *keyPlayback {
+	configGetter, audioPkgUUID, selection := resolvePlaybackSource(keycode)
+	return &keyPlayback{get: configGetter, audioPkgUUID: audioPkgUUID, selection: selection, keycode: keycode, keyState: keyState}
+}
+
+// albumPath 返回播放来源专辑的目录; 离线渲染时为被渲染的专辑目录。
+func (p *keyPlayback) albumPath() string {
+	if p.render != nil {
+		return p.render.albumPath
+	}
+	return filepath.Join(audioPackageConfig.AudioPackagePath, p.audioPkgUUID)
+}
+
+// output 返回播放使用的输出后端与复音管理; 离线渲染时为渲染专用的内存输出。
+func (p *keyPlayback) output() (AudioOutput, *voiceManager) {
+	if p.render != nil {
+		return p.render.output, p.render.voices
+	}
+	return CurrentAudioOutput(), playbackVoices
+}
+
+// now 返回播放使用的当前时刻; 离线渲染时为脚本时刻。
+func (p *keyPlayback) now() time.Time {
+	if p.render != nil {
+		return p.render.now
+	}
+	return time.Now()
+}
+
+// randomFloat64 返回 [0, 1) 内的随机数; 离线渲染时取自按 Seed 初始化的随机源, 保证结果可复现。
+func (p *keyPlayback) randomFloat64() float64 {
+	if p.render != nil {
+		return p.render.random.Float64()
+	}
+	return liveRandomFloat64()
+}
+
+// liveRandomFloat64 为实时播放使用的随机数。
+func liveRandomFloat64() float64 {
+	return rand.New(rand.NewSource(time.Now().UnixNano())).Float64()
+}
+
 func resolvePlaybackConfig(keycode string) (ConfigGetter, string) {
+	configGetter, audioPkgUUID, _ := resolvePlaybackSource(keycode)
+	return configGetter, audioPkgUUID
+}
+
+// resolvePlaybackSource 与 resolvePlaybackConfig 相同, 额外返回该来源的至臻键音随机选择状态。
+func resolvePlaybackSource(keycode string) (ConfigGetter, string, *keySoundSelection) {
 	// =============================
 	// 选择播放来源（核心逻辑）
 	// =============================
@@ -926,10 +989,10 @@ func resolvePlaybackConfig(keycode string) (ConfigGetter, string) {
 		// 编辑试听模式下直接使用当前可编辑配置。
 		// 注意：audioPackageConfig.Viper 由 LoadConfig 初始化；若为空表示尚未加载专辑。
 		if audioPackageConfig.Viper == nil {
-			return nil, ""
+			return nil, "", nil
 		}
 		uuid, _ := getAudioPkgUUID(audioPackageConfig.GetValue, "")
-		return audioPackageConfig.GetValue, uuid
+		return audioPackageConfig.GetValue, uuid, editorSelection(uuid)
 	}
 
 	if state.SourceMode == SourceModeRouteUnified || state.SourceMode == SourceModeRouteSplit {
@@ -958,45 +1021,63 @@ func resolvePlaybackConfig(keycode string) (ConfigGetter, string) {
 			}
 		}
 		if snapshot != nil && snapshot.Viper != nil {
-			return snapshot.GetValue, snapshot.AudioPkgUUID()
+			return snapshot.GetValue, snapshot.AudioPkgUUID(), snapshot.selection
 		}
 	}
 
 	// 未命中任何来源时回退到内嵌测试音。
-	return nil, ""
+	return nil, "", nil
 }
 
 // 音频包处理器
 // * 此函数会根据处理结果来调用播放器播放对应的音频结果。
 func KeySoundHandler(keyState string, keycode string) {
-	configGetter, audioPkgUUID := resolvePlaybackConfig(keycode)
+	KeySoundHandlerWith(keyState, keycode, 0, GetTypingSpeed())
+}
+
+// KeySoundHandlerWith 与 KeySoundHandler 相同, 但会按 modifiers(事件发生时按住的修饰键)
+// 选择修饰键变体(见 modifiers.go)与组合键(见 chords.go), 并按 speed(事件发生时的打字速度, 键盘按下时为
+// RecordTypingKeyDown 的返回值)选择速度档位(见 typing_speed.go)。
+func KeySoundHandlerWith(keyState string, keycode string, modifiers Modifiers, speed TypingSpeed) {
+	newKeyPlayback(keycode, keyState).handle(modifiers, speed)
+}
+
+// handle 为 KeySoundHandlerWith 的实现, 离线渲染以自己的播放上下文调用它, 使用与实时播放完全相同的解析流程。
+func (p *keyPlayback) handle(modifiers Modifiers, speed TypingSpeed) {
+	keycode, keyState := p.keycode, p.keyState
+	configGetter, audioPkgUUID := p.get, p.audioPkgUUID
 
 	// audioPkgUUID 的用途：当配置引用 audio_files（sha256+ext）时，需要拼出真实文件路径：
 	//   AudioPackagePath/<audioPkgUUID>/audioFiles/<sha256><ext>
 	// 在路由快照里，我们优先读取配置内的 audio_pkg_uuid；若缺失则回退为专辑目录名。
 
+	// hold 状态完全可选: 未选择音频包或专辑未配置 hold 时不发声, 也不回退到内嵌测试音
+	if keyState == KeyStateHold && configGetter == nil {
+		return
+	}
+
 	// 如果没有选择音频包，则默认使用内嵌的测试音频进行播放
 	if configGetter == nil {
 		switch keycode {
 		case "-1", "-2", "-3", "-4", "-5":
 			if keyState == KeyStateDown {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "mouse_test_down.MP3",
-				}, &Cut{StartMS: 42, EndMS: 60, Volume: 0.6}, keycode, keyState)
+				}, &Cut{StartMS: 42, EndMS: 60, Volume: 0.6}, false)
 			} else {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "mouse_test_up.MP3",
-				}, &Cut{StartMS: 42, EndMS: 60, Volume: -0.6}, keycode, keyState)
+				}, &Cut{StartMS: 42, EndMS: 60, Volume: -0.6}, false)
 			}
 		default:
 			if keyState == KeyStateDown {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "test_down.MP3",
-				}, &Cut{StartMS: 32, EndMS: 100, Volume: 0}, keycode, keyState)
+				}, &Cut{StartMS: 32, EndMS: 100, Volume: 0}, false)
 			} else {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "test_up.MP3",
-				}, &Cut{StartMS: 28, EndMS: 100, Volume: 0}, keycode, keyState)
+				}, &Cut{StartMS: 28, EndMS: 100, Volume: 0}, false)
 			}
 		}
 
@@ -1005,13 +1086,30 @@ func KeySoundHandler(keyState string, keycode string) {
 	// 从音频包配置中获取相关设置, 并根据配置决定如何播放
 
 	// TODO: 根据传入的具体按键Keycode, 来独立寻找其预设的播放配置, 以播放对应音频。
-	single := getValue(configGetter, "key_tone.single."+keycode+"."+keyState)
+	// 速度档位(见 typing_speed.go)命中且配置了当前状态时, 以档位中的配置代替原有配置
+
+	// 组合键优先于一切按键配置: replace 模式代替原有声音, overlay 模式与原有声音同时播放
+	if chordPath, overlay := chordEffectPath(configGetter, keycode, keyState, modifiers); chordPath != "" {
+		if !overlay {
+			p.playKeyToneEffect(chordPath)
+			return
+		}
+		// 离线渲染时同步播放, 使叠加的声音与本次按键落在同一时刻
+		if p.render != nil {
+			p.playKeyToneEffect(chordPath)
+		} else {
+			go p.playKeyToneEffect(chordPath)
+		}
+	}
+
+	singlePath, _ := keyToneEffectPath(configGetter, "key_tone.single."+keycode, keycode, keyState, modifiers, speed)
+	single := getValue(configGetter, singlePath)
 	fmt.Println("single====", single)
 	if single != nil {
 		// 将single转换为map类型以便访问其中的值
-		soundEffectType := getValue(configGetter, "key_tone.single."+keycode+"."+keyState+".type")
+		soundEffectType := getValue(configGetter, singlePath+".type")
 		// TIPS: 这个虽然 single和global都有, 但也没必要提取, 因为它仍旧只会执行一次。(但提取后, 会使得仅播放嵌入测试音时, 也执行这个无关紧要的逻辑)
-		audioPkgUUID, ok := audioPkgUUID, audioPkgUUID != ""
+		ok := audioPkgUUID != ""
 		if !ok {
 			logger.Error("message", "error: 获取音频包UUID失败")
 			return
@@ -1019,42 +1117,37 @@ func KeySoundHandler(keyState string, keycode string) {
 
 		// TIPS: 没必要将single和global的 handleSoundEffect 的逻辑抽离到一个函数内。 因为这样我们在改传参的基础上, 还需要改返回值 并在此处调用后 通过返回值判断是否return。
 		if soundEffectType == "audio_files" {
-			sha256, ok := getValue(configGetter, "key_tone.single."+keycode+"."+keyState+".value.sha256").(string)
+			sha256, ok := getValue(configGetter, singlePath+".value.sha256").(string)
 			if !ok {
 				return
 			}
-			fileType, ok := getValue(configGetter, "key_tone.single."+keycode+"."+keyState+".value.type").(string)
+			fileType, ok := getValue(configGetter, singlePath+".value.type").(string)
 			if !ok {
 				return
 			}
-			audio_file_name := sha256 + fileType
-			audio_file_path := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", audio_file_name)
-
-			PlayKeySound(&AudioFilePath{
-				Global: audio_file_path,
-			}, nil, keycode, keyState)
+			p.playSound(albumAudioFile(configGetter, p.albumPath(), sha256, fileType), nil, false)
 			return
 		}
 
 		if soundEffectType == "sounds" {
-			sound_UUID := getValue(configGetter, "key_tone.single."+keycode+"."+keyState+".value")
+			sound_UUID := getValue(configGetter, singlePath+".value")
 			if sound_UUID == nil {
 				return
 			}
 
-			soundParsePlayWith(configGetter, sound_UUID.(string), audioPkgUUID, keycode, keyState)
+			p.playSoundUUID(sound_UUID.(string))
 
 			return
 		}
 
 		if soundEffectType == "key_sounds" {
-			key_sound_UUID := getValue(configGetter, "key_tone.single."+keycode+"."+keyState+".value")
+			key_sound_UUID := getValue(configGetter, singlePath+".value")
 			if key_sound_UUID == nil {
 				return
 			}
 
-			keySoundParsePlayWith(configGetter, key_sound_UUID.(string), keyState, audioPkgUUID, true, keycode, 0)
-			// PlayKeySound(&AudioFilePath{
+			p.playKeySoundUUID(key_sound_UUID.(string), true)
+			// p.playSound(&AudioFilePath{
 			// 	Global: audio_file_path,
 			// }, nil)
 			return
@@ -1062,57 +1155,99 @@ func KeySoundHandler(keyState string, keycode string) {
 
 	}
 
+	// 若具体按键配置为空, 则根据按键所属分组的配置决定如何播放(见 key_groups.go)
+	groupPath, _, _ := keyGroupEffectPath(configGetter, keycode, keyState, modifiers, speed)
+	if groupPath != "" {
+		soundEffectType := getValue(configGetter, groupPath+".type")
+		ok := audioPkgUUID != ""
+		if !ok {
+			logger.Error("message", "error: 获取音频包UUID失败")
+			return
+		}
+
+		if soundEffectType == "audio_files" {
+			sha256, ok := getValue(configGetter, groupPath+".value.sha256").(string)
+			if !ok {
+				return
+			}
+			fileType, ok := getValue(configGetter, groupPath+".value.type").(string)
+			if !ok {
+				return
+			}
+			p.playSound(albumAudioFile(configGetter, p.albumPath(), sha256, fileType), nil, false)
+			return
+		}
+
+		if soundEffectType == "sounds" {
+			sound_UUID := getValue(configGetter, groupPath+".value")
+			if sound_UUID == nil {
+				return
+			}
+
+			p.playSoundUUID(sound_UUID.(string))
+
+			return
+		}
+
+		if soundEffectType == "key_sounds" {
+			key_sound_UUID := getValue(configGetter, groupPath+".value")
+			if key_sound_UUID == nil {
+				return
+			}
+
+			p.playKeySoundUUID(key_sound_UUID.(string), true)
+			return
+		}
+
+	}
+
 	// TODO: 若具体按键配置为空, 则根据全局配置决定如何播放
-	global := getValue(configGetter, "key_tone.global."+keyState)
+	globalPath, _ := keyToneEffectPath(configGetter, "key_tone.global", keycode, keyState, modifiers, speed)
+	global := getValue(configGetter, globalPath)
 	fmt.Println("global====", global)
 	// * 如果global不为空, 则根据global的值来决定如何播放, 否则使用后续逻辑中的默认音频
 	if global != nil {
 		// 将global转换为map类型以便访问其中的值
-		soundEffectType := getValue(configGetter, "key_tone.global."+keyState+".type")
+		soundEffectType := getValue(configGetter, globalPath+".type")
 		// soundEffectValue := audioPackageConfig.GetValue("key_tone.global." + keyState + ".value")
-		audioPkgUUID, ok := audioPkgUUID, audioPkgUUID != ""
+		ok := audioPkgUUID != ""
 		if !ok {
 			logger.Error("message", "error: 获取音频包UUID失败")
 			return
 		}
 
 		if soundEffectType == "audio_files" {
-			sha256, ok := getValue(configGetter, "key_tone.global."+keyState+".value.sha256").(string)
+			sha256, ok := getValue(configGetter, globalPath+".value.sha256").(string)
 			if !ok {
 				return
 			}
-			fileType, ok := getValue(configGetter, "key_tone.global."+keyState+".value.type").(string)
+			fileType, ok := getValue(configGetter, globalPath+".value.type").(string)
 			if !ok {
 				return
 			}
-			audio_file_name := sha256 + fileType
-			audio_file_path := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", audio_file_name)
-
-			PlayKeySound(&AudioFilePath{
-				Global: audio_file_path,
-			}, nil, keycode, keyState)
+			p.playSound(albumAudioFile(configGetter, p.albumPath(), sha256, fileType), nil, false)
 			return
 		}
 
 		if soundEffectType == "sounds" {
-			sound_UUID := getValue(configGetter, "key_tone.global."+keyState+".value")
+			sound_UUID := getValue(configGetter, globalPath+".value")
 			if sound_UUID == nil {
 				return
 			}
 
-			soundParsePlayWith(configGetter, sound_UUID.(string), audioPkgUUID, keycode, keyState)
+			p.playSoundUUID(sound_UUID.(string))
 
 			return
 		}
 
 		if soundEffectType == "key_sounds" {
-			key_sound_UUID := getValue(configGetter, "key_tone.global."+keyState+".value")
+			key_sound_UUID := getValue(configGetter, globalPath+".value")
 			if key_sound_UUID == nil {
 				return
 			}
 
-			keySoundParsePlayWith(configGetter, key_sound_UUID.(string), keyState, audioPkgUUID, true, keycode, 0)
-			// PlayKeySound(&AudioFilePath{
+			p.playKeySoundUUID(key_sound_UUID.(string), true)
+			// p.playSound(&AudioFilePath{
 			// 	Global: audio_file_path,
 			// }, nil)
 			return
@@ -1120,6 +1255,10 @@ func KeySoundHandler(keyState string, keycode string) {
 
 	}
 
+	if keyState == KeyStateHold {
+		return
+	}
+
 	// 若全局配置中为空, 则获取配置中内置测试音效的启用状态, 以决定是否使用默认音频进行播放。(优先级最低)
 	// * 我们没有对is_enable_embedded_test_sound做类型断言, 因此其可能为nil或bool,
 	isEnableEmbeddedTestSound := getValue(configGetter, "key_tone.is_enable_embedded_test_sound."+keyState)
@@ -1128,23 +1267,23 @@ func KeySoundHandler(keyState string, keycode string) {
 		switch keycode {
 		case "-1", "-2", "-3", "-4", "-5":
 			if keyState == KeyStateDown {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "mouse_test_down.MP3",
-				}, &Cut{StartMS: 42, EndMS: 60, Volume: 0.6}, keycode, keyState)
+				}, &Cut{StartMS: 42, EndMS: 60, Volume: 0.6}, false)
 			} else {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "mouse_test_up.MP3",
-				}, &Cut{StartMS: 42, EndMS: 60, Volume: -0.6}, keycode, keyState)
+				}, &Cut{StartMS: 42, EndMS: 60, Volume: -0.6}, false)
 			}
 		default:
 			if keyState == KeyStateDown {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "test_down.MP3",
-				}, &Cut{StartMS: 32, EndMS: 100, Volume: 0}, keycode, keyState)
+				}, &Cut{StartMS: 32, EndMS: 100, Volume: 0}, false)
 			} else {
-				PlayKeySound(&AudioFilePath{
+				p.playSound(&AudioFilePath{
 					SS: "test_up.MP3",
-				}, &Cut{StartMS: 28, EndMS: 100, Volume: 0}, keycode, keyState)
+				}, &Cut{StartMS: 28, EndMS: 100, Volume: 0}, false)
 			}
 		}
 		return
@@ -1158,81 +1297,63 @@ func KeySoundHandler(keyState string, keycode string) {
 //   - audioPkgUUID: 音频包的UUID
 func soundParsePlay(sound_UUID string, audioPkgUUID string) {
 	// 兼容旧调用：默认不区分键盘/鼠标（仅用于非按键上下文）
-	soundParsePlayWith(audioPackageConfig.GetValue, sound_UUID, audioPkgUUID, "", "")
+	p := &keyPlayback{get: audioPackageConfig.GetValue, audioPkgUUID: audioPkgUUID, selection: editorSelection(audioPkgUUID)}
+	p.playSoundUUID(sound_UUID)
 }
 
-// audioFileAliasExists 严格校验音频源别名是否存在。
-//
-// 校验维度：
-// 1) `audio_files.<sha256>` 节点存在；
-// 2) 节点内 `type` 与引用的 `fileType` 一致；
-// 3) 节点内 `name.<nameID>` 真实存在。
-//
-// 设计目的：
-// - 彻底避免“只按 sha256+type 就能播放”的隐式回连；
-// - 让运行时播放语义与前端依赖检测语义保持一致（都基于 sha256 + name_id + type 三元组）。
-func audioFileAliasExists(get ConfigGetter, sha256 string, nameID string, fileType string) bool {
-	if strings.TrimSpace(sha256) == "" || strings.TrimSpace(nameID) == "" || strings.TrimSpace(fileType) == "" {
-		return false
-	}
-
-	storedType, ok := getValue(get, "audio_files."+sha256+".type").(string)
-	if !ok || strings.TrimSpace(storedType) == "" || storedType != fileType {
-		return false
-	}
-
-	alias := getValue(get, "audio_files."+sha256+".name."+nameID)
-	if alias == nil {
-		return false
-	}
-
-	if aliasText, ok := alias.(string); ok {
-		return strings.TrimSpace(aliasText) != ""
+// playSoundUUID 播放 sounds.<sound_UUID>。
+func (p *keyPlayback) playSoundUUID(sound_UUID string) {
+	audioFilePath, cut, ok := p.soundSource(sound_UUID)
+	if !ok {
+		return
 	}
-
-	return true
+	p.playSound(audioFilePath, cut, false)
 }
 
-func soundParsePlayWith(get ConfigGetter, sound_UUID string, audioPkgUUID string, keycode string, keyState string) {
+// soundSource 解析 sounds.<uuid> 的音频路径与裁剪参数, 引用无效时记录错误并返回 false。
+func (p *keyPlayback) soundSource(sound_UUID string) (*AudioFilePath, *Cut, bool) {
+	get, audioPkgUUID := p.get, p.audioPkgUUID
 	sha256, ok := getValue(get, "sounds."+sound_UUID+".source_file_for_sound"+".sha256").(string)
 	if !ok {
 		logger.Error("message", "error: sha256 value is nil or not a string")
-		return
+		return nil, nil, false
 	}
 
 	nameID, ok := getValue(get, "sounds."+sound_UUID+".source_file_for_sound"+".name_id").(string)
 	if !ok {
 		logger.Error("message", "error: name_id value is nil or not a string")
-		return
+		return nil, nil, false
 	}
 
 	fileType, ok := getValue(get, "sounds."+sound_UUID+".source_file_for_sound"+".type").(string)
 	if !ok {
 		logger.Error("message", "error: file type value is nil or not a string")
-		return
+		return nil, nil, false
 	}
 
 	// 关键行为：仅当三元引用（sha256 + name_id + type）真实存在时才允许播放。
 	// 这样可确保“删除后重导入同文件”不会自动恢复历史裁剪声音的依赖。
-	if !audioFileAliasExists(get, sha256, nameID, fileType) {
+	if !audio.FileAliasExists(get, sha256, nameID, fileType) {
 		logger.Error("message", "error: source_file_for_sound alias missing",
 			"sha256", sha256,
 			"name_id", nameID,
 			"type", fileType,
 		)
-		return
+		return nil, nil, false
 	}
 
-	audio_file_name := sha256 + fileType
-	audio_file_path := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", audio_file_name)
+	audioFilePath := albumAudioFile(get, p.albumPath(), sha256, fileType)
 	cut := &Cut{
 		StartMS: int64(getValue(get, "sounds."+sound_UUID+".cut.start_time").(float64)),
 		EndMS:   int64(getValue(get, "sounds."+sound_UUID+".cut.end_time").(float64)),
 		Volume:  getValue(get, "sounds."+sound_UUID+".cut.volume").(float64),
 	}
-	PlayKeySound(&AudioFilePath{
-		Global: audio_file_path,
-	}, cut, keycode, keyState)
+	audio.ReadCutFade(get, sound_UUID, cut)
+	// 记录声音与缓存片段的对应关系, 以便编辑器修改该声音时精确失效。
+	if cacheKey, ok := newPCMCacheKey(audioFilePath, cut); ok {
+		playbackPCMCache.rememberSound(audioPkgUUID, sound_UUID, cacheKey)
+	}
+	return audioFilePath, cut, true
 }
 
 // 键音解析, 获取 实际音频文件的路径 以及 播放参数
@@ -1247,181 +1368,156 @@ func soundParsePlayWith(get ConfigGetter, sound_UUID string, audioPkgUUID string
 //
 // 1. 根据mode判断播放模式:
 //   - single: 单一音效模式,按顺序播放配置的音效
-//   - random: 随机音效模式,随机选择一个音效播放
-//   - loop:   循环音效模式,循环播放配置的音效
-func keySoundParsePlay(key_sound_UUID string, keyState string, audioPkgUUID string, isGlobal bool, keycode string, count uint16) {
-	keySoundParsePlayWith(audioPackageConfig.GetValue, key_sound_UUID, keyState, audioPkgUUID, isGlobal, keycode, count)
+//   - random: 随机音效模式,随机选择一个音效播放(可选 avoid_repeat, 避免连续重复)
+//   - weighted: 加权随机模式,按每一项的 weight 随机选择一个音效播放
+//   - shuffle:  洗牌模式,整袋播放完之前不会重复
+//   - loop:   循环音效模式,循环播放配置的音效(可选 reset_after_idle_ms, 停顿后从头开始)
+//   - layer:  叠加模式,同时播放全部音效(每一项可选 volume 与 delay_ms), 混合为一个声音
+//
+// 随机类模式的选择状态见 selection.go, loop 模式的播放位置见 loop.go, layer 模式见 layer.go。
+func keySoundParsePlay(key_sound_UUID string, keyState string, audioPkgUUID string, isGlobal bool, keycode string) {
+	p := &keyPlayback{get: audioPackageConfig.GetValue, audioPkgUUID: audioPkgUUID, selection: editorSelection(audioPkgUUID), keycode: keycode, keyState: keyState}
+	p.playSoundLayers(p.resolveKeySoundLayers(key_sound_UUID, isGlobal))
 }
 
-func keySoundParsePlayWith(get ConfigGetter, key_sound_UUID string, keyState string, audioPkgUUID string, isGlobal bool, keycode string, count uint16) {
-	// 此处限制键音的嵌套数量上限为1000层, 这样即使键音专辑中存在至臻键音间的无限循环依赖也不必担心因此可能引起的  cpu超负荷风险 或 内存占用过多的内存溢出风险。
-	// * 理论上, 设置9999甚至更高也是可行的, 但没必要, 因为没有人会去制作继承嵌套超过1000层的键音。
-	if count > 1000 {
-		return
-	}
-	count = count + 1
-
-	mode := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".mode")
-	if mode == "single" {
-		value := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".value")
-
-		if value != nil {
-			for _, v := range value.([]interface{}) {
-				vMap := v.(map[string]interface{})
-				if vMap["type"] == "audio_files" {
-					valueMap := vMap["value"].(map[string]interface{})
-					sha256, shaOK := valueMap["sha256"].(string)
-					nameID, idOK := valueMap["name_id"].(string)
-					fileType, typeOK := valueMap["type"].(string)
-					if !shaOK || !idOK || !typeOK || !audioFileAliasExists(get, sha256, nameID, fileType) {
-						logger.Error("message", "error: key_sound single audio_files alias missing",
-							"key_sound_uuid", key_sound_UUID,
-							"sha256", valueMap["sha256"],
-							"name_id", valueMap["name_id"],
-							"type", valueMap["type"],
-						)
-						return
-					}
-					audio_file_name := sha256 + fileType
-					audio_file_path := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", audio_file_name)
-					PlayKeySound(&AudioFilePath{
-						Global: audio_file_path,
-					}, nil, keycode, keyState)
-					return
-				}
-				if vMap["type"] == "sounds" {
-					sound_UUID := vMap["value"].(string)
-					soundParsePlayWith(get, sound_UUID, audioPkgUUID, keycode, keyState)
-					return
-				}
-				if vMap["type"] == "key_sounds" {
-					key_sound_UUID := vMap["value"].(string)
-					keySoundParsePlayWith(get, key_sound_UUID, keyState, audioPkgUUID, isGlobal, keycode, count)
-					return
-				}
-			}
-		}
-	}
-	if mode == "random" {
-		value := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".value")
-		if value != nil {
-			values := value.([]interface{})
-			// TIPS: 防止因空值造成后续步骤panic。
-			if len(values) == 0 {
-				return
-			}
-			// 创建一个新的随机数生成器实例
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-			randomIndex := r.Intn(len(values))
-			logger.Debug("随机算法检测", "randomIndex====", randomIndex)
-			v := values[randomIndex]
-			vMap := v.(map[string]interface{})
-
-			if vMap["type"] == "audio_files" {
-				valueMap := vMap["value"].(map[string]interface{})
-				sha256, shaOK := valueMap["sha256"].(string)
-				nameID, idOK := valueMap["name_id"].(string)
-				fileType, typeOK := valueMap["type"].(string)
-				if !shaOK || !idOK || !typeOK || !audioFileAliasExists(get, sha256, nameID, fileType) {
-					logger.Error("message", "error: key_sound random audio_files alias missing",
-						"key_sound_uuid", key_sound_UUID,
-						"sha256", valueMap["sha256"],
-						"name_id", valueMap["name_id"],
-						"type", valueMap["type"],
-					)
-					return
-				}
-				audio_file_name := sha256 + fileType
-				audio_file_path := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", audio_file_name)
-				PlayKeySound(&AudioFilePath{
-					Global: audio_file_path,
-				}, nil, keycode, keyState)
-				return
-			}
-			if vMap["type"] == "sounds" {
-				sound_UUID := vMap["value"].(string)
-				soundParsePlayWith(get, sound_UUID, audioPkgUUID, keycode, keyState)
-				return
-			}
-			if vMap["type"] == "key_sounds" {
-				key_sound_UUID := vMap["value"].(string)
-				keySoundParsePlayWith(get, key_sound_UUID, keyState, audioPkgUUID, isGlobal, keycode, count)
-				return
-			}
-		}
-	}
-	if mode == "loop" {
-		value := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".value")
-		if value != nil {
+// playKeySoundUUID 播放至臻键音 key_sounds.<key_sound_UUID> 在当前按键状态下选出的声音。
+func (p *keyPlayback) playKeySoundUUID(key_sound_UUID string, isGlobal bool) {
+	p.playSoundLayers(p.resolveKeySoundLayers(key_sound_UUID, isGlobal))
+}
 
-			// 检测音频包是否发生切换
-			if lastAudioPkgUUID != audioPkgUUID {
-				loopCurrentIndex = make(map[string]uint) // 重置循环计数器
-				lastAudioPkgUUID = audioPkgUUID
-			}
+// keySoundResolveBudget 为解析一次按键最多展开的至臻键音数量(含嵌套)。
+// 没有循环的引用在 layer 模式下仍可能成倍展开(如每一级都叠加两次下一级), 因此除循环检测外还需限制总量。
+const keySoundResolveBudget = 1000
+
+// keySoundResolution 为一次按键解析至臻键音时共享的状态。
+type keySoundResolution struct {
+	// path 为当前解析路径上的至臻键音, 再次遇到其中之一即为循环引用
+	path map[string]bool
+	// remaining 为剩余可展开的至臻键音数量
+	remaining int
+	// rejected 为 true 时表示遇到了循环引用或超出了展开上限, 整个键音不再播放
+	rejected bool
+}
 
-			values := value.([]interface{})
-			// TIPS: 防止因空值造成后续步骤panic。
-			if len(values) == 0 {
-				return
-			}
+// resolveKeySoundLayers 按 mode 选出至臻键音本次应播放的项, 并逐项(含嵌套的至臻键音)解析为声音层。
+// layer 以外的模式至多得到一层。
+// 至臻键音之间存在循环引用, 或展开的数量超过 keySoundResolveBudget 时, 视为无效配置, 不播放任何声音。
+func (p *keyPlayback) resolveKeySoundLayers(key_sound_UUID string, isGlobal bool) []soundLayer {
+	resolution := &keySoundResolution{path: map[string]bool{}, remaining: keySoundResolveBudget}
+	layers := p.resolveKeySoundLayersWith(resolution, key_sound_UUID, isGlobal)
+	if resolution.rejected {
+		return nil
+	}
+	return layers
+}
 
-			// 使用简单的key来标识不同的键音
-			var key string
-			if isGlobal {
-				key = fmt.Sprintf("global_%s_%s_%s", keycode, key_sound_UUID, keyState)
-			} else {
-				key = fmt.Sprintf("%s_%s_%s", keycode, key_sound_UUID, keyState)
-			}
+func (p *keyPlayback) resolveKeySoundLayersWith(resolution *keySoundResolution, key_sound_UUID string, isGlobal bool) []soundLayer {
+	if resolution.path[key_sound_UUID] {
+		logger.Error("message", "error: key_sounds 存在循环引用", "key_sound_uuid", key_sound_UUID)
+		resolution.rejected = true
+		return nil
+	}
+	if resolution.remaining <= 0 {
+		logger.Error("message", "error: key_sounds 展开的数量超过上限", "key_sound_uuid", key_sound_UUID, "limit", keySoundResolveBudget)
+		resolution.rejected = true
+		return nil
+	}
+	resolution.remaining--
+	resolution.path[key_sound_UUID] = true
+	defer delete(resolution.path, key_sound_UUID)
 
-			// 获取当前索引
-			currentIndex := loopCurrentIndex[key]
+	get, selection, keyState, audioPkgUUID, keycode := p.get, p.selection, p.keyState, p.audioPkgUUID, p.keycode
+	mode, _ := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".mode").(string)
+	values, _ := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".value").([]interface{})
+	// TIPS: 防止因空值造成后续步骤panic。
+	if len(values) == 0 {
+		return nil
+	}
+	if selection == nil {
+		selection = editorSelection(audioPkgUUID)
+	}
+
+	var entries []interface{}
+	switch mode {
+	case "single", "layer":
+		entries = values
+	case "random", "weighted", "shuffle":
+		// 选择状态不区分按键, 详见 selection.go
+		selectionKey := key_sound_UUID + "_" + keyState
+		avoidRepeat, _ := getValue(get, "key_sounds."+key_sound_UUID+"."+keyState+".avoid_repeat").(bool)
+		var index int
+		switch mode {
+		case "weighted":
+			index = selection.pickWeighted(selectionKey, keySoundEntryWeights(values), avoidRepeat)
+		case "shuffle":
+			index = selection.pickShuffle(selectionKey, len(values))
+		default:
+			index = selection.pickRandom(selectionKey, len(values), avoidRepeat)
+		}
+		logger.Debug("随机算法检测", "mode", mode, "index", index)
+		entries = values[index : index+1]
+	case "loop":
+		// 播放位置按按键区分, 记录在当前专辑快照的选择状态中, 详见 loop.go
+		key := loopKey{global: isGlobal, keycode: keycode, keySoundUUID: key_sound_UUID, keyState: keyState}
+		index := selection.nextLoop(key, len(values), loopResetAfterIdle(get, key_sound_UUID), p.now())
+		entries = values[index : index+1]
+	default:
+		return nil
+	}
 
-			// 如果当前索引超出数组长度，重置为0
-			if currentIndex >= uint(len(values)) {
-				currentIndex = 0
-			}
+	var layers []soundLayer
+	for _, v := range entries {
+		vMap, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
 
-			// 获取当前要播放的值
-			v := values[currentIndex]
-			vMap := v.(map[string]interface{})
-
-			// 更新索引，简单加1即可
-			loopCurrentIndex[key] = currentIndex + 1
-
-			// 根据类型播放音频
-			if vMap["type"] == "audio_files" {
-				valueMap := vMap["value"].(map[string]interface{})
-				sha256, shaOK := valueMap["sha256"].(string)
-				nameID, idOK := valueMap["name_id"].(string)
-				fileType, typeOK := valueMap["type"].(string)
-				if !shaOK || !idOK || !typeOK || !audioFileAliasExists(get, sha256, nameID, fileType) {
-					logger.Error("message", "error: key_sound loop audio_files alias missing",
-						"key_sound_uuid", key_sound_UUID,
-						"sha256", valueMap["sha256"],
-						"name_id", valueMap["name_id"],
-						"type", valueMap["type"],
-					)
-					return
+		var entryLayers []soundLayer
+		switch vMap["type"] {
+		case "audio_files":
+			valueMap, _ := vMap["value"].(map[string]interface{})
+			sha256, shaOK := valueMap["sha256"].(string)
+			nameID, idOK := valueMap["name_id"].(string)
+			fileType, typeOK := valueMap["type"].(string)
+			if !shaOK || !idOK || !typeOK || !audio.FileAliasExists(get, sha256, nameID, fileType) {
+				logger.Error("message", "error: key_sound "+mode+" audio_files alias missing",
+					"key_sound_uuid", key_sound_UUID,
+					"sha256", valueMap["sha256"],
+					"name_id", valueMap["name_id"],
+					"type", valueMap["type"],
+				)
+				// 叠加模式下跳过缺失的一层, 其余层照常播放
+				if mode == "layer" {
+					continue
 				}
-				audio_file_name := sha256 + fileType
-				audio_file_path := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", audio_file_name)
-				PlayKeySound(&AudioFilePath{
-					Global: audio_file_path,
-				}, nil, keycode, keyState)
-				return
+				return nil
 			}
-			if vMap["type"] == "sounds" {
-				sound_UUID := vMap["value"].(string)
-				soundParsePlayWith(get, sound_UUID, audioPkgUUID, keycode, keyState)
-				return
+			entryLayers = []soundLayer{{audioFilePath: albumAudioFile(get, p.albumPath(), sha256, fileType)}}
+		case "sounds":
+			sound_UUID, _ := vMap["value"].(string)
+			audioFilePath, cut, ok := p.soundSource(sound_UUID)
+			if !ok {
+				if mode == "layer" {
+					continue
+				}
+				return nil
 			}
-			if vMap["type"] == "key_sounds" {
-				key_sound_UUID := vMap["value"].(string)
-				keySoundParsePlayWith(get, key_sound_UUID, keyState, audioPkgUUID, isGlobal, keycode, count)
-				return
+			entryLayers = []soundLayer{{audioFilePath: audioFilePath, cut: cut}}
+		case "key_sounds":
+			nested_key_sound_UUID, _ := vMap["value"].(string)
+			entryLayers = p.resolveKeySoundLayersWith(resolution, nested_key_sound_UUID, isGlobal)
+			if resolution.rejected {
+				return nil
 			}
+		default:
+			// single 模式沿用原有行为: 跳过无法识别的项, 播放第一个可识别的项
+			continue
+		}
+
+		if mode != "layer" {
+			return entryLayers
 		}
+		layers = append(layers, offsetSoundLayers(entryLayers, vMap)...)
 	}
+	return layers
 }
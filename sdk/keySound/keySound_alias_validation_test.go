@@ -19,7 +19,11 @@
 
 package keySound
 
-import "testing"
+import (
+	"testing"
+
+	"KeyTone/keySound/audio"
+)
 
 // TestAudioFileAliasExists
 //
@@ -38,19 +42,19 @@ func TestAudioFileAliasExists(t *testing.T) {
 		}
 	}
 
-	if !audioFileAliasExists(get, "sha_ok", "alias_ok", ".wav") {
+	if !audio.FileAliasExists(get, "sha_ok", "alias_ok", ".wav") {
 		t.Fatalf("expected alias exists when sha256 + name_id + type are all valid")
 	}
-	if audioFileAliasExists(get, "sha_ok", "alias_missing", ".wav") {
+	if audio.FileAliasExists(get, "sha_ok", "alias_missing", ".wav") {
 		t.Fatalf("expected alias missing when name_id does not exist")
 	}
-	if audioFileAliasExists(get, "sha_ok", "alias_ok", ".mp3") {
+	if audio.FileAliasExists(get, "sha_ok", "alias_ok", ".mp3") {
 		t.Fatalf("expected alias missing when type does not match")
 	}
-	if audioFileAliasExists(get, "", "alias_ok", ".wav") {
+	if audio.FileAliasExists(get, "", "alias_ok", ".wav") {
 		t.Fatalf("expected alias missing when sha256 is empty")
 	}
-	if audioFileAliasExists(get, "sha_ok", "", ".wav") {
+	if audio.FileAliasExists(get, "sha_ok", "", ".wav") {
 		t.Fatalf("expected alias missing when name_id is empty")
 	}
 }
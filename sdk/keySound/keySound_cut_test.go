@@ -2,8 +2,11 @@ package keySound
 
 import (
 	"errors"
+	"math"
 	"testing"
 
+	"KeyTone/keySound/audio"
+
 	"github.com/gopxl/beep/v2"
 )
 
@@ -97,19 +100,19 @@ func collectLeftChannel(streamer beep.Streamer) []float64 {
 	return values
 }
 
-// TestPreparePlaybackSourceSlicesRequestedRange 验证 preparePlaybackSource 在给定起止毫秒范围时：
+// TestPreparePlaybackSourceSlicesRequestedRange 验证 audio.PrepareCut 在给定起止毫秒范围时：
 // - 正确从底层流中裁切出对应样本（按采样率换算毫秒到帧）
 // - 返回的初始音量等于 Cut.Volume
 // - 底层流的位置被移动到切片末端
 func TestPreparePlaybackSourceSlicesRequestedRange(t *testing.T) {
 	stream := newFakeStreamSeekCloser([]float64{0, 1, 2, 3, 4, 5, 6})
-	segment, initVolume, err := preparePlaybackSource(stream, beep.SampleRate(1000), &Cut{
+	segment, initVolume, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
 		StartMS: 2,
 		EndMS:   5,
 		Volume:  0.25,
 	})
 	if err != nil {
-		t.Fatalf("preparePlaybackSource returned error: %v", err)
+		t.Fatalf("PrepareCut returned error: %v", err)
 	}
 	if initVolume != 0.25 {
 		t.Fatalf("unexpected init volume: got %v want %v", initVolume, 0.25)
@@ -129,15 +132,15 @@ func TestPreparePlaybackSourceSlicesRequestedRange(t *testing.T) {
 	}
 }
 
-// TestPreparePlaybackSourceClampsEndToLength 验证当 Cut.EndMS 超过流长度时，preparePlaybackSource 会将结束位置限制到流的末尾，避免越界。
+// TestPreparePlaybackSourceClampsEndToLength 验证当 Cut.EndMS 超过流长度时，audio.PrepareCut 会将结束位置限制到流的末尾，避免越界。
 func TestPreparePlaybackSourceClampsEndToLength(t *testing.T) {
 	stream := newFakeStreamSeekCloser([]float64{0, 1, 2, 3, 4, 5, 6})
-	segment, _, err := preparePlaybackSource(stream, beep.SampleRate(1000), &Cut{
+	segment, _, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
 		StartMS: 5,
 		EndMS:   20,
 	})
 	if err != nil {
-		t.Fatalf("preparePlaybackSource returned error: %v", err)
+		t.Fatalf("PrepareCut returned error: %v", err)
 	}
 
 	values := collectLeftChannel(segment)
@@ -151,39 +154,120 @@ func TestPreparePlaybackSourceClampsEndToLength(t *testing.T) {
 	}
 }
 
-// TestPreparePlaybackSourceRejectsEmptyOrOutOfRangeCut 验证当裁切范围完全在流之外或长度为零时，preparePlaybackSource 返回 errEmptyAudioCut。
+// TestPreparePlaybackSourceRejectsEmptyOrOutOfRangeCut 验证当裁切范围完全在流之外或长度为零时，audio.PrepareCut 返回 audio.ErrEmptyCut。
 func TestPreparePlaybackSourceRejectsEmptyOrOutOfRangeCut(t *testing.T) {
 	stream := newFakeStreamSeekCloser([]float64{0, 1, 2, 3, 4})
-	_, _, err := preparePlaybackSource(stream, beep.SampleRate(1000), &Cut{
+	_, _, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
 		StartMS: 10,
 		EndMS:   20,
 	})
-	if !errors.Is(err, errEmptyAudioCut) {
-		t.Fatalf("unexpected error: got %v want %v", err, errEmptyAudioCut)
+	if !errors.Is(err, audio.ErrEmptyCut) {
+		t.Fatalf("unexpected error: got %v want %v", err, audio.ErrEmptyCut)
 	}
 
-	_, _, err = preparePlaybackSource(stream, beep.SampleRate(1000), &Cut{
+	_, _, err = audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
 		StartMS: 3,
 		EndMS:   3,
 	})
-	if !errors.Is(err, errEmptyAudioCut) {
-		t.Fatalf("unexpected error for zero-length cut: got %v want %v", err, errEmptyAudioCut)
+	if !errors.Is(err, audio.ErrEmptyCut) {
+		t.Fatalf("unexpected error for zero-length cut: got %v want %v", err, audio.ErrEmptyCut)
 	}
 }
 
-// TestPreparePlaybackSourceReturnsSeekError 验证当底层流的 Seek 操作返回错误时，preparePlaybackSource 会将该错误向上传递而不是将其视为空裁切。
+// TestPreparePlaybackSourceReturnsSeekError 验证当底层流的 Seek 操作返回错误时，audio.PrepareCut 会将该错误向上传递而不是将其视为空裁切。
 func TestPreparePlaybackSourceReturnsSeekError(t *testing.T) {
 	stream := newFakeStreamSeekCloser([]float64{0, 1, 2, 3, 4})
 	stream.failOnSeek = map[int]error{2: errors.New("seek failed")}
 
-	_, _, err := preparePlaybackSource(stream, beep.SampleRate(1000), &Cut{
+	_, _, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
 		StartMS: 2,
 		EndMS:   4,
 	})
 	if err == nil {
 		t.Fatal("expected seek error, got nil")
 	}
-	if errors.Is(err, errEmptyAudioCut) {
+	if errors.Is(err, audio.ErrEmptyCut) {
 		t.Fatalf("expected seek error, got empty cut error: %v", err)
 	}
 }
+
+// TestPreparePlaybackSourceAppliesLinearFade 验证线性淡入/淡出按原始采样率逐样本施加:
+// 片段首个样本与最后一个样本增益为 0, 淡入/淡出区间外保持原值。
+func TestPreparePlaybackSourceAppliesLinearFade(t *testing.T) {
+	stream := newFakeStreamSeekCloser([]float64{9, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 9})
+	segment, _, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
+		StartMS:   1,
+		EndMS:     11,
+		FadeInMS:  4,
+		FadeOutMS: 2,
+	})
+	if err != nil {
+		t.Fatalf("PrepareCut returned error: %v", err)
+	}
+
+	values := collectLeftChannel(segment)
+	expected := []float64{0, 0.25, 0.5, 0.75, 1, 1, 1, 1, 0.5, 0}
+	if len(values) != len(expected) {
+		t.Fatalf("unexpected sample count: got %d want %d", len(values), len(expected))
+	}
+	for index, want := range expected {
+		if values[index] != want {
+			t.Fatalf("unexpected sample at %d: got %v want %v", index, values[index], want)
+		}
+	}
+}
+
+// TestPreparePlaybackSourceAppliesExponentialFade 验证指数曲线的增益值, 以及淡入/淡出重叠时增益相乘。
+func TestPreparePlaybackSourceAppliesExponentialFade(t *testing.T) {
+	stream := newFakeStreamSeekCloser([]float64{2, 2, 2, 2})
+	segment, _, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
+		StartMS:   0,
+		EndMS:     4,
+		FadeInMS:  2,
+		FadeOutMS: 4,
+		FadeCurve: audio.FadeCurveExponential,
+	})
+	if err != nil {
+		t.Fatalf("PrepareCut returned error: %v", err)
+	}
+
+	curve := func(x float64) float64 {
+		return (math.Exp(4*x) - 1) / (math.Exp(4) - 1)
+	}
+	values := collectLeftChannel(segment)
+	expected := []float64{
+		0,
+		2 * curve(0.5) * curve(0.5),
+		2 * curve(0.25),
+		0,
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("unexpected sample count: got %d want %d", len(values), len(expected))
+	}
+	for index, want := range expected {
+		if math.Abs(values[index]-want) > 1e-12 {
+			t.Fatalf("unexpected sample at %d: got %v want %v", index, values[index], want)
+		}
+	}
+}
+
+// TestPreparePlaybackSourceClampsFadeToSegment 验证淡入时长超过片段长度时被钳制到片段长度, 未知曲线按线性处理。
+func TestPreparePlaybackSourceClampsFadeToSegment(t *testing.T) {
+	stream := newFakeStreamSeekCloser([]float64{1, 1, 1, 1})
+	segment, _, err := audio.PrepareCut(stream, beep.SampleRate(1000), &Cut{
+		StartMS:   0,
+		EndMS:     4,
+		FadeInMS:  100,
+		FadeCurve: "unknown",
+	})
+	if err != nil {
+		t.Fatalf("PrepareCut returned error: %v", err)
+	}
+
+	values := collectLeftChannel(segment)
+	for index, want := range []float64{0, 0.25, 0.5, 0.75} {
+		if values[index] != want {
+			t.Fatalf("unexpected sample at %d: got %v want %v", index, values[index], want)
+		}
+	}
+}
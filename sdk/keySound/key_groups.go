@@ -0,0 +1,172 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 按键分组(key groups)说明
+// =============================
+//
+// 专辑可以为一组按键统一配置声音, 优先级介于 single 与 global 之间:
+//
+//	key_tone.single.<keycode>.<state>  >  key_tone.group.<name>.<state>  >  key_tone.global.<state>
+//
+// 分组的声音配置与 single/global 完全相同({type, value}), 同样支持 speed_tiers(见 typing_speed.go):
+//
+//	"key_tone": { "group": { "modifiers": { "down": {...}, "up": {...} } } }
+//
+// 内置分组: letters, digits, modifiers, function, arrows, numpad, mouse。
+// 内置分组的 keycode 表按平台(runtime.GOOS)区分, 见 builtinKeyGroupsFor。
+//
+// 专辑也可以在 key_groups 下自定义分组(值为 keycode 列表, 字符串或数字均可):
+//
+//	"key_groups": { "thumbs": ["57", "3640"], "wasd": [17, 30, 31, 32] }
+//
+// 与内置分组同名的自定义分组会替换内置的按键列表。
+// 一个按键属于多个分组时, 自定义分组(按名称排序)优先于内置分组; 内置分组之间互不重叠。
+// 只有配置了当前按键状态的分组才会命中, 否则继续尝试下一个分组, 最后回退到 global。
+//
+// 注意: viper 会将配置键转为小写, 因此分组名称不区分大小写; 名称中不能包含 "."。
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// 内置分组名称, 同时也是内置分组之间的匹配顺序。
+const (
+	KeyGroupLetters   = "letters"
+	KeyGroupDigits    = "digits"
+	KeyGroupModifiers = "modifiers"
+	KeyGroupFunction  = "function"
+	KeyGroupArrows    = "arrows"
+	KeyGroupNumpad    = "numpad"
+	KeyGroupMouse     = "mouse"
+)
+
+var builtinKeyGroupNames = []string{
+	KeyGroupLetters,
+	KeyGroupDigits,
+	KeyGroupModifiers,
+	KeyGroupFunction,
+	KeyGroupArrows,
+	KeyGroupNumpad,
+	KeyGroupMouse,
+}
+
+// builtinKeyGroups 为当前平台的内置分组表。
+var builtinKeyGroups = builtinKeyGroupsFor(runtime.GOOS)
+
+// builtinKeyGroupsFor 返回 goos 平台下内置分组与 gohook keycode 的对应关系。
+//
+// 平台差异:
+//   - windows/linux: 方向键上报为 0xEE00 前缀的扩展码(61000 等); 原始的 VC 导航码(57416 等)
+//     以及 NumLock 关闭时的 0xEE00 小键盘码(60927 等)均来自数字小键盘。
+//   - darwin: 没有 NumLock, 方向键直接上报原始 VC 码(57416 等); 小键盘的 Clear 键上报为 NumLock(69)。
+func builtinKeyGroupsFor(goos string) map[string][]string {
+	groups := map[string][]string{
+		KeyGroupLetters: {
+			"30", "48", "46", "32", "18", "33", "34", "35", "23", "36", "37", "38", "50",
+			"49", "24", "25", "16", "19", "31", "20", "22", "47", "17", "45", "21", "44",
+		},
+		KeyGroupDigits: {"2", "3", "4", "5", "6", "7", "8", "9", "10", "11"},
+		// Shift / Ctrl / Alt / Meta 的左右两侧
+		KeyGroupModifiers: {"42", "54", "29", "3613", "56", "3640", "3675", "3676"},
+		// F1-F12, F13-F24
+		KeyGroupFunction: {
+			"59", "60", "61", "62", "63", "64", "65", "66", "67", "68", "87", "88",
+			"91", "92", "93", "99", "100", "101", "102", "103", "104", "105", "106", "107",
+		},
+		KeyGroupNumpad: {
+			// NumLock、运算符、回车、小数点
+			"69", "3637", "55", "74", "78", "3612", "83", "3597",
+			// 数字 0-9
+			"82", "79", "80", "81", "75", "76", "77", "71", "72", "73",
+		},
+		KeyGroupMouse: {"-1", "-2", "-3", "-4", "-5"},
+	}
+
+	if goos == "darwin" {
+		groups[KeyGroupArrows] = []string{"57416", "57419", "57421", "57424"}
+		return groups
+	}
+
+	groups[KeyGroupArrows] = []string{"61000", "61003", "61005", "61008"}
+	groups[KeyGroupNumpad] = append(groups[KeyGroupNumpad],
+		// 原始 VC 导航码: Home/Up/PgUp/Left/Clear/Right/End/Down/PgDn/Insert/Delete
+		"3655", "57416", "3657", "57419", "57420", "57421", "3663", "57424", "3665", "3666", "3667",
+		// NumLock 关闭时的 0xEE00 扩展码
+		"60927", "60928", "60929", "60930", "60931", "60932", "60933", "60934", "60935", "60936", "60937", "60947",
+	)
+	return groups
+}
+
+// keyGroupsFor 按匹配顺序返回包含 keycode 的分组名称。
+func keyGroupsFor(get ConfigGetter, keycode string) []string {
+	custom := map[string][]string{}
+	if userGroups, ok := getValue(get, "key_groups").(map[string]interface{}); ok {
+		for name, keycodes := range userGroups {
+			list, ok := keycodes.([]interface{})
+			if !ok || strings.Contains(name, ".") {
+				continue
+			}
+			custom[name] = make([]string, 0, len(list))
+			for _, code := range list {
+				custom[name] = append(custom[name], strings.TrimSpace(fmt.Sprint(code)))
+			}
+		}
+	}
+
+	customNames := make([]string, 0, len(custom))
+	for name := range custom {
+		customNames = append(customNames, name)
+	}
+	sort.Strings(customNames)
+
+	groups := []string{}
+	for _, name := range customNames {
+		if slices.Contains(custom[name], keycode) {
+			groups = append(groups, name)
+		}
+	}
+	for _, name := range builtinKeyGroupNames {
+		if _, replaced := custom[name]; replaced {
+			continue
+		}
+		if slices.Contains(builtinKeyGroups[name], keycode) {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// keyGroupEffectPath 返回 keycode 所属分组中第一个配置了当前按键状态的配置路径(已考虑修饰键变体与速度档位),
+// 以及分组名称与命中的档位名称; 没有分组命中时 path 为空。
+func keyGroupEffectPath(get ConfigGetter, keycode string, keyState string, modifiers Modifiers, speed TypingSpeed) (string, string, string) {
+	for _, group := range keyGroupsFor(get, keycode) {
+		path, tier := keyToneEffectPath(get, "key_tone.group."+group, keycode, keyState, modifiers, speed)
+		if getValue(get, path) != nil {
+			return path, group, tier
+		}
+	}
+	return "", "", ""
+}
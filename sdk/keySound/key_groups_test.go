@@ -0,0 +1,110 @@
+package keySound
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestBuiltinKeyGroupsArePlatformAwareAndDisjoint 验证内置分组互不重叠, 且方向键/小键盘按平台区分。
+func TestBuiltinKeyGroupsArePlatformAwareAndDisjoint(t *testing.T) {
+	for _, goos := range []string{"windows", "linux", "darwin"} {
+		groups := builtinKeyGroupsFor(goos)
+		owner := map[string]string{}
+		for _, name := range builtinKeyGroupNames {
+			if len(groups[name]) == 0 {
+				t.Fatalf("%s: empty builtin group %q", goos, name)
+			}
+			for _, keycode := range groups[name] {
+				if other, ok := owner[keycode]; ok {
+					t.Fatalf("%s: keycode %s is in both %q and %q", goos, keycode, other, name)
+				}
+				owner[keycode] = name
+			}
+		}
+	}
+
+	windows, darwin := builtinKeyGroupsFor("windows"), builtinKeyGroupsFor("darwin")
+	if !slices.Contains(windows[KeyGroupArrows], "61000") || !slices.Contains(windows[KeyGroupNumpad], "57416") || !slices.Contains(windows[KeyGroupNumpad], "60936") {
+		t.Fatalf("unexpected windows arrows/numpad: %v %v", windows[KeyGroupArrows], windows[KeyGroupNumpad])
+	}
+	if !slices.Contains(darwin[KeyGroupArrows], "57416") || slices.Contains(darwin[KeyGroupNumpad], "60936") {
+		t.Fatalf("unexpected darwin arrows/numpad: %v %v", darwin[KeyGroupArrows], darwin[KeyGroupNumpad])
+	}
+}
+
+// TestKeyGroupsForOrdersCustomBeforeBuiltin 验证自定义分组(按名称排序)优先于内置分组, 且同名时替换内置列表。
+func TestKeyGroupsForOrdersCustomBeforeBuiltin(t *testing.T) {
+	album := map[string]any{
+		"key_groups": map[string]interface{}{
+			"wasd":    []interface{}{17.0, 30.0, 31.0, 32.0},
+			"left":    []interface{}{"30", " 31 "},
+			"mouse":   []interface{}{"-1"},
+			"bad.key": []interface{}{"30"},
+		},
+	}
+	get := ConfigGetter(func(key string) any { return album[key] })
+
+	if got := keyGroupsFor(get, "30"); !slices.Equal(got, []string{"left", "wasd", KeyGroupLetters}) {
+		t.Fatalf("unexpected groups for 30: %v", got)
+	}
+	if got := keyGroupsFor(get, "-1"); !slices.Equal(got, []string{KeyGroupMouse}) {
+		t.Fatalf("unexpected groups for -1: %v", got)
+	}
+	if got := keyGroupsFor(get, "-2"); len(got) != 0 {
+		t.Fatalf("expected the custom mouse group to replace the builtin one, got %v", got)
+	}
+	if got := keyGroupsFor(nil, "2"); !slices.Equal(got, []string{KeyGroupDigits}) {
+		t.Fatalf("unexpected groups without an album: %v", got)
+	}
+}
+
+// TestKeyGroupEffectPathPrecedence 验证 single > group > global 的优先级, 以及分组内的速度档位。
+func TestKeyGroupEffectPathPrecedence(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("json")
+	err := v.ReadConfig(bytes.NewBufferString(`{
+		"key_groups": { "homerow": ["30", "31", "32", "33"] },
+		"key_tone": {
+			"global": { "down": {"type": "sounds", "value": "normal"} },
+			"group": {
+				"homerow": { "up": {"type": "sounds", "value": "home_up"} },
+				"letters": {
+					"down": {"type": "sounds", "value": "letter"},
+					"speed_tiers": [ {"name": "fast", "max_interval_ms": 150, "down": {"type": "sounds", "value": "letter_fast"}} ]
+				}
+			},
+			"single": { "31": { "down": {"type": "sounds", "value": "s_key"} } }
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &AlbumSnapshot{Viper: v}
+	slow, fast := TypingSpeed{IntervalMS: 900}, TypingSpeed{IntervalMS: 90}
+
+	// homerow 没有 down, 继续尝试内置的 letters
+	path, group, _ := keyGroupEffectPath(snapshot.GetValue, "30", KeyStateDown, 0, slow)
+	if path != "key_tone.group.letters.down" || group != KeyGroupLetters {
+		t.Fatalf("unexpected down path: %q %q", path, group)
+	}
+	if path, group, _ := keyGroupEffectPath(snapshot.GetValue, "30", KeyStateUp, 0, slow); path != "key_tone.group.homerow.up" || group != "homerow" {
+		t.Fatalf("unexpected up path: %q %q", path, group)
+	}
+	if path, _, tier := keyGroupEffectPath(snapshot.GetValue, "30", KeyStateDown, 0, fast); path != "key_tone.group.letters.speed_tiers.0.down" || tier != "fast" {
+		t.Fatalf("unexpected tier path: %q %q", path, tier)
+	}
+	// 数字键没有分组配置, 回退到 global
+	if path, _, _ := keyGroupEffectPath(snapshot.GetValue, "2", KeyStateDown, 0, slow); path != "" {
+		t.Fatalf("expected no group path for a digit, got %q", path)
+	}
+
+	if tier := typingTierFor(snapshot.GetValue, "30", KeyStateDown, 0, fast); tier != "fast" {
+		t.Fatalf("expected the group tier, got %q", tier)
+	}
+	if tier := typingTierFor(snapshot.GetValue, "31", KeyStateDown, 0, fast); tier != "" {
+		t.Fatalf("expected single config to take precedence over the group, got %q", tier)
+	}
+}
@@ -0,0 +1,123 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 至臻键音(key_sounds)的 layer 模式说明
+// =============================
+//
+// mode 为 layer 时, key_sounds.<uuid>.<down|up>.value 中的每一项都会同时播放, 例如把 "thock" 的主体与 "click" 的瞬态叠在一起。
+// 每一项可选:
+//   - volume:   音量偏移, 与 sounds.<uuid>.cut.volume 同单位, 叠加在该层自身音量之上;
+//   - delay_ms: 该层相对按键事件的起始延迟(毫秒)。
+//
+// 嵌套的至臻键音按其自身的 mode 解析(同样可以是 layer), 偏移逐级累加。
+// 全部层先各自应用音量与延迟, 再混合为一个流, 之后才进入随机音高、全局/路由/随机音量、声像与复音管理,
+// 因此一次叠加只占用一个 voice, 音量设置也只作用一次。
+
+import (
+	"KeyTone/keySound/audio"
+	"KeyTone/logger"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/effects"
+)
+
+// soundLayer 为一次按键事件中需要播放的一层声音。
+type soundLayer struct {
+	audioFilePath *AudioFilePath
+	cut           *Cut
+	// volume 为叠加在 cut.Volume 之上的音量偏移
+	volume float64
+	// delay 为该层的起始延迟
+	delay time.Duration
+}
+
+// offsetSoundLayers 将 layer 项的 volume 与 delay_ms 叠加到该项解析出的全部层上。
+func offsetSoundLayers(layers []soundLayer, entry map[string]interface{}) []soundLayer {
+	volume, _ := entry["volume"].(float64)
+	delayMS, _ := entry["delay_ms"].(float64)
+	delay := time.Duration(max(delayMS, 0) * float64(time.Millisecond))
+	for i := range layers {
+		layers[i].volume += volume
+		layers[i].delay += delay
+	}
+	return layers
+}
+
+// playSoundLayers 播放解析出的声音层。
+// 单层且没有偏移时直接交给 playSound, 与非 layer 模式的行为完全一致; 否则混合为一个流后播放。
+func (p *keyPlayback) playSoundLayers(layers []soundLayer) {
+	if len(layers) == 0 {
+		return
+	}
+	if len(layers) == 1 && layers[0].volume == 0 && layers[0].delay == 0 {
+		p.playSound(layers[0].audioFilePath, layers[0].cut, false)
+		return
+	}
+
+	// 保证在删除全部活动流期间, 不新增任何播放项
+	if activeStreamsAllDeleteFlag {
+		return
+	}
+
+	streamers := make([]beep.Streamer, 0, len(layers))
+	for _, layer := range layers {
+		streamer, release, err := prepareSoundLayer(layer)
+		if err != nil {
+			if !errors.Is(err, audio.ErrEmptyCut) {
+				logger.Error("message", fmt.Sprintf("error: %v", err))
+			}
+			continue
+		}
+		streamers = append(streamers, streamer)
+		defer release()
+	}
+	if len(streamers) == 0 {
+		return
+	}
+
+	p.playPreparedStreamer(beep.Mix(streamers...), 0, false)
+}
+
+// prepareSoundLayer 准备单层的播放源, 并应用该层的音量与起始延迟; 调用方必须在播放结束后调用 release。
+func prepareSoundLayer(layer soundLayer) (beep.Streamer, func(), error) {
+	if layer.audioFilePath == nil {
+		return nil, nil, audio.ErrEmptyCut
+	}
+	reStreamer, initVolume, release, err := preparePlaybackStreamer(layer.audioFilePath, layer.cut)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var streamer beep.Streamer = &effects.Volume{
+		Streamer: reStreamer,
+		Base:     1.6,
+		Volume:   initVolume + layer.volume,
+		Silent:   false,
+	}
+	if layer.delay > 0 {
+		streamer = beep.Seq(beep.Silence(formatGlobalSampleRate.N(layer.delay)), streamer)
+	}
+	return streamer, release, nil
+}
@@ -0,0 +1,191 @@
+package keySound
+
+import (
+	"KeyTone/keySound/audio"
+	"KeyTone/logger"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// useLayerTestLogger 为解析过程中可能记录的错误提供一个丢弃输出的日志实例。
+func useLayerTestLogger(t *testing.T) {
+	t.Helper()
+	if logger.Logger == nil {
+		logger.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		t.Cleanup(func() { logger.Logger = nil })
+	}
+}
+
+// layerTestAlbum 构造一个包含两个声音与若干至臻键音的专辑配置。
+func layerTestAlbum() ConfigGetter {
+	album := map[string]any{
+		"audio_files.aaa.type":                       ".wav",
+		"audio_files.aaa.name.n1":                    "body",
+		"sounds.thock.source_file_for_sound.sha256":  "aaa",
+		"sounds.thock.source_file_for_sound.name_id": "n1",
+		"sounds.thock.source_file_for_sound.type":    ".wav",
+		"sounds.thock.cut.start_time":                0.0,
+		"sounds.thock.cut.end_time":                  80.0,
+		"sounds.thock.cut.volume":                    -0.5,
+		"sounds.click.source_file_for_sound.sha256":  "aaa",
+		"sounds.click.source_file_for_sound.name_id": "n1",
+		"sounds.click.source_file_for_sound.type":    ".wav",
+		"sounds.click.cut.start_time":                100.0,
+		"sounds.click.cut.end_time":                  120.0,
+		"sounds.click.cut.volume":                    0.0,
+		"key_sounds.stack.down.mode":                 "layer",
+		"key_sounds.stack.down.value": []interface{}{
+			map[string]interface{}{"type": "sounds", "value": "thock"},
+			map[string]interface{}{"type": "sounds", "value": "click", "volume": 1.5, "delay_ms": 12.0},
+			map[string]interface{}{"type": "key_sounds", "value": "inner", "volume": -1.0, "delay_ms": 20.0},
+			map[string]interface{}{"type": "audio_files", "value": map[string]interface{}{"sha256": "missing", "name_id": "n1", "type": ".wav"}},
+		},
+		"key_sounds.inner.down.mode": "layer",
+		"key_sounds.inner.down.value": []interface{}{
+			map[string]interface{}{"type": "audio_files", "value": map[string]interface{}{"sha256": "aaa", "name_id": "n1", "type": ".wav"}, "delay_ms": 3.0},
+		},
+		"key_sounds.plain.down.mode": "single",
+		"key_sounds.plain.down.value": []interface{}{
+			map[string]interface{}{"type": "sounds", "value": "click", "volume": 2.0, "delay_ms": 50.0},
+		},
+		"key_sounds.self.down.mode": "layer",
+		"key_sounds.self.down.value": []interface{}{
+			map[string]interface{}{"type": "sounds", "value": "thock"},
+			map[string]interface{}{"type": "key_sounds", "value": "self", "delay_ms": 1.0},
+		},
+	}
+	return func(key string) any { return album[key] }
+}
+
+// layerTestPlayback 返回以 layerTestAlbum 为播放来源的按下事件上下文。
+func layerTestPlayback() *keyPlayback {
+	return &keyPlayback{get: layerTestAlbum(), audioPkgUUID: "album", selection: newKeySoundSelection(1), keycode: "30", keyState: KeyStateDown}
+}
+
+// TestResolveKeySoundLayersLayerMode 验证 layer 模式解析出全部层, 偏移逐级累加, 且跳过缺失的层。
+func TestResolveKeySoundLayersLayerMode(t *testing.T) {
+	useLayerTestLogger(t)
+	layers := layerTestPlayback().resolveKeySoundLayers("stack", false)
+	if len(layers) != 3 {
+		t.Fatalf("expected 3 layers, got %d: %+v", len(layers), layers)
+	}
+
+	if layers[0].cut == nil || layers[0].cut.EndMS != 80 || layers[0].cut.Volume != -0.5 || layers[0].volume != 0 || layers[0].delay != 0 {
+		t.Fatalf("unexpected body layer: %+v %+v", layers[0], layers[0].cut)
+	}
+	if layers[1].cut == nil || layers[1].cut.StartMS != 100 || layers[1].volume != 1.5 || layers[1].delay != 12*time.Millisecond {
+		t.Fatalf("unexpected click layer: %+v %+v", layers[1], layers[1].cut)
+	}
+	if layers[2].cut != nil || layers[2].volume != -1 || layers[2].delay != 23*time.Millisecond {
+		t.Fatalf("expected nested offsets to accumulate: %+v", layers[2])
+	}
+}
+
+// TestResolveKeySoundLayersSingleMode 验证非 layer 模式只得到一层, 且忽略 layer 专用的偏移字段。
+func TestResolveKeySoundLayersSingleMode(t *testing.T) {
+	useLayerTestLogger(t)
+	layers := layerTestPlayback().resolveKeySoundLayers("plain", false)
+	if len(layers) != 1 || layers[0].volume != 0 || layers[0].delay != 0 || layers[0].cut.StartMS != 100 {
+		t.Fatalf("unexpected single mode layers: %+v", layers)
+	}
+	if layers := layerTestPlayback().resolveKeySoundLayers("unknown", false); len(layers) != 0 {
+		t.Fatalf("expected no layers for an unknown key sound, got %+v", layers)
+	}
+}
+
+// TestResolveKeySoundLayersRejectsCycles 验证直接或间接循环引用的至臻键音被拒绝, 不播放任何声音。
+func TestResolveKeySoundLayersRejectsCycles(t *testing.T) {
+	useLayerTestLogger(t)
+	if layers := layerTestPlayback().resolveKeySoundLayers("self", false); layers != nil {
+		t.Fatalf("expected a self reference to be rejected, got %d layers", len(layers))
+	}
+
+	album := map[string]any{
+		"key_sounds.a.down.mode":  "single",
+		"key_sounds.a.down.value": []interface{}{map[string]interface{}{"type": "key_sounds", "value": "b"}},
+		"key_sounds.b.down.mode":  "layer",
+		"key_sounds.b.down.value": []interface{}{map[string]interface{}{"type": "key_sounds", "value": "a"}},
+	}
+	playback := &keyPlayback{get: func(key string) any { return album[key] }, selection: newKeySoundSelection(1), keycode: "30", keyState: KeyStateDown}
+	if layers := playback.resolveKeySoundLayers("a", false); layers != nil {
+		t.Fatalf("expected an indirect cycle to be rejected, got %d layers", len(layers))
+	}
+}
+
+// TestResolveKeySoundLayersBudget 验证没有循环、但成倍展开的 layer 引用: 同一键音可在不同分支重复出现,
+// 展开总量超过 keySoundResolveBudget 时被拒绝。
+func TestResolveKeySoundLayersBudget(t *testing.T) {
+	useLayerTestLogger(t)
+	// doubling 返回 depth 级的键音: 每一级叠加两次下一级, 最后一级为一个声音, 共展开 2^(depth+1)-1 个键音
+	doubling := func(depth int) *keyPlayback {
+		album := map[string]any{}
+		for level := 0; level <= depth; level++ {
+			uuid := fmt.Sprint("k", level)
+			entry := map[string]interface{}{"type": "key_sounds", "value": fmt.Sprint("k", level+1)}
+			if level == depth {
+				entry = map[string]interface{}{"type": "sounds", "value": "click"}
+			}
+			album["key_sounds."+uuid+".down.mode"] = "layer"
+			album["key_sounds."+uuid+".down.value"] = []interface{}{entry, entry}
+		}
+		base := layerTestAlbum()
+		get := func(key string) any {
+			if value, ok := album[key]; ok {
+				return value
+			}
+			return base(key)
+		}
+		return &keyPlayback{get: get, audioPkgUUID: "album", selection: newKeySoundSelection(1), keycode: "30", keyState: KeyStateDown}
+	}
+
+	if layers := doubling(3).resolveKeySoundLayers("k0", false); len(layers) != 16 {
+		t.Fatalf("expected repeated references without a cycle to resolve, got %d layers", len(layers))
+	}
+	if layers := doubling(12).resolveKeySoundLayers("k0", false); layers != nil {
+		t.Fatalf("expected the expansion budget to reject the key sound, got %d layers", len(layers))
+	}
+}
+
+// TestPrepareSoundLayerAppliesDelayAndVolume 验证单层的起始延迟与音量偏移。
+func TestPrepareSoundLayerAppliesDelayAndVolume(t *testing.T) {
+	useTestConfig(t, pcmCacheTestConfig)
+	audioFilePath := &AudioFilePath{SS: "test_down.MP3"}
+	cut := &Cut{StartMS: 0, EndMS: 100}
+
+	plain, release, err := prepareSoundLayer(soundLayer{audioFilePath: audioFilePath, cut: cut})
+	if err != nil {
+		t.Fatalf("prepareSoundLayer returned error: %v", err)
+	}
+	defer release()
+	want := collectLeftChannel(plain)
+
+	delay := 10 * time.Millisecond
+	shifted, release, err := prepareSoundLayer(soundLayer{audioFilePath: audioFilePath, cut: cut, volume: 1, delay: delay})
+	if err != nil {
+		t.Fatalf("prepareSoundLayer returned error: %v", err)
+	}
+	defer release()
+	got := collectLeftChannel(shifted)
+
+	offset := formatGlobalSampleRate.N(delay)
+	if len(got) != len(want)+offset {
+		t.Fatalf("unexpected length: got %d want %d", len(got), len(want)+offset)
+	}
+	for i := 0; i < offset; i++ {
+		if got[i] != 0 {
+			t.Fatalf("expected silence before the delay, sample %d = %v", i, got[i])
+		}
+	}
+	for i := range want {
+		if diff := got[offset+i] - want[i]*1.6; diff > 1e-9 || diff < -1e-9 {
+			t.Fatalf("unexpected sample %d: got %v want %v", i, got[offset+i], want[i]*1.6)
+		}
+	}
+
+	if _, _, err := prepareSoundLayer(soundLayer{}); err != audio.ErrEmptyCut {
+		t.Fatalf("expected an empty layer to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 至臻键音(key_sounds)的 loop 模式说明
+// =============================
+//
+// loop 模式按顺序依次播放 key_sounds.<uuid>.<down|up>.value 中的各项, 播放位置按
+// (是否全局, 按键, 键音, 按键状态) 分别记录, 保存在所属专辑快照的 keySoundSelection 中并由其互斥锁保护,
+// 因此分离路由下键盘与鼠标专辑互不干扰, 也不会在两者之间来回切换时被清零。
+//
+// 可选的 key_sounds.<uuid>.reset_after_idle_ms(毫秒, <=0 表示不启用): 距同一序列上一次播放超过该时长后,
+// 下一次从第一项重新开始, 便于在停顿后重新起句。
+//
+// 可通过 GetLoopState 查看当前各来源的播放位置, 通过 ResetLoopPositions 手动清零。
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// loopKey 标识一个 loop 序列。
+type loopKey struct {
+	global       bool
+	keycode      string
+	keySoundUUID string
+	keyState     string
+}
+
+// loopPosition 记录 loop 序列下一次要播放的下标以及上一次推进的时间。
+type loopPosition struct {
+	next   uint
+	lastAt time.Time
+}
+
+// nextLoop 返回 loop 序列本次应播放的下标(共 n 项)并推进位置;
+// resetAfterIdle > 0 且距上一次推进已超过该时长时从第一项重新开始。
+func (s *keySoundSelection) nextLoop(key loopKey, n int, resetAfterIdle time.Duration, now time.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	position, ok := s.loops[key]
+	if !ok {
+		position = &loopPosition{}
+		s.loops[key] = position
+	}
+
+	// 超出数组长度(例如条目被删减)或停顿过久时, 重置为 0
+	if position.next >= uint(n) || (ok && resetAfterIdle > 0 && now.Sub(position.lastAt) >= resetAfterIdle) {
+		position.next = 0
+	}
+
+	index := position.next
+	position.next = index + 1
+	position.lastAt = now
+	return int(index)
+}
+
+// LoopPosition 为单个 loop 序列的播放位置。
+type LoopPosition struct {
+	Global       bool   `json:"global"`
+	Keycode      string `json:"keycode"`
+	KeySoundUUID string `json:"keySoundUuid"`
+	KeyState     string `json:"keyState"`
+	// Next 为下一次将播放的下标(若超出条目数量, 播放时会从 0 开始)。
+	Next uint `json:"next"`
+	// IdleMS 为距上一次播放经过的毫秒数。
+	IdleMS int64 `json:"idleMs"`
+}
+
+// loopPositions 按 键音/按键状态/按键 排序返回全部 loop 序列的播放位置。
+func (s *keySoundSelection) loopPositions(now time.Time) []LoopPosition {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	positions := make([]LoopPosition, 0, len(s.loops))
+	for key, position := range s.loops {
+		positions = append(positions, LoopPosition{
+			Global:       key.global,
+			Keycode:      key.keycode,
+			KeySoundUUID: key.keySoundUUID,
+			KeyState:     key.keyState,
+			Next:         position.next,
+			IdleMS:       now.Sub(position.lastAt).Milliseconds(),
+		})
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		a, b := positions[i], positions[j]
+		if a.KeySoundUUID != b.KeySoundUUID {
+			return a.KeySoundUUID < b.KeySoundUUID
+		}
+		if a.KeyState != b.KeyState {
+			return a.KeyState < b.KeyState
+		}
+		if a.Global != b.Global {
+			return a.Global
+		}
+		return a.Keycode < b.Keycode
+	})
+	return positions
+}
+
+// resetLoops 清除全部 loop 序列的播放位置。
+func (s *keySoundSelection) resetLoops() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.loops = map[loopKey]*loopPosition{}
+}
+
+// loopResetAfterIdle 读取 key_sounds.<uuid>.reset_after_idle_ms。
+func loopResetAfterIdle(get ConfigGetter, key_sound_UUID string) time.Duration {
+	idleMS, _ := getValue(get, "key_sounds."+key_sound_UUID+".reset_after_idle_ms").(float64)
+	if idleMS <= 0 {
+		return 0
+	}
+	return time.Duration(idleMS * float64(time.Millisecond))
+}
+
+const (
+	// LoopSourceEditor 表示编辑试听模式使用的 loop 状态。
+	LoopSourceEditor = "editor"
+	// LoopSourceUnified 表示统一路由快照的 loop 状态。
+	LoopSourceUnified = "unified"
+	// LoopSourceKeyboard 表示分离路由下键盘快照的 loop 状态。
+	LoopSourceKeyboard = "keyboard"
+	// LoopSourceMouse 表示分离路由下鼠标快照的 loop 状态。
+	LoopSourceMouse = "mouse"
+)
+
+// LoopSourceState 为某一播放来源(编辑器或路由快照)的 loop 状态。
+type LoopSourceState struct {
+	Source    string         `json:"source"`
+	AlbumUUID string         `json:"albumUuid"`
+	Positions []LoopPosition `json:"positions"`
+}
+
+// LoopState 为当前全部播放来源的 loop 状态。
+type LoopState struct {
+	SourceMode string            `json:"sourceMode"`
+	Sources    []LoopSourceState `json:"sources"`
+}
+
+type loopSource struct {
+	name      string
+	albumUUID string
+	selection *keySoundSelection
+}
+
+// loopSources 列出当前持有 loop 状态的播放来源。
+func loopSources() []loopSource {
+	state := GetPlaybackState()
+	var sources []loopSource
+	if albumUUID, selection := currentEditorSelection(); selection != nil {
+		sources = append(sources, loopSource{LoopSourceEditor, albumUUID, selection})
+	}
+	for _, item := range []struct {
+		name     string
+		snapshot *AlbumSnapshot
+	}{
+		{LoopSourceUnified, state.Routing.UnifiedSnapshot},
+		{LoopSourceKeyboard, state.Routing.KeyboardSnapshot},
+		{LoopSourceMouse, state.Routing.MouseSnapshot},
+	} {
+		if item.snapshot != nil && item.snapshot.selection != nil {
+			sources = append(sources, loopSource{item.name, item.snapshot.AudioPkgUUID(), item.snapshot.selection})
+		}
+	}
+	return sources
+}
+
+// GetLoopState 返回当前各播放来源中 loop 序列的播放位置。
+func GetLoopState() LoopState {
+	now := time.Now()
+	result := LoopState{
+		SourceMode: GetPlaybackState().SourceMode,
+		Sources:    []LoopSourceState{},
+	}
+	for _, source := range loopSources() {
+		result.Sources = append(result.Sources, LoopSourceState{
+			Source:    source.name,
+			AlbumUUID: source.albumUUID,
+			Positions: source.selection.loopPositions(now),
+		})
+	}
+	return result
+}
+
+// ResetLoopPositions 将 loop 序列的播放位置清零; source 为空时重置全部来源。
+func ResetLoopPositions(source string) error {
+	switch source {
+	case "", LoopSourceEditor, LoopSourceUnified, LoopSourceKeyboard, LoopSourceMouse:
+	default:
+		return errors.New("unknown loop source: " + source)
+	}
+	for _, item := range loopSources() {
+		if source == "" || item.name == source {
+			item.selection.resetLoops()
+		}
+	}
+	return nil
+}
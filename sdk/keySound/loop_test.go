@@ -0,0 +1,139 @@
+package keySound
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNextLoopAdvancesAndWraps 验证 loop 序列按顺序推进、到达末尾后回到开头, 且不同按键互不影响。
+func TestNextLoopAdvancesAndWraps(t *testing.T) {
+	selection := newKeySoundSelection(1)
+	now := time.Unix(0, 0)
+	a := loopKey{keycode: "30", keySoundUUID: "ks", keyState: KeyStateDown}
+	b := loopKey{keycode: "31", keySoundUUID: "ks", keyState: KeyStateDown}
+
+	var got []int
+	for i := 0; i < 5; i++ {
+		got = append(got, selection.nextLoop(a, 3, 0, now))
+	}
+	if want := []int{0, 1, 2, 0, 1}; !slices.Equal(got, want) {
+		t.Fatalf("unexpected sequence: got %v want %v", got, want)
+	}
+	if index := selection.nextLoop(b, 3, 0, now); index != 0 {
+		t.Fatalf("expected another key to start from 0, got %d", index)
+	}
+
+	// 条目减少后越界的位置从 0 开始
+	if index := selection.nextLoop(a, 2, 0, now); index != 0 {
+		t.Fatalf("expected shrunk sequence to restart, got %d", index)
+	}
+}
+
+// TestNextLoopResetsAfterIdle 验证停顿超过 reset_after_idle_ms 后从第一项重新开始。
+func TestNextLoopResetsAfterIdle(t *testing.T) {
+	selection := newKeySoundSelection(1)
+	key := loopKey{keycode: "30", keySoundUUID: "ks", keyState: KeyStateDown}
+	start := time.Unix(100, 0)
+	idle := 500 * time.Millisecond
+
+	selection.nextLoop(key, 4, idle, start)
+	selection.nextLoop(key, 4, idle, start.Add(200*time.Millisecond))
+	if index := selection.nextLoop(key, 4, idle, start.Add(600*time.Millisecond)); index != 2 {
+		t.Fatalf("expected sequence to continue while typing, got %d", index)
+	}
+	if index := selection.nextLoop(key, 4, idle, start.Add(1100*time.Millisecond)); index != 0 {
+		t.Fatalf("expected sequence to restart after idle, got %d", index)
+	}
+	if index := selection.nextLoop(key, 4, 0, start.Add(time.Hour)); index != 1 {
+		t.Fatalf("expected no idle reset when disabled, got %d", index)
+	}
+}
+
+// TestLoopResetAfterIdleReadsConfig 验证 reset_after_idle_ms 的读取与禁用值。
+func TestLoopResetAfterIdleReadsConfig(t *testing.T) {
+	album := map[string]any{
+		"key_sounds.a.reset_after_idle_ms": 750.0,
+		"key_sounds.b.reset_after_idle_ms": -1.0,
+	}
+	get := func(key string) any { return album[key] }
+	if idle := loopResetAfterIdle(get, "a"); idle != 750*time.Millisecond {
+		t.Fatalf("unexpected idle: %v", idle)
+	}
+	if idle := loopResetAfterIdle(get, "b"); idle != 0 {
+		t.Fatalf("expected negative value to disable reset, got %v", idle)
+	}
+	if idle := loopResetAfterIdle(get, "missing"); idle != 0 {
+		t.Fatalf("expected missing value to disable reset, got %v", idle)
+	}
+}
+
+// TestLoopStateIsPerSnapshot 验证 loop 状态归属各自的快照, 可查看并按来源重置。
+func TestLoopStateIsPerSnapshot(t *testing.T) {
+	keyboard := &AlbumSnapshot{AlbumUUID: "keyboard-album", selection: newKeySoundSelection(1)}
+	mouse := &AlbumSnapshot{AlbumUUID: "mouse-album", selection: newKeySoundSelection(2)}
+
+	playbackStateLock.Lock()
+	previous := playbackState
+	playbackState = PlaybackState{
+		SourceMode: SourceModeRouteSplit,
+		Routing:    PlaybackRoutingState{Mode: "split", KeyboardSnapshot: keyboard, MouseSnapshot: mouse},
+	}
+	playbackStateLock.Unlock()
+	t.Cleanup(func() {
+		playbackStateLock.Lock()
+		playbackState = previous
+		playbackStateLock.Unlock()
+	})
+
+	now := time.Now()
+	keyKey := loopKey{keycode: "30", keySoundUUID: "ks", keyState: KeyStateDown}
+	mouseKey := loopKey{keycode: "-1", keySoundUUID: "ks", keyState: KeyStateDown}
+
+	// 键盘与鼠标交替触发时, 各自的序列不会被对方清零
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() { defer wg.Done(); keyboard.selection.nextLoop(keyKey, 100, 0, now) }()
+		go func() { defer wg.Done(); mouse.selection.nextLoop(mouseKey, 100, 0, now) }()
+	}
+	wg.Wait()
+
+	positions := map[string]LoopPosition{}
+	for _, source := range GetLoopState().Sources {
+		if source.Source == LoopSourceEditor {
+			continue
+		}
+		if len(source.Positions) != 1 {
+			t.Fatalf("%s: unexpected positions: %+v", source.Source, source.Positions)
+		}
+		positions[source.Source] = source.Positions[0]
+	}
+	if positions[LoopSourceKeyboard].Next != 10 || positions[LoopSourceKeyboard].Keycode != "30" {
+		t.Fatalf("unexpected keyboard position: %+v", positions[LoopSourceKeyboard])
+	}
+	if positions[LoopSourceMouse].Next != 10 || positions[LoopSourceMouse].Keycode != "-1" {
+		t.Fatalf("unexpected mouse position: %+v", positions[LoopSourceMouse])
+	}
+
+	if err := ResetLoopPositions(LoopSourceMouse); err != nil {
+		t.Fatal(err)
+	}
+	if index := mouse.selection.nextLoop(mouseKey, 100, 0, now); index != 0 {
+		t.Fatalf("expected mouse loop to be reset, got %d", index)
+	}
+	if index := keyboard.selection.nextLoop(keyKey, 100, 0, now); index != 10 {
+		t.Fatalf("expected keyboard loop to be kept, got %d", index)
+	}
+
+	if err := ResetLoopPositions("unknown"); err == nil {
+		t.Fatal("expected an error for an unknown source")
+	}
+	if err := ResetLoopPositions(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(keyboard.selection.loopPositions(now)) != 0 {
+		t.Fatal("expected every source to be reset")
+	}
+}
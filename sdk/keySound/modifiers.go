@@ -0,0 +1,140 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 修饰键变体说明
+// =============================
+//
+// keyEvent 维护全局的修饰键按住状态, 并在分发按键事件时随 KeySoundHandlerWith 传入。
+// 专辑可以为 single/group/global 的任意按键状态定义修饰键变体, 键名为 <state>@<修饰键组合>:
+//
+//	"key_tone": { "single": { "30": { "down": {...}, "down@shift": {...}, "down@ctrl+shift": {...} } } }
+//
+// 修饰键组合必须与当前按住的修饰键完全一致才会命中(按 ctrl、alt、shift、meta 的顺序书写, 不区分左右);
+// 未命中时回退到同一层级的 <state>, 层级之间的优先级(single > group > global)保持不变。
+// 修饰键变体同样可以出现在 speed_tiers 的档位中。
+//
+// 组合键(如 Ctrl+S)见 chords.go。
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Modifiers 为按住的修饰键集合(不区分左右)。
+type Modifiers uint8
+
+const (
+	ModifierCtrl Modifiers = 1 << iota
+	ModifierAlt
+	ModifierShift
+	ModifierMeta
+)
+
+// modifierNames 同时决定了修饰键组合的书写顺序。
+var modifierNames = []struct {
+	modifier Modifiers
+	name     string
+}{
+	{ModifierCtrl, "ctrl"},
+	{ModifierAlt, "alt"},
+	{ModifierShift, "shift"},
+	{ModifierMeta, "meta"},
+}
+
+// modifierAliases 为解析配置时接受的别名。
+var modifierAliases = map[string]Modifiers{
+	"ctrl":    ModifierCtrl,
+	"control": ModifierCtrl,
+	"alt":     ModifierAlt,
+	"option":  ModifierAlt,
+	"shift":   ModifierShift,
+	"meta":    ModifierMeta,
+	"cmd":     ModifierMeta,
+	"command": ModifierMeta,
+	"win":     ModifierMeta,
+	"super":   ModifierMeta,
+}
+
+// modifierKeycodes 为各修饰键左右两侧的 gohook keycode。
+var modifierKeycodes = map[string]Modifiers{
+	"29":   ModifierCtrl,
+	"3613": ModifierCtrl,
+	"56":   ModifierAlt,
+	"3640": ModifierAlt,
+	"42":   ModifierShift,
+	"54":   ModifierShift,
+	"3675": ModifierMeta,
+	"3676": ModifierMeta,
+}
+
+// ModifierForKeycode 返回 keycode 对应的修饰键, 非修饰键返回 0。
+func ModifierForKeycode(keycode string) Modifiers {
+	return modifierKeycodes[keycode]
+}
+
+// String 返回规范的修饰键组合写法, 如 "ctrl+shift"; 没有修饰键时为空。
+func (m Modifiers) String() string {
+	names := make([]string, 0, len(modifierNames))
+	for _, entry := range modifierNames {
+		if m&entry.modifier != 0 {
+			names = append(names, entry.name)
+		}
+	}
+	return strings.Join(names, "+")
+}
+
+// Names 返回修饰键名称列表, 供 SSE 推送等场景使用。
+func (m Modifiers) Names() []string {
+	if m == 0 {
+		return nil
+	}
+	return strings.Split(m.String(), "+")
+}
+
+// ParseModifiers 解析 "ctrl+shift" 形式的修饰键组合(不区分大小写, 接受 cmd/option 等别名)。
+func ParseModifiers(value string) (Modifiers, error) {
+	var modifiers Modifiers
+	for _, part := range strings.Split(value, "+") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		modifier, ok := modifierAliases[part]
+		if !ok {
+			return 0, fmt.Errorf("未知的修饰键: %q", part)
+		}
+		modifiers |= modifier
+	}
+	return modifiers, nil
+}
+
+// keyToneEffectPath 返回 prefix 下当前按键状态生效的配置路径与命中的速度档位名称:
+// 按住修饰键且配置了对应变体时使用 <state>@<修饰键组合>, 否则使用 <state>。
+func keyToneEffectPath(get ConfigGetter, prefix string, keycode string, keyState string, modifiers Modifiers, speed TypingSpeed) (string, string) {
+	if modifiers != 0 {
+		path, tier := typingTierEffectPath(get, prefix, keycode, keyState+"@"+modifiers.String(), speed)
+		if getValue(get, path) != nil {
+			return path, tier
+		}
+	}
+	return typingTierEffectPath(get, prefix, keycode, keyState, speed)
+}
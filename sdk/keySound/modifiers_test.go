@@ -0,0 +1,104 @@
+package keySound
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestParseModifiers 验证修饰键组合的解析、别名与规范写法。
+func TestParseModifiers(t *testing.T) {
+	modifiers, err := ParseModifiers(" Shift + cmd+CTRL ")
+	if err != nil {
+		t.Fatalf("ParseModifiers returned error: %v", err)
+	}
+	if modifiers != ModifierCtrl|ModifierShift|ModifierMeta || modifiers.String() != "ctrl+shift+meta" {
+		t.Fatalf("unexpected modifiers: %v %q", modifiers, modifiers.String())
+	}
+	if !slices.Equal(modifiers.Names(), []string{"ctrl", "shift", "meta"}) || Modifiers(0).Names() != nil {
+		t.Fatalf("unexpected names: %v", modifiers.Names())
+	}
+	if _, err := ParseModifiers("ctrl+hyper"); err == nil {
+		t.Fatal("expected an unknown modifier to be rejected")
+	}
+	if ModifierForKeycode("3613") != ModifierCtrl || ModifierForKeycode("30") != 0 {
+		t.Fatal("unexpected modifier keycodes")
+	}
+}
+
+func modifierTestSnapshot(t *testing.T) *AlbumSnapshot {
+	t.Helper()
+	v := viper.New()
+	v.SetConfigType("json")
+	err := v.ReadConfig(bytes.NewBufferString(`{
+		"key_tone": {
+			"global": {
+				"down": {"type": "sounds", "value": "normal"},
+				"down@shift": {"type": "sounds", "value": "shifted"}
+			},
+			"single": {
+				"30": { "down": {"type": "sounds", "value": "a"}, "down@ctrl+shift": {"type": "sounds", "value": "a_cs"} }
+			},
+			"chords": [
+				{"name": "save", "modifiers": "ctrl", "keycode": "31", "down": {"type": "sounds", "value": "save"}},
+				{"name": "undo", "modifiers": ["ctrl"], "keycode": 44, "mode": "overlay", "down": {"type": "sounds", "value": "undo"}},
+				{"name": "empty", "modifiers": "", "keycode": "45", "down": {"type": "sounds", "value": "never"}}
+			]
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AlbumSnapshot{Viper: v}
+}
+
+// TestKeyToneEffectPathModifierVariants 验证修饰键变体需完全一致才命中, 否则回退到同一层级的原有状态。
+func TestKeyToneEffectPathModifierVariants(t *testing.T) {
+	snapshot := modifierTestSnapshot(t)
+	speed := TypingSpeed{IntervalMS: -1}
+	cases := []struct {
+		prefix    string
+		modifiers Modifiers
+		want      string
+	}{
+		{"key_tone.global", ModifierShift, "key_tone.global.down@shift"},
+		{"key_tone.global", ModifierShift | ModifierAlt, "key_tone.global.down"},
+		{"key_tone.global", 0, "key_tone.global.down"},
+		{"key_tone.single.30", ModifierCtrl | ModifierShift, "key_tone.single.30.down@ctrl+shift"},
+		{"key_tone.single.30", ModifierShift, "key_tone.single.30.down"},
+	}
+	for _, c := range cases {
+		if path, _ := keyToneEffectPath(snapshot.GetValue, c.prefix, "30", KeyStateDown, c.modifiers, speed); path != c.want {
+			t.Fatalf("%s with %q: got %q want %q", c.prefix, c.modifiers, path, c.want)
+		}
+	}
+}
+
+// TestChordEffectPath 验证组合键的匹配、模式与未配置状态时的回退。
+func TestChordEffectPath(t *testing.T) {
+	snapshot := modifierTestSnapshot(t)
+
+	if path, overlay := chordEffectPath(snapshot.GetValue, "31", KeyStateDown, ModifierCtrl); path != "key_tone.chords.0.down" || overlay {
+		t.Fatalf("unexpected save chord: %q %v", path, overlay)
+	}
+	if path, overlay := chordEffectPath(snapshot.GetValue, "44", KeyStateDown, ModifierCtrl); path != "key_tone.chords.1.down" || !overlay {
+		t.Fatalf("unexpected undo chord: %q %v", path, overlay)
+	}
+	// 修饰键必须完全一致; 未配置 up 时不影响原有声音; 空修饰键的组合键永不命中
+	if path, _ := chordEffectPath(snapshot.GetValue, "31", KeyStateDown, ModifierCtrl|ModifierShift); path != "" {
+		t.Fatalf("expected ctrl+shift+s not to match ctrl+s, got %q", path)
+	}
+	if path, _ := chordEffectPath(snapshot.GetValue, "31", KeyStateUp, ModifierCtrl); path != "" {
+		t.Fatalf("expected the chord without up to fall back, got %q", path)
+	}
+	if path, _ := chordEffectPath(snapshot.GetValue, "45", KeyStateDown, 0); path != "" {
+		t.Fatalf("expected a chord without modifiers never to match, got %q", path)
+	}
+
+	// 组合键代替原有声音时不报告速度档位
+	if tier := typingTierFor(snapshot.GetValue, "31", KeyStateDown, ModifierCtrl, TypingSpeed{IntervalMS: -1}); tier != "" {
+		t.Fatalf("unexpected tier for a chord: %q", tier)
+	}
+}
@@ -0,0 +1,388 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 导入时的音频处理
+// =============================
+//
+// add_new_sound_file 可选地对导入的音频执行一次处理: 裁掉首尾静音、缩混/重采样到指定目标, 并测量 EBU R128 综合响度。
+//
+// 原始文件始终以 audioFiles/<sha256><type> 保存, 不会被改写; 处理结果另存为 audioFiles/<sha256>.processed.wav,
+// 并在专辑配置中记录:
+//
+//   audio_files.<sha256>.processed: {type, sample_rate, channels, trim_start_ms, trim_end_ms, duration_ms}
+//   audio_files.<sha256>.loudness:  {integrated_lufs, target_lufs, gain_db}
+//
+// 播放时(见 albumAudioFile)存在 processed 则改用处理后的文件; loudness.gain_db 会叠加到播放音量上,
+// 使同一专辑内响度差异很大的素材听起来一样响。两项配置都不存在时, 行为与之前完全一致。
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"KeyTone/keySound/audio"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/wav"
+)
+
+// ProcessedAudioSuffix 为处理后文件名中 sha256 与扩展名之间的标记(<sha256>.processed.wav)。
+const ProcessedAudioSuffix = audio.ProcessedAudioSuffix
+
+const (
+	// defaultSilenceThresholdDB 为未指定阈值时判定静音的峰值电平(dBFS)。
+	defaultSilenceThresholdDB = -60.0
+	// defaultTargetLUFS 为未指定目标时的目标响度(EBU R128 推荐值)。
+	defaultTargetLUFS = -23.0
+	// maxLoudnessGainDB 限制响度增益的幅度, 避免把几乎无声的素材放大成噪音。
+	maxLoudnessGainDB = 24.0
+)
+
+// ProcessingOptions 为导入时的处理选项, 以 JSON 形式随上传请求提交。零值表示不做任何处理。
+type ProcessingOptions struct {
+	// TrimSilence 为 true 时裁掉首尾峰值低于 SilenceThresholdDB 的部分
+	TrimSilence        bool    `json:"trim_silence"`
+	SilenceThresholdDB float64 `json:"silence_threshold_db"`
+	// TargetSampleRate 为 0 时保持原采样率
+	TargetSampleRate int `json:"target_sample_rate"`
+	// TargetChannels 为 0 时保持原声道数; 1 表示缩混为单声道
+	TargetChannels int `json:"target_channels"`
+	// MeasureLoudness 为 true 时测量综合响度, 并写入使其达到 TargetLUFS 的增益
+	MeasureLoudness bool    `json:"measure_loudness"`
+	TargetLUFS      float64 `json:"target_lufs"`
+}
+
+// Validate 检查选项取值是否合理。
+func (o ProcessingOptions) Validate() error {
+	if o.SilenceThresholdDB > 0 {
+		return fmt.Errorf("silence_threshold_db must be <= 0, got %v", o.SilenceThresholdDB)
+	}
+	if o.TargetSampleRate != 0 && (o.TargetSampleRate < 8000 || o.TargetSampleRate > 192000) {
+		return fmt.Errorf("target_sample_rate must be 0 or within [8000, 192000], got %d", o.TargetSampleRate)
+	}
+	if o.TargetChannels < 0 || o.TargetChannels > 2 {
+		return fmt.Errorf("target_channels must be 0, 1 or 2, got %d", o.TargetChannels)
+	}
+	if o.TargetLUFS > 0 {
+		return fmt.Errorf("target_lufs must be <= 0, got %v", o.TargetLUFS)
+	}
+	return nil
+}
+
+// Enabled 报告是否请求了任何处理。
+func (o ProcessingOptions) Enabled() bool {
+	return o.rewritesAudio() || o.MeasureLoudness
+}
+
+// rewritesAudio 报告处理是否会改变音频内容(从而需要保存处理后的文件)。
+func (o ProcessingOptions) rewritesAudio() bool {
+	return o.TrimSilence || o.TargetSampleRate != 0 || o.TargetChannels != 0
+}
+
+// ProcessedVariant 描述保存在原始文件旁的处理后文件。
+type ProcessedVariant struct {
+	Type        string  `json:"type"`
+	SampleRate  int     `json:"sample_rate"`
+	Channels    int     `json:"channels"`
+	TrimStartMS float64 `json:"trim_start_ms"`
+	TrimEndMS   float64 `json:"trim_end_ms"`
+	DurationMS  float64 `json:"duration_ms"`
+}
+
+// ConfigValue 返回写入 audio_files.<sha256>.processed 的配置值。
+func (v *ProcessedVariant) ConfigValue() map[string]any {
+	return map[string]any{
+		"type":          v.Type,
+		"sample_rate":   v.SampleRate,
+		"channels":      v.Channels,
+		"trim_start_ms": v.TrimStartMS,
+		"trim_end_ms":   v.TrimEndMS,
+		"duration_ms":   v.DurationMS,
+	}
+}
+
+// LoudnessInfo 为一次响度测量的结果。
+type LoudnessInfo struct {
+	IntegratedLUFS float64 `json:"integrated_lufs"`
+	TargetLUFS     float64 `json:"target_lufs"`
+	GainDB         float64 `json:"gain_db"`
+}
+
+// ConfigValue 返回写入 audio_files.<sha256>.loudness 的配置值。
+func (l *LoudnessInfo) ConfigValue() map[string]any {
+	return map[string]any{
+		"integrated_lufs": l.IntegratedLUFS,
+		"target_lufs":     l.TargetLUFS,
+		"gain_db":         l.GainDB,
+	}
+}
+
+// ProcessedAudio 为处理结果。Variant 为 nil 表示音频内容未改变, 无需保存处理后的文件;
+// Loudness 为 nil 表示未请求测量, 或素材整体低于绝对门限(近乎无声)而无法给出响度。
+type ProcessedAudio struct {
+	Variant  *ProcessedVariant
+	Loudness *LoudnessInfo
+
+	samples [][2]float64
+	format  beep.Format
+}
+
+// ProcessAudio 识别 r 的格式并完整解码, 按 opts 依次执行: 裁剪静音 -> 缩混 -> 重采样 -> 响度测量。
+// 响度在最终输出上测量, 因此增益与播放时实际使用的文件一致。
+func ProcessAudio(r io.ReadSeekCloser, opts ProcessingOptions) (*ProcessedAudio, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	ext, err := audio.Sniff(r)
+	if err != nil {
+		return nil, err
+	}
+	streamer, format, err := audio.Decode(r, ext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s audio: %w", ext, err)
+	}
+	samples, err := audio.ReadAll(streamer)
+	streamer.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s audio: %w", ext, err)
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("audio has no samples")
+	}
+
+	result := &ProcessedAudio{samples: samples, format: format}
+	var variant ProcessedVariant
+
+	if opts.TrimSilence {
+		threshold := opts.SilenceThresholdDB
+		if threshold == 0 {
+			threshold = defaultSilenceThresholdDB
+		}
+		start, end := silenceBounds(result.samples, math.Pow(10, threshold/20))
+		if start >= end {
+			return nil, errors.New("audio is entirely below the silence threshold")
+		}
+		variant.TrimStartMS = durationMS(format.SampleRate, start)
+		variant.TrimEndMS = durationMS(format.SampleRate, len(result.samples)-end)
+		result.samples = result.samples[start:end]
+	}
+
+	if opts.TargetChannels == 1 && result.format.NumChannels != 1 {
+		for i, s := range result.samples {
+			mid := (s[0] + s[1]) / 2
+			result.samples[i] = [2]float64{mid, mid}
+		}
+	}
+	if opts.TargetChannels != 0 {
+		result.format.NumChannels = opts.TargetChannels
+	}
+
+	if opts.TargetSampleRate != 0 && beep.SampleRate(opts.TargetSampleRate) != result.format.SampleRate {
+		resampled := beep.Resample(4, result.format.SampleRate, beep.SampleRate(opts.TargetSampleRate),
+			audio.NewMemoryStreamer(result.samples))
+		result.samples, _ = audio.ReadAll(resampled)
+		result.format.SampleRate = beep.SampleRate(opts.TargetSampleRate)
+	}
+
+	if opts.rewritesAudio() {
+		// 处理后的文件固定保存为 16 位 WAV, 足以满足键音的回放需求, 也便于任何平台解码
+		result.format.Precision = 2
+		variant.Type = audio.ProcessedAudioType
+		variant.SampleRate = int(result.format.SampleRate)
+		variant.Channels = result.format.NumChannels
+		variant.DurationMS = durationMS(result.format.SampleRate, len(result.samples))
+		result.Variant = &variant
+	}
+
+	if opts.MeasureLoudness {
+		target := opts.TargetLUFS
+		if target == 0 {
+			target = defaultTargetLUFS
+		}
+		if lufs, ok := IntegratedLoudness(result.samples, result.format.SampleRate, result.format.NumChannels); ok {
+			gain := math.Max(-maxLoudnessGainDB, math.Min(maxLoudnessGainDB, target-lufs))
+			result.Loudness = &LoudnessInfo{IntegratedLUFS: lufs, TargetLUFS: target, GainDB: gain}
+		}
+	}
+
+	return result, nil
+}
+
+// WriteWAV 将处理后的音频编码为 WAV 写入 w。
+func (p *ProcessedAudio) WriteWAV(w io.WriteSeeker) error {
+	return wav.Encode(w, audio.NewMemoryStreamer(p.samples), p.format)
+}
+
+// ProcessedAudioFileName 返回 sha256 对应的处理后文件名。
+func ProcessedAudioFileName(sha256 string) string {
+	return audio.ProcessedAudioFileName(sha256)
+}
+
+// PlaybackAudioFileName 返回 sha256 实际用于播放与编辑的文件名: 存在处理后的文件时返回它, 否则返回原始文件名。
+func PlaybackAudioFileName(get ConfigGetter, sha256 string, fileType string) string {
+	return audio.PlaybackAudioFileName(get, sha256, fileType)
+}
+
+// LoudnessGainDB 返回导入时为 sha256 测得的响度增益, 未测量时为 0。
+func LoudnessGainDB(get ConfigGetter, sha256 string) float64 {
+	return audio.LoudnessGainDB(get, sha256)
+}
+
+// albumAudioFile 返回专辑内音频文件的播放路径(优先使用处理后的文件)与响度增益。
+func albumAudioFile(get ConfigGetter, albumPath string, sha256 string, fileType string) *AudioFilePath {
+	return &AudioFilePath{
+		Global:         audio.AlbumAudioFile(get, albumPath, sha256, fileType),
+		LoudnessGainDB: audio.LoudnessGainDB(get, sha256),
+	}
+}
+
+// loudnessGainVolume 将 dB 增益换算为 effects.Volume(Base 1.6)的音量值, 以便直接叠加到 initVolume 上。
+func loudnessGainVolume(audioFilePath *AudioFilePath) float64 {
+	if audioFilePath == nil {
+		return 0
+	}
+	return audio.LoudnessGainVolume(audioFilePath.LoudnessGainDB)
+}
+
+// silenceBounds 返回首个与最后一个峰值达到 threshold(线性幅度)的样本所界定的区间 [start, end)。
+func silenceBounds(samples [][2]float64, threshold float64) (int, int) {
+	loud := func(s [2]float64) bool { return math.Abs(s[0]) >= threshold || math.Abs(s[1]) >= threshold }
+	start := 0
+	for start < len(samples) && !loud(samples[start]) {
+		start++
+	}
+	end := len(samples)
+	for end > start && !loud(samples[end-1]) {
+		end--
+	}
+	return start, end
+}
+
+func durationMS(sampleRate beep.SampleRate, samples int) float64 {
+	return float64(samples) * 1000 / float64(sampleRate)
+}
+
+// biquad 为直接 I 型二阶 IIR 滤波器。
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// kWeightingFilters 按 ITU-R BS.1770 返回任意采样率下的 K 计权滤波器(高架 + 高通)。
+// 系数由模拟原型经双线性变换得到, 在 48kHz 下与标准给出的系数一致。
+func kWeightingFilters(sampleRate float64) (biquad, biquad) {
+	// 第一级: 约 +4dB 的高架滤波器, 模拟头部的声学效应
+	f0, gainDB, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / sampleRate)
+	vh := math.Pow(10, gainDB/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	// 第二级: RLB 高通滤波器
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / sampleRate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// IntegratedLoudness 按 EBU R128 / ITU-R BS.1770 测量综合响度(LUFS)。
+// 以 400ms 块、75% 重叠计算块响度, 先用 -70 LUFS 的绝对门限、再用比其均值低 10 LU 的相对门限筛选。
+// 键音素材常短于 400ms, 此时把整段作为一个块测量。channels 为 1 时只测量一个声道。
+// 所有块都低于绝对门限时返回 false。
+func IntegratedLoudness(samples [][2]float64, sampleRate beep.SampleRate, channels int) (float64, bool) {
+	if len(samples) == 0 || sampleRate <= 0 {
+		return 0, false
+	}
+	channels = max(1, min(channels, 2))
+
+	// 每个声道 K 计权后的平方值
+	squared := make([][2]float64, len(samples))
+	for ch := 0; ch < channels; ch++ {
+		shelf, highPass := kWeightingFilters(float64(sampleRate))
+		for i, s := range samples {
+			y := highPass.process(shelf.process(s[ch]))
+			squared[i][ch] = y * y
+		}
+	}
+
+	blockSize := int(float64(sampleRate) * 0.4)
+	step := blockSize / 4
+	if len(samples) < blockSize {
+		blockSize, step = len(samples), len(samples)
+	}
+	// 前缀和, 便于计算每个重叠块的均方值
+	prefix := make([]float64, len(samples)+1)
+	for i, s := range squared {
+		prefix[i+1] = prefix[i] + s[0] + s[1]
+	}
+	var powers []float64
+	for start := 0; start+blockSize <= len(samples); start += step {
+		powers = append(powers, (prefix[start+blockSize]-prefix[start])/float64(blockSize))
+	}
+
+	loudness := func(power float64) float64 { return -0.691 + 10*math.Log10(power) }
+	gatedMean := func(threshold float64) (float64, bool) {
+		sum, count := 0.0, 0
+		for _, power := range powers {
+			if power > 0 && loudness(power) > threshold {
+				sum += power
+				count++
+			}
+		}
+		if count == 0 {
+			return 0, false
+		}
+		return sum / float64(count), true
+	}
+
+	absoluteMean, ok := gatedMean(-70)
+	if !ok {
+		return 0, false
+	}
+	relativeMean, ok := gatedMean(loudness(absoluteMean) - 10)
+	if !ok {
+		return 0, false
+	}
+	return loudness(relativeMean), true
+}
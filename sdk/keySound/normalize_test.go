@@ -0,0 +1,157 @@
+package keySound
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sineSamples 生成两个声道相同的正弦波。
+func sineSamples(frequency, amplitude float64, sampleRate, frames int) [][2]float64 {
+	samples := make([][2]float64, frames)
+	for i := range samples {
+		v := amplitude * math.Sin(2*math.Pi*frequency*float64(i)/float64(sampleRate))
+		samples[i] = [2]float64{v, v}
+	}
+	return samples
+}
+
+// TestIntegratedLoudness 以 BS.1770 的参考信号验证测量: 1kHz 正弦在双声道下读数约等于其峰值 dBFS,
+// 单声道则再低约 3dB; 全部低于绝对门限时不给出结果。
+func TestIntegratedLoudness(t *testing.T) {
+	samples := sineSamples(1000, 0.1, 48000, 48000*2)
+	if lufs, ok := IntegratedLoudness(samples, 48000, 2); !ok || math.Abs(lufs-(-20)) > 0.1 {
+		t.Fatalf("stereo loudness: got %v (%v), want about -20", lufs, ok)
+	}
+	if lufs, ok := IntegratedLoudness(samples, 48000, 1); !ok || math.Abs(lufs-(-23.01)) > 0.1 {
+		t.Fatalf("mono loudness: got %v (%v), want about -23", lufs, ok)
+	}
+	// 其他采样率下的 K 计权系数同样成立
+	if lufs, ok := IntegratedLoudness(sineSamples(1000, 0.1, 22050, 22050), 22050, 2); !ok || math.Abs(lufs-(-20)) > 0.2 {
+		t.Fatalf("22.05kHz loudness: got %v (%v), want about -20", lufs, ok)
+	}
+	// 短于 400ms 的素材作为一个块测量
+	if lufs, ok := IntegratedLoudness(samples[:4800], 48000, 2); !ok || math.Abs(lufs-(-20)) > 0.5 {
+		t.Fatalf("short clip loudness: got %v (%v), want about -20", lufs, ok)
+	}
+	if _, ok := IntegratedLoudness(make([][2]float64, 48000), 48000, 2); ok {
+		t.Fatal("expected no loudness for silence")
+	}
+}
+
+// TestProcessAudio 验证裁剪静音、缩混、重采样与响度增益, 并确认处理后的 WAV 可被播放端解码。
+func TestProcessAudio(t *testing.T) {
+	// 44.1kHz 立体声 16 位: 100ms 静音 + 200ms 正弦(仅左声道) + 50ms 静音
+	var sound bytes.Buffer
+	write := func(left, right float64) {
+		binary.Write(&sound, binary.BigEndian, int16(left*32767))
+		binary.Write(&sound, binary.BigEndian, int16(right*32767))
+	}
+	for i := 0; i < 4410; i++ {
+		write(0, 0)
+	}
+	for i := 0; i < 8820; i++ {
+		// 余弦从峰值开始, 使裁剪边界精确落在片段起点
+		write(0.5*math.Cos(2*math.Pi*1000*float64(i)/44100), 0)
+	}
+	for i := 0; i < 2205; i++ {
+		write(0, 0)
+	}
+	aiff := buildAIFF("", 2, 16, sound.Bytes())
+
+	processed, err := ProcessAudio(memoryFile(aiff), ProcessingOptions{
+		TrimSilence:      true,
+		TargetSampleRate: 22050,
+		TargetChannels:   1,
+		MeasureLoudness:  true,
+		TargetLUFS:       -18,
+	})
+	if err != nil {
+		t.Fatalf("ProcessAudio returned error: %v", err)
+	}
+	variant := processed.Variant
+	if variant == nil {
+		t.Fatal("expected a processed variant")
+	}
+	if variant.Type != ".wav" || variant.SampleRate != 22050 || variant.Channels != 1 {
+		t.Fatalf("unexpected variant format: %+v", variant)
+	}
+	if math.Abs(variant.TrimStartMS-100) > 1 || math.Abs(variant.TrimEndMS-50) > 1 || math.Abs(variant.DurationMS-200) > 1 {
+		t.Fatalf("unexpected trim: %+v", variant)
+	}
+	loudness := processed.Loudness
+	if loudness == nil || loudness.TargetLUFS != -18 || math.Abs(loudness.GainDB-(-18-loudness.IntegratedLUFS)) > 1e-9 {
+		t.Fatalf("unexpected loudness: %+v", loudness)
+	}
+
+	path := filepath.Join(t.TempDir(), ProcessedAudioFileName("abc"))
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processed.WriteWAV(file); err != nil {
+		t.Fatalf("WriteWAV returned error: %v", err)
+	}
+	file.Close()
+	reopened, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := ProbeAudio(reopened)
+	if err != nil {
+		t.Fatalf("processed file is not decodable: %v", err)
+	}
+	if info.Format != ".wav" || info.SampleRate != 22050 || info.Channels != 1 || math.Abs(info.DurationMS-200) > 1 {
+		t.Fatalf("unexpected processed file info: %+v", info)
+	}
+
+	// 仅测量响度时不改写音频
+	processed, err = ProcessAudio(memoryFile(aiff), ProcessingOptions{MeasureLoudness: true})
+	if err != nil {
+		t.Fatalf("ProcessAudio returned error: %v", err)
+	}
+	if processed.Variant != nil || processed.Loudness == nil || processed.Loudness.TargetLUFS != defaultTargetLUFS {
+		t.Fatalf("unexpected loudness-only result: %+v %+v", processed.Variant, processed.Loudness)
+	}
+
+	if _, err := ProcessAudio(memoryFile(aiff), ProcessingOptions{TargetChannels: 3}); err == nil {
+		t.Fatal("expected invalid options to be rejected")
+	}
+	silent := buildAIFF("", 2, 16, make([]byte, 4*1000))
+	if _, err := ProcessAudio(memoryFile(silent), ProcessingOptions{TrimSilence: true}); err == nil {
+		t.Fatal("expected an all-silent file to be rejected when trimming")
+	}
+}
+
+// TestAlbumAudioFilePrefersProcessedVariant 验证播放路径优先使用处理后的文件并带上响度增益,
+// 且处理后的文件与原始文件共用 sha256 缓存位、互不冲突。
+func TestAlbumAudioFilePrefersProcessedVariant(t *testing.T) {
+	values := map[string]any{
+		"audio_files.abc.processed.type":   ".wav",
+		"audio_files.abc.loudness.gain_db": 6.0,
+	}
+	get := func(key string) any { return values[key] }
+	album := filepath.Join("root", "album-uuid")
+
+	path := albumAudioFile(get, album, "abc", ".wav")
+	if path.Global != filepath.Join(album, "audioFiles", "abc.processed.wav") || path.LoudnessGainDB != 6 {
+		t.Fatalf("unexpected processed path: %+v", path)
+	}
+	if original := albumAudioFile(get, album, "def", ".mp3"); original.Global != filepath.Join(album, "audioFiles", "def.mp3") || original.LoudnessGainDB != 0 {
+		t.Fatalf("unexpected original path: %+v", original)
+	}
+
+	processedKey, _ := newPCMCacheKey(path, nil)
+	originalKey, _ := newPCMCacheKey(&AudioFilePath{Global: filepath.Join(album, "audioFiles", "abc.wav")}, nil)
+	if processedKey.Sha256 != "abc" || processedKey.AlbumUUID != "album-uuid" || processedKey == originalKey {
+		t.Fatalf("unexpected processed cache key: %+v (original %+v)", processedKey, originalKey)
+	}
+
+	// +6dB 换算为 Base 1.6 的音量值后应约为 2 倍振幅
+	if amplitude := math.Pow(1.6, loudnessGainVolume(path)); math.Abs(amplitude-math.Pow(10, 6.0/20)) > 1e-9 {
+		t.Fatalf("unexpected gain amplitude: %v", amplitude)
+	}
+}
@@ -0,0 +1,50 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// 起音检测算法(说明见 KeyTone/keySound/audio 的 onsets.go)与 ktalbum-tools 共用; 这里只负责定位专辑中的音频文件。
+
+import (
+	"fmt"
+
+	"KeyTone/keySound/audio"
+)
+
+// OnsetOptions 为起音检测的可调参数, 零值字段使用默认值。
+type OnsetOptions = audio.OnsetOptions
+
+// OnsetRange 为一个候选裁剪范围, 时间单位均为毫秒, 可直接写入 sounds.<uuid>.cut。
+type OnsetRange = audio.OnsetRange
+
+// DetectAudioFileOnsets 对专辑中的音频文件执行起音检测(存在处理后的文件时使用处理后的文件, 与播放和编辑器一致)。
+func DetectAudioFileOnsets(get ConfigGetter, albumPath string, sha256 string, opts OnsetOptions) ([]OnsetRange, error) {
+	fileType, ok := getValue(get, "audio_files."+sha256+".type").(string)
+	if !ok || fileType == "" {
+		return nil, fmt.Errorf("audio file %s not found", sha256)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	samples, format, err := audio.ReadFile(albumAudioFile(get, albumPath, sha256, fileType).Global)
+	if err != nil {
+		return nil, err
+	}
+	return audio.DetectOnsets(samples, format.SampleRate, opts)
+}
@@ -0,0 +1,453 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// PCM Cache（解码缓存）说明
+// =============================
+//
+// 目标：按键热路径（KeySoundHandler -> PlayKeySound）不再重复执行 os.Open + 解码 + 重采样。
+// 实现方式：
+//   1) 缓存“已裁剪 + 已重采样到 formatGlobalSampleRate”的 beep.Buffer；
+//   2) key 为 专辑UUID + sha256 + type + cut(start/end)，音量不参与 key（音量在播放链中叠加）；
+//   3) 总内存受 playback.pcm_cache.max_memory_mb 限制，超出后按 LRU 淘汰最久未使用的条目；
+//   4) ApplyPlaybackRouting 生成快照、编辑器加载专辑时，会在后台预先填充 sounds 的裁剪片段；
+//   5) 编辑器修改 audio_files / sounds.* 后，通过 audioPackageConfig.OnValueChange 使对应条目失效。
+//
+// 关键约束：
+//   - 单个条目超过内存上限的 1/4 时不进入缓存，直接走原有的流式播放路径，避免长音频挤掉所有短片段。
+//   - 缓存内的 Buffer 只读；每次播放通过 Buffer.Streamer 获取独立的读取游标，可被多个 goroutine 并发使用。
+
+import (
+	"KeyTone/config"
+	"KeyTone/keySound/audio"
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	audioPackageConfig "KeyTone/audioPackage/config"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// pcmBufferFormat 为缓存内 Buffer 的存储格式。
+// Precision 取 3(24bit), 在重采样后的精度与内存占用之间取平衡。
+var pcmBufferFormat = beep.Format{
+	SampleRate:  formatGlobalSampleRate,
+	NumChannels: 2,
+	Precision:   3,
+}
+
+// pcmCacheKey 唯一标识一个“已裁剪 + 已重采样”的音频片段。
+type pcmCacheKey struct {
+	AlbumUUID string
+	Sha256    string
+	FileType  string
+	HasCut    bool
+	StartMS   int64
+	EndMS     int64
+	FadeInMS  int64
+	FadeOutMS int64
+	FadeCurve string
+}
+
+type pcmCacheEntry struct {
+	key    pcmCacheKey
+	buffer *beep.Buffer
+	bytes  int64
+}
+
+// PCMCacheStats 为缓存的运行统计, 供诊断接口展示。
+type PCMCacheStats struct {
+	Enabled       bool    `json:"enabled"`
+	Entries       int     `json:"entries"`
+	Bytes         int64   `json:"bytes"`
+	MaxBytes      int64   `json:"maxBytes"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRatio      float64 `json:"hitRatio"`
+	Evictions     uint64  `json:"evictions"`
+	Invalidations uint64  `json:"invalidations"`
+	// Bypassed 为因片段过大而未进入缓存(走流式播放)的次数。
+	Bypassed uint64 `json:"bypassed"`
+}
+
+type pcmCache struct {
+	mutex   sync.Mutex
+	entries map[pcmCacheKey]*list.Element
+	lru     *list.List // Front 为最近使用
+	bytes   int64
+	// soundKeys 记录 “专辑UUID/声音UUID” 当前对应的缓存 key, 用于 sounds.<uuid> 变更时精确失效。
+	soundKeys map[string]pcmCacheKey
+
+	hits          uint64
+	misses        uint64
+	evictions     uint64
+	invalidations uint64
+	bypassed      uint64
+}
+
+func newPCMCache() *pcmCache {
+	return &pcmCache{
+		entries:   make(map[pcmCacheKey]*list.Element),
+		lru:       list.New(),
+		soundKeys: make(map[string]pcmCacheKey),
+	}
+}
+
+var playbackPCMCache = newPCMCache()
+
+func init() {
+	audioPackageConfig.OnValueChange(invalidatePCMCacheByConfigKey)
+}
+
+// newPCMCacheKey 根据音频路径与裁剪参数生成缓存 key。
+// 专辑内音频的路径约定为 AudioPackagePath/<albumUUID>/audioFiles/<sha256><type>;
+// 内嵌测试音(SS)没有专辑, 以文件名作为 sha256 位。
+func newPCMCacheKey(audioFilePath *AudioFilePath, cut *Cut) (pcmCacheKey, bool) {
+	if audioFilePath == nil {
+		return pcmCacheKey{}, false
+	}
+
+	var key pcmCacheKey
+	path := audioFilePath.Part
+	if path == "" {
+		path = audioFilePath.Global
+	}
+	if path != "" {
+		fileName := filepath.Base(path)
+		key.FileType = strings.ToLower(filepath.Ext(fileName))
+		key.Sha256 = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+		// 处理后的文件(<sha256>.processed.wav)与原始文件共用 sha256, 以便 audio_files.<sha256> 变更时一并失效
+		if sha256, ok := strings.CutSuffix(key.Sha256, ProcessedAudioSuffix); ok {
+			key.Sha256 = sha256
+			key.FileType = ProcessedAudioSuffix + key.FileType
+		}
+		key.AlbumUUID = filepath.Base(filepath.Dir(filepath.Dir(path)))
+	} else if audioFilePath.SS != "" {
+		key.FileType = strings.ToLower(filepath.Ext(audioFilePath.SS))
+		key.Sha256 = audioFilePath.SS
+	} else {
+		return pcmCacheKey{}, false
+	}
+
+	if cut != nil {
+		key.HasCut = true
+		key.StartMS = cut.StartMS
+		key.EndMS = cut.EndMS
+		if cut.FadeInMS > 0 || cut.FadeOutMS > 0 {
+			key.FadeInMS = cut.FadeInMS
+			key.FadeOutMS = cut.FadeOutMS
+			key.FadeCurve = audio.NormalizeFadeCurve(cut.FadeCurve)
+		}
+	}
+	return key, true
+}
+
+// pcmBufferStreamer 为缓存 Buffer 提供一个独立的、可关闭的读取游标。
+// 关闭后立即停止输出, 使 CloseAllStreams 对缓存命中的播放同样生效。
+type pcmBufferStreamer struct {
+	beep.StreamSeeker
+	closed atomic.Bool
+}
+
+func newPCMBufferStreamer(buffer *beep.Buffer) *pcmBufferStreamer {
+	return &pcmBufferStreamer{StreamSeeker: buffer.Streamer(0, buffer.Len())}
+}
+
+func (s *pcmBufferStreamer) Stream(samples [][2]float64) (int, bool) {
+	if s.closed.Load() {
+		return 0, false
+	}
+	return s.StreamSeeker.Stream(samples)
+}
+
+func (s *pcmBufferStreamer) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+// estimatePCMBytes 估算片段重采样到 formatGlobalSampleRate 并以 pcmBufferFormat 存储后的字节数。
+func estimatePCMBytes(totalSamples int, sampleRate beep.SampleRate, cut *Cut) int64 {
+	samples := totalSamples
+	if cut != nil {
+		startSample := sampleRate.N(time.Millisecond * time.Duration(cut.StartMS))
+		endSample := sampleRate.N(time.Millisecond * time.Duration(cut.EndMS))
+		if startSample < 0 {
+			startSample = 0
+		}
+		if endSample > totalSamples {
+			endSample = totalSamples
+		}
+		samples = endSample - startSample
+	}
+	if samples <= 0 || sampleRate <= 0 {
+		return 0
+	}
+	resampled := int64(samples) * int64(formatGlobalSampleRate) / int64(sampleRate)
+	return resampled * int64(pcmBufferFormat.Width())
+}
+
+// pcmCacheSettings 读取缓存开关与内存上限。
+func pcmCacheSettings() (bool, int64) {
+	isEnabled, ok := config.GetValue("playback.pcm_cache.is_enabled").(bool)
+	if !ok {
+		isEnabled = config.Playback___pcm_cache___is_enabled
+		go config.SetValue("playback.pcm_cache.is_enabled", isEnabled)
+	}
+
+	maxMemoryMB, ok := config.GetValue("playback.pcm_cache.max_memory_mb").(float64)
+	if !ok {
+		maxMemoryMB = config.Playback___pcm_cache___max_memory_mb
+		go config.SetValue("playback.pcm_cache.max_memory_mb", maxMemoryMB)
+	}
+	if maxMemoryMB < 0 {
+		maxMemoryMB = 0
+	}
+
+	return isEnabled, int64(maxMemoryMB * 1024 * 1024)
+}
+
+// admits 判断一个预计占用 estimatedBytes 的片段是否允许进入缓存。
+func (c *pcmCache) admits(estimatedBytes int64, maxBytes int64) bool {
+	if maxBytes <= 0 || estimatedBytes > maxBytes/4 {
+		c.mutex.Lock()
+		c.bypassed++
+		c.mutex.Unlock()
+		return false
+	}
+	return true
+}
+
+func (c *pcmCache) get(key pcmCacheKey) (*beep.Buffer, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(element)
+	return element.Value.(*pcmCacheEntry).buffer, true
+}
+
+// contains 仅检查是否存在, 不影响命中统计与 LRU 顺序(用于预填充时跳过已缓存条目)。
+func (c *pcmCache) contains(key pcmCacheKey) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.entries[key]
+	return ok
+}
+
+func (c *pcmCache) put(key pcmCacheKey, buffer *beep.Buffer, maxBytes int64) {
+	size := int64(buffer.Len() * buffer.Format().Width())
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*pcmCacheEntry)
+		c.bytes += size - entry.bytes
+		entry.buffer = buffer
+		entry.bytes = size
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[key] = c.lru.PushFront(&pcmCacheEntry{key: key, buffer: buffer, bytes: size})
+		c.bytes += size
+	}
+
+	// 超出上限时从最久未使用的一端淘汰, 但不淘汰刚写入的条目。
+	for c.bytes > maxBytes && c.lru.Len() > 1 {
+		c.removeElement(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// removeElement 要求调用方已持有 c.mutex。
+func (c *pcmCache) removeElement(element *list.Element) {
+	entry := element.Value.(*pcmCacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.bytes
+}
+
+// rememberSound 记录声音当前对应的缓存 key。
+func (c *pcmCache) rememberSound(albumUUID string, soundUUID string, key pcmCacheKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.soundKeys[albumUUID+"/"+soundUUID] = key
+}
+
+// invalidateSound 使某个声音当前引用的片段失效。
+func (c *pcmCache) invalidateSound(albumUUID string, soundUUID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	indexKey := albumUUID + "/" + soundUUID
+	key, ok := c.soundKeys[indexKey]
+	if !ok {
+		return
+	}
+	delete(c.soundKeys, indexKey)
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+		c.invalidations++
+	}
+}
+
+// invalidateWhere 使所有满足条件的条目失效。
+func (c *pcmCache) invalidateWhere(match func(key pcmCacheKey) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*pcmCacheEntry).key) {
+			c.removeElement(element)
+			c.invalidations++
+		}
+		element = next
+	}
+	for indexKey, key := range c.soundKeys {
+		if match(key) {
+			delete(c.soundKeys, indexKey)
+		}
+	}
+}
+
+func (c *pcmCache) stats() PCMCacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stats := PCMCacheStats{
+		Entries:       c.lru.Len(),
+		Bytes:         c.bytes,
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Evictions:     c.evictions,
+		Invalidations: c.invalidations,
+		Bypassed:      c.bypassed,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRatio = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+// GetPCMCacheStats 返回解码缓存的命中与内存统计。
+func GetPCMCacheStats() PCMCacheStats {
+	stats := playbackPCMCache.stats()
+	stats.Enabled, stats.MaxBytes = pcmCacheSettings()
+	return stats
+}
+
+// InvalidatePCMCacheAlbum 使某个专辑的全部缓存条目失效(如专辑被删除或被覆盖导入时)。
+func InvalidatePCMCacheAlbum(albumUUID string) {
+	playbackPCMCache.invalidateWhere(func(key pcmCacheKey) bool {
+		return key.AlbumUUID == albumUUID
+	})
+}
+
+// InvalidatePCMCacheAudioFile 使某个专辑中一个音频文件(sha256)的全部缓存条目失效(如其处理后的文件被替换时)。
+func InvalidatePCMCacheAudioFile(albumUUID string, sha256 string) {
+	playbackPCMCache.invalidateWhere(func(key pcmCacheKey) bool {
+		return key.AlbumUUID == albumUUID && key.Sha256 == sha256
+	})
+}
+
+// invalidatePCMCacheByConfigKey 响应编辑器配置变更:
+//   - audio_files(.<sha256>...) 变更: 使对应音频(或整个专辑)的条目失效;
+//   - sounds(.<uuid>...) 变更: 使该声音此前引用的片段(或整个专辑)失效。
+func invalidatePCMCacheByConfigKey(key string) {
+	segments := strings.Split(key, ".")
+	if len(segments) == 0 || (segments[0] != "audio_files" && segments[0] != "sounds") {
+		return
+	}
+
+	albumUUID, ok := getAudioPkgUUID(audioPackageConfig.GetValue, "")
+	if !ok {
+		return
+	}
+
+	switch {
+	case len(segments) == 1:
+		InvalidatePCMCacheAlbum(albumUUID)
+	case segments[0] == "audio_files":
+		InvalidatePCMCacheAudioFile(albumUUID, segments[1])
+	default:
+		playbackPCMCache.invalidateSound(albumUUID, segments[1])
+	}
+}
+
+// soundCut 按 keyPlayback.soundSource 的规则解析 sounds.<uuid> 的音频路径与裁剪参数。
+func soundCut(get ConfigGetter, sound_UUID string, audioPkgUUID string) (*AudioFilePath, *Cut, bool) {
+	sha256, fileType, cut, err := audio.SoundCut(get, sound_UUID)
+	if err != nil {
+		return nil, nil, false
+	}
+	return albumAudioFile(get, filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID), sha256, fileType), cut, true
+}
+
+// WarmPCMCache 在后台为专辑中的全部 sounds 预先填充解码缓存。
+// 该函数会产生磁盘 IO 与解码, 只允许在热路径之外调用(ApplyPlaybackRouting / 编辑器加载)。
+func WarmPCMCache(get ConfigGetter, audioPkgUUID string) {
+	if get == nil || strings.TrimSpace(audioPkgUUID) == "" {
+		return
+	}
+	if isEnabled, _ := pcmCacheSettings(); !isEnabled {
+		return
+	}
+
+	soundsMap, ok := getValue(get, "sounds").(map[string]any)
+	if !ok {
+		return
+	}
+
+	for sound_UUID := range soundsMap {
+		audioFilePath, cut, ok := soundCut(get, sound_UUID, audioPkgUUID)
+		if !ok {
+			continue
+		}
+		key, ok := newPCMCacheKey(audioFilePath, cut)
+		if !ok {
+			continue
+		}
+		playbackPCMCache.rememberSound(audioPkgUUID, sound_UUID, key)
+		if playbackPCMCache.contains(key) {
+			continue
+		}
+		if _, _, release, err := preparePlaybackStreamer(audioFilePath, cut); err == nil {
+			release()
+		}
+	}
+}
+
+// WarmPCMCacheForEditor 为编辑器当前加载的专辑预填充解码缓存。
+func WarmPCMCacheForEditor() {
+	if audioPackageConfig.Viper == nil {
+		return
+	}
+	uuid, ok := getAudioPkgUUID(audioPackageConfig.GetValue, "")
+	if !ok {
+		return
+	}
+	WarmPCMCache(audioPackageConfig.GetValue, uuid)
+}
@@ -0,0 +1,184 @@
+package keySound
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/spf13/viper"
+)
+
+// newTestPCMBuffer 构造一个包含 frames 帧静音的缓存 Buffer。
+func newTestPCMBuffer(frames int) *beep.Buffer {
+	buffer := beep.NewBuffer(pcmBufferFormat)
+	buffer.Append(beep.Take(frames, beep.Silence(-1)))
+	return buffer
+}
+
+// useTestConfig 显式写入播放链读取的用户设置(避免读取默认值时触发的异步落盘), 测试结束后清除。
+func useTestConfig(t *testing.T, values map[string]any) {
+	t.Helper()
+	for key, value := range values {
+		viper.Set(key, value)
+	}
+	t.Cleanup(func() {
+		for key := range values {
+			viper.Set(key, nil)
+		}
+	})
+}
+
+// pcmCacheTestConfig 为 preparePlaybackStreamer 读取的缓存设置(见 useTestConfig)。
+var pcmCacheTestConfig = map[string]any{
+	"playback.pcm_cache.is_enabled":    true,
+	"playback.pcm_cache.max_memory_mb": 64.0,
+}
+
+// TestNewPCMCacheKeyParsesAlbumPath 验证缓存 key 能从专辑音频路径中解析出 专辑UUID + sha256 + type。
+func TestNewPCMCacheKeyParsesAlbumPath(t *testing.T) {
+	path := filepath.Join("root", "album-uuid", "audioFiles", "abc123.WAV")
+	key, ok := newPCMCacheKey(&AudioFilePath{Global: path}, &Cut{StartMS: 10, EndMS: 20, Volume: -1})
+	if !ok {
+		t.Fatal("expected cache key for album audio path")
+	}
+	want := pcmCacheKey{AlbumUUID: "album-uuid", Sha256: "abc123", FileType: ".wav", HasCut: true, StartMS: 10, EndMS: 20}
+	if key != want {
+		t.Fatalf("unexpected key: got %+v want %+v", key, want)
+	}
+
+	if _, ok := newPCMCacheKey(&AudioFilePath{}, nil); ok {
+		t.Fatal("expected no cache key for empty audio path")
+	}
+}
+
+// TestPCMCacheEvictsLeastRecentlyUsed 验证超出内存上限时淘汰最久未使用的条目, 且命中会刷新 LRU 顺序。
+func TestPCMCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newPCMCache()
+	entryBytes := int64(100 * pcmBufferFormat.Width())
+	maxBytes := entryBytes * 2
+
+	keyA := pcmCacheKey{AlbumUUID: "album", Sha256: "a"}
+	keyB := pcmCacheKey{AlbumUUID: "album", Sha256: "b"}
+	keyC := pcmCacheKey{AlbumUUID: "album", Sha256: "c"}
+
+	cache.put(keyA, newTestPCMBuffer(100), maxBytes)
+	cache.put(keyB, newTestPCMBuffer(100), maxBytes)
+	if _, ok := cache.get(keyA); !ok {
+		t.Fatal("expected hit for keyA")
+	}
+	cache.put(keyC, newTestPCMBuffer(100), maxBytes)
+
+	if _, ok := cache.get(keyB); ok {
+		t.Fatal("expected keyB to be evicted as least recently used")
+	}
+	if !cache.contains(keyA) || !cache.contains(keyC) {
+		t.Fatal("expected keyA and keyC to remain cached")
+	}
+
+	stats := cache.stats()
+	if stats.Entries != 2 || stats.Bytes != maxBytes {
+		t.Fatalf("unexpected size: entries=%d bytes=%d", stats.Entries, stats.Bytes)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Fatalf("unexpected counters: %+v", stats)
+	}
+}
+
+// TestPCMCacheInvalidation 验证按声音与按音频文件两种粒度的失效。
+func TestPCMCacheInvalidation(t *testing.T) {
+	cache := newPCMCache()
+	maxBytes := int64(1 << 20)
+
+	keyCut1 := pcmCacheKey{AlbumUUID: "album", Sha256: "a", HasCut: true, StartMS: 0, EndMS: 10}
+	keyCut2 := pcmCacheKey{AlbumUUID: "album", Sha256: "a", HasCut: true, StartMS: 10, EndMS: 20}
+	keyOther := pcmCacheKey{AlbumUUID: "other", Sha256: "a"}
+	for _, key := range []pcmCacheKey{keyCut1, keyCut2, keyOther} {
+		cache.put(key, newTestPCMBuffer(10), maxBytes)
+	}
+	cache.rememberSound("album", "sound-1", keyCut1)
+
+	cache.invalidateSound("album", "sound-1")
+	if cache.contains(keyCut1) {
+		t.Fatal("expected sound invalidation to drop its cut")
+	}
+	if !cache.contains(keyCut2) {
+		t.Fatal("expected other cuts of the same file to remain")
+	}
+
+	cache.invalidateWhere(func(key pcmCacheKey) bool {
+		return key.AlbumUUID == "album" && key.Sha256 == "a"
+	})
+	if cache.contains(keyCut2) {
+		t.Fatal("expected file invalidation to drop every cut of the file")
+	}
+	if !cache.contains(keyOther) {
+		t.Fatal("expected entries of other albums to remain")
+	}
+}
+
+// TestPreparePlaybackStreamerServesFromCache 验证首次播放解码后写入缓存, 再次播放直接命中缓存且样本一致。
+func TestPreparePlaybackStreamerServesFromCache(t *testing.T) {
+	useTestConfig(t, pcmCacheTestConfig)
+	// 使用空的缓存实例, 使命中统计不受其他测试影响
+	original := playbackPCMCache
+	playbackPCMCache = newPCMCache()
+	t.Cleanup(func() { playbackPCMCache = original })
+
+	audioFilePath := &AudioFilePath{SS: "test_down.MP3"}
+	cut := &Cut{StartMS: 0, EndMS: 50, Volume: -0.5}
+
+	first, initVolume, release, err := preparePlaybackStreamer(audioFilePath, cut)
+	if err != nil {
+		t.Fatalf("preparePlaybackStreamer returned error: %v", err)
+	}
+	if initVolume != cut.Volume {
+		t.Fatalf("unexpected init volume: got %v want %v", initVolume, cut.Volume)
+	}
+	firstValues := collectLeftChannel(first)
+	release()
+
+	second, _, release, err := preparePlaybackStreamer(audioFilePath, cut)
+	if err != nil {
+		t.Fatalf("preparePlaybackStreamer returned error on cached call: %v", err)
+	}
+	secondValues := collectLeftChannel(second)
+	release()
+
+	stats := playbackPCMCache.stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected cache stats: %+v", stats)
+	}
+	if len(firstValues) == 0 || len(firstValues) != len(secondValues) {
+		t.Fatalf("unexpected sample count: first=%d second=%d", len(firstValues), len(secondValues))
+	}
+	for index := range firstValues {
+		if firstValues[index] != secondValues[index] {
+			t.Fatalf("cached sample mismatch at %d", index)
+		}
+	}
+}
+
+// TestCloseFileStreamsOnlyClosesMatchingFile 验证替换某个音频文件前只关闭读取该文件的流, 其余播放不受影响。
+func TestCloseFileStreamsOnlyClosesMatchingFile(t *testing.T) {
+	target := &managedStream{StreamSeekCloser: newFakeStreamSeekCloser([]float64{1}), path: filepath.Join("album", "audioFiles", "abc.processed.wav")}
+	other := &managedStream{StreamSeekCloser: newFakeStreamSeekCloser([]float64{1}), path: filepath.Join("album", "audioFiles", "def.wav")}
+	cached := &managedStream{StreamSeekCloser: newFakeStreamSeekCloser([]float64{1})}
+	for _, stream := range []*managedStream{target, other, cached} {
+		activeStreams.Store(stream, struct{}{})
+	}
+	t.Cleanup(func() {
+		other.Close()
+		cached.Close()
+	})
+
+	CloseFileStreams(filepath.Join("album", "audioFiles", "abc.processed.wav"))
+	if _, ok := activeStreams.Load(target); ok {
+		t.Fatal("expected the stream reading the replaced file to be closed")
+	}
+	if _, ok := activeStreams.Load(other); !ok {
+		t.Fatal("expected streams of other files to keep playing")
+	}
+	if _, ok := activeStreams.Load(cached); !ok {
+		t.Fatal("expected cached streams to keep playing")
+	}
+}
@@ -0,0 +1,165 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 随机音高(音高/速度)说明
+// =============================
+//
+// 与随机音量相对应, 每次播放可在 [-max_cents_down, +max_cents_up] 音分内随机偏移音高(1200 音分 = 1 个八度)。
+// 偏移通过重采样比率 2^(cents/1200) 实现, 因此音高与速度同时变化(与改变磁带转速相同)。
+//
+// 偏移量来源(按优先级):
+//  1. 专辑 package.json 中的 random_pitch 节点(可选), 存在时完全取代用户设置:
+//     "random_pitch": { "max_cents_up": 30, "max_cents_down": 30 }   // 均为 0 表示该专辑不做随机音高
+//  2. 用户设置: 全局随机层(main_home.random_pitch_processing) + 按下/抬起随机层
+//     (main_home.press_release_random_pitch_processing.down/up), 两层的偏移相加。
+//
+// 重采样比率作用在已重采样到 formatGlobalSampleRate 的播放源之上, 从而不影响解码缓存中的片段。
+// 预览模式与音量处理一样不做随机音高。
+
+import (
+	"math"
+
+	"KeyTone/config"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// randomPitchMaxCents 为单侧偏移的上限(一个八度), 防止配置异常导致音频被极端拉伸。
+const randomPitchMaxCents = 1200.0
+
+// randomCents 以 random 返回 [-maxDown, maxUp] 内均匀分布的随机音分值。
+func randomCents(random func() float64, maxUp float64, maxDown float64) float64 {
+	maxUp = min(max(maxUp, 0), randomPitchMaxCents)
+	maxDown = min(max(maxDown, 0), randomPitchMaxCents)
+	if maxUp+maxDown <= 0 {
+		return 0
+	}
+	return random()*(maxUp+maxDown) - maxDown
+}
+
+// centsToRatio 将音分偏移换算为重采样比率(>1 为升高且加快)。
+func centsToRatio(cents float64) float64 {
+	return math.Pow(2, cents/1200)
+}
+
+// albumRandomPitchWith 读取专辑中的随机音高覆盖设置, 未配置时 ok 为 false。
+func albumRandomPitchWith(get ConfigGetter) (float64, float64, bool) {
+	if getValue(get, "random_pitch") == nil {
+		return 0, 0, false
+	}
+	maxUp, _ := getValue(get, "random_pitch.max_cents_up").(float64)
+	maxDown, _ := getValue(get, "random_pitch.max_cents_down").(float64)
+	return maxUp, maxDown, true
+}
+
+// userRandomPitchCents 按用户设置计算随机音高偏移(全局随机层 + 按下/抬起随机层)。
+func userRandomPitchCents(keyState string, random func() float64) float64 {
+	readLayer := func(enabledKey string, upKey string, downKey string, defaultEnabled bool, defaultUp float64, defaultDown float64) float64 {
+		isEnabled, ok := config.GetValue(enabledKey).(bool)
+		if !ok {
+			isEnabled = defaultEnabled
+			go config.SetValue(enabledKey, defaultEnabled)
+		}
+		if !isEnabled {
+			return 0
+		}
+
+		maxUp, ok := config.GetValue(upKey).(float64)
+		if !ok {
+			maxUp = defaultUp
+			go config.SetValue(upKey, defaultUp)
+		}
+		maxDown, ok := config.GetValue(downKey).(float64)
+		if !ok {
+			maxDown = defaultDown
+			go config.SetValue(downKey, defaultDown)
+		}
+		return randomCents(random, maxUp, maxDown)
+	}
+
+	cents := readLayer(
+		"main_home.random_pitch_processing.is_enabled",
+		"main_home.random_pitch_processing.max_cents_up",
+		"main_home.random_pitch_processing.max_cents_down",
+		config.Main_home___random_pitch_processing___is_enabled,
+		config.Main_home___random_pitch_processing___max_cents_up,
+		config.Main_home___random_pitch_processing___max_cents_down,
+	)
+
+	if keyState != KeyStateDown && keyState != KeyStateUp {
+		return cents
+	}
+
+	isEnabled, ok := config.GetValue("main_home.press_release_random_pitch_processing.is_enabled").(bool)
+	if !ok {
+		isEnabled = config.Main_home___press_release_random_pitch_processing___is_enabled
+		go config.SetValue("main_home.press_release_random_pitch_processing.is_enabled", isEnabled)
+	}
+	if !isEnabled {
+		return cents
+	}
+
+	if keyState == KeyStateDown {
+		cents += readLayer(
+			"main_home.press_release_random_pitch_processing.down.is_enabled",
+			"main_home.press_release_random_pitch_processing.down.max_cents_up",
+			"main_home.press_release_random_pitch_processing.down.max_cents_down",
+			config.Main_home___press_release_random_pitch_processing___down_is_enabled,
+			config.Main_home___press_release_random_pitch_processing___down_max_cents_up,
+			config.Main_home___press_release_random_pitch_processing___down_max_cents_down,
+		)
+	} else {
+		cents += readLayer(
+			"main_home.press_release_random_pitch_processing.up.is_enabled",
+			"main_home.press_release_random_pitch_processing.up.max_cents_up",
+			"main_home.press_release_random_pitch_processing.up.max_cents_down",
+			config.Main_home___press_release_random_pitch_processing___up_is_enabled,
+			config.Main_home___press_release_random_pitch_processing___up_max_cents_up,
+			config.Main_home___press_release_random_pitch_processing___up_max_cents_down,
+		)
+	}
+	return cents
+}
+
+// randomPitchCentsWith 以 random 计算本次播放的随机音高偏移; get 为当前播放来源的专辑配置(可为 nil)。
+func randomPitchCentsWith(get ConfigGetter, keyState string, random func() float64) float64 {
+	// hold 仍处于按下期间, 按 down 处理
+	if keyState == KeyStateHold {
+		keyState = KeyStateDown
+	}
+	if maxUp, maxDown, ok := albumRandomPitchWith(get); ok {
+		return randomCents(random, maxUp, maxDown)
+	}
+	return userRandomPitchCents(keyState, random)
+}
+
+// randomPitchProcessing 在需要时以随机重采样比率包装播放源; get 为本次按键事件的专辑配置(可为 nil)。
+func randomPitchProcessing(streamer beep.Streamer, get ConfigGetter, keyState string, random func() float64) beep.Streamer {
+	if streamer == nil {
+		return streamer
+	}
+	cents := randomPitchCentsWith(get, keyState, random)
+	if cents == 0 {
+		return streamer
+	}
+	return beep.ResampleRatio(4, centsToRatio(cents), streamer)
+}
@@ -0,0 +1,110 @@
+package keySound
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/spf13/viper"
+)
+
+func TestCentsToRatio(t *testing.T) {
+	for cents, want := range map[float64]float64{0: 1, 1200: 2, -1200: 0.5, 100: math.Pow(2, 1.0/12)} {
+		if got := centsToRatio(cents); math.Abs(got-want) > 1e-12 {
+			t.Fatalf("centsToRatio(%v): got %v want %v", cents, got, want)
+		}
+	}
+}
+
+func TestRandomCentsStaysInRange(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		if cents := randomCents(liveRandomFloat64, 30, 10); cents < -10 || cents > 30 {
+			t.Fatalf("cents out of range: %v", cents)
+		}
+		if cents := randomCents(liveRandomFloat64, 5000, -20); cents < 0 || cents > randomPitchMaxCents {
+			t.Fatalf("cents should be clamped to [0, %v]: %v", randomPitchMaxCents, cents)
+		}
+	}
+	if cents := randomCents(liveRandomFloat64, 0, 0); cents != 0 {
+		t.Fatalf("expected no offset, got %v", cents)
+	}
+}
+
+// TestRandomPitchCentsWithUserSettings 验证全局层与按下/抬起层的偏移相加, 且总开关关闭时不偏移。
+func TestRandomPitchCentsWithUserSettings(t *testing.T) {
+	useTestConfig(t, map[string]any{
+		"main_home.random_pitch_processing.is_enabled":                        true,
+		"main_home.random_pitch_processing.max_cents_up":                      10.0,
+		"main_home.random_pitch_processing.max_cents_down":                    0.0,
+		"main_home.press_release_random_pitch_processing.is_enabled":          true,
+		"main_home.press_release_random_pitch_processing.down.is_enabled":     true,
+		"main_home.press_release_random_pitch_processing.down.max_cents_up":   0.0,
+		"main_home.press_release_random_pitch_processing.down.max_cents_down": 100.0,
+		"main_home.press_release_random_pitch_processing.up.is_enabled":       false,
+		"main_home.press_release_random_pitch_processing.up.max_cents_up":     50.0,
+		"main_home.press_release_random_pitch_processing.up.max_cents_down":   50.0,
+	})
+
+	for i := 0; i < 100; i++ {
+		// down: 全局 [0, 10] + 按下 [-100, 0]
+		if cents := randomPitchCentsWith(nil, KeyStateDown, liveRandomFloat64); cents < -100 || cents > 10 {
+			t.Fatalf("down cents out of range: %v", cents)
+		}
+		// hold 按 down 处理
+		if cents := randomPitchCentsWith(nil, KeyStateHold, liveRandomFloat64); cents < -100 || cents > 10 {
+			t.Fatalf("hold cents out of range: %v", cents)
+		}
+		// up 层未开启, 仅全局层生效
+		if cents := randomPitchCentsWith(nil, KeyStateUp, liveRandomFloat64); cents < 0 || cents > 10 {
+			t.Fatalf("up cents out of range: %v", cents)
+		}
+	}
+
+	viper.Set("main_home.random_pitch_processing.is_enabled", false)
+	viper.Set("main_home.press_release_random_pitch_processing.is_enabled", false)
+	if cents := randomPitchCentsWith(nil, KeyStateDown, liveRandomFloat64); cents != 0 {
+		t.Fatalf("expected no offset when disabled, got %v", cents)
+	}
+}
+
+// TestRandomPitchCentsWithAlbumOverride 验证专辑中的 random_pitch 节点取代用户设置。
+func TestRandomPitchCentsWithAlbumOverride(t *testing.T) {
+	useTestConfig(t, map[string]any{
+		"main_home.random_pitch_processing.is_enabled":               true,
+		"main_home.random_pitch_processing.max_cents_up":             1000.0,
+		"main_home.random_pitch_processing.max_cents_down":           1000.0,
+		"main_home.press_release_random_pitch_processing.is_enabled": false,
+	})
+
+	album := map[string]any{
+		"random_pitch":                map[string]any{},
+		"random_pitch.max_cents_up":   5.0,
+		"random_pitch.max_cents_down": 5.0,
+	}
+	get := func(key string) any { return album[key] }
+	for i := 0; i < 100; i++ {
+		if cents := randomPitchCentsWith(get, KeyStateDown, liveRandomFloat64); cents < -5 || cents > 5 {
+			t.Fatalf("album override cents out of range: %v", cents)
+		}
+	}
+
+	// 专辑显式设置为 0 时, 即使用户开启了随机音高也不偏移
+	album["random_pitch.max_cents_up"] = 0.0
+	album["random_pitch.max_cents_down"] = 0.0
+	if cents := randomPitchCentsWith(get, KeyStateDown, liveRandomFloat64); cents != 0 {
+		t.Fatalf("expected album to disable random pitch, got %v", cents)
+	}
+}
+
+// TestRandomPitchProcessingBypassesWhenDisabled 验证未开启随机音高时播放源保持不变(不额外引入重采样)。
+func TestRandomPitchProcessingBypassesWhenDisabled(t *testing.T) {
+	useTestConfig(t, map[string]any{
+		"main_home.random_pitch_processing.is_enabled":               false,
+		"main_home.press_release_random_pitch_processing.is_enabled": false,
+	})
+
+	source := beep.Take(10, beep.Silence(-1))
+	if got := randomPitchProcessing(source, nil, KeyStateDown, liveRandomFloat64); got != beep.Streamer(source) {
+		t.Fatal("expected streamer to be returned unchanged")
+	}
+}
@@ -43,6 +43,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	audioPackageConfig "KeyTone/audioPackage/config"
 	"KeyTone/audioPackage/enc"
@@ -63,6 +64,8 @@ type AlbumSnapshot struct {
 	AlbumPath string
 	AlbumUUID string
 	Viper     *viper.Viper
+	// selection 为该快照独立的至臻键音随机选择状态(见 selection.go), 随快照重新加载而重置。
+	selection *keySoundSelection
 }
 
 func (s *AlbumSnapshot) GetValue(key string) any {
@@ -197,6 +200,9 @@ func ApplyPlaybackRouting(mode, unifiedPath, keyboardPath, mousePath string) (Ap
 		}
 		playbackStateLock.Unlock()
 
+		// 快照就绪后在后台预填充解码缓存, 避免首次按键时才解码。
+		warmSnapshotPCMCache(snapshot)
+
 		return result, nil
 	}
 
@@ -223,6 +229,11 @@ func ApplyPlaybackRouting(mode, unifiedPath, keyboardPath, mousePath string) (Ap
 	}
 	playbackStateLock.Unlock()
 
+	warmSnapshotPCMCache(keyboardSnapshot)
+	if mouseSnapshot != keyboardSnapshot {
+		warmSnapshotPCMCache(mouseSnapshot)
+	}
+
 	// 两侧都失败才认为整体不可用；否则允许 partial（前端可根据 result 诊断）。
 	if keyboardErr != nil && mouseErr != nil {
 		return result, fmt.Errorf("keyboard and mouse snapshots failed")
@@ -231,6 +242,14 @@ func ApplyPlaybackRouting(mode, unifiedPath, keyboardPath, mousePath string) (Ap
 	return result, nil
 }
 
+// warmSnapshotPCMCache 在后台为快照对应的专辑预填充解码缓存。
+func warmSnapshotPCMCache(snapshot *AlbumSnapshot) {
+	if snapshot == nil || snapshot.Viper == nil {
+		return
+	}
+	go WarmPCMCache(snapshot.GetValue, snapshot.AudioPkgUUID())
+}
+
 func buildStatus(requestedPath, resolvedPath string, snapshot *AlbumSnapshot, err error) RoutingSourceStatus {
 	status := RoutingSourceStatus{
 		RequestedPath: strings.TrimSpace(requestedPath),
@@ -327,5 +346,6 @@ func loadAlbumSnapshot(albumPath string) (*AlbumSnapshot, error) {
 		AlbumPath: albumPath,
 		AlbumUUID: albumUUID,
 		Viper:     v,
+		selection: newKeySoundSelection(time.Now().UnixNano()),
 	}, nil
 }
@@ -0,0 +1,50 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 离线渲染说明
+// =============================
+//
+// 离线渲染将专辑中的声音渲染为 formatGlobalSampleRate 下的双声道样本, 而不经过输出后端。
+// 渲染流程(解码 -> 裁剪与淡入/淡出 -> 重采样 -> 声音自身的音量与响度增益)由 KeyTone/keySound/audio 实现, 与 ktalbum-tools 共用,
+// 与试听(预览模式)完全一致, 不叠加任何全局/路由/随机音量。
+
+import "KeyTone/keySound/audio"
+
+// RenderSampleRate 返回离线渲染结果的采样率。
+func RenderSampleRate() int {
+	return int(formatGlobalSampleRate)
+}
+
+// LoadAlbumSnapshot 将专辑配置加载为只读快照(支持加密专辑), 供播放路由之外的只读场景使用。
+func LoadAlbumSnapshot(albumPath string) (*AlbumSnapshot, error) {
+	return loadAlbumSnapshot(albumPath)
+}
+
+// RenderSound 渲染专辑中的一个声音(sounds.<soundID>), 裁剪、包络与音量均已应用。
+func RenderSound(get ConfigGetter, albumPath string, soundID string) ([][2]float64, error) {
+	return audio.RenderSound(get, albumPath, soundID, formatGlobalSampleRate, maxCapturedDuration)
+}
+
+// RenderAudioFile 渲染专辑中的一个完整音频文件(audioFiles/<sha256><type>, 存在处理后的文件时使用处理后的文件)。
+func RenderAudioFile(get ConfigGetter, albumPath string, sha256 string, fileType string) ([][2]float64, error) {
+	return audio.RenderAudioFile(get, albumPath, sha256, fileType, formatGlobalSampleRate, maxCapturedDuration)
+}
@@ -0,0 +1,333 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 按键离线渲染说明
+// =============================
+//
+// RenderKeyState / RenderKeyEvents 不需要声卡与真实按键, 即可把一次按键或一段按键脚本渲染为混音样本:
+//   - 解析流程与实时播放完全相同(组合键 -> 单键 -> 分组 -> 全局 -> 内嵌测试音),
+//     至臻键音的随机/循环/layer、速度档位、修饰键变体、hold 均生效;
+//   - 音量处理链同样完整(声音音量 -> hold 音量 -> 全局/按下抬起/随机音量 -> 随机音高 -> 声像), 使用当前的用户设置。
+//
+// 每次渲染使用独立的播放环境(renderSession), 随播放上下文(keyPlayback)沿解析与播放链传递, 不修改任何包级状态:
+// 播放来源固定为被渲染的专辑, 输出为内存混音(MemoryOutput), 时钟为脚本时间, 随机数全部取自按 Seed 初始化的随机源。
+// 因此相同的专辑、脚本、用户设置与 Seed 总能得到相同的结果, 可用于专辑行为的回归测试;
+// 渲染期间的实时按键与编辑器试听照常播放到当前输出后端, 既不会混入渲染结果, 也不受渲染影响。
+//
+// 与实时播放的唯一差异: 每次触发都会被完整渲染后再处理下一个事件, 因此复音上限与淘汰(voice.go)不会生效。
+//
+// 按键脚本沿用输入事件录制(keyEvent/recording.go)的 JSONL 格式, 录制文件可以直接作为脚本渲染:
+//   {"t_ms":0,"device":"keyboard","keycode":30,"state":"down"}
+//   {"t_ms":86,"device":"keyboard","keycode":30,"state":"up"}
+//   {"t_ms":300,"device":"mouse","button":1,"state":"down"}
+// 事件的分发规则与 keyEvent 一致: 按住期间重复的 down 视为系统自动重复(仅在专辑配置了 hold 时播放 hold);
+// 配置了 hold.interval_ms 时按间隔合成 hold, 直到抬起(或脚本的最后一个事件); 修饰键按脚本中的按下/抬起跟踪。
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRenderScriptDuration 为按键脚本的最长时长, 防止异常脚本占用过多内存。
+const maxRenderScriptDuration = 2 * time.Minute
+
+// RenderEvent 为按键脚本中的一个事件, 字段与输入事件录制文件的每一行一致(kind 字段被忽略)。
+type RenderEvent struct {
+	// TimeMS 为相对脚本开始时刻的毫秒数
+	TimeMS float64 `json:"t_ms"`
+	// Device 为 keyboard 或 mouse, 为空时按 keycode/button 推断
+	Device  string `json:"device"`
+	Keycode uint16 `json:"keycode,omitempty"`
+	Button  uint16 `json:"button,omitempty"`
+	// State 为 down 或 up
+	State string `json:"state"`
+}
+
+// RenderOptions 为离线渲染的参数。
+type RenderOptions struct {
+	// Seed 决定随机选择、随机音量与随机音高, 相同的 Seed 得到相同的渲染结果
+	Seed int64 `json:"seed"`
+}
+
+// target 校验事件并返回播放使用的 keycode(鼠标按键编码为负数)。
+func (e RenderEvent) target() (string, bool, error) {
+	if math.IsNaN(e.TimeMS) || e.TimeMS < 0 {
+		return "", false, fmt.Errorf("invalid event time: %v", e.TimeMS)
+	}
+	if e.State != KeyStateDown && e.State != KeyStateUp {
+		return "", false, fmt.Errorf("unsupported event state: %q", e.State)
+	}
+
+	device := strings.ToLower(strings.TrimSpace(e.Device))
+	if device == "" {
+		if e.Button != 0 && e.Keycode == 0 {
+			device = "mouse"
+		} else {
+			device = "keyboard"
+		}
+	}
+	switch device {
+	case "keyboard":
+		if e.Keycode == 0 {
+			return "", false, errors.New("keyboard event requires a non-zero keycode")
+		}
+		return fmt.Sprint(e.Keycode), true, nil
+	case "mouse":
+		if e.Button == 0 {
+			return "", false, errors.New("mouse event requires a non-zero button")
+		}
+		return "-" + fmt.Sprint(e.Button), false, nil
+	default:
+		return "", false, fmt.Errorf("unsupported input device: %q", e.Device)
+	}
+}
+
+// ReadRenderEvents 解析 JSONL 格式的按键脚本; 空行会被忽略。
+func ReadRenderEvents(reader io.Reader) ([]RenderEvent, error) {
+	events := make([]RenderEvent, 0)
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Bytes()
+		if len(strings.TrimSpace(string(text))) == 0 {
+			continue
+		}
+		var event RenderEvent
+		if err := json.Unmarshal(text, &event); err != nil {
+			return nil, fmt.Errorf("invalid script line %d: %w", line, err)
+		}
+		if _, _, err := event.target(); err != nil {
+			return nil, fmt.Errorf("invalid script line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// RenderKeyState 渲染一次按键(keycode 与实时播放一致, 鼠标按键为负数; keyState 为 down/up/hold)。
+func RenderKeyState(albumPath string, keycode string, keyState string, modifiers Modifiers, options RenderOptions) ([][2]float64, error) {
+	if code, err := strconv.Atoi(strings.TrimSpace(keycode)); err != nil || code == 0 {
+		return nil, fmt.Errorf("invalid keycode: %q", keycode)
+	}
+	if keyState != KeyStateDown && keyState != KeyStateUp && keyState != KeyStateHold {
+		return nil, fmt.Errorf("unsupported key state: %q", keyState)
+	}
+	snapshot, err := loadAlbumSnapshot(albumPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keycode = strings.TrimSpace(keycode)
+	action := renderAction{
+		keycode:   keycode,
+		keyState:  keyState,
+		modifiers: modifiers,
+		isTyping:  keyState == KeyStateDown && !strings.HasPrefix(keycode, "-"),
+	}
+	return renderActions(snapshot, []renderAction{action}, options), nil
+}
+
+// RenderKeyEvents 按时间渲染一段按键脚本, 结果长度至少覆盖到脚本的最后一个事件。
+func RenderKeyEvents(albumPath string, events []RenderEvent, options RenderOptions) ([][2]float64, error) {
+	if len(events) == 0 {
+		return nil, errors.New("render script has no events")
+	}
+	snapshot, err := loadAlbumSnapshot(albumPath)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := planRenderActions(snapshot.GetValue, events)
+	if err != nil {
+		return nil, err
+	}
+	return renderActions(snapshot, actions, options), nil
+}
+
+// renderAction 为按 keyEvent 的分发规则展开后的一次播放。
+type renderAction struct {
+	atMS      float64
+	keycode   string
+	keyState  string
+	modifiers Modifiers
+	// isTyping 为 true 时先记录打字速度(仅键盘的首次按下)
+	isTyping bool
+}
+
+// planRenderActions 按 keyEvent 的分发规则, 将脚本事件展开为播放序列(含自动重复与合成的 hold)。
+func planRenderActions(get ConfigGetter, events []RenderEvent) ([]renderAction, error) {
+	sorted := make([]RenderEvent, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].TimeMS < sorted[j].TimeMS })
+	for index, event := range sorted {
+		if _, _, err := event.target(); err != nil {
+			return nil, fmt.Errorf("invalid event %d: %w", index, err)
+		}
+	}
+	if last := sorted[len(sorted)-1].TimeMS; last > float64(maxRenderScriptDuration/time.Millisecond) {
+		return nil, fmt.Errorf("render script is longer than %v", maxRenderScriptDuration)
+	}
+
+	actions := make([]renderAction, 0, len(sorted))
+	pressed := map[string]bool{}
+	heldModifiers := map[string]bool{}
+	// nextHold 为正在合成 hold 的按键及其下一次 hold 的时刻
+	nextHold := map[string]float64{}
+	holdIntervals := map[string]float64{}
+
+	// modifiersFor 与 keyEvent 的 modifierTracker.snapshot 一致: 不计入按键自身
+	modifiersFor := func(keycode string) Modifiers {
+		var modifiers Modifiers
+		for held := range heldModifiers {
+			if held != keycode {
+				modifiers |= ModifierForKeycode(held)
+			}
+		}
+		return modifiers
+	}
+	// emitHoldsBefore 按时间顺序展开 before 之前到期的合成 hold
+	emitHoldsBefore := func(before float64) {
+		for {
+			keycode, due := "", before
+			for key, at := range nextHold {
+				if at < due || (at == due && keycode != "" && key < keycode) {
+					keycode, due = key, at
+				}
+			}
+			if keycode == "" {
+				return
+			}
+			actions = append(actions, renderAction{atMS: due, keycode: keycode, keyState: KeyStateHold, modifiers: modifiersFor(keycode)})
+			nextHold[keycode] = due + holdIntervals[keycode]
+		}
+	}
+
+	for _, event := range sorted {
+		keycode, isKeyboard, _ := event.target()
+		emitHoldsBefore(event.TimeMS)
+
+		if !isKeyboard {
+			actions = append(actions, renderAction{atMS: event.TimeMS, keycode: keycode, keyState: event.State, modifiers: modifiersFor(keycode)})
+			continue
+		}
+
+		// 修饰键状态在分发之前更新(与 KeyEventListenWith 一致)
+		if ModifierForKeycode(keycode) != 0 {
+			if event.State == KeyStateDown {
+				heldModifiers[keycode] = true
+			} else {
+				delete(heldModifiers, keycode)
+			}
+		}
+
+		if event.State == KeyStateUp {
+			actions = append(actions, renderAction{atMS: event.TimeMS, keycode: keycode, keyState: KeyStateUp, modifiers: modifiersFor(keycode)})
+			delete(pressed, keycode)
+			delete(nextHold, keycode)
+			continue
+		}
+
+		hold, hasHold := holdConfigWith(get, keycode)
+		if !pressed[keycode] {
+			pressed[keycode] = true
+			actions = append(actions, renderAction{atMS: event.TimeMS, keycode: keycode, keyState: KeyStateDown, modifiers: modifiersFor(keycode), isTyping: true})
+			if hasHold && hold.Interval > 0 {
+				holdIntervals[keycode] = float64(hold.Interval) / float64(time.Millisecond)
+				nextHold[keycode] = event.TimeMS + holdIntervals[keycode]
+			}
+		} else if _, isSynthesizing := nextHold[keycode]; !isSynthesizing && hasHold {
+			// 按住期间的系统自动重复
+			actions = append(actions, renderAction{atMS: event.TimeMS, keycode: keycode, keyState: KeyStateHold, modifiers: modifiersFor(keycode)})
+		}
+	}
+	return actions, nil
+}
+
+//region 渲染期间的播放环境
+
+// renderSession 为一次离线渲染的播放环境, 由 renderActions 创建并通过 keyPlayback.render 传给播放链。
+type renderSession struct {
+	albumPath   string
+	output      *MemoryOutput
+	voices      *voiceManager
+	typingSpeed *typingSpeedTracker
+	random      *rand.Rand
+	// now 为当前事件的脚本时刻, 仅在渲染 goroutine 内读写
+	now time.Time
+}
+
+// renderMutex 保证同一时刻只进行一次渲染, 限制渲染占用的 CPU 与内存。
+var renderMutex sync.Mutex
+
+// renderActions 在独立的播放环境中依次执行播放序列, 返回混音结果。
+func renderActions(snapshot *AlbumSnapshot, actions []renderAction, options RenderOptions) [][2]float64 {
+	renderMutex.Lock()
+	defer renderMutex.Unlock()
+
+	epoch := time.Unix(0, 0)
+	session := &renderSession{
+		albumPath:   snapshot.AlbumPath,
+		output:      NewMemoryOutput(),
+		voices:      &voiceManager{},
+		typingSpeed: &typingSpeedTracker{current: TypingSpeed{IntervalMS: -1}},
+		random:      rand.New(rand.NewSource(options.Seed)),
+		now:         epoch,
+	}
+	session.output.start = epoch
+	session.output.now = func() time.Time { return session.now }
+	get, audioPkgUUID, selection := snapshot.GetValue, snapshot.AudioPkgUUID(), newKeySoundSelection(options.Seed)
+
+	for _, action := range actions {
+		session.now = epoch.Add(time.Duration(action.atMS * float64(time.Millisecond)))
+		// 与 keyEvent 一致: 键盘按下先更新打字速度, 并以算出的速度档位选择本次按下的音效
+		speed := session.typingSpeed.snapshot()
+		if action.isTyping {
+			speed = session.typingSpeed.recordDown(session.now)
+			speed = session.typingSpeed.setTier(typingTierFor(get, action.keycode, KeyStateDown, action.modifiers, speed))
+		}
+		p := &keyPlayback{get: get, audioPkgUUID: audioPkgUUID, selection: selection, keycode: action.keycode, keyState: action.keyState, render: session}
+		p.handle(action.modifiers, speed)
+	}
+
+	mixed := session.output.Mixdown()
+	if len(actions) > 0 {
+		if end := formatGlobalSampleRate.N(session.now.Sub(epoch)); end > len(mixed) {
+			mixed = append(mixed, make([][2]float64, end-len(mixed))...)
+		}
+	}
+	return mixed
+}
+
+//endregion 渲染期间的播放环境
@@ -0,0 +1,244 @@
+package keySound
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useRenderTestConfig 显式写入播放链读取的用户设置(避免读取默认值时触发的异步落盘), 测试结束后清除。
+func useRenderTestConfig(t *testing.T) {
+	t.Helper()
+	useTestConfig(t, pcmCacheTestConfig)
+	useTestConfig(t, voiceTestConfig)
+	useLayerTestLogger(t)
+	useTestConfig(t, map[string]any{
+		"playback.spatial_pan.is_enabled":                             false,
+		"playback.spatial_pan.layout":                                 "",
+		"playback.spatial_pan.width":                                  0.0,
+		"playback.spatial_pan.mouse_pan":                              map[string]any{},
+		"audio_volume_processing.volume_amplify":                      0.0,
+		"main_home.audio_volume_processing.volume_normal":             0.0,
+		"main_home.audio_volume_processing.volume_silent":             false,
+		"main_home.press_release_audio_volume_processing.is_enabled":  false,
+		"main_home.random_volume_processing.is_enabled":               false,
+		"main_home.press_release_random_volume_processing.is_enabled": false,
+		"main_home.random_pitch_processing.is_enabled":                false,
+		"main_home.press_release_random_pitch_processing.is_enabled":  false,
+		"playback.routing.mouse_fallback_to_keyboard":                 false,
+	})
+}
+
+// writeRenderAlbum 写出一个专辑: 按下与 hold(每 50ms 合成一次)播放 10ms 的单一声音, 抬起不发声。
+func writeRenderAlbum(t *testing.T, randomPitch bool) string {
+	t.Helper()
+	albumPath := filepath.Join(t.TempDir(), "render-album")
+	click := make([][2]float64, 441)
+	for i := range click {
+		click[i] = [2]float64{0.5, 0.5}
+	}
+	writeAnalysisWAV(t, filepath.Join(albumPath, "audioFiles", "abc.wav"), click)
+
+	album := map[string]any{
+		"audio_files": map[string]any{"abc": map[string]any{"type": ".wav", "name": map[string]any{"n1": "click"}}},
+		"sounds": map[string]any{"s1": map[string]any{
+			"source_file_for_sound": map[string]any{"sha256": "abc", "name_id": "n1", "type": ".wav"},
+			"cut":                   map[string]any{"start_time": 0, "end_time": 10, "volume": 0},
+		}},
+		"key_tone": map[string]any{
+			"global": map[string]any{
+				"down": map[string]any{"type": "sounds", "value": "s1"},
+				"hold": map[string]any{"type": "sounds", "value": "s1", "interval_ms": 50},
+			},
+			"is_enable_embedded_test_sound": map[string]any{"down": false, "up": false},
+		},
+	}
+	if randomPitch {
+		album["random_pitch"] = map[string]any{"max_cents_up": 200, "max_cents_down": 200}
+	}
+	data, err := json.Marshal(album)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(albumPath, "package.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return albumPath
+}
+
+// clickOnsets 返回渲染结果中每段声音的起点(样本下标)。
+func clickOnsets(samples [][2]float64) []int {
+	var onsets []int
+	for i, sample := range samples {
+		if math.Abs(sample[0]) > 0.1 && (i == 0 || math.Abs(samples[i-1][0]) <= 0.1) {
+			onsets = append(onsets, i)
+		}
+	}
+	return onsets
+}
+
+// TestPlanRenderActions 验证脚本按 keyEvent 的规则展开: 合成 hold、系统自动重复、修饰键与鼠标按键。
+func TestPlanRenderActions(t *testing.T) {
+	withInterval := ConfigGetter(func(key string) any {
+		return map[string]any{
+			"key_tone.global.hold":             map[string]any{},
+			"key_tone.global.hold.interval_ms": 50.0,
+		}[key]
+	})
+	events := []RenderEvent{
+		{TimeMS: 120, Device: "keyboard", Keycode: 30, State: "up"},
+		{TimeMS: 0, Device: "keyboard", Keycode: 29, State: "down"},
+		{TimeMS: 0, Device: "keyboard", Keycode: 30, State: "down"},
+		// 合成 hold 期间系统的自动重复被忽略
+		{TimeMS: 40, Keycode: 30, State: "down"},
+		{TimeMS: 130, Button: 1, State: "down"},
+	}
+	actions, err := planRenderActions(withInterval, events)
+	if err != nil {
+		t.Fatalf("planRenderActions returned error: %v", err)
+	}
+	want := []renderAction{
+		{atMS: 0, keycode: "29", keyState: KeyStateDown, isTyping: true},
+		{atMS: 0, keycode: "30", keyState: KeyStateDown, modifiers: ModifierCtrl, isTyping: true},
+		{atMS: 50, keycode: "29", keyState: KeyStateHold},
+		{atMS: 50, keycode: "30", keyState: KeyStateHold, modifiers: ModifierCtrl},
+		{atMS: 100, keycode: "29", keyState: KeyStateHold},
+		{atMS: 100, keycode: "30", keyState: KeyStateHold, modifiers: ModifierCtrl},
+		{atMS: 120, keycode: "30", keyState: KeyStateUp, modifiers: ModifierCtrl},
+		{atMS: 130, keycode: "-1", keyState: KeyStateDown, modifiers: ModifierCtrl},
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("unexpected actions:\n got %+v\nwant %+v", actions, want)
+	}
+
+	// 未配置合成间隔时, 按住期间重复的 down 播放 hold; 未配置 hold 时被忽略
+	withoutInterval := ConfigGetter(func(key string) any {
+		if key == "key_tone.global.hold" {
+			return map[string]any{}
+		}
+		return nil
+	})
+	repeat := []RenderEvent{{TimeMS: 0, Keycode: 30, State: "down"}, {TimeMS: 500, Keycode: 30, State: "down"}}
+	if actions, _ := planRenderActions(withoutInterval, repeat); len(actions) != 2 || actions[1].keyState != KeyStateHold {
+		t.Fatalf("expected the auto-repeat to play hold: %+v", actions)
+	}
+	if actions, _ := planRenderActions(nil, repeat); len(actions) != 1 {
+		t.Fatalf("expected the auto-repeat to be ignored without hold: %+v", actions)
+	}
+
+	for _, invalid := range [][]RenderEvent{
+		{{Keycode: 30, State: "hold"}},
+		{{Device: "keyboard", State: "down"}},
+		{{TimeMS: -1, Keycode: 30, State: "down"}},
+		{{TimeMS: 3 * 60 * 1000, Keycode: 30, State: "down"}},
+	} {
+		if _, err := planRenderActions(nil, invalid); err == nil {
+			t.Fatalf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+// TestReadRenderEvents 验证输入事件录制文件可直接作为脚本读取。
+func TestReadRenderEvents(t *testing.T) {
+	recording := `{"t_ms":0,"device":"keyboard","keycode":30,"state":"down","kind":4}
+
+{"t_ms":86,"device":"keyboard","keycode":30,"state":"up","kind":5}
+{"t_ms":90,"device":"mouse","button":2,"state":"down","kind":7}
+`
+	events, err := ReadRenderEvents(strings.NewReader(recording))
+	if err != nil {
+		t.Fatalf("ReadRenderEvents returned error: %v", err)
+	}
+	if len(events) != 3 || events[1].TimeMS != 86 || events[2].Button != 2 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if _, err := ReadRenderEvents(strings.NewReader(`{"t_ms":0,"device":"pen","state":"down"}`)); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected an invalid line error, got %v", err)
+	}
+}
+
+// TestRenderKeyEvents 端到端验证: 脚本经完整的解析与播放链渲染, 每次触发落在脚本时刻, 且结果由 Seed 决定。
+func TestRenderKeyEvents(t *testing.T) {
+	useRenderTestConfig(t)
+	albumPath := writeRenderAlbum(t, false)
+
+	events := []RenderEvent{
+		{TimeMS: 0, Keycode: 30, State: "down"},
+		{TimeMS: 120, Keycode: 30, State: "up"},
+		{TimeMS: 200, Button: 1, State: "down"},
+		{TimeMS: 400, Button: 1, State: "up"},
+	}
+	samples, err := RenderKeyEvents(albumPath, events, RenderOptions{})
+	if err != nil {
+		t.Fatalf("RenderKeyEvents returned error: %v", err)
+	}
+	// 按下(0ms)、合成 hold(50ms, 100ms)、鼠标按下(200ms); 抬起未配置且关闭了内嵌测试音
+	if onsets := clickOnsets(samples); !reflect.DeepEqual(onsets, []int{0, 2205, 4410, 8820}) {
+		t.Fatalf("unexpected onsets: %v", onsets)
+	}
+	if len(samples) != 17640 {
+		t.Fatalf("expected the render to cover the script, got %d samples", len(samples))
+	}
+
+	// 随机音高由 Seed 决定
+	albumPath = writeRenderAlbum(t, true)
+	first, err := RenderKeyState(albumPath, "30", KeyStateDown, 0, RenderOptions{Seed: 1})
+	if err != nil {
+		t.Fatalf("RenderKeyState returned error: %v", err)
+	}
+	again, _ := RenderKeyState(albumPath, "30", KeyStateDown, 0, RenderOptions{Seed: 1})
+	other, _ := RenderKeyState(albumPath, "30", KeyStateDown, 0, RenderOptions{Seed: 2})
+	if len(first) == 0 || !reflect.DeepEqual(first, again) {
+		t.Fatal("expected the same seed to render identical samples")
+	}
+	if reflect.DeepEqual(first, other) {
+		t.Fatal("expected a different seed to change the random pitch")
+	}
+
+	if _, err := RenderKeyState(albumPath, "0", KeyStateDown, 0, RenderOptions{}); err == nil {
+		t.Fatal("expected an invalid keycode to be rejected")
+	}
+	if _, err := RenderKeyEvents(filepath.Join(t.TempDir(), "missing"), events, RenderOptions{}); err == nil {
+		t.Fatal("expected a missing album to be rejected")
+	}
+}
+
+// TestLivePlaybackDuringRender 验证渲染与实时播放互不影响: 渲染期间的试听照常输出到当前后端且不会混入渲染结果,
+// 渲染结果也与单独渲染时相同。
+func TestLivePlaybackDuringRender(t *testing.T) {
+	useRenderTestConfig(t)
+	albumPath := writeRenderAlbum(t, false)
+	live := NewMemoryOutput()
+	useTestAudioOutput(t, live)
+
+	events := []RenderEvent{
+		{TimeMS: 0, Keycode: 30, State: "down"},
+		{TimeMS: 120, Keycode: 30, State: "up"},
+	}
+	want, err := RenderKeyEvents(albumPath, events, RenderOptions{})
+	if err != nil {
+		t.Fatalf("RenderKeyEvents returned error: %v", err)
+	}
+
+	const previews = 20
+	rendered := make(chan [][2]float64)
+	go func() {
+		samples, _ := RenderKeyEvents(albumPath, events, RenderOptions{})
+		rendered <- samples
+	}()
+	for i := 0; i < previews; i++ {
+		PlayKeySound(&AudioFilePath{SS: "test_down.MP3"}, &Cut{StartMS: 0, EndMS: 10}, "30", KeyStateDown, true)
+	}
+	got := <-rendered
+
+	if captures := live.Captures(); len(captures) != previews {
+		t.Fatalf("expected every preview to reach the live output, got %d captures", len(captures))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatal("expected live playback not to change the render result")
+	}
+}
@@ -0,0 +1,44 @@
+package keySound
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"KeyTone/keySound/audio"
+)
+
+// TestRenderMatchesPlaybackSource 验证离线渲染(audio.Render)的样本数与播放源一致, 且空裁剪渲染为空。
+func TestRenderMatchesPlaybackSource(t *testing.T) {
+	useTestConfig(t, pcmCacheTestConfig)
+
+	data, err := sounds.ReadFile("sounds/test_down.MP3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "test_down.mp3")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cut := &Cut{StartMS: 0, EndMS: 50, Volume: 0}
+
+	streamer, _, release, err := preparePlaybackStreamer(&AudioFilePath{Global: path}, cut)
+	if err != nil {
+		t.Fatalf("preparePlaybackStreamer returned error: %v", err)
+	}
+	want := len(collectLeftChannel(streamer))
+	release()
+
+	samples, err := audio.Render(path, cut, 0, formatGlobalSampleRate, maxCapturedDuration)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+	if want == 0 || len(samples) != want {
+		t.Fatalf("unexpected rendered length: got %d want %d", len(samples), want)
+	}
+
+	empty, err := audio.Render(path, &Cut{StartMS: 50, EndMS: 50}, 0, formatGlobalSampleRate, maxCapturedDuration)
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("expected empty render for empty cut, got %d samples err=%v", len(empty), err)
+	}
+}
@@ -0,0 +1,182 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 至臻键音(key_sounds)的随机选择说明
+// =============================
+//
+// key_sounds.<uuid>.<down|up>.mode 支持以下随机类模式:
+//   - random:   均匀随机; 可选 "avoid_repeat": true, 保证不会连续两次选中同一项;
+//   - weighted: 按每一项的 "weight" 字段加权随机(缺省为 1, <=0 表示不参与; 全部不参与时退化为均匀随机), 同样支持 avoid_repeat;
+//   - shuffle:  洗牌袋, 每一项在整袋用完之前不会重复, 换袋时也不会与上一次选中的项相同。
+//
+// 选择状态(随机数生成器、上一次选中项、洗牌袋)按 "<key_sound_UUID>_<keyState>" 记录, 不区分按键,
+// 因此多个按键共用同一个键音时, 连续敲击不同按键也不会重复。
+//
+// loop 模式的播放位置同样记录在 keySoundSelection 中(见 loop.go), 但按按键区分。
+//
+// 每个专辑快照(AlbumSnapshot)持有一个独立播种的 keySoundSelection, 重新加载快照即重置状态;
+// 编辑器模式则使用包级的 editorSelection, 在切换专辑时重置。测试可通过固定种子得到确定的结果。
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type keySoundSelection struct {
+	mutex sync.Mutex
+	rng   *rand.Rand
+	// last 记录每个选择状态上一次选中的下标
+	last map[string]int
+	// bags 记录 shuffle 模式下尚未播放的下标
+	bags map[string][]int
+	// bagSizes 记录洗牌袋建立时的条目数量, 配置变化时重新洗牌
+	bagSizes map[string]int
+	// loops 记录 loop 模式下每个序列的播放位置
+	loops map[loopKey]*loopPosition
+}
+
+func newKeySoundSelection(seed int64) *keySoundSelection {
+	return &keySoundSelection{
+		rng:      rand.New(rand.NewSource(seed)),
+		last:     map[string]int{},
+		bags:     map[string][]int{},
+		bagSizes: map[string]int{},
+		loops:    map[loopKey]*loopPosition{},
+	}
+}
+
+var (
+	editorSelectionMutex   sync.Mutex
+	editorSelectionAlbum   string
+	editorSelectionCurrent *keySoundSelection
+)
+
+// editorSelection 返回编辑器模式使用的选择状态, 切换专辑时重置。
+func editorSelection(audioPkgUUID string) *keySoundSelection {
+	editorSelectionMutex.Lock()
+	defer editorSelectionMutex.Unlock()
+	if editorSelectionCurrent == nil || editorSelectionAlbum != audioPkgUUID {
+		editorSelectionCurrent = newKeySoundSelection(time.Now().UnixNano())
+		editorSelectionAlbum = audioPkgUUID
+	}
+	return editorSelectionCurrent
+}
+
+// currentEditorSelection 返回编辑器模式当前的选择状态及其所属专辑, 不存在时不创建。
+func currentEditorSelection() (string, *keySoundSelection) {
+	editorSelectionMutex.Lock()
+	defer editorSelectionMutex.Unlock()
+	return editorSelectionAlbum, editorSelectionCurrent
+}
+
+// pickRandom 在 n 项中均匀随机选择; avoidRepeat 为 true 时不会与上一次相同(n > 1 时)。
+func (s *keySoundSelection) pickRandom(key string, n int, avoidRepeat bool) int {
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return s.pickWeighted(key, weights, avoidRepeat)
+}
+
+// pickWeighted 按 weights 加权随机选择; 权重 <=0 的项不参与, 全部不参与时退化为均匀随机。
+func (s *keySoundSelection) pickWeighted(key string, weights []float64, avoidRepeat bool) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	last, hasLast := s.last[key]
+	candidate := func(i int) bool {
+		return !(avoidRepeat && hasLast && i == last)
+	}
+
+	total := 0.0
+	for i, weight := range weights {
+		if weight > 0 && candidate(i) {
+			total += weight
+		}
+	}
+
+	index := -1
+	if total > 0 {
+		target := s.rng.Float64() * total
+		for i, weight := range weights {
+			if weight <= 0 || !candidate(i) {
+				continue
+			}
+			index = i
+			if target < weight {
+				break
+			}
+			target -= weight
+		}
+	} else {
+		// 没有可用权重时退化为均匀随机(仍然遵循 avoid_repeat)
+		choices := make([]int, 0, len(weights))
+		for i := range weights {
+			if candidate(i) {
+				choices = append(choices, i)
+			}
+		}
+		if len(choices) == 0 {
+			choices = append(choices, last)
+		}
+		index = choices[s.rng.Intn(len(choices))]
+	}
+
+	s.last[key] = index
+	return index
+}
+
+// pickShuffle 从洗牌袋中取出下一项; 袋空或条目数量变化时重新洗牌, 且新袋的首项不会与上一次相同。
+func (s *keySoundSelection) pickShuffle(key string, n int) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	bag := s.bags[key]
+	if len(bag) == 0 || s.bagSizes[key] != n {
+		bag = s.rng.Perm(n)
+		if last, ok := s.last[key]; ok && n > 1 && bag[0] == last {
+			swap := 1 + s.rng.Intn(n-1)
+			bag[0], bag[swap] = bag[swap], bag[0]
+		}
+		s.bagSizes[key] = n
+	}
+
+	index := bag[0]
+	s.bags[key] = bag[1:]
+	s.last[key] = index
+	return index
+}
+
+// keySoundEntryWeights 读取每一项的 weight 字段(缺省为 1)。
+func keySoundEntryWeights(values []interface{}) []float64 {
+	weights := make([]float64, len(values))
+	for i, v := range values {
+		weights[i] = 1
+		if vMap, ok := v.(map[string]interface{}); ok {
+			if weight, ok := vMap["weight"].(float64); ok {
+				weights[i] = weight
+			}
+		}
+	}
+	return weights
+}
@@ -0,0 +1,105 @@
+package keySound
+
+import "testing"
+
+// TestKeySoundSelectionIsDeterministic 验证相同种子得到相同的选择序列。
+func TestKeySoundSelectionIsDeterministic(t *testing.T) {
+	first := newKeySoundSelection(42)
+	second := newKeySoundSelection(42)
+	for i := 0; i < 50; i++ {
+		if a, b := first.pickRandom("ks_down", 5, false), second.pickRandom("ks_down", 5, false); a != b {
+			t.Fatalf("step %d: expected identical picks, got %d and %d", i, a, b)
+		}
+		if a, b := first.pickShuffle("ks_up", 4), second.pickShuffle("ks_up", 4); a != b {
+			t.Fatalf("step %d: expected identical shuffle picks, got %d and %d", i, a, b)
+		}
+	}
+}
+
+// TestKeySoundSelectionAvoidRepeat 验证 avoid_repeat 开启时不会连续两次选中同一项。
+func TestKeySoundSelectionAvoidRepeat(t *testing.T) {
+	selection := newKeySoundSelection(1)
+	last := -1
+	seen := map[int]bool{}
+	for i := 0; i < 500; i++ {
+		index := selection.pickRandom("ks_down", 3, true)
+		if index == last {
+			t.Fatalf("step %d: repeated index %d", i, index)
+		}
+		seen[index] = true
+		last = index
+	}
+	if len(seen) != 3 {
+		t.Fatalf("expected every entry to be picked, got %v", seen)
+	}
+
+	// 只有一项时仍然可以播放
+	if index := selection.pickRandom("single", 1, true); index != 0 {
+		t.Fatalf("unexpected index: %d", index)
+	}
+	if index := selection.pickRandom("single", 1, true); index != 0 {
+		t.Fatalf("unexpected index: %d", index)
+	}
+}
+
+// TestKeySoundSelectionShuffleBag 验证洗牌袋在用完前不重复, 且换袋时不与上一项相同。
+func TestKeySoundSelectionShuffleBag(t *testing.T) {
+	selection := newKeySoundSelection(7)
+	const n = 4
+	last := -1
+	for bag := 0; bag < 50; bag++ {
+		seen := map[int]bool{}
+		for i := 0; i < n; i++ {
+			index := selection.pickShuffle("ks_down", n)
+			if seen[index] {
+				t.Fatalf("bag %d: index %d repeated before the bag was exhausted", bag, index)
+			}
+			if index == last {
+				t.Fatalf("bag %d: index %d repeated across bags", bag, index)
+			}
+			seen[index] = true
+			last = index
+		}
+	}
+
+	// 条目数量变化时重新洗牌, 不会返回越界下标
+	for i := 0; i < 10; i++ {
+		if index := selection.pickShuffle("ks_down", 2); index < 0 || index >= 2 {
+			t.Fatalf("unexpected index after resize: %d", index)
+		}
+	}
+}
+
+// TestKeySoundSelectionWeighted 验证权重为 0 的项不会被选中, 且选择频率与权重大致成比例。
+func TestKeySoundSelectionWeighted(t *testing.T) {
+	selection := newKeySoundSelection(3)
+	weights := keySoundEntryWeights([]interface{}{
+		map[string]interface{}{"type": "sounds", "value": "a", "weight": 3.0},
+		map[string]interface{}{"type": "sounds", "value": "b"},
+		map[string]interface{}{"type": "sounds", "value": "c", "weight": 0.0},
+	})
+	if weights[0] != 3 || weights[1] != 1 || weights[2] != 0 {
+		t.Fatalf("unexpected weights: %v", weights)
+	}
+
+	counts := make([]int, len(weights))
+	for i := 0; i < 4000; i++ {
+		counts[selection.pickWeighted("ks_down", weights, false)]++
+	}
+	if counts[2] != 0 {
+		t.Fatalf("expected zero-weight entry to be skipped, got %d picks", counts[2])
+	}
+	if ratio := float64(counts[0]) / float64(counts[1]); ratio < 2.5 || ratio > 3.5 {
+		t.Fatalf("expected about 3:1, got %v (%v)", ratio, counts)
+	}
+
+	// 全部权重为 0 时退化为均匀随机, 且仍遵循 avoid_repeat
+	last := -1
+	for i := 0; i < 100; i++ {
+		index := selection.pickWeighted("zero", []float64{0, 0}, true)
+		if index == last {
+			t.Fatalf("step %d: repeated index %d", i, index)
+		}
+		last = index
+	}
+}
@@ -0,0 +1,293 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 按键位置声像(空间化)说明
+// =============================
+//
+// 开启后, 每个按键的声音按其在所选键盘布局上的水平位置向左/右声道偏移, 使录音听起来像一把摆在面前的真实键盘:
+//   - 布局表将 keyEvent 发出的 gohook 键码映射为归一化的 x 坐标(0 = 键盘最左, 1 = 键盘最右, 取按键中心);
+//   - pan = (2x - 1) * width, 其中 width 为声像宽度;
+//   - 鼠标按键(keycode 以 "-" 开头)使用 mouse_pan 中以该负数按键码为键的声像("-1" 左键, "-2" 右键, "-3" 中键...), 未配置的按键保持居中;
+//   - 不在布局中的按键(如 60% 布局下的方向键)保持居中。
+//
+// 设置位于 KeyToneSetting.json 的 playback.spatial_pan, 专辑可在 package.json 中以同名节点 spatial_pan 覆盖其中任意字段:
+//
+//	"spatial_pan": { "is_enabled": true, "layout": "iso", "width": 0.8, "mouse_pan": { "-1": -0.9, "-2": 0.9 } }
+//
+// 专辑中的 mouse_pan 逐按键覆盖用户设置, 未出现的按键沿用用户设置。
+
+import (
+	"strconv"
+	"strings"
+
+	"KeyTone/config"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/effects"
+)
+
+const (
+	SpatialLayoutANSI = "ansi"
+	SpatialLayoutISO  = "iso"
+	SpatialLayoutJIS  = "jis"
+	SpatialLayoutTKL  = "tkl"
+	SpatialLayout60   = "60"
+)
+
+// layoutBuilder 以键位单位(1u = 一个标准按键宽度)逐行排布按键, 记录每个键码的中心 x 坐标。
+type layoutBuilder struct {
+	x    float64
+	keys map[uint16]float64
+}
+
+func newLayoutBuilder() *layoutBuilder {
+	return &layoutBuilder{keys: map[uint16]float64{}}
+}
+
+// row 将游标移动到新一行的起始位置 start。
+func (b *layoutBuilder) row(start float64) *layoutBuilder {
+	b.x = start
+	return b
+}
+
+func (b *layoutBuilder) gap(width float64) *layoutBuilder {
+	b.x += width
+	return b
+}
+
+// key 放置一个宽度为 width 的按键; 同一物理按键可能对应多个键码(如 NumLock 开/关时的小键盘)。
+// 已放置过的键码保持首次的位置(如 ISO 回车跨两行)。
+func (b *layoutBuilder) key(width float64, codes ...uint16) *layoutBuilder {
+	for _, code := range codes {
+		if _, exists := b.keys[code]; !exists {
+			b.keys[code] = b.x + width/2
+		}
+	}
+	b.x += width
+	return b
+}
+
+// keys1u 依次放置多个 1u 按键。
+func (b *layoutBuilder) keys1u(codes ...uint16) *layoutBuilder {
+	for _, code := range codes {
+		b.key(1, code)
+	}
+	return b
+}
+
+// normalize 将坐标归一化到 [0, 1] 并以字符串键码索引(与 keycode 参数一致)。
+func (b *layoutBuilder) normalize(totalWidth float64) map[string]float64 {
+	positions := make(map[string]float64, len(b.keys))
+	for code, x := range b.keys {
+		positions[strconv.Itoa(int(code))] = x / totalWidth
+	}
+	return positions
+}
+
+// functionRow 排布 Esc 与 F1~F12(主键区宽 15u)。
+func (b *layoutBuilder) functionRow() *layoutBuilder {
+	return b.row(0).key(1, 1).gap(1).
+		keys1u(59, 60, 61, 62).gap(0.5).
+		keys1u(63, 64, 65, 66).gap(0.5).
+		keys1u(67, 68, 87, 88)
+}
+
+// mainBlock 排布主键区(数字行至空格行), variant 为 ANSI/ISO/JIS 之一。
+func (b *layoutBuilder) mainBlock(variant string) *layoutBuilder {
+	switch variant {
+	case SpatialLayoutISO:
+		b.row(0).keys1u(41, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13).key(2, 14)
+		b.row(0).key(1.5, 15).keys1u(16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27).key(1.5, 28)
+		b.row(0).key(1.75, 58).keys1u(30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 43)
+		b.row(0).key(1.25, 42).keys1u(86, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53).key(2.75, 54)
+	case SpatialLayoutJIS:
+		b.row(0).keys1u(41, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 125, 14)
+		b.row(0).key(1.5, 15).keys1u(16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27).key(1.5, 28)
+		b.row(0).key(1.75, 58).keys1u(30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 43)
+		b.row(0).key(2.25, 42).keys1u(44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 115).key(1.75, 54)
+		b.row(0).key(1.25, 29).key(1.25, 3675).key(1.25, 56).key(1.25, 123).key(2.5, 57).
+			key(1.25, 121).key(1.25, 112).key(1.25, 3640).key(1.25, 3676).key(1.25, 3677).key(1.25, 3613)
+		return b
+	default:
+		b.row(0).keys1u(41, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13).key(2, 14)
+		b.row(0).key(1.5, 15).keys1u(16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27).key(1.5, 43)
+		b.row(0).key(1.75, 58).keys1u(30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40).key(2.25, 28)
+		b.row(0).key(2.25, 42).keys1u(44, 45, 46, 47, 48, 49, 50, 51, 52, 53).key(2.75, 54)
+	}
+	b.row(0).key(1.25, 29).key(1.25, 3675).key(1.25, 56).key(6.25, 57).
+		key(1.25, 3640).key(1.25, 3676).key(1.25, 3677).key(1.25, 3613)
+	return b
+}
+
+// navigationBlock 排布编辑区与方向键(起始于 15.25u, 宽 3u)。
+// 编辑区/方向键使用 KeyTone 实际收到的 0xEE00 前缀扩展码(见 mechvibes.keycodeTable)。
+func (b *layoutBuilder) navigationBlock() *layoutBuilder {
+	const start = 15.25
+	b.row(start).keys1u(3639, 70, 3653)
+	b.row(start).keys1u(61010, 60999, 61001)
+	b.row(start).keys1u(61011, 61007, 61009)
+	b.row(start).gap(1).key(1, 61000)
+	b.row(start).keys1u(61003, 61008, 61005)
+	return b
+}
+
+// numpadBlock 排布数字小键盘(起始于 18.5u, 宽 4u), 同时登记 NumLock 关闭时的导航键码。
+func (b *layoutBuilder) numpadBlock() *layoutBuilder {
+	const start = 18.5
+	b.row(start).keys1u(69, 3637, 55, 74)
+	b.row(start).key(1, 71, 3655, 60935).key(1, 72, 57416, 60936).key(1, 73, 3657, 60937).key(1, 78)
+	b.row(start).key(1, 75, 57419, 60931).key(1, 76, 57420, 60932).key(1, 77, 57421, 60933)
+	b.row(start).key(1, 79, 3663, 60927).key(1, 80, 57424, 60928).key(1, 81, 3665, 60929).key(1, 3612)
+	b.row(start).key(2, 82, 3666, 60930).key(1, 83, 3667, 60947)
+	return b
+}
+
+// buildSpatialLayouts 生成全部布局的归一化坐标表(仅在包初始化时执行一次)。
+func buildSpatialLayouts() map[string]map[string]float64 {
+	const fullWidth = 22.5
+	const tklWidth = 18.25
+	const compactWidth = 15.0
+
+	layouts := map[string]map[string]float64{}
+	for _, variant := range []string{SpatialLayoutANSI, SpatialLayoutISO, SpatialLayoutJIS} {
+		layouts[variant] = newLayoutBuilder().functionRow().mainBlock(variant).navigationBlock().numpadBlock().normalize(fullWidth)
+	}
+	layouts[SpatialLayoutTKL] = newLayoutBuilder().functionRow().mainBlock(SpatialLayoutANSI).navigationBlock().normalize(tklWidth)
+
+	// 60% 没有功能键行, Esc 与 F1~F12 通常位于数字行的 Fn 层, 因此按数字行的位置发声。
+	compact := newLayoutBuilder().mainBlock(SpatialLayoutANSI)
+	compact.row(0).keys1u(1, 59, 60, 61, 62, 63, 64, 65, 66, 67, 68, 87, 88)
+	layouts[SpatialLayout60] = compact.normalize(compactWidth)
+	return layouts
+}
+
+var spatialLayouts = buildSpatialLayouts()
+
+// SpatialPanSettings 为生效中的声像设置。
+type SpatialPanSettings struct {
+	IsEnabled bool
+	Layout    string
+	Width     float64
+	MousePan  map[string]float64 // 以鼠标按键的负数按键码为键
+}
+
+// spatialPanSettingsWith 读取用户设置, 并以专辑(get, 可为 nil)中的 spatial_pan 节点覆盖。
+func spatialPanSettingsWith(get ConfigGetter) SpatialPanSettings {
+	settings := SpatialPanSettings{}
+	var ok bool
+
+	settings.IsEnabled, ok = config.GetValue("playback.spatial_pan.is_enabled").(bool)
+	if !ok {
+		settings.IsEnabled = config.Playback___spatial_pan___is_enabled
+		go config.SetValue("playback.spatial_pan.is_enabled", settings.IsEnabled)
+	}
+	settings.Layout, ok = config.GetValue("playback.spatial_pan.layout").(string)
+	if !ok {
+		settings.Layout = config.Playback___spatial_pan___layout
+		go config.SetValue("playback.spatial_pan.layout", settings.Layout)
+	}
+	settings.Width, ok = config.GetValue("playback.spatial_pan.width").(float64)
+	if !ok {
+		settings.Width = config.Playback___spatial_pan___width
+		go config.SetValue("playback.spatial_pan.width", settings.Width)
+	}
+	settings.MousePan, ok = mousePanMap(config.GetValue("playback.spatial_pan.mouse_pan"))
+	if !ok {
+		defaultMousePan := map[string]any{
+			"-1": config.Playback___spatial_pan___mouse_pan___left,
+			"-2": config.Playback___spatial_pan___mouse_pan___right,
+			"-3": config.Playback___spatial_pan___mouse_pan___middle,
+		}
+		settings.MousePan, _ = mousePanMap(defaultMousePan)
+		go config.SetValue("playback.spatial_pan.mouse_pan", defaultMousePan)
+	}
+
+	if isEnabled, ok := getValue(get, "spatial_pan.is_enabled").(bool); ok {
+		settings.IsEnabled = isEnabled
+	}
+	if layout, ok := getValue(get, "spatial_pan.layout").(string); ok {
+		settings.Layout = layout
+	}
+	if width, ok := getValue(get, "spatial_pan.width").(float64); ok {
+		settings.Width = width
+	}
+	if mousePan, ok := mousePanMap(getValue(get, "spatial_pan.mouse_pan")); ok {
+		for keycode, pan := range mousePan {
+			settings.MousePan[keycode] = pan
+		}
+	}
+
+	settings.Layout = strings.ToLower(strings.TrimSpace(settings.Layout))
+	settings.Width = min(max(settings.Width, 0), 1)
+	for keycode, pan := range settings.MousePan {
+		settings.MousePan[keycode] = min(max(pan, -1), 1)
+	}
+	return settings
+}
+
+// mousePanMap 将配置中的 mouse_pan 节点转换为 按键码 -> 声像 的映射, 忽略非数值的条目。
+// 节点不是映射时(如旧版本中的单个数值)返回 false。
+func mousePanMap(value any) (map[string]float64, bool) {
+	node, ok := value.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	mousePan := make(map[string]float64, len(node))
+	for keycode, v := range node {
+		if pan, ok := v.(float64); ok {
+			mousePan[keycode] = pan
+		}
+	}
+	return mousePan, true
+}
+
+// spatialPanFor 返回 keycode 在给定设置下的声像(-1 ~ 1)。
+func spatialPanFor(settings SpatialPanSettings, keycode string) float64 {
+	if !settings.IsEnabled || keycode == "" {
+		return 0
+	}
+	if strings.HasPrefix(keycode, "-") {
+		// 未配置的鼠标按键保持居中
+		return settings.MousePan[keycode]
+	}
+	layout, ok := spatialLayouts[settings.Layout]
+	if !ok {
+		layout = spatialLayouts[SpatialLayoutANSI]
+	}
+	x, ok := layout[keycode]
+	if !ok {
+		return 0
+	}
+	return (2*x - 1) * settings.Width
+}
+
+// spatialPanProcessing 在开启声像时按按键位置偏移声道; get 为本次按键事件的专辑配置(可为 nil)。
+func spatialPanProcessing(streamer beep.Streamer, get ConfigGetter, keycode string) beep.Streamer {
+	if streamer == nil {
+		return streamer
+	}
+	pan := spatialPanFor(spatialPanSettingsWith(get), keycode)
+	if pan == 0 {
+		return streamer
+	}
+	return &effects.Pan{Streamer: streamer, Pan: pan}
+}
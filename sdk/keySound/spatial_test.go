@@ -0,0 +1,137 @@
+package keySound
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestSpatialLayoutsOrderKeysLeftToRight 验证各布局中按键的相对位置与真实键盘一致。
+func TestSpatialLayoutsOrderKeysLeftToRight(t *testing.T) {
+	for _, name := range []string{SpatialLayoutANSI, SpatialLayoutISO, SpatialLayoutJIS, SpatialLayoutTKL, SpatialLayout60} {
+		layout := spatialLayouts[name]
+		// Esc < a < g < l < Enter
+		order := []string{"1", "30", "34", "38", "28"}
+		for i := 1; i < len(order); i++ {
+			if layout[order[i-1]] >= layout[order[i]] {
+				t.Fatalf("%s: expected key %s left of %s, got %v >= %v", name, order[i-1], order[i], layout[order[i-1]], layout[order[i]])
+			}
+		}
+		for code, x := range layout {
+			if x <= 0 || x >= 1 {
+				t.Fatalf("%s: key %s out of range: %v", name, code, x)
+			}
+		}
+	}
+
+	ansi := spatialLayouts[SpatialLayoutANSI]
+	// 空格中心位于 3 个 1.25u 修饰键之后 3.125u 处, 小键盘位于最右侧
+	if math.Abs(ansi["57"]-6.875/22.5) > 1e-9 {
+		t.Fatalf("unexpected space position: %v", ansi["57"])
+	}
+	if ansi["3612"] <= ansi["61005"] || ansi["61005"] <= ansi["54"] {
+		t.Fatal("expected numpad right of arrows, arrows right of right shift")
+	}
+	// NumLock 关闭时的小键盘键码与数字键位于同一位置
+	if ansi["3655"] != ansi["71"] || ansi["60935"] != ansi["71"] {
+		t.Fatal("expected numpad navigation codes to share the digit key position")
+	}
+
+	// TKL 没有小键盘, 方向键位于最右侧
+	if _, ok := spatialLayouts[SpatialLayoutTKL]["3612"]; ok {
+		t.Fatal("expected no numpad on tkl layout")
+	}
+	// 60% 没有方向键, F1 与数字 1 同位置
+	compact := spatialLayouts[SpatialLayout60]
+	if _, ok := compact["61000"]; ok {
+		t.Fatal("expected no arrow keys on 60% layout")
+	}
+	if compact["59"] != compact["2"] {
+		t.Fatal("expected F1 at the position of 1 on 60% layout")
+	}
+
+	// ISO/JIS 的特有按键
+	if _, ok := spatialLayouts[SpatialLayoutISO]["86"]; !ok {
+		t.Fatal("expected iso layout to contain the extra key next to left shift")
+	}
+	if _, ok := spatialLayouts[SpatialLayoutJIS]["125"]; !ok {
+		t.Fatal("expected jis layout to contain the yen key")
+	}
+}
+
+// TestSpatialPanForKeycodes 验证 pan 的换算、鼠标声像与未知按键居中。
+func TestSpatialPanForKeycodes(t *testing.T) {
+	settings := SpatialPanSettings{IsEnabled: true, Layout: SpatialLayout60, Width: 1, MousePan: map[string]float64{"-1": -0.5}}
+
+	// 60% 布局下, Esc(左上角 1u 键)中心位于 0.5u / 15u
+	if pan := spatialPanFor(settings, "1"); math.Abs(pan-(2*0.5/15-1)) > 1e-9 {
+		t.Fatalf("unexpected pan for escape: %v", pan)
+	}
+	if pan := spatialPanFor(settings, "-1"); pan != -0.5 {
+		t.Fatalf("unexpected mouse pan: %v", pan)
+	}
+	if pan := spatialPanFor(settings, "-3"); pan != 0 {
+		t.Fatalf("expected mouse buttons without an entry to be centered, got %v", pan)
+	}
+	if pan := spatialPanFor(settings, "61000"); pan != 0 {
+		t.Fatalf("expected keys outside the layout to be centered, got %v", pan)
+	}
+	settings.Layout = "unknown"
+	if pan := spatialPanFor(settings, "3612"); pan <= 0 {
+		t.Fatalf("expected unknown layout to fall back to ansi, got %v", pan)
+	}
+	settings.IsEnabled = false
+	if pan := spatialPanFor(settings, "1"); pan != 0 {
+		t.Fatalf("expected no pan when disabled, got %v", pan)
+	}
+}
+
+// TestSpatialPanSettingsAlbumOverride 验证专辑中的 spatial_pan 节点逐字段覆盖用户设置。
+func TestSpatialPanSettingsAlbumOverride(t *testing.T) {
+	useTestConfig(t, map[string]any{
+		"playback.spatial_pan.is_enabled": false,
+		"playback.spatial_pan.layout":     "ANSI",
+		"playback.spatial_pan.width":      0.6,
+		"playback.spatial_pan.mouse_pan":  map[string]any{"-1": 0.8, "-2": 0.8},
+	})
+
+	if settings := spatialPanSettingsWith(nil); settings.IsEnabled || settings.Layout != SpatialLayoutANSI || settings.Width != 0.6 {
+		t.Fatalf("unexpected user settings: %+v", settings)
+	}
+
+	album := map[string]any{
+		"spatial_pan.is_enabled": true,
+		"spatial_pan.layout":     "iso",
+		"spatial_pan.width":      3.0,
+		"spatial_pan.mouse_pan":  map[string]any{"-2": -1.5},
+	}
+	settings := spatialPanSettingsWith(func(key string) any { return album[key] })
+	want := SpatialPanSettings{IsEnabled: true, Layout: SpatialLayoutISO, Width: 1, MousePan: map[string]float64{"-1": 0.8, "-2": -1}}
+	if !reflect.DeepEqual(settings, want) {
+		t.Fatalf("unexpected overridden settings: got %+v want %+v", settings, want)
+	}
+}
+
+// TestSpatialPanMouseButtons 验证鼠标左右键可分别设置声像, 未配置的按键保持居中。
+func TestSpatialPanMouseButtons(t *testing.T) {
+	useTestConfig(t, map[string]any{
+		"playback.spatial_pan.is_enabled": true,
+		"playback.spatial_pan.layout":     SpatialLayoutANSI,
+		"playback.spatial_pan.width":      0.6,
+		"playback.spatial_pan.mouse_pan":  map[string]any{"-1": -0.4, "-2": 0.7},
+	})
+
+	settings := spatialPanSettingsWith(nil)
+	left, right := spatialPanFor(settings, "-1"), spatialPanFor(settings, "-2")
+	if left != -0.4 || right != 0.7 {
+		t.Fatalf("expected left and right buttons to pan independently, got %v / %v", left, right)
+	}
+	if pan := spatialPanFor(settings, "-3"); pan != 0 {
+		t.Fatalf("expected middle button without an entry to be centered, got %v", pan)
+	}
+
+	// 旧版本的单个数值配置无法区分按键, 不作为 mouse_pan 使用
+	if _, ok := mousePanMap(0.8); ok {
+		t.Fatal("expected a scalar mouse_pan to be rejected")
+	}
+}
@@ -0,0 +1,189 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 打字速度档位(speed_tiers)说明
+// =============================
+//
+// 慢速敲击与快速连打的声音不同, 专辑可以在 key_tone.global、key_tone.group.<name>(见 key_groups.go)
+// 与 key_tone.single.<keycode> 下定义速度档位:
+//
+//	"speed_tiers": [
+//	  { "name": "fast", "max_interval_ms": 150, "down": { "type": "key_sounds", "value": "<uuid>" } },
+//	  { "name": "rolling", "min_wpm": 90, "down": {...}, "up": {...} }
+//	]
+//
+// 每一档可选的条件(全部满足才算命中, 没有任何条件的一档总是命中):
+//   - min_interval_ms / max_interval_ms: 本次按下距上一次键盘按下的间隔, 区间为 [min, max);
+//   - min_wpm / max_wpm: 滚动的每分钟单词数(5 次按键计 1 个单词), 区间为 [min, max)。
+//
+// 按顺序取第一个命中的档位; 该档位配置了当前按键状态(down/up/hold)时, 使用其 {type, value} 代替原有配置,
+// 否则仍使用原有的 key_tone.global.<state> / key_tone.single.<keycode>.<state>。
+// 速度档位只作用于键盘, 鼠标按键不受影响。
+//
+// 速度统计由 keyEvent 在每次键盘按下时调用 RecordTypingKeyDown 更新, 只使用时间戳, 不记录任何按键内容;
+// 当前速度与命中的档位随按下事件通过 SSE(messageKeyEvent)推送给前端。
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// typingSpeedWindow 为 WPM 滚动统计的时间窗口。
+const typingSpeedWindow = 5 * time.Second
+
+// TypingSpeed 为当前的打字速度。
+type TypingSpeed struct {
+	// IntervalMS 为最近一次键盘按下距上一次按下的毫秒数, -1 表示尚无上一次按下。
+	IntervalMS float64 `json:"intervalMs"`
+	// WPM 为最近 typingSpeedWindow 内按键折算的每分钟单词数。
+	WPM float64 `json:"wpm"`
+	// Tier 为最近一次键盘按下命中的速度档位名称, 未命中时为空。
+	Tier string `json:"tier"`
+}
+
+type typingSpeedTracker struct {
+	mutex sync.Mutex
+	// downs 为 typingSpeedWindow 内的按下时间戳(仅时间, 不含按键)
+	downs   []time.Time
+	current TypingSpeed
+}
+
+var typingSpeed = &typingSpeedTracker{current: TypingSpeed{IntervalMS: -1}}
+
+// recordDown 记录一次键盘按下, 返回更新后的间隔与 WPM。
+func (t *typingSpeedTracker) recordDown(at time.Time) TypingSpeed {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.downs) > 0 {
+		t.current.IntervalMS = float64(at.Sub(t.downs[len(t.downs)-1])) / float64(time.Millisecond)
+	}
+
+	// 丢弃窗口之外的时间戳
+	kept := t.downs[:0]
+	for _, down := range t.downs {
+		if at.Sub(down) < typingSpeedWindow {
+			kept = append(kept, down)
+		}
+	}
+	t.downs = append(kept, at)
+
+	// 以窗口内第一次与最后一次按下之间的跨度折算, 使刚开始打字时的读数也能及时反映速度
+	t.current.WPM = 0
+	if span := at.Sub(t.downs[0]); len(t.downs) > 1 && span > 0 {
+		t.current.WPM = float64(len(t.downs)-1) / span.Minutes() / 5
+	}
+	return t.current
+}
+
+func (t *typingSpeedTracker) setTier(tier string) TypingSpeed {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.current.Tier = tier
+	return t.current
+}
+
+func (t *typingSpeedTracker) snapshot() TypingSpeed {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.current
+}
+
+// RecordTypingKeyDown 记录一次键盘按下(keycode 与 modifiers 仅用于确定命中的档位, 不会被保存), 返回当前速度与命中的档位。
+func RecordTypingKeyDown(keycode string, modifiers Modifiers, at time.Time) TypingSpeed {
+	speed := typingSpeed.recordDown(at)
+	configGetter, _, _ := resolvePlaybackSource(keycode)
+	return typingSpeed.setTier(typingTierFor(configGetter, keycode, KeyStateDown, modifiers, speed))
+}
+
+// GetTypingSpeed 返回当前的打字速度。
+func GetTypingSpeed() TypingSpeed {
+	return typingSpeed.snapshot()
+}
+
+// matchTypingTier 按顺序返回第一个命中的速度档位的下标与名称。
+func matchTypingTier(tiers []interface{}, speed TypingSpeed) (int, string, bool) {
+	for index, tier := range tiers {
+		tierMap, ok := tier.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if minInterval, ok := tierMap["min_interval_ms"].(float64); ok && speed.IntervalMS >= 0 && speed.IntervalMS < minInterval {
+			continue
+		}
+		// 尚无上一次按下时视为足够慢, 不满足任何间隔上限
+		if maxInterval, ok := tierMap["max_interval_ms"].(float64); ok && (speed.IntervalMS < 0 || speed.IntervalMS >= maxInterval) {
+			continue
+		}
+		if minWPM, ok := tierMap["min_wpm"].(float64); ok && speed.WPM < minWPM {
+			continue
+		}
+		if maxWPM, ok := tierMap["max_wpm"].(float64); ok && speed.WPM >= maxWPM {
+			continue
+		}
+
+		name, _ := tierMap["name"].(string)
+		if strings.TrimSpace(name) == "" {
+			name = fmt.Sprint(index)
+		}
+		return index, name, true
+	}
+	return 0, "", false
+}
+
+// typingTierEffectPath 返回 prefix(key_tone.global、key_tone.group.<name> 或 key_tone.single.<keycode>)下当前按键状态生效的配置路径,
+// 以及命中的档位名称; 未命中或命中的档位未配置该状态时返回原有路径与空名称。
+func typingTierEffectPath(get ConfigGetter, prefix string, keycode string, keyState string, speed TypingSpeed) (string, string) {
+	if !strings.HasPrefix(keycode, "-") {
+		tiers, _ := getValue(get, prefix+".speed_tiers").([]interface{})
+		if index, name, ok := matchTypingTier(tiers, speed); ok {
+			tierPath := fmt.Sprintf("%s.speed_tiers.%d.%s", prefix, index, keyState)
+			if getValue(get, tierPath) != nil {
+				return tierPath, name
+			}
+		}
+	}
+	return prefix + "." + keyState, ""
+}
+
+// typingTierFor 返回按键当前实际生效的速度档位名称(single > group > global, 与 KeySoundHandler 一致)。
+func typingTierFor(get ConfigGetter, keycode string, keyState string, modifiers Modifiers, speed TypingSpeed) string {
+	if get == nil {
+		return ""
+	}
+	// replace 模式的组合键代替了原有声音, 不存在生效的档位
+	if path, overlay := chordEffectPath(get, keycode, keyState, modifiers); path != "" && !overlay {
+		return ""
+	}
+	if path, tier := keyToneEffectPath(get, "key_tone.single."+keycode, keycode, keyState, modifiers, speed); getValue(get, path) != nil {
+		return tier
+	}
+	if path, _, tier := keyGroupEffectPath(get, keycode, keyState, modifiers, speed); path != "" {
+		return tier
+	}
+	if path, tier := keyToneEffectPath(get, "key_tone.global", keycode, keyState, modifiers, speed); getValue(get, path) != nil {
+		return tier
+	}
+	return ""
+}
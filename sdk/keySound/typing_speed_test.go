@@ -0,0 +1,114 @@
+package keySound
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TestTypingSpeedTrackerMeasuresIntervalAndWPM 验证间隔与滚动 WPM 的计算, 以及窗口外时间戳的丢弃。
+func TestTypingSpeedTrackerMeasuresIntervalAndWPM(t *testing.T) {
+	tracker := &typingSpeedTracker{current: TypingSpeed{IntervalMS: -1}}
+	start := time.Unix(1000, 0)
+
+	if speed := tracker.recordDown(start); speed.IntervalMS != -1 || speed.WPM != 0 {
+		t.Fatalf("unexpected first speed: %+v", speed)
+	}
+
+	// 每 100ms 按下一次: 10 次/秒 = 600 次/分钟 = 120 WPM
+	var speed TypingSpeed
+	for i := 1; i <= 10; i++ {
+		speed = tracker.recordDown(start.Add(time.Duration(i) * 100 * time.Millisecond))
+	}
+	if speed.IntervalMS != 100 || math.Abs(speed.WPM-120) > 1e-9 {
+		t.Fatalf("unexpected rolling speed: %+v", speed)
+	}
+
+	// 长时间停顿后, 窗口内只剩本次按下
+	speed = tracker.recordDown(start.Add(time.Minute))
+	if speed.WPM != 0 || len(tracker.downs) != 1 || math.Abs(speed.IntervalMS-59000) > 1e-9 {
+		t.Fatalf("unexpected speed after a pause: %+v (%d downs)", speed, len(tracker.downs))
+	}
+}
+
+// TestMatchTypingTier 验证档位条件与顺序。
+func TestMatchTypingTier(t *testing.T) {
+	tiers := []interface{}{
+		map[string]interface{}{"name": "fast", "max_interval_ms": 150.0},
+		map[string]interface{}{"min_wpm": 60.0, "max_wpm": 100.0},
+		map[string]interface{}{"name": "slow", "min_interval_ms": 400.0},
+	}
+	cases := []struct {
+		speed TypingSpeed
+		index int
+		name  string
+		ok    bool
+	}{
+		{TypingSpeed{IntervalMS: 80, WPM: 70}, 0, "fast", true},
+		{TypingSpeed{IntervalMS: 200, WPM: 70}, 1, "1", true},
+		{TypingSpeed{IntervalMS: 200, WPM: 100}, 0, "", false},
+		{TypingSpeed{IntervalMS: 500, WPM: 10}, 2, "slow", true},
+		// 尚无上一次按下时视为足够慢
+		{TypingSpeed{IntervalMS: -1}, 2, "slow", true},
+	}
+	for _, c := range cases {
+		index, name, ok := matchTypingTier(tiers, c.speed)
+		if ok != c.ok || (ok && (index != c.index || name != c.name)) {
+			t.Fatalf("%+v: got (%d, %q, %v) want (%d, %q, %v)", c.speed, index, name, ok, c.index, c.name, c.ok)
+		}
+	}
+}
+
+// TestTypingTierEffectPath 验证档位路径可被快照(viper)按数组下标读取, 并在档位缺少当前状态或为鼠标时回退。
+func TestTypingTierEffectPath(t *testing.T) {
+	v := viper.New()
+	v.SetConfigType("json")
+	err := v.ReadConfig(bytes.NewBufferString(`{
+		"key_tone": {
+			"global": {
+				"down": {"type": "sounds", "value": "normal"},
+				"up": {"type": "sounds", "value": "normal_up"},
+				"speed_tiers": [
+					{"name": "fast", "max_interval_ms": 150, "down": {"type": "sounds", "value": "light"}}
+				]
+			},
+			"single": {
+				"57": {"down": {"type": "sounds", "value": "space"}}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := &AlbumSnapshot{Viper: v}
+	fast := TypingSpeed{IntervalMS: 90}
+	slow := TypingSpeed{IntervalMS: 900}
+
+	path, tier := typingTierEffectPath(snapshot.GetValue, "key_tone.global", "30", KeyStateDown, fast)
+	if path != "key_tone.global.speed_tiers.0.down" || tier != "fast" || snapshot.GetValue(path+".value") != "light" {
+		t.Fatalf("unexpected fast path: %q %q", path, tier)
+	}
+	if path, tier := typingTierEffectPath(snapshot.GetValue, "key_tone.global", "30", KeyStateUp, fast); path != "key_tone.global.up" || tier != "" {
+		t.Fatalf("expected tier without up to fall back: %q %q", path, tier)
+	}
+	if path, _ := typingTierEffectPath(snapshot.GetValue, "key_tone.global", "30", KeyStateDown, slow); path != "key_tone.global.down" {
+		t.Fatalf("unexpected slow path: %q", path)
+	}
+	if path, _ := typingTierEffectPath(snapshot.GetValue, "key_tone.global", "-1", KeyStateDown, fast); path != "key_tone.global.down" {
+		t.Fatalf("expected mouse buttons to ignore speed tiers: %q", path)
+	}
+
+	// single 优先于 global: 空格键有独立配置而没有档位
+	if tier := typingTierFor(snapshot.GetValue, "57", KeyStateDown, 0, fast); tier != "" {
+		t.Fatalf("expected single config to take precedence, got %q", tier)
+	}
+	if tier := typingTierFor(snapshot.GetValue, "30", KeyStateDown, 0, fast); tier != "fast" {
+		t.Fatalf("unexpected tier: %q", tier)
+	}
+	if tier := typingTierFor(nil, "30", KeyStateDown, 0, fast); tier != "" {
+		t.Fatalf("expected no tier without an album, got %q", tier)
+	}
+}
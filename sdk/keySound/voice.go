@@ -0,0 +1,235 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package keySound
+
+// =============================
+// 复音(voice)管理说明
+// =============================
+//
+// PlayKeySound 的每次播放即为一个 voice。快速连击或长尾音时, 同时发声的 voice 会无限叠加, 造成 CPU 占用与削波。
+// 因此在交给输出后端之前, 每个 voice 都需先向 playbackVoices 申请名额:
+//   1. 单键上限(playback.voices.max_voices_per_key): 同一按键已达上限时, 切断该按键最早的声音;
+//   2. 全局上限(playback.voices.max_voices): 已达上限时, 淘汰(steal)全局最早的声音;
+//   3. 被切断/淘汰的 voice 不会被立刻截断(会产生爆音), 而是在 steal_fade_ms 内线性淡出后结束,
+//      随后 beep.Callback 照常触发, PlayKeySound 按原流程释放资源。
+// 淡出中的 voice 不再计入名额。
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"KeyTone/config"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// voice 包装一次播放的最终 Streamer(已经过音量处理链), 支持被淘汰时淡出。
+type voice struct {
+	id       uint64
+	keycode  string
+	streamer beep.Streamer
+	manager  *voiceManager
+
+	// stolen 由申请新名额的 goroutine 设置, fadeSamples 须在其之前写入
+	stolen      atomic.Bool
+	fadeSamples atomic.Int64
+	released    atomic.Bool
+
+	// 以下字段仅在输出后端的消费 goroutine 中访问
+	fading    bool
+	fadeTotal int
+	fadeLeft  int
+}
+
+func (v *voice) Stream(samples [][2]float64) (int, bool) {
+	if v.stolen.Load() && !v.fading {
+		v.fading = true
+		v.fadeTotal = int(v.fadeSamples.Load())
+		v.fadeLeft = v.fadeTotal
+	}
+	if v.fading {
+		if v.fadeLeft <= 0 {
+			v.release()
+			return 0, false
+		}
+		if len(samples) > v.fadeLeft {
+			samples = samples[:v.fadeLeft]
+		}
+	}
+
+	n, ok := v.streamer.Stream(samples)
+	if v.fading {
+		// 线性淡出: 增益从剩余比例逐样本降到 0
+		for i := 0; i < n; i++ {
+			gain := float64(v.fadeLeft-i) / float64(v.fadeTotal+1)
+			samples[i][0] *= gain
+			samples[i][1] *= gain
+		}
+		v.fadeLeft -= n
+	}
+	if !ok {
+		v.release()
+	}
+	return n, ok
+}
+
+func (v *voice) Err() error {
+	return v.streamer.Err()
+}
+
+// release 归还名额(可重复调用)。
+func (v *voice) release() {
+	if v.released.CompareAndSwap(false, true) {
+		v.manager.remove(v)
+	}
+}
+
+// VoiceStats 为复音管理的运行统计, 供诊断接口展示。
+type VoiceStats struct {
+	// Active 为当前占用名额的 voice 数量(不含淡出中的 voice)
+	Active int `json:"active"`
+	// PerKey 为各按键当前占用名额的 voice 数量
+	PerKey map[string]int `json:"perKey"`
+	Peak   int            `json:"peak"`
+	// Started 为累计申请名额的播放次数
+	Started uint64 `json:"started"`
+	// Stolen 为因全局上限被淘汰的次数
+	Stolen uint64 `json:"stolen"`
+	// KeyCut 为因单键上限被切断的次数
+	KeyCut          uint64 `json:"keyCut"`
+	MaxVoices       int    `json:"maxVoices"`
+	MaxVoicesPerKey int    `json:"maxVoicesPerKey"`
+}
+
+type voiceManager struct {
+	mutex  sync.Mutex
+	nextID uint64
+	// active 按开始时间排序, 不含已被淘汰(淡出中)的 voice
+	active []*voice
+
+	peak    int
+	started uint64
+	stolen  uint64
+	keyCut  uint64
+}
+
+var playbackVoices = &voiceManager{}
+
+// acquire 为 streamer 申请一个名额, 必要时淘汰已有 voice。maxVoices/maxPerKey 为 0 表示不限制。
+func (m *voiceManager) acquire(keycode string, streamer beep.Streamer, maxVoices int, maxPerKey int, fadeSamples int) *voice {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if maxPerKey > 0 && keycode != "" {
+		count := 0
+		for _, active := range m.active {
+			if active.keycode == keycode {
+				count++
+			}
+		}
+		for i := 0; count >= maxPerKey && i < len(m.active); {
+			if m.active[i].keycode != keycode {
+				i++
+				continue
+			}
+			m.stealAt(i, fadeSamples)
+			m.keyCut++
+			count--
+		}
+	}
+	for maxVoices > 0 && len(m.active) >= maxVoices {
+		m.stealAt(0, fadeSamples)
+		m.stolen++
+	}
+
+	m.nextID++
+	v := &voice{id: m.nextID, keycode: keycode, streamer: streamer, manager: m}
+	m.active = append(m.active, v)
+	m.started++
+	m.peak = max(m.peak, len(m.active))
+	return v
+}
+
+// stealAt 令 active[i] 开始淡出, 并将其移出名额(调用方需持有锁)。
+func (m *voiceManager) stealAt(i int, fadeSamples int) {
+	v := m.active[i]
+	v.fadeSamples.Store(int64(fadeSamples))
+	v.stolen.Store(true)
+	m.active = append(m.active[:i], m.active[i+1:]...)
+}
+
+func (m *voiceManager) remove(v *voice) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, active := range m.active {
+		if active == v {
+			m.active = append(m.active[:i], m.active[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *voiceManager) stats() VoiceStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	perKey := make(map[string]int)
+	for _, active := range m.active {
+		perKey[active.keycode]++
+	}
+	return VoiceStats{
+		Active:  len(m.active),
+		PerKey:  perKey,
+		Peak:    m.peak,
+		Started: m.started,
+		Stolen:  m.stolen,
+		KeyCut:  m.keyCut,
+	}
+}
+
+// voiceSettings 读取复音上限与淘汰淡出时长。
+func voiceSettings() (int, int, time.Duration) {
+	maxVoices, ok := config.GetValue("playback.voices.max_voices").(float64)
+	if !ok {
+		maxVoices = config.Playback___voices___max_voices
+		go config.SetValue("playback.voices.max_voices", maxVoices)
+	}
+
+	maxVoicesPerKey, ok := config.GetValue("playback.voices.max_voices_per_key").(float64)
+	if !ok {
+		maxVoicesPerKey = config.Playback___voices___max_voices_per_key
+		go config.SetValue("playback.voices.max_voices_per_key", maxVoicesPerKey)
+	}
+
+	stealFadeMS, ok := config.GetValue("playback.voices.steal_fade_ms").(float64)
+	if !ok {
+		stealFadeMS = config.Playback___voices___steal_fade_ms
+		go config.SetValue("playback.voices.steal_fade_ms", stealFadeMS)
+	}
+
+	return max(int(maxVoices), 0), max(int(maxVoicesPerKey), 0), time.Duration(max(stealFadeMS, 0) * float64(time.Millisecond))
+}
+
+// GetVoiceStats 返回复音管理的运行统计。
+func GetVoiceStats() VoiceStats {
+	stats := playbackVoices.stats()
+	stats.MaxVoices, stats.MaxVoicesPerKey, _ = voiceSettings()
+	return stats
+}
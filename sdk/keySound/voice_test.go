@@ -0,0 +1,117 @@
+package keySound
+
+import (
+	"testing"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// voiceTestConfig 为 PlayKeySound 读取的复音设置(见 useTestConfig)。
+var voiceTestConfig = map[string]any{
+	"playback.voices.max_voices":         32.0,
+	"playback.voices.max_voices_per_key": 4.0,
+	"playback.voices.steal_fade_ms":      8.0,
+}
+
+// constantStreamer 输出恒定幅度为 1 的无限长流。
+type constantStreamer struct{}
+
+func (constantStreamer) Stream(samples [][2]float64) (int, bool) {
+	for i := range samples {
+		samples[i] = [2]float64{1, 1}
+	}
+	return len(samples), true
+}
+
+func (constantStreamer) Err() error { return nil }
+
+// drainVoice 持续读取 v 直至结束, 返回读取的帧与总帧数; 超过 limit 帧仍未结束时测试失败。
+func drainVoice(t *testing.T, v *voice, limit int) [][2]float64 {
+	t.Helper()
+	var out [][2]float64
+	buf := make([][2]float64, 64)
+	for len(out) <= limit {
+		n, ok := v.Stream(buf)
+		out = append(out, buf[:n]...)
+		if !ok {
+			return out
+		}
+	}
+	t.Fatalf("voice did not end within %d frames", limit)
+	return nil
+}
+
+// TestVoiceManagerStealsOldestVoice 验证达到全局上限时淘汰最早的 voice, 且被淘汰者淡出后结束。
+func TestVoiceManagerStealsOldestVoice(t *testing.T) {
+	m := &voiceManager{}
+	first := m.acquire("30", constantStreamer{}, 2, 0, 100)
+	second := m.acquire("31", constantStreamer{}, 2, 0, 100)
+	third := m.acquire("32", constantStreamer{}, 2, 0, 100)
+
+	stats := m.stats()
+	if stats.Active != 2 || stats.Stolen != 1 || stats.KeyCut != 0 || stats.Started != 3 || stats.Peak != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if !first.stolen.Load() || second.stolen.Load() || third.stolen.Load() {
+		t.Fatal("expected only the oldest voice to be stolen")
+	}
+
+	frames := drainVoice(t, first, 1000)
+	if len(frames) != 100 {
+		t.Fatalf("expected stolen voice to fade out over 100 frames, got %d", len(frames))
+	}
+	for i := 1; i < len(frames); i++ {
+		if frames[i][0] >= frames[i-1][0] || frames[i][0] < 0 {
+			t.Fatalf("expected a decreasing fade, frame %d: %v -> %v", i, frames[i-1][0], frames[i][0])
+		}
+	}
+	if frames[0][0] > 1 || frames[len(frames)-1][0] > 0.02 {
+		t.Fatalf("unexpected fade range: %v .. %v", frames[0][0], frames[len(frames)-1][0])
+	}
+}
+
+// TestVoiceManagerCutsSameKey 验证单键上限只切断同一按键最早的声音。
+func TestVoiceManagerCutsSameKey(t *testing.T) {
+	m := &voiceManager{}
+	a1 := m.acquire("30", constantStreamer{}, 0, 2, 0)
+	b := m.acquire("31", constantStreamer{}, 0, 2, 0)
+	a2 := m.acquire("30", constantStreamer{}, 0, 2, 0)
+	a3 := m.acquire("30", constantStreamer{}, 0, 2, 0)
+
+	if !a1.stolen.Load() || b.stolen.Load() || a2.stolen.Load() || a3.stolen.Load() {
+		t.Fatal("expected only the oldest voice of key 30 to be cut")
+	}
+	stats := m.stats()
+	if stats.Active != 3 || stats.KeyCut != 1 || stats.Stolen != 0 || stats.PerKey["30"] != 2 || stats.PerKey["31"] != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// 淡出时长为 0 时立即结束
+	if frames := drainVoice(t, a1, 0); len(frames) != 0 {
+		t.Fatalf("expected cut voice to end immediately, got %d frames", len(frames))
+	}
+
+	// 预览(无 keycode)不受单键上限约束
+	m.acquire("", constantStreamer{}, 0, 1, 0)
+	m.acquire("", constantStreamer{}, 0, 1, 0)
+	if stats := m.stats(); stats.KeyCut != 1 || stats.PerKey[""] != 2 {
+		t.Fatalf("unexpected stats after preview voices: %+v", stats)
+	}
+}
+
+// TestVoiceReleasesOnNaturalEnd 验证自然播放结束或重复释放后名额正确归还。
+func TestVoiceReleasesOnNaturalEnd(t *testing.T) {
+	m := &voiceManager{}
+	v := m.acquire("30", beep.Take(10, constantStreamer{}), 0, 0, 0)
+	other := m.acquire("31", constantStreamer{}, 0, 0, 0)
+
+	if frames := drainVoice(t, v, 100); len(frames) != 10 {
+		t.Fatalf("expected 10 frames, got %d", len(frames))
+	}
+	v.release()
+	other.release()
+	other.release()
+	if stats := m.stats(); stats.Active != 0 || stats.Peak != 2 || stats.Started != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
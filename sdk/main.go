@@ -23,13 +23,17 @@ import (
 	audioPackageConfig "KeyTone/audioPackage/config"
 	"KeyTone/config"
 	"KeyTone/keyEvent"
+	"KeyTone/keySound"
 	"KeyTone/logger"
 	"KeyTone/server"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -45,6 +49,15 @@ var ConfigPath string
 // 定义日志文件路径的命令行参数
 var LogPathAndName string
 
+// 定义音频输出后端的命令行参数(speaker | memory | file | null)
+var AudioBackend string
+
+// 定义 file 音频输出后端写出的 WAV 文件路径
+var AudioOutputFile string
+
+// 定义输入事件源的命令行参数(hook | inject)
+var InputSource string
+
 // https://github.com/gopxl/beep/issues/179 此测试代码块对于简单的内存泄漏检测很有帮助, 之前曾借助其定位过beep的内存泄漏问题。
 func PrintMemUsage() {
 	var m runtime.MemStats
@@ -88,13 +101,16 @@ func init() {
 		flag.StringVar(&ConfigPath, "configPath", ".", "Path to the config file")
 		flag.StringVar(&audioPackageConfig.AudioPackagePath, "audioPackagePath", "./temporaryDebug", "Path to the Audio Package Root Dir")
 		flag.StringVar(&LogPathAndName, "logPathAndName", "./log.jsonl", "Path and name to the log file")
+		flag.StringVar(&AudioBackend, "audioBackend", keySound.AudioBackendSpeaker, "Audio output backend: speaker | memory | file | null")
+		flag.StringVar(&InputSource, "inputSource", keyEvent.InputSourceHook, "Input event source: hook (gohook + injector) | inject (injector only)")
+		flag.StringVar(&AudioOutputFile, "audioOutputFile", "./keytone_output.wav", "Path of the wav file written by the 'file' audio backend")
 
 		// 解析命令行参数
 		flag.Parse()
 
 		// 使用命令行参数
 		// ...
-		slog.Info("命行参数已正确解析", "configPath", ConfigPath, "audioPackagePath", audioPackageConfig.AudioPackagePath, "logPathAndName", LogPathAndName)
+		slog.Info("命行参数已正确解析", "configPath", ConfigPath, "audioPackagePath", audioPackageConfig.AudioPackagePath, "logPathAndName", LogPathAndName, "audioBackend", AudioBackend, "inputSource", InputSource)
 	}
 
 	// 初始化模块
@@ -138,6 +154,22 @@ func init() {
 				logger.Info("配置文件路径已存在且无异常。", "你的配置文件路径为", ConfigPath)
 			}
 			config.ConfigRun(ConfigPath)
+
+			// 波形峰值与频谱图的磁盘缓存放在配置目录下, 避免随专辑一起导出
+			keySound.SetAnalysisCacheDir(filepath.Join(ConfigPath, "analysis_cache"))
+			// 通过接口录制的输入事件文件只能位于配置目录下的 recordings 中
+			keyEvent.SetRecordingDir(filepath.Join(ConfigPath, "recordings"))
+		}
+
+		// 初始化音频输出后端
+		{
+			if err := keySound.InitAudioOutput(AudioBackend, AudioOutputFile); err != nil {
+				// 指定的后端不可用时(如无声卡的机器上使用 speaker), 退回到 null 后端以保证 SDK 其余功能正常运行。
+				logger.Error("音频输出后端初始化失败, 已回退到 null 后端。", "audioBackend", AudioBackend, "err", err.Error())
+				keySound.InitAudioOutput(keySound.AudioBackendNull, "")
+			} else {
+				logger.Info("音频输出后端已初始化。", "audioBackend", keySound.CurrentAudioOutput().Name())
+			}
 		}
 
 	}
@@ -145,6 +177,24 @@ func init() {
 }
 
 func main() {
+	// 进程退出前关闭音频输出后端(file 后端会在此时写出 WAV 文件)。
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		if err := keySound.CloseAudioOutput(); err != nil {
+			logger.Error("关闭音频输出后端时发生错误。", "err", err.Error())
+		}
+		os.Exit(0)
+	}()
+
 	go server.ServerRun()
-	keyEvent.KeyEventListen()
+
+	sources, err := keyEvent.EventSourcesByName(InputSource)
+	if err != nil {
+		logger.Error("输入事件源参数无效, 已使用默认事件源。", "inputSource", InputSource, "err", err.Error())
+		keyEvent.KeyEventListen()
+		return
+	}
+	keyEvent.KeyEventListenWith(sources...)
 }
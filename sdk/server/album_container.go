@@ -0,0 +1,357 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+// =============================
+// KTALBUM v3 容器格式说明
+// =============================
+//
+// v1/v2 使用静态密钥对整个 zip 做 XOR, 并在文件头中保存明文的 SHA-256。
+// v3 改为带认证的分块加密:
+//
+//   文件头(48 字节, 与 v1/v2 的 KeytoneFileHeader 等长, 旧代码可照常读取后按 Version 分派):
+//     Signature [7]byte  "KTALBUM"
+//     Version   uint8    3
+//     DataSize  uint64   密文总长度(所有分块密文 + 认证标签)
+//     Cipher    uint8    1 = AES-256-GCM
+//     Reserved  [3]byte  保留, 必须为 0
+//     ChunkSize uint32   明文分块大小
+//     PlainSize uint64   明文(zip)总长度
+//     Salt      [16]byte 每个文件随机生成
+//
+//   数据区: 依次为每个分块的 AES-GCM 密文(明文长度 + 16 字节标签)。
+//     - 文件密钥 = HKDF-SHA256(版本密钥, Salt, "KTALBUM v3 AES-256-GCM"), 每个文件各不相同;
+//     - 分块 nonce = 8 字节大端分块序号 + 3 字节 0 + 1 字节结束标记(最后一个分块为 1);
+//     - 每个分块都以完整的 48 字节文件头作为附加认证数据(AAD)。
+//
+// 因此: 篡改文件头或任一分块都会在该分块解密时立即失败; 截断/重排分块也会因序号与结束标记不匹配而失败。
+
+import (
+	"KeyTone/logger"
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// KeytoneFileVersionV3 为带认证加密的容器版本, 也是当前导出使用的版本。
+	KeytoneFileVersionV3 = 3
+	// KeytoneFileVersionCurrent 为导出时使用的文件版本。
+	KeytoneFileVersionCurrent = KeytoneFileVersionV3
+
+	albumCipherAES256GCM = 1
+	albumChunkSizeV3     = 64 * 1024
+	// albumMaxChunkSizeV3 限制读取时可接受的分块大小, 防止恶意文件头导致超大内存分配。
+	albumMaxChunkSizeV3 = 16 * 1024 * 1024
+	albumHKDFInfoV3     = "KTALBUM v3 AES-256-GCM"
+)
+
+// KeytoneFileHeaderV3 为 v3 文件头, 与 KeytoneFileHeader 等长。
+type KeytoneFileHeaderV3 struct {
+	Signature [7]byte
+	Version   uint8
+	DataSize  uint64
+	Cipher    uint8
+	Reserved  [3]byte
+	ChunkSize uint32
+	PlainSize uint64
+	Salt      [16]byte
+}
+
+// bytes 返回文件头的二进制形式(即写入文件的 48 字节, 同时用作 AAD)。
+func (h KeytoneFileHeaderV3) bytes() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, h)
+	return buffer.Bytes()
+}
+
+// headerV3FromLegacy 将按 v1/v2 结构读取的 48 字节文件头重新解释为 v3 文件头。
+func headerV3FromLegacy(header KeytoneFileHeader) (KeytoneFileHeaderV3, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, header)
+	var headerV3 KeytoneFileHeaderV3
+	if err := binary.Read(buffer, binary.LittleEndian, &headerV3); err != nil {
+		return KeytoneFileHeaderV3{}, err
+	}
+	return headerV3, nil
+}
+
+// legacyHeaderFromV3 将 v3 文件头转换为 KeytoneFileHeader, 以便沿用既有的读写代码。
+func legacyHeaderFromV3(headerV3 KeytoneFileHeaderV3) KeytoneFileHeader {
+	var header KeytoneFileHeader
+	binary.Read(bytes.NewReader(headerV3.bytes()), binary.LittleEndian, &header)
+	return header
+}
+
+// albumChunkCountV3 返回明文对应的分块数量(空明文也会产生一个结束分块)。
+func albumChunkCountV3(plainSize uint64, chunkSize uint32) uint64 {
+	if plainSize == 0 {
+		return 1
+	}
+	return (plainSize + uint64(chunkSize) - 1) / uint64(chunkSize)
+}
+
+// newAlbumHeaderV3 为给定长度的明文生成带随机 Salt 的 v3 文件头。
+func newAlbumHeaderV3(plainSize uint64) (KeytoneFileHeaderV3, error) {
+	header := KeytoneFileHeaderV3{
+		Version:   KeytoneFileVersionV3,
+		Cipher:    albumCipherAES256GCM,
+		ChunkSize: albumChunkSizeV3,
+		PlainSize: plainSize,
+	}
+	copy(header.Signature[:], KeytoneFileSignature)
+	if _, err := rand.Read(header.Salt[:]); err != nil {
+		return KeytoneFileHeaderV3{}, fmt.Errorf("生成随机盐失败: %w", err)
+	}
+	header.DataSize = plainSize + albumChunkCountV3(plainSize, header.ChunkSize)*16
+	return header, nil
+}
+
+// newAlbumAEADV3 由版本密钥与文件头中的 Salt 派生出该文件专用的 AES-256-GCM 实例。
+func newAlbumAEADV3(header KeytoneFileHeaderV3, secret string) (cipher.AEAD, error) {
+	if header.Cipher != albumCipherAES256GCM {
+		return nil, fmt.Errorf("不支持的加密算法: %d", header.Cipher)
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), header.Salt[:], albumHKDFInfoV3, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// albumNonceV3 返回第 index 个分块的 nonce。
+func albumNonceV3(index uint64, isFinal bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[:8], index)
+	if isFinal {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// validateAlbumHeaderV3 在解密前检查文件头各字段的一致性。
+func validateAlbumHeaderV3(header KeytoneFileHeaderV3) error {
+	if header.Version != KeytoneFileVersionV3 {
+		return fmt.Errorf("不是 v3 文件头: %d", header.Version)
+	}
+	if header.Reserved != [3]byte{} {
+		return errors.New("文件头保留字段无效")
+	}
+	if header.ChunkSize == 0 || header.ChunkSize > albumMaxChunkSizeV3 {
+		return fmt.Errorf("文件头分块大小无效: %d", header.ChunkSize)
+	}
+	if header.DataSize != header.PlainSize+albumChunkCountV3(header.PlainSize, header.ChunkSize)*16 {
+		return errors.New("文件头数据长度不一致")
+	}
+	return nil
+}
+
+// encryptAlbumStreamV3 将 src 中长度为 header.PlainSize 的明文分块加密后写入 dst(不含文件头)。
+func encryptAlbumStreamV3(dst io.Writer, src io.Reader, header KeytoneFileHeaderV3, secret string) error {
+	aead, err := newAlbumAEADV3(header, secret)
+	if err != nil {
+		return err
+	}
+	aad := header.bytes()
+	chunkCount := albumChunkCountV3(header.PlainSize, header.ChunkSize)
+	remaining := header.PlainSize
+	plain := make([]byte, header.ChunkSize)
+	sealed := make([]byte, 0, int(header.ChunkSize)+aead.Overhead())
+	for index := uint64(0); index < chunkCount; index++ {
+		size := uint64(header.ChunkSize)
+		if remaining < size {
+			size = remaining
+		}
+		if _, err := io.ReadFull(src, plain[:size]); err != nil {
+			return fmt.Errorf("读取明文数据失败: %w", err)
+		}
+		remaining -= size
+		sealed = aead.Seal(sealed[:0], albumNonceV3(index, index == chunkCount-1), plain[:size], aad)
+		if _, err := dst.Write(sealed); err != nil {
+			return fmt.Errorf("写入加密数据失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// decryptAlbumStreamV3 从 src 逐块解密并认证, 将明文写入 dst(src 位于文件头之后)。
+// 任一分块认证失败时立即返回错误, 不会继续读取后续数据。
+func decryptAlbumStreamV3(dst io.Writer, src io.Reader, header KeytoneFileHeaderV3, secret string) error {
+	if err := validateAlbumHeaderV3(header); err != nil {
+		return err
+	}
+	aead, err := newAlbumAEADV3(header, secret)
+	if err != nil {
+		return err
+	}
+	aad := header.bytes()
+	chunkCount := albumChunkCountV3(header.PlainSize, header.ChunkSize)
+	remaining := header.PlainSize
+	sealed := make([]byte, int(header.ChunkSize)+aead.Overhead())
+	plain := make([]byte, 0, header.ChunkSize)
+	for index := uint64(0); index < chunkCount; index++ {
+		size := uint64(header.ChunkSize)
+		if remaining < size {
+			size = remaining
+		}
+		chunk := sealed[:size+uint64(aead.Overhead())]
+		if _, err := io.ReadFull(src, chunk); err != nil {
+			return fmt.Errorf("读取加密数据失败: %w", err)
+		}
+		plain, err = aead.Open(plain[:0], albumNonceV3(index, index == chunkCount-1), chunk, aad)
+		if err != nil {
+			return fmt.Errorf("文件校验失败，文件可能已被篡改或密钥不匹配(分块 %d)", index)
+		}
+		remaining -= size
+		if _, err := dst.Write(plain); err != nil {
+			return fmt.Errorf("写入解密数据失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// encryptAlbumDataV3 使用当前 v3 密钥加密 zip 数据, 返回与 v1/v2 相同形式的文件头以及密文。
+func encryptAlbumDataV3(zipData []byte) (KeytoneFileHeader, []byte, error) {
+	headerV3, err := newAlbumHeaderV3(uint64(len(zipData)))
+	if err != nil {
+		return KeytoneFileHeader{}, nil, err
+	}
+	encrypted := bytes.NewBuffer(make([]byte, 0, headerV3.DataSize))
+	if err := encryptAlbumStreamV3(encrypted, bytes.NewReader(zipData), headerV3, getEncryptKeyByVersion(KeytoneFileVersionV3)); err != nil {
+		return KeytoneFileHeader{}, nil, err
+	}
+	return legacyHeaderFromV3(headerV3), encrypted.Bytes(), nil
+}
+
+// decryptAlbumDataV3 解密 v3 文件的数据区。
+func decryptAlbumDataV3(encryptedData []byte, header KeytoneFileHeader) ([]byte, error) {
+	headerV3, err := headerV3FromLegacy(header)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(encryptedData)) != headerV3.DataSize {
+		return nil, errors.New("文件数据长度与文件头不一致")
+	}
+	plain := bytes.NewBuffer(make([]byte, 0, headerV3.PlainSize))
+	if err := decryptAlbumStreamV3(plain, bytes.NewReader(encryptedData), headerV3, getEncryptKeyByVersion(KeytoneFileVersionV3)); err != nil {
+		return nil, err
+	}
+	return plain.Bytes(), nil
+}
+
+// decryptAlbumToFile 将 src 中的加密数据区流式解密写入 dstPath, 返回实际使用的密钥版本。
+// 内存占用与文件大小无关: v3 逐块认证; v1/v2 逐块 XOR 并增量计算校验和,
+// 同时以 v1 密钥计算一份候选校验和, 若仅 v1 匹配, 则在文件上原地改写为 v1 解密结果。
+func decryptAlbumToFile(src io.Reader, header KeytoneFileHeader, dstPath string) (uint8, error) {
+	dst, err := os.OpenFile(dstPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer dst.Close()
+
+	if header.Version == KeytoneFileVersionV3 {
+		headerV3, err := headerV3FromLegacy(header)
+		if err != nil {
+			return 0, err
+		}
+		buffered := bufio.NewWriterSize(dst, albumChunkSizeV3)
+		if err := decryptAlbumStreamV3(buffered, src, headerV3, getEncryptKeyByVersion(KeytoneFileVersionV3)); err != nil {
+			return 0, err
+		}
+		if err := buffered.Flush(); err != nil {
+			return 0, fmt.Errorf("写入临时文件失败: %w", err)
+		}
+		return header.Version, nil
+	}
+
+	primaryKey := []byte(getEncryptKeyByVersion(header.Version))
+	fallbackKey := []byte(getEncryptKeyByVersion(1))
+	tryFallback := header.Version != 1
+	primaryHash, fallbackHash := sha256.New(), sha256.New()
+
+	encrypted := make([]byte, albumChunkSizeV3)
+	plain := make([]byte, albumChunkSizeV3)
+	alternative := make([]byte, albumChunkSizeV3)
+	var offset uint64
+	for offset < header.DataSize {
+		size := uint64(len(encrypted))
+		if header.DataSize-offset < size {
+			size = header.DataSize - offset
+		}
+		if _, err := io.ReadFull(src, encrypted[:size]); err != nil {
+			return 0, fmt.Errorf("读取文件数据失败: %w", err)
+		}
+		for i := uint64(0); i < size; i++ {
+			plain[i] = encrypted[i] ^ primaryKey[(offset+i)%uint64(len(primaryKey))]
+			if tryFallback {
+				alternative[i] = encrypted[i] ^ fallbackKey[(offset+i)%uint64(len(fallbackKey))]
+			}
+		}
+		primaryHash.Write(plain[:size])
+		if tryFallback {
+			fallbackHash.Write(alternative[:size])
+		}
+		if _, err := dst.Write(plain[:size]); err != nil {
+			return 0, fmt.Errorf("写入临时文件失败: %w", err)
+		}
+		offset += size
+	}
+
+	if bytes.Equal(primaryHash.Sum(nil), header.Checksum[:]) {
+		return header.Version, nil
+	}
+	if !tryFallback || !bytes.Equal(fallbackHash.Sum(nil), header.Checksum[:]) {
+		return 0, fmt.Errorf("文件校验失败，文件可能已损坏或使用了不支持的加密版本")
+	}
+
+	// 仅 v1 密钥校验通过: 将已写入的数据原地转换为 v1 解密结果。
+	logger.Warn("使用版本密钥解密失败，使用v1密钥回退", "version", header.Version)
+	for offset = 0; offset < header.DataSize; {
+		size := uint64(len(plain))
+		if header.DataSize-offset < size {
+			size = header.DataSize - offset
+		}
+		if _, err := dst.ReadAt(plain[:size], int64(offset)); err != nil {
+			return 0, fmt.Errorf("读取临时文件失败: %w", err)
+		}
+		for i := uint64(0); i < size; i++ {
+			position := offset + i
+			plain[i] ^= primaryKey[position%uint64(len(primaryKey))] ^ fallbackKey[position%uint64(len(fallbackKey))]
+		}
+		if _, err := dst.WriteAt(plain[:size], int64(offset)); err != nil {
+			return 0, fmt.Errorf("写入临时文件失败: %w", err)
+		}
+		offset += size
+	}
+	return 1, nil
+}
@@ -0,0 +1,161 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+// testAlbumPayload 生成跨越多个分块且末块不满的测试数据。
+func testAlbumPayload() []byte {
+	payload := make([]byte, 2*albumChunkSizeV3+1234)
+	for i := range payload {
+		payload[i] = byte(i * 31)
+	}
+	return payload
+}
+
+// writeAndReadHeader 模拟导出写入文件头、导入端按 KeytoneFileHeader 读取的过程。
+func writeAndReadHeader(t *testing.T, header KeytoneFileHeader) KeytoneFileHeader {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	if err := binary.Write(buffer, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.Len() != 48 {
+		t.Fatalf("expected 48-byte header, got %d", buffer.Len())
+	}
+	var readBack KeytoneFileHeader
+	if err := binary.Read(buffer, binary.LittleEndian, &readBack); err != nil {
+		t.Fatal(err)
+	}
+	return readBack
+}
+
+func TestAlbumV3RoundTrip(t *testing.T) {
+	payload := testAlbumPayload()
+	header, encrypted, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatalf("encryptAlbumDataV3 returned error: %v", err)
+	}
+	if header.Version != KeytoneFileVersionV3 || string(header.Signature[:]) != KeytoneFileSignature {
+		t.Fatalf("unexpected header: %+v", header)
+	}
+	if header.DataSize != uint64(len(encrypted)) || header.DataSize != uint64(len(payload))+3*16 {
+		t.Fatalf("unexpected data size %d for %d bytes", header.DataSize, len(encrypted))
+	}
+
+	decrypted, version, err := decryptAlbumData(encrypted, writeAndReadHeader(t, header))
+	if err != nil {
+		t.Fatalf("decryptAlbumData returned error: %v", err)
+	}
+	if version != KeytoneFileVersionV3 || !bytes.Equal(decrypted, payload) {
+		t.Fatalf("round trip mismatch: version=%d len=%d", version, len(decrypted))
+	}
+}
+
+// TestAlbumV3PerFileSalt 验证同一内容两次导出得到不同的盐与密文。
+func TestAlbumV3PerFileSalt(t *testing.T) {
+	payload := []byte("same album content")
+	first, firstData, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, secondData, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Checksum == second.Checksum || bytes.Equal(firstData, secondData) {
+		t.Fatal("expected a fresh salt and ciphertext for every export")
+	}
+	if bytes.Contains(firstData, payload) {
+		t.Fatal("ciphertext leaks the plaintext")
+	}
+}
+
+func TestAlbumV3RejectsTampering(t *testing.T) {
+	payload := testAlbumPayload()
+	header, encrypted, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]func() (KeytoneFileHeader, []byte){
+		"header salt": func() (KeytoneFileHeader, []byte) {
+			tampered := header
+			tampered.Checksum[31] ^= 0x01
+			return tampered, encrypted
+		},
+		"header reserved": func() (KeytoneFileHeader, []byte) {
+			tampered := header
+			tampered.Checksum[1] = 0xFF
+			return tampered, encrypted
+		},
+		"first chunk": func() (KeytoneFileHeader, []byte) {
+			data := bytes.Clone(encrypted)
+			data[10] ^= 0x80
+			return header, data
+		},
+		"last tag": func() (KeytoneFileHeader, []byte) {
+			data := bytes.Clone(encrypted)
+			data[len(data)-1] ^= 0x01
+			return header, data
+		},
+		"truncated": func() (KeytoneFileHeader, []byte) {
+			return header, encrypted[:len(encrypted)-16]
+		},
+		"swapped chunks": func() (KeytoneFileHeader, []byte) {
+			data := bytes.Clone(encrypted)
+			sealedChunk := albumChunkSizeV3 + 16
+			copy(data[:sealedChunk], encrypted[sealedChunk:2*sealedChunk])
+			copy(data[sealedChunk:2*sealedChunk], encrypted[:sealedChunk])
+			return header, data
+		},
+	}
+	for name, build := range cases {
+		tamperedHeader, tamperedData := build()
+		if _, _, err := decryptAlbumData(tamperedData, tamperedHeader); err == nil {
+			t.Fatalf("%s: expected tampering to be detected", name)
+		}
+	}
+}
+
+// TestDecryptAlbumDataLegacyV2 验证 v2(XOR + 校验和)文件仍然可以导入。
+func TestDecryptAlbumDataLegacyV2(t *testing.T) {
+	payload := testAlbumPayload()
+	header := KeytoneFileHeader{
+		Version:  2,
+		DataSize: uint64(len(payload)),
+		Checksum: sha256.Sum256(payload),
+	}
+	copy(header.Signature[:], KeytoneFileSignature)
+	encrypted := xorCrypt(payload, getEncryptKeyByVersion(2))
+
+	decrypted, version, err := decryptAlbumData(encrypted, header)
+	if err != nil {
+		t.Fatalf("decryptAlbumData returned error: %v", err)
+	}
+	if version != 2 || !bytes.Equal(decrypted, payload) {
+		t.Fatalf("legacy v2 mismatch: version=%d", version)
+	}
+}
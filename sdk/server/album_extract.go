@@ -0,0 +1,249 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+// =============================
+// 安全解压说明
+// =============================
+//
+// 导入的 zip(来自 .ktalbum 或 Mechvibes 音效包)完全由外部提供, 解压前后需逐条校验:
+//   1. 条目路径: 拒绝绝对路径(含 Windows 盘符/UNC)、".." 路径段, 反斜杠按分隔符处理;
+//   2. 条目类型: 仅允许普通文件与目录, 拒绝符号链接及其它特殊文件;
+//   3. 资源限制: 条目数量、解压总大小、压缩比(单个条目与整体)均受限, 上限可通过设置调整;
+//   4. 实际写入: 以 O_EXCL 创建文件(重复条目或与临时文件同名时失败), 并按实际解压字节数再次核对总大小,
+//      不信任 zip 中声明的大小。
+// 所有拒绝都以带有 Reason 的 *ImportError 返回, 前端可据此给出具体提示。
+
+import (
+	"KeyTone/config"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AlbumExtractLimits 为解压时的资源限制, 0 表示不限制。
+type AlbumExtractLimits struct {
+	// MaxTotalSize 为所有条目解压后的总字节数上限
+	MaxTotalSize int64
+	// MaxFiles 为条目(文件与目录)数量上限
+	MaxFiles int
+	// MaxCompressionRatio 为压缩比(解压大小/压缩大小)上限
+	MaxCompressionRatio float64
+}
+
+// albumRatioCheckMinSize 为压缩比检查的起始大小, 体积很小的文本类文件压缩比天然较高, 不做限制。
+const albumRatioCheckMinSize = 1 << 20
+
+// DefaultAlbumExtractLimits 返回与默认设置一致的解压限制。
+func DefaultAlbumExtractLimits() AlbumExtractLimits {
+	return AlbumExtractLimits{
+		MaxTotalSize:        int64(config.Album_import___max_total_size_mb * 1024 * 1024),
+		MaxFiles:            int(config.Album_import___max_file_count),
+		MaxCompressionRatio: config.Album_import___max_compression_ratio,
+	}
+}
+
+// albumExtractLimitsFromConfig 读取用户设置中的解压限制(缺失时回退到默认值)。
+func albumExtractLimitsFromConfig() AlbumExtractLimits {
+	maxTotalSizeMB, ok := config.GetValue("album_import.max_total_size_mb").(float64)
+	if !ok {
+		maxTotalSizeMB = config.Album_import___max_total_size_mb
+		go config.SetValue("album_import.max_total_size_mb", maxTotalSizeMB)
+	}
+
+	maxFileCount, ok := config.GetValue("album_import.max_file_count").(float64)
+	if !ok {
+		maxFileCount = config.Album_import___max_file_count
+		go config.SetValue("album_import.max_file_count", maxFileCount)
+	}
+
+	maxCompressionRatio, ok := config.GetValue("album_import.max_compression_ratio").(float64)
+	if !ok {
+		maxCompressionRatio = config.Album_import___max_compression_ratio
+		go config.SetValue("album_import.max_compression_ratio", maxCompressionRatio)
+	}
+
+	return AlbumExtractLimits{
+		MaxTotalSize:        int64(maxTotalSizeMB * 1024 * 1024),
+		MaxFiles:            int(maxFileCount),
+		MaxCompressionRatio: maxCompressionRatio,
+	}
+}
+
+// zipEntryPath 校验 zip 条目名并返回以 "/" 分隔的安全相对路径。
+func zipEntryPath(name string) (string, error) {
+	normalized := strings.ReplaceAll(name, `\`, "/")
+	if normalized == "" || strings.ContainsRune(normalized, 0) {
+		return "", &ImportError{Reason: ImportReasonUnsafePath, Message: fmt.Sprintf("zip 文件中包含非法路径: %q", name)}
+	}
+	// 绝对路径: /etc/passwd、//server/share、C:/Windows、C:relative
+	if strings.HasPrefix(normalized, "/") || (len(normalized) >= 2 && normalized[1] == ':') {
+		return "", &ImportError{Reason: ImportReasonAbsolutePath, Message: fmt.Sprintf("zip 文件中包含绝对路径: %q", name)}
+	}
+	for _, segment := range strings.Split(normalized, "/") {
+		if segment == ".." {
+			return "", &ImportError{Reason: ImportReasonPathTraversal, Message: fmt.Sprintf("zip 文件中包含越界路径: %q", name)}
+		}
+	}
+	cleaned := path.Clean(normalized)
+	if cleaned == "." || !filepath.IsLocal(filepath.FromSlash(cleaned)) {
+		return "", &ImportError{Reason: ImportReasonUnsafePath, Message: fmt.Sprintf("zip 文件中包含非法路径: %q", name)}
+	}
+	return cleaned, nil
+}
+
+// checkZipEntries 在写入任何文件之前, 依据 zip 目录中的声明信息做整体校验。
+func checkZipEntries(files []*zip.File, archiveSize int64, limits AlbumExtractLimits) error {
+	if limits.MaxFiles > 0 && len(files) > limits.MaxFiles {
+		return &ImportError{Reason: ImportReasonTooManyFiles, Message: fmt.Sprintf("zip 文件条目过多: %d (上限 %d)", len(files), limits.MaxFiles)}
+	}
+
+	var totalSize uint64
+	for _, file := range files {
+		if _, err := zipEntryPath(file.Name); err != nil {
+			return err
+		}
+
+		mode := file.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return &ImportError{Reason: ImportReasonSymlink, Message: fmt.Sprintf("zip 文件中包含符号链接: %q", file.Name)}
+		}
+		if !mode.IsRegular() && !mode.IsDir() {
+			return &ImportError{Reason: ImportReasonSpecialFile, Message: fmt.Sprintf("zip 文件中包含不支持的文件类型: %q", file.Name)}
+		}
+
+		if limits.MaxCompressionRatio > 0 && file.UncompressedSize64 > albumRatioCheckMinSize &&
+			float64(file.UncompressedSize64) > float64(max(file.CompressedSize64, 1))*limits.MaxCompressionRatio {
+			return &ImportError{Reason: ImportReasonCompressionRatio, Message: fmt.Sprintf("zip 文件压缩比异常: %q", file.Name)}
+		}
+
+		totalSize += file.UncompressedSize64
+		if limits.MaxTotalSize > 0 && totalSize > uint64(limits.MaxTotalSize) {
+			return &ImportError{Reason: ImportReasonTooLarge, Message: fmt.Sprintf("zip 文件解压后过大 (上限 %d 字节)", limits.MaxTotalSize)}
+		}
+	}
+
+	// 整体压缩比: 防止大量条目共享同一段压缩数据(重叠条目型 zip 炸弹)
+	if limits.MaxCompressionRatio > 0 && archiveSize > 0 && totalSize > albumRatioCheckMinSize &&
+		float64(totalSize) > float64(archiveSize)*limits.MaxCompressionRatio {
+		return &ImportError{Reason: ImportReasonCompressionRatio, Message: "zip 文件整体压缩比异常"}
+	}
+	return nil
+}
+
+// extractZipSafely 将 zipReader 中的条目解压到 destDir(需为新建的临时目录)。
+// archiveSize 为 zip 文件自身大小, 用于整体压缩比检查; transfer 可为 nil。
+func extractZipSafely(zipReader *zip.Reader, archiveSize int64, destDir string, limits AlbumExtractLimits, transfer *albumTransfer) error {
+	if err := checkZipEntries(zipReader.File, archiveSize, limits); err != nil {
+		return err
+	}
+
+	destDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	for _, file := range zipReader.File {
+		if transfer != nil {
+			if err := transfer.ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		entryPath, _ := zipEntryPath(file.Name)
+		targetPath := filepath.Join(destDir, filepath.FromSlash(entryPath))
+		// 二次确认: 拼接后的路径必须仍位于 destDir 之内
+		if relPath, err := filepath.Rel(destDir, targetPath); err != nil || !filepath.IsLocal(relPath) {
+			return &ImportError{Reason: ImportReasonPathTraversal, Message: fmt.Sprintf("zip 文件中包含越界路径: %q", file.Name)}
+		}
+
+		if file.Mode().IsDir() {
+			if err := os.MkdirAll(targetPath, 0755); err != nil {
+				return fmt.Errorf("创建目录失败: %w", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("创建目录失败: %w", err)
+		}
+
+		outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return &ImportError{Reason: ImportReasonDuplicateEntry, Message: fmt.Sprintf("zip 文件中包含重复条目: %q", file.Name)}
+			}
+			return fmt.Errorf("创建目标文件失败: %w", err)
+		}
+
+		inFile, err := file.Open()
+		if err != nil {
+			outFile.Close()
+			return &ImportError{Reason: ImportReasonInvalidArchive, Message: fmt.Sprintf("打开源文件失败: %v", err)}
+		}
+
+		var src io.Reader = inFile
+		if transfer != nil {
+			src = transfer.reader(inFile)
+		}
+		// 不信任声明的大小: 最多读取剩余额度 + 1 字节, 超出即判定为过大
+		if limits.MaxTotalSize > 0 {
+			src = io.LimitReader(src, limits.MaxTotalSize-written+1)
+		}
+		n, err := io.Copy(outFile, src)
+		outFile.Close()
+		inFile.Close()
+		written += n
+		if err != nil {
+			if errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrChecksum) {
+				return &ImportError{Reason: ImportReasonInvalidArchive, Message: fmt.Sprintf("zip 文件已损坏: %q", file.Name)}
+			}
+			return fmt.Errorf("复制文件内容失败: %w", err)
+		}
+		if limits.MaxTotalSize > 0 && written > limits.MaxTotalSize {
+			return &ImportError{Reason: ImportReasonTooLarge, Message: fmt.Sprintf("zip 文件解压后过大 (上限 %d 字节)", limits.MaxTotalSize)}
+		}
+	}
+	return nil
+}
+
+// openZipForImport 打开待导入的 zip 文件, 将格式错误转换为 *ImportError。
+func openZipForImport(zipPath string) (*zip.ReadCloser, int64, error) {
+	info, err := os.Stat(zipPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		if errors.Is(err, zip.ErrInsecurePath) {
+			if zipReader != nil {
+				zipReader.Close()
+			}
+			return nil, 0, &ImportError{Reason: ImportReasonUnsafePath, Message: "zip 文件中包含非法路径"}
+		}
+		return nil, 0, &ImportError{Reason: ImportReasonInvalidArchive, Message: "打开zip文件失败:" + err.Error()}
+	}
+	return zipReader, info.Size(), nil
+}
@@ -0,0 +1,458 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+// =============================
+// 专辑导入/导出的流式传输说明
+// =============================
+//
+// 导出: 专辑目录 -> zip(写入临时文件, 而非内存) -> v3 分块加密 -> 直接写入响应。
+//   v3 文件头需要提前知道明文长度, 因此 zip 先落盘; 内存占用始终只有一个分块。
+// 导入: 请求体(multipart 逐段读取) -> 流式解密 -> 临时 zip 文件 -> 解压。
+//
+// 每个导入/导出都是一个传输任务(taskId 由前端通过查询参数传入, 未传入时自动生成):
+//   - 进度通过 /stream 的 messageAlbumTransfer 事件广播;
+//   - 客户端断开请求, 或调用 /keytone_pkg/cancel_album_transfer 时, 任务会被取消,
+//     正在进行的读写会立即返回错误, 临时文件由各路由的 defer 清理。
+
+import (
+	"KeyTone/logger"
+	"archive/zip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 传输任务类型
+const (
+	AlbumTransferExport = "export"
+	AlbumTransferImport = "import"
+	AlbumTransferMeta   = "meta"
+)
+
+// 传输阶段
+const (
+	AlbumTransferStagePacking    = "packing"    // 导出: 打包 zip
+	AlbumTransferStageEncrypting = "encrypting" // 导出: 加密并发送
+	AlbumTransferStageReceiving  = "receiving"  // 导入: 接收并解密
+	AlbumTransferStageExtracting = "extracting" // 导入: 解压
+	AlbumTransferStageDone       = "done"
+	AlbumTransferStageError      = "error"
+	AlbumTransferStageCanceled   = "canceled"
+)
+
+// albumTransferReportInterval 为同一阶段内两次进度广播的最小间隔。
+const albumTransferReportInterval = 200 * time.Millisecond
+
+// albumTransferClientBuffer 为每个 SSE 客户端可积压的进度条数。
+// 广播不等待任何客户端; 积压写满说明该客户端跟不上广播, 会被移除并断开(前端的 EventSource 会自动重连)。
+const albumTransferClientBuffer = 64
+
+// AlbumTransferProgress 为通过 SSE 广播的传输进度。
+type AlbumTransferProgress struct {
+	TaskID string `json:"taskId"`
+	Kind   string `json:"kind"`
+	Stage  string `json:"stage"`
+	Done   int64  `json:"done"`
+	Total  int64  `json:"total"`
+	Error  string `json:"error,omitempty"`
+}
+
+// albumTransferClient 为订阅传输进度的一个 SSE 客户端。
+type albumTransferClient struct {
+	progress chan *AlbumTransferProgress
+	// lagging 在客户端因积压写满而被移除时关闭, /stream 据此断开连接
+	lagging chan struct{}
+}
+
+// AlbumTransfer_sse_stores 保存订阅传输进度的 SSE 客户端(*albumTransferClient -> struct{})。
+var AlbumTransfer_sse_stores sync.Map
+
+// albumTransferTasks 保存进行中的传输任务(taskId -> *albumTransfer), 用于取消。
+var albumTransferTasks sync.Map
+
+// subscribeAlbumTransfer 登记一个 SSE 客户端, 调用方需在连接结束时从 AlbumTransfer_sse_stores 中删除它。
+func subscribeAlbumTransfer() *albumTransferClient {
+	client := &albumTransferClient{
+		progress: make(chan *AlbumTransferProgress, albumTransferClientBuffer),
+		lagging:  make(chan struct{}),
+	}
+	AlbumTransfer_sse_stores.Store(client, struct{}{})
+	return client
+}
+
+// broadcastAlbumTransfer 以非阻塞方式向每个客户端投递进度, 单个缓慢的客户端不会拖慢其余客户端与传输本身。
+func broadcastAlbumTransfer(progress *AlbumTransferProgress) {
+	AlbumTransfer_sse_stores.Range(func(key, value interface{}) bool {
+		client := key.(*albumTransferClient)
+		select {
+		case client.progress <- progress:
+		default:
+			// 积压已满: 移除该客户端并通知断开(LoadAndDelete 保证并发广播时只关闭一次)
+			if _, loaded := AlbumTransfer_sse_stores.LoadAndDelete(client); loaded {
+				logger.Warn("SSE 客户端接收传输进度过慢, 已断开")
+				close(client.lagging)
+			}
+		}
+		return true
+	})
+}
+
+// albumTransfer 为单个导入/导出任务, 仅在处理该请求的 goroutine 中使用。
+type albumTransfer struct {
+	ID   string
+	Kind string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	stage      string
+	done       int64
+	total      int64
+	lastReport time.Time
+}
+
+// startAlbumTransfer 以请求的上下文创建传输任务, 并登记以便取消。调用方需 defer end()。
+func startAlbumTransfer(ctx *gin.Context, kind string) *albumTransfer {
+	id := ctx.Query("taskId")
+	if id == "" {
+		id, _ = generateNanoID()
+	}
+	transferCtx, cancel := context.WithCancel(ctx.Request.Context())
+	transfer := &albumTransfer{ID: id, Kind: kind, ctx: transferCtx, cancel: cancel}
+	if previous, loaded := albumTransferTasks.Swap(id, transfer); loaded {
+		// 同一 taskId 的旧任务已无法再被前端区分, 直接取消
+		previous.(*albumTransfer).cancel()
+	}
+	return transfer
+}
+
+// end 结束任务: 从登记表中移除并释放上下文。
+func (t *albumTransfer) end() {
+	albumTransferTasks.CompareAndDelete(t.ID, t)
+	t.cancel()
+}
+
+// canceled 报告任务是否已被取消(客户端断开或调用了取消接口)。
+func (t *albumTransfer) canceled() bool {
+	return t.ctx.Err() != nil
+}
+
+func (t *albumTransfer) report(force bool) {
+	if !force && time.Since(t.lastReport) < albumTransferReportInterval {
+		return
+	}
+	t.lastReport = time.Now()
+	broadcastAlbumTransfer(&AlbumTransferProgress{
+		TaskID: t.ID,
+		Kind:   t.Kind,
+		Stage:  t.stage,
+		Done:   t.done,
+		Total:  t.total,
+	})
+}
+
+// setStage 进入新的阶段, total 为该阶段需要处理的字节数(未知时为 0)。
+func (t *albumTransfer) setStage(stage string, total int64) {
+	t.stage = stage
+	t.done = 0
+	t.total = total
+	t.report(true)
+}
+
+func (t *albumTransfer) add(n int) {
+	t.done += int64(n)
+	t.report(t.total > 0 && t.done >= t.total)
+}
+
+// finish 广播任务完成。
+func (t *albumTransfer) finish() {
+	t.stage = AlbumTransferStageDone
+	t.report(true)
+}
+
+// fail 广播任务失败或被取消。
+func (t *albumTransfer) fail(err error) {
+	progress := &AlbumTransferProgress{TaskID: t.ID, Kind: t.Kind, Stage: AlbumTransferStageError, Done: t.done, Total: t.total}
+	if t.canceled() {
+		progress.Stage = AlbumTransferStageCanceled
+	} else if err != nil {
+		progress.Error = err.Error()
+	}
+	broadcastAlbumTransfer(progress)
+}
+
+// abort 广播失败并以统一格式响应错误; 任务被取消时响应"操作已取消"。
+func (t *albumTransfer) abort(ctx *gin.Context, status int, message string) {
+	if t.canceled() {
+		t.fail(nil)
+		logger.Info("专辑传输任务已取消", "taskId", t.ID, "kind", t.Kind)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "error: 操作已取消",
+		})
+		return
+	}
+	t.fail(errors.New(message))
+	ctx.JSON(status, gin.H{
+		"message": "error: " + message,
+	})
+}
+
+type albumTransferReader struct {
+	transfer *albumTransfer
+	reader   io.Reader
+}
+
+func (r *albumTransferReader) Read(p []byte) (int, error) {
+	if err := r.transfer.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(p)
+	r.transfer.add(n)
+	return n, err
+}
+
+type albumTransferWriter struct {
+	transfer *albumTransfer
+	writer   io.Writer
+}
+
+func (w *albumTransferWriter) Write(p []byte) (int, error) {
+	if err := w.transfer.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.writer.Write(p)
+	w.transfer.add(n)
+	return n, err
+}
+
+// reader 返回统计进度并响应取消的 Reader。
+func (t *albumTransfer) reader(r io.Reader) io.Reader {
+	return &albumTransferReader{transfer: t, reader: r}
+}
+
+// writer 返回统计进度并响应取消的 Writer。
+func (t *albumTransfer) writer(w io.Writer) io.Writer {
+	return &albumTransferWriter{transfer: t, writer: w}
+}
+
+// albumDirSize 返回专辑目录中所有文件的总大小, 用作打包阶段的进度总量。
+func albumDirSize(albumPath string) int64 {
+	var total int64
+	filepath.Walk(albumPath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// writeAlbumZip 将专辑目录(连同元数据)以 zip 格式写入 dst。
+func writeAlbumZip(transfer *albumTransfer, dst io.Writer, albumPath string, meta KeytoneAlbumMeta) error {
+	zipWriter := zip.NewWriter(dst)
+
+	// 创建并写入元数据文件
+	metaWriter, err := zipWriter.Create(".keytone-album")
+	if err != nil {
+		return fmt.Errorf("创建元数据文件失败: %v", err)
+	}
+	metaJson, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("生成元数据失败: %v", err)
+	}
+	if _, err := metaWriter.Write(metaJson); err != nil {
+		return fmt.Errorf("写入元数据失败: %v", err)
+	}
+
+	// 遍历键音专辑文件夹并添加到zip
+	err = filepath.Walk(albumPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("遍历文件夹失败: %v", err)
+		}
+		if err := transfer.ctx.Err(); err != nil {
+			return err
+		}
+
+		// 获取相对路径, 统一使用正斜杠，确保跨平台兼容性
+		relPath, err := filepath.Rel(filepath.Dir(albumPath), path)
+		if err != nil {
+			return fmt.Errorf("计算相对路径失败: %v", err)
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("创建文件头信息失败: %v", err)
+		}
+		header.Name = relPath
+		if info.IsDir() {
+			header.Name += "/" // 确保目录以/结尾
+		} else {
+			header.Method = zip.Deflate // 使用压缩
+		}
+
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("创建zip条目失败: %v", err)
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		// 以只读方式打开源文件
+		file, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			return fmt.Errorf("打开源文件失败: %v", err)
+		}
+		defer file.Close()
+
+		if _, err := io.Copy(writer, transfer.reader(file)); err != nil {
+			return fmt.Errorf("写入zip文件失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("关闭zip writer失败: %v", err)
+	}
+	return nil
+}
+
+// albumUpload 为流式接收的 .ktalbum 上传结果。
+type albumUpload struct {
+	// Fields 为 multipart 中除文件外的表单字段(如 overwrite、newAlbumId)
+	Fields map[string]string
+	// ZipPath 为解密后的临时 zip 文件
+	ZipPath string
+}
+
+// albumUploadMaxFieldSize 限制单个表单字段的大小。
+const albumUploadMaxFieldSize = 4 << 10
+
+// receiveAlbumUpload 逐段读取 multipart 请求体, 将 "file" 字段中的 .ktalbum 流式解密为 tempDir 下的 temp.zip。
+// 用户输入导致的错误以 *ImportError 返回。
+func receiveAlbumUpload(transfer *albumTransfer, request *http.Request, tempDir string) (*albumUpload, error) {
+	reader, err := request.MultipartReader()
+	if err != nil {
+		return nil, &ImportError{Reason: ImportReasonUploadFailed, Message: "文件上传失败:" + err.Error()}
+	}
+
+	upload := &albumUpload{Fields: make(map[string]string)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if transfer.canceled() {
+				return nil, transfer.ctx.Err()
+			}
+			return nil, &ImportError{Reason: ImportReasonUploadFailed, Message: "文件上传失败:" + err.Error()}
+		}
+
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, albumUploadMaxFieldSize))
+			part.Close()
+			if err != nil {
+				return nil, &ImportError{Reason: ImportReasonUploadFailed, Message: "文件上传失败:" + err.Error()}
+			}
+			upload.Fields[part.FormName()] = string(value)
+			continue
+		}
+
+		if upload.ZipPath != "" {
+			part.Close()
+			return nil, &ImportError{Reason: ImportReasonUploadFailed, Message: "文件上传失败: 只能上传一个文件"}
+		}
+		zipPath, err := receiveAlbumFilePart(transfer, part, tempDir)
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		upload.ZipPath = zipPath
+	}
+
+	if upload.ZipPath == "" {
+		return nil, &ImportError{Reason: ImportReasonUploadFailed, Message: "文件上传失败: 未找到上传的文件"}
+	}
+	return upload, nil
+}
+
+func receiveAlbumFilePart(transfer *albumTransfer, part *multipart.Part, tempDir string) (string, error) {
+	// 检查文件扩展名
+	if !strings.HasSuffix(strings.ToLower(part.FileName()), ".ktalbum") {
+		return "", &ImportError{Reason: ImportReasonInvalidFormat, Message: "无效的文件格式，请选择 .ktalbum 文件"}
+	}
+
+	// 读取文件头并验证文件签名
+	var header KeytoneFileHeader
+	if err := binary.Read(part, binary.LittleEndian, &header); err != nil {
+		return "", &ImportError{Reason: ImportReasonInvalidFormat, Message: "读取文件头失败:" + err.Error()}
+	}
+	if string(header.Signature[:]) != KeytoneFileSignature {
+		return "", &ImportError{Reason: ImportReasonInvalidFormat, Message: "无效的文件格式：不是 KeyTone 专辑文件"}
+	}
+
+	transfer.setStage(AlbumTransferStageReceiving, int64(header.DataSize))
+	tempZipPath := filepath.Join(tempDir, "temp.zip")
+	if err := processImportedFile(transfer.reader(part), header, tempZipPath); err != nil {
+		return "", err
+	}
+	return tempZipPath, nil
+}
+
+// zipUncompressedSize 返回 zip 中所有条目解压后的总大小, 用作解压阶段的进度总量。
+func zipUncompressedSize(zipReader *zip.ReadCloser) int64 {
+	var total int64
+	for _, file := range zipReader.File {
+		total += int64(file.UncompressedSize64)
+	}
+	return total
+}
+
+// abortImport 以导入错误响应: *ImportError 为用户输入问题(400, 附带 reason), 其余为服务端错误(500)。
+func (t *albumTransfer) abortImport(ctx *gin.Context, err error) {
+	var importErr *ImportError
+	if errors.As(err, &importErr) && !t.canceled() {
+		t.fail(importErr)
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "error: " + importErr.Message,
+			"reason":  importErr.Reason,
+		})
+		return
+	}
+	t.abort(ctx, http.StatusInternalServerError, err.Error())
+}
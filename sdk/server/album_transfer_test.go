@@ -0,0 +1,289 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"KeyTone/logger"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func useDiscardLogger(t *testing.T) {
+	t.Helper()
+	if logger.Logger == nil {
+		logger.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		t.Cleanup(func() { logger.Logger = nil })
+	}
+}
+
+// encodeAlbumFile 返回完整的 .ktalbum 文件内容(文件头 + 数据区)。
+func encodeAlbumFile(t *testing.T, header KeytoneFileHeader, encrypted []byte) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	if err := binary.Write(buffer, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write(encrypted)
+	return buffer.Bytes()
+}
+
+// newAlbumUploadRequest 模拟前端的 FormData: 文件在前, 其余字段在后。
+func newAlbumUploadRequest(t *testing.T, filename string, content []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/keytone_pkg/import_album?taskId=task-1", body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func newTestTransfer(t *testing.T, request *http.Request) (*albumTransfer, *gin.Context) {
+	t.Helper()
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = request
+	transfer := startAlbumTransfer(ctx, AlbumTransferImport)
+	t.Cleanup(transfer.end)
+	return transfer, ctx
+}
+
+func TestDecryptAlbumToFileAllVersions(t *testing.T) {
+	useDiscardLogger(t)
+	payload := testAlbumPayload()
+
+	headerV3, encryptedV3, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerV2 := KeytoneFileHeader{Version: 2, DataSize: uint64(len(payload)), Checksum: sha256.Sum256(payload)}
+	// 文件头标记为 v2, 但实际使用 v1 密钥加密: 应通过 v1 回退成功解密
+	headerV1Fallback := headerV2
+
+	cases := []struct {
+		name        string
+		header      KeytoneFileHeader
+		encrypted   []byte
+		usedVersion uint8
+	}{
+		{"v3", headerV3, encryptedV3, KeytoneFileVersionV3},
+		{"v2", headerV2, xorCrypt(payload, getEncryptKeyByVersion(2)), 2},
+		{"v1 fallback", headerV1Fallback, xorCrypt(payload, getEncryptKeyByVersion(1)), 1},
+	}
+	for _, c := range cases {
+		dstPath := filepath.Join(t.TempDir(), "temp.zip")
+		usedVersion, err := decryptAlbumToFile(bytes.NewReader(c.encrypted), c.header, dstPath)
+		if err != nil {
+			t.Fatalf("%s: decryptAlbumToFile returned error: %v", c.name, err)
+		}
+		decrypted, _ := os.ReadFile(dstPath)
+		if usedVersion != c.usedVersion || !bytes.Equal(decrypted, payload) {
+			t.Fatalf("%s: unexpected result: version=%d len=%d", c.name, usedVersion, len(decrypted))
+		}
+	}
+}
+
+// TestReceiveAlbumUploadStreamsAndReportsProgress 验证上传被流式解密, 文件之后的表单字段仍可读取, 且进度通过 SSE 广播。
+func TestReceiveAlbumUploadStreamsAndReportsProgress(t *testing.T) {
+	useDiscardLogger(t)
+	payload := testAlbumPayload()
+	header, encrypted, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := newAlbumUploadRequest(t, "album.KTALBUM", encodeAlbumFile(t, header, encrypted), map[string]string{"overwrite": "true"})
+
+	client := subscribeAlbumTransfer()
+	defer AlbumTransfer_sse_stores.Delete(client)
+
+	transfer, _ := newTestTransfer(t, request)
+	upload, err := receiveAlbumUpload(transfer, request, t.TempDir())
+	if err != nil {
+		t.Fatalf("receiveAlbumUpload returned error: %v", err)
+	}
+	if upload.Fields["overwrite"] != "true" {
+		t.Fatalf("expected trailing form field to be read, got %v", upload.Fields)
+	}
+	decrypted, _ := os.ReadFile(upload.ZipPath)
+	if !bytes.Equal(decrypted, payload) {
+		t.Fatal("decrypted upload does not match original")
+	}
+
+	var last *AlbumTransferProgress
+	for len(client.progress) > 0 {
+		last = <-client.progress
+	}
+	if last == nil || last.TaskID != "task-1" || last.Stage != AlbumTransferStageReceiving || last.Done != last.Total || last.Total != int64(header.DataSize) {
+		t.Fatalf("unexpected final progress: %+v", last)
+	}
+}
+
+// TestBroadcastAlbumTransferDropsLaggingClient 验证积压写满的客户端被移除并通知断开, 且不阻塞其余客户端。
+func TestBroadcastAlbumTransferDropsLaggingClient(t *testing.T) {
+	useDiscardLogger(t)
+	lagging := subscribeAlbumTransfer()
+	defer AlbumTransfer_sse_stores.Delete(lagging)
+	active := subscribeAlbumTransfer()
+	defer AlbumTransfer_sse_stores.Delete(active)
+
+	for i := 0; i <= albumTransferClientBuffer; i++ {
+		broadcastAlbumTransfer(&AlbumTransferProgress{TaskID: "task-1", Done: int64(i)})
+		<-active.progress
+	}
+	select {
+	case <-lagging.lagging:
+	default:
+		t.Fatal("expected the lagging client to be disconnected")
+	}
+	if _, ok := AlbumTransfer_sse_stores.Load(lagging); ok {
+		t.Fatal("expected the lagging client to be removed")
+	}
+
+	broadcastAlbumTransfer(&AlbumTransferProgress{TaskID: "task-1"})
+	if len(active.progress) != 1 {
+		t.Fatal("expected the remaining client to keep receiving progress")
+	}
+}
+
+func TestReceiveAlbumUploadRejectsInvalidFiles(t *testing.T) {
+	useDiscardLogger(t)
+	header, encrypted, err := encryptAlbumDataV3([]byte("zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := encodeAlbumFile(t, header, encrypted)
+	tampered := bytes.Clone(content)
+	tampered[len(tampered)-1] ^= 0x01
+
+	cases := map[string]*http.Request{
+		"extension": newAlbumUploadRequest(t, "album.zip", content, nil),
+		"signature": newAlbumUploadRequest(t, "album.ktalbum", append([]byte("NOTALBM"), content[7:]...), nil),
+		"tampered":  newAlbumUploadRequest(t, "album.ktalbum", tampered, nil),
+	}
+	for name, request := range cases {
+		transfer, _ := newTestTransfer(t, request)
+		_, err := receiveAlbumUpload(transfer, request, t.TempDir())
+		var importErr *ImportError
+		if !errors.As(err, &importErr) {
+			t.Fatalf("%s: expected ImportError, got %v", name, err)
+		}
+	}
+}
+
+// TestAlbumTransferCancel 验证取消后读写立即停止, 并返回 context.Canceled。
+func TestAlbumTransferCancel(t *testing.T) {
+	useDiscardLogger(t)
+	payload := testAlbumPayload()
+	header, encrypted, err := encryptAlbumDataV3(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := newAlbumUploadRequest(t, "album.ktalbum", encodeAlbumFile(t, header, encrypted), nil)
+	transfer, _ := newTestTransfer(t, request)
+
+	task, ok := albumTransferTasks.Load("task-1")
+	if !ok || task.(*albumTransfer) != transfer {
+		t.Fatal("expected transfer to be registered under its taskId")
+	}
+	task.(*albumTransfer).cancel()
+
+	_, err = receiveAlbumUpload(transfer, request, t.TempDir())
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := io.Copy(io.Discard, transfer.reader(bytes.NewReader(payload))); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled reader, got %v", err)
+	}
+
+	transfer.end()
+	if _, ok := albumTransferTasks.Load("task-1"); ok {
+		t.Fatal("expected transfer to be unregistered after end")
+	}
+}
+
+// TestExportPipelineRoundTrip 验证导出管线(zip 落盘 -> 流式加密)的产物可被导入管线还原。
+func TestExportPipelineRoundTrip(t *testing.T) {
+	useDiscardLogger(t)
+	albumPath := filepath.Join(t.TempDir(), "album-id")
+	os.MkdirAll(filepath.Join(albumPath, "audioFiles"), 0755)
+	os.WriteFile(filepath.Join(albumPath, "package.json"), []byte(`{"package_name":"A"}`), 0644)
+	os.WriteFile(filepath.Join(albumPath, "audioFiles", "a.wav"), bytes.Repeat([]byte{1, 2, 3}, 70000), 0644)
+
+	request := httptest.NewRequest(http.MethodPost, "/keytone_pkg/export_album", nil)
+	transfer, _ := newTestTransfer(t, request)
+
+	zipFile, err := os.CreateTemp(t.TempDir(), "export_*.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zipFile.Close()
+	meta := KeytoneAlbumMeta{MagicNumber: KeytoneMagicNumber, Version: KeytoneVersion, AlbumUUID: "album-id", AlbumName: "A"}
+	if err := writeAlbumZip(transfer, zipFile, albumPath, meta); err != nil {
+		t.Fatalf("writeAlbumZip returned error: %v", err)
+	}
+	if transfer.done != albumDirSize(albumPath) {
+		t.Fatalf("expected packing progress %d, got %d", albumDirSize(albumPath), transfer.done)
+	}
+	zipSize, _ := zipFile.Seek(0, io.SeekCurrent)
+	zipFile.Seek(0, io.SeekStart)
+
+	header, err := newAlbumHeaderV3(uint64(zipSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := bytes.NewBuffer(header.bytes())
+	if err := encryptAlbumStreamV3(transfer.writer(exported), zipFile, header, getEncryptKeyByVersion(KeytoneFileVersionV3)); err != nil {
+		t.Fatalf("encryptAlbumStreamV3 returned error: %v", err)
+	}
+
+	var readHeader KeytoneFileHeader
+	binary.Read(exported, binary.LittleEndian, &readHeader)
+	zipPath := filepath.Join(t.TempDir(), "temp.zip")
+	if err := processImportedFile(exported, readHeader, zipPath); err != nil {
+		t.Fatalf("processImportedFile returned error: %v", err)
+	}
+	zipFile.Seek(0, io.SeekStart)
+	original, _ := io.ReadAll(zipFile)
+	imported, _ := os.ReadFile(zipPath)
+	if !bytes.Equal(original, imported) {
+		t.Fatal("imported zip does not match exported zip")
+	}
+}
@@ -0,0 +1,141 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+// =============================
+// 本地 API 鉴权说明
+// =============================
+//
+// 本地服务监听在 localhost 上, 用户浏览的任意网页都可以向其发起请求(如删除专辑、导出签名)。因此:
+//   1. 每次启动生成一个随机令牌, 以 "KEYTONE_TOKEN=" 输出到 stdout(紧挨 "KEYTONE_PORT="), 仅由启动 SDK 的壳程序(Electron 等)获取;
+//   2. 除 /ping 外的所有路由都需携带令牌:
+//      - 请求头 "Authorization: Bearer <token>"(axios 等可设置请求头的场景);
+//      - 仅 GET 请求: 查询参数 "?token=<token>" 或 Cookie "keytone_token"(EventSource、<audio> 等无法设置请求头的场景);
+//   3. CORS 仅放行设置 "server.allowed_origins" 与环境变量 KEYTONE_ALLOWED_ORIGINS(逗号分隔, 由壳程序传入)中的来源,
+//      其余来源的跨域请求直接以 403 拒绝。
+
+import (
+	"KeyTone/config"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// APITokenQueryKey 为通过查询参数传递令牌时使用的参数名
+	APITokenQueryKey = "token"
+	// APITokenCookieName 为通过 Cookie 传递令牌时使用的名称
+	APITokenCookieName = "keytone_token"
+	// AllowedOriginsEnv 为壳程序追加允许来源时使用的环境变量
+	AllowedOriginsEnv = "KEYTONE_ALLOWED_ORIGINS"
+)
+
+// newAPIToken 生成本次启动使用的随机令牌(32 字节, hex 编码)。
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// requestAPIToken 从请求中取出客户端携带的令牌。
+func requestAPIToken(ctx *gin.Context) string {
+	if authorization := ctx.GetHeader("Authorization"); authorization != "" {
+		if token, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	// 查询参数与 Cookie 仅用于只读的 GET 请求(SSE、音频流), 避免被用于跨站提交表单等写操作
+	if ctx.Request.Method != http.MethodGet {
+		return ""
+	}
+	if token := ctx.Query(APITokenQueryKey); token != "" {
+		return token
+	}
+	if token, err := ctx.Cookie(APITokenCookieName); err == nil {
+		return token
+	}
+	return ""
+}
+
+// authMiddleware 拒绝未携带正确令牌的请求(/ping 除外, 其用于启动时的就绪检测)。
+func authMiddleware(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.URL.Path == "/ping" {
+			ctx.Next()
+			return
+		}
+		got := requestAPIToken(ctx)
+		if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "error: 未授权的请求",
+			})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// allowedOrigins 汇总设置与环境变量中的允许来源。
+func allowedOrigins() []string {
+	origins := []string{}
+	switch values := config.GetValue("server.allowed_origins").(type) {
+	case []string:
+		origins = append(origins, values...)
+	case []any:
+		for _, value := range values {
+			if origin, ok := value.(string); ok {
+				origins = append(origins, origin)
+			}
+		}
+	default:
+		origins = append(origins, config.Server___allowed_origins...)
+		go config.SetValue("server.allowed_origins", config.Server___allowed_origins)
+	}
+	for _, origin := range strings.Split(os.Getenv(AllowedOriginsEnv), ",") {
+		origins = append(origins, origin)
+	}
+	return origins
+}
+
+// corsMiddleware 仅允许 origins 中的来源跨域访问。
+func corsMiddleware(origins []string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+			allowed[origin] = true
+		}
+	}
+	return cors.New(cors.Config{
+		AllowOriginFunc: func(origin string) bool {
+			return allowed[strings.TrimRight(origin, "/")]
+		},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders: []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
+	})
+}
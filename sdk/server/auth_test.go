@@ -0,0 +1,154 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAPIToken = "test-token"
+
+// newAuthTestRouter 按 ServerRun 的方式注册中间件与全部路由, /stream 以桩代替(避免建立长连接)。
+func newAuthTestRouter(t *testing.T, origins ...string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(corsMiddleware(origins))
+	r.Use(authMiddleware(testAPIToken))
+	r.GET("/ping", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "pong"})
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "ok"})
+	})
+	mainRouters(r)
+	keytonePkgRouters(r)
+	signatureRouters(r)
+	inputRouters(r)
+	return r
+}
+
+func serve(r *gin.Engine, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// TestAuthMiddlewareRejectsUnauthenticatedRequests 验证除 /ping 外的每个路由在缺少或携带错误令牌时都被拒绝, 且处理函数不会执行。
+func TestAuthMiddlewareRejectsUnauthenticatedRequests(t *testing.T) {
+	useDiscardLogger(t)
+	r := newAuthTestRouter(t)
+
+	routes := r.Routes()
+	if len(routes) < 10 {
+		t.Fatalf("expected all routes to be registered, got %d", len(routes))
+	}
+	for _, route := range routes {
+		if route.Path == "/ping" {
+			continue
+		}
+		requests := map[string]*http.Request{
+			"no token":    httptest.NewRequest(route.Method, route.Path, nil),
+			"wrong token": httptest.NewRequest(route.Method, route.Path, nil),
+			"not bearer":  httptest.NewRequest(route.Method, route.Path, nil),
+		}
+		requests["wrong token"].Header.Set("Authorization", "Bearer wrong-token")
+		requests["not bearer"].Header.Set("Authorization", testAPIToken)
+		if route.Method != http.MethodGet {
+			// 写操作不接受查询参数与 Cookie 中的令牌
+			requests["query token"] = httptest.NewRequest(route.Method, route.Path+"?token="+testAPIToken, nil)
+			requests["cookie token"] = httptest.NewRequest(route.Method, route.Path, nil)
+			requests["cookie token"].AddCookie(&http.Cookie{Name: APITokenCookieName, Value: testAPIToken})
+		}
+		for name, request := range requests {
+			if recorder := serve(r, request); recorder.Code != http.StatusUnauthorized {
+				t.Fatalf("%s %s (%s): expected 401, got %d", route.Method, route.Path, name, recorder.Code)
+			}
+		}
+	}
+}
+
+func TestAuthMiddlewareAcceptsToken(t *testing.T) {
+	useDiscardLogger(t)
+	r := newAuthTestRouter(t)
+
+	if recorder := serve(r, httptest.NewRequest(http.MethodGet, "/ping", nil)); recorder.Code != http.StatusOK {
+		t.Fatalf("expected /ping to be public, got %d", recorder.Code)
+	}
+
+	header := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	header.Header.Set("Authorization", "Bearer "+testAPIToken)
+	query := httptest.NewRequest(http.MethodGet, "/stream?token="+testAPIToken, nil)
+	cookie := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	cookie.AddCookie(&http.Cookie{Name: APITokenCookieName, Value: testAPIToken})
+	for name, request := range map[string]*http.Request{"header": header, "query": query, "cookie": cookie} {
+		if recorder := serve(r, request); recorder.Code != http.StatusOK {
+			t.Fatalf("/stream with %s token: expected 200, got %d", name, recorder.Code)
+		}
+	}
+}
+
+func TestAuthMiddlewareRejectsEmptyServerToken(t *testing.T) {
+	r := gin.New()
+	r.Use(authMiddleware(""))
+	r.GET("/stream", func(c *gin.Context) { c.Status(http.StatusOK) })
+	if recorder := serve(r, httptest.NewRequest(http.MethodGet, "/stream?token=", nil)); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when no token is configured, got %d", recorder.Code)
+	}
+}
+
+// TestCorsMiddlewareAllowlist 验证仅允许列表中的来源可跨域访问, 其余来源即使携带令牌也被拒绝。
+func TestCorsMiddlewareAllowlist(t *testing.T) {
+	useDiscardLogger(t)
+	r := newAuthTestRouter(t, "http://localhost:9000", " file:// ")
+
+	evil := httptest.NewRequest(http.MethodPost, "/keytone_pkg/delete_album", nil)
+	evil.Header.Set("Origin", "https://evil.example")
+	evil.Header.Set("Authorization", "Bearer "+testAPIToken)
+	if recorder := serve(r, evil); recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected disallowed origin to be rejected with 403, got %d", recorder.Code)
+	}
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/keytone_pkg/delete_album", nil)
+	preflight.Header.Set("Origin", "http://localhost:9000")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	preflight.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+	recorder := serve(r, preflight)
+	if recorder.Code != http.StatusNoContent || recorder.Header().Get("Access-Control-Allow-Origin") != "http://localhost:9000" {
+		t.Fatalf("expected allowed preflight, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	allowed := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	allowed.Header.Set("Origin", "file://")
+	recorder = serve(r, allowed)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Access-Control-Allow-Origin") != "file://" {
+		t.Fatalf("expected allowed origin to pass, got %d %v", recorder.Code, recorder.Header())
+	}
+
+	unauthenticated := httptest.NewRequest(http.MethodGet, "/store/get?key=x", nil)
+	unauthenticated.Header.Set("Origin", "http://localhost:9000")
+	if recorder := serve(r, unauthenticated); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected allowed origin without token to be rejected with 401, got %d", recorder.Code)
+	}
+}
@@ -0,0 +1,194 @@
+/**
+ * This file is part of the KeyTone project.
+ *
+ * Copyright (C) 2024 LuSrackhall
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package server
+
+import (
+	"KeyTone/keyEvent"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inputRouters 提供与硬件无关的输入事件注入、录制与回放接口。
+// 注入的事件会进入与真实按键完全相同的处理路径(按键分发、键音播放、SSE 广播)。
+func inputRouters(r *gin.Engine) {
+
+	inputRouters := r.Group("/input")
+
+	// 注入输入事件
+	// * 单个事件: {"device":"keyboard","keycode":30,"state":"down"}
+	// * 多个事件: {"events":[{...},{...}]}, 按数组顺序依次注入
+	inputRouters.POST("/inject", func(ctx *gin.Context) {
+		type Arg struct {
+			keyEvent.InputEvent
+			Events []keyEvent.InputEvent `json:"events"`
+		}
+
+		var arg Arg
+		if err := ctx.ShouldBindJSON(&arg); err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值, 不符合接口规定格式:" + err.Error(),
+			})
+			return
+		}
+
+		events := arg.Events
+		if len(events) == 0 {
+			events = []keyEvent.InputEvent{arg.InputEvent}
+		}
+
+		// 先整体校验, 避免只注入了一部分事件。
+		for _, event := range events {
+			if _, err := event.ToHookEvent(); err != nil {
+				ctx.JSON(http.StatusNotAcceptable, gin.H{
+					"message": "error: 输入事件无效:" + err.Error(),
+				})
+				return
+			}
+		}
+		for _, event := range events {
+			if err := keyEvent.Injector.Inject(event); err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"message": "error: 注入输入事件失败:" + err.Error(),
+				})
+				return
+			}
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message":  "ok",
+			"injected": len(events),
+		})
+	})
+
+	// 开始录制输入事件到 JSONL 文件
+	// * name: 录制文件名(不含路径), 文件保存在录制目录下
+	inputRouters.POST("/record/start", func(ctx *gin.Context) {
+		type Arg struct {
+			Name string `json:"name"`
+		}
+
+		var arg Arg
+		if err := ctx.ShouldBindJSON(&arg); err != nil || strings.TrimSpace(arg.Name) == "" {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--录制文件名不能为空",
+			})
+			return
+		}
+		path, err := keyEvent.RecordingPath(arg.Name)
+		if err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 录制文件名无效:" + err.Error(),
+			})
+			return
+		}
+
+		if err := keyEvent.StartRecording(path); err != nil {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "error: 开始录制失败:" + err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "ok",
+		})
+	})
+
+	// 结束录制
+	inputRouters.POST("/record/stop", func(ctx *gin.Context) {
+		count, err := keyEvent.StopRecording()
+		if err != nil {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "error: 结束录制失败:" + err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "ok",
+			"events":  count,
+		})
+	})
+
+	// 回放 JSONL 录制文件(或直接提交的事件列表)
+	// * name: 录制目录下的录制文件名(不含路径)
+	// * speed: 回放倍速, 默认 1(原速); 小于等于 0 时不等待, 立即依次注入
+	inputRouters.POST("/replay", func(ctx *gin.Context) {
+		type Arg struct {
+			Name   string                `json:"name"`
+			Events []keyEvent.InputEvent `json:"events"`
+			Speed  *float64              `json:"speed"`
+		}
+
+		var arg Arg
+		if err := ctx.ShouldBindJSON(&arg); err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值, 不符合接口规定格式:" + err.Error(),
+			})
+			return
+		}
+
+		events := arg.Events
+		if strings.TrimSpace(arg.Name) != "" {
+			path, err := keyEvent.RecordingPath(arg.Name)
+			if err != nil {
+				ctx.JSON(http.StatusNotAcceptable, gin.H{
+					"message": "error: 录制文件名无效:" + err.Error(),
+				})
+				return
+			}
+			loaded, err := keyEvent.LoadRecording(path)
+			if err != nil {
+				ctx.JSON(http.StatusNotAcceptable, gin.H{
+					"message": "error: 读取录制文件失败:" + err.Error(),
+				})
+				return
+			}
+			events = loaded
+		}
+		if len(events) == 0 {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 没有可回放的输入事件",
+			})
+			return
+		}
+
+		speed := 1.0
+		if arg.Speed != nil {
+			speed = *arg.Speed
+		}
+		keyEvent.StartReplay(events, speed)
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "ok",
+			"events":  len(events),
+		})
+	})
+
+	// 停止当前回放
+	inputRouters.POST("/replay/stop", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "ok",
+			"stopped": keyEvent.StopReplay(),
+		})
+	})
+}
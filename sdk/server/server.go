@@ -23,19 +23,22 @@ import (
 	audioPackageConfig "KeyTone/audioPackage/config"
 	"KeyTone/audioPackage/enc"
 	audioPackageList "KeyTone/audioPackage/list"
+	"KeyTone/audioPackage/mechvibes"
 	"KeyTone/config"
 	"KeyTone/keyEvent"
 	"KeyTone/keySound"
 	"KeyTone/logger"
 	"KeyTone/signature"
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -43,10 +46,10 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
@@ -69,14 +72,16 @@ var xorMask = []byte{0x55, 0xAA, 0x33, 0xCC, 0x99, 0x66, 0x11, 0xEE, 0x77, 0xBB,
 // 默认开源密钥常量（明文）
 const (
 	DefaultKeytoneEncryptKeyV1      = "KeyTone2024SecretKey"                  // v1 密钥（旧版本，用于向后兼容）
-	DefaultKeytoneEncryptKeyV2      = "KeyTone2025AlbumSecureEncryptionKeyV2" // v2 密钥（当前版本）
-	DefaultKeytoneEncryptKeyCurrent = DefaultKeytoneEncryptKeyV2
+	DefaultKeytoneEncryptKeyV2      = "KeyTone2025AlbumSecureEncryptionKeyV2" // v2 密钥（XOR，仅用于读取旧文件）
+	DefaultKeytoneEncryptKeyV3      = "KeyTone2026AlbumAuthenticatedKeyV3"    // v3 密钥（当前版本，经 HKDF 派生为每个文件的 AES-256-GCM 密钥）
+	DefaultKeytoneEncryptKeyCurrent = DefaultKeytoneEncryptKeyV3
 )
 
 // 版本化加密密钥（可注入）
 var (
 	KeytoneEncryptKeyV1      = DefaultKeytoneEncryptKeyV1
 	KeytoneEncryptKeyV2      = DefaultKeytoneEncryptKeyV2
+	KeytoneEncryptKeyV3      = DefaultKeytoneEncryptKeyV3
 	KeytoneEncryptKeyCurrent = DefaultKeytoneEncryptKeyCurrent
 	KeytoneEncryptKey        = KeytoneEncryptKeyV1 // 已废弃：向后兼容，请使用 KeytoneEncryptKeyV1
 )
@@ -141,6 +146,33 @@ func isValidNanoID(id string) bool {
 	return true
 }
 
+// nanoidAlphabet 为 nanoid 默认字符集。
+const nanoidAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-"
+
+// generateNanoID 生成与前端 nanoid() 格式一致的 21 位专辑ID（用于请求未提供专辑ID的场景）。
+func generateNanoID() (string, error) {
+	b := make([]byte, 21)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		// 字符集长度为 64, 取低 6 位即可保证均匀分布
+		b[i] = nanoidAlphabet[b[i]&63]
+	}
+	return string(b), nil
+}
+
+// extractZipToDir 将 zip 安全地解压到 dir(校验规则与 .ktalbum 导入一致)。
+func extractZipToDir(zipPath string, dir string) error {
+	zipReader, archiveSize, err := openZipForImport(zipPath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+
+	return extractZipSafely(&zipReader.Reader, archiveSize, dir, albumExtractLimitsFromConfig(), nil)
+}
+
 // generateAudioSourceNameID 生成音频源别名的唯一标识。
 //
 // 设计说明：
@@ -168,6 +200,76 @@ func generateAudioSourceNameID() (string, error) {
 	), nil
 }
 
+// audioFileReferencedBySounds 返回当前专辑中是否有声音(sounds)裁剪自 sha256 对应的音频。
+func audioFileReferencedBySounds(sha256 string) bool {
+	sounds, _ := audioPackageConfig.GetValue("sounds").(map[string]interface{})
+	for _, sound := range sounds {
+		soundMap, _ := sound.(map[string]interface{})
+		source, _ := soundMap["source_file_for_sound"].(map[string]interface{})
+		if source["sha256"] == sha256 {
+			return true
+		}
+	}
+	return false
+}
+
+// processImportedAudioFile 对已保存的原始音频执行导入时处理(见 keySound/normalize.go)。
+// 原始文件保持不变; 需要改写音频时另存 audioFiles/<sha256>.processed.wav, 并以本次结果整体替换
+// audio_files.<sha256> 下的 processed 与 loudness 配置(重复导入时即按新选项重新处理)。
+// 已被声音引用的音频不应重新处理: 新的首尾裁剪会使已有的裁剪区间错位(见 audioFileReferencedBySounds)。
+func processImportedAudioFile(sha256 string, fileType string, opts keySound.ProcessingOptions) (*keySound.ProcessedAudio, error) {
+	audioPkgUUID, ok := audioPackageConfig.GetValue("audio_pkg_uuid").(string)
+	if !ok {
+		return nil, errors.New("获取音频包UUID失败")
+	}
+	audioDir := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles")
+
+	src, err := os.Open(filepath.Join(audioDir, sha256+fileType))
+	if err != nil {
+		return nil, err
+	}
+	processed, err := keySound.ProcessAudio(src, opts)
+	src.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// 旧的处理结果可能仍被播放流持有, 先释放文件句柄(Windows 下否则无法覆盖或删除); 其他音频的播放不受影响
+	processedPath := filepath.Join(audioDir, keySound.ProcessedAudioFileName(sha256))
+	keySound.CloseFileStreams(processedPath)
+	defer keySound.InvalidatePCMCacheAudioFile(audioPkgUUID, sha256)
+	if processed.Variant != nil {
+		// 先写入临时文件再改名, 避免写入失败时留下残缺的处理后文件
+		tempFile, err := os.CreateTemp(audioDir, sha256+".*.tmp")
+		if err != nil {
+			return nil, err
+		}
+		if err := processed.WriteWAV(tempFile); err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+			return nil, err
+		}
+		tempFile.Close()
+		if err := os.Rename(tempFile.Name(), processedPath); err != nil {
+			os.Remove(tempFile.Name())
+			return nil, err
+		}
+		audioPackageConfig.SetValue("audio_files."+sha256+".processed", processed.Variant.ConfigValue())
+	} else {
+		audioPackageConfig.DeleteValue("audio_files." + sha256 + ".processed")
+		if err := os.Remove(processedPath); err != nil && !os.IsNotExist(err) {
+			logger.Error("message", "error: 删除旧的处理后音频文件失败:"+err.Error())
+		}
+	}
+
+	if processed.Loudness != nil {
+		audioPackageConfig.SetValue("audio_files."+sha256+".loudness", processed.Loudness.ConfigValue())
+	} else {
+		audioPackageConfig.DeleteValue("audio_files." + sha256 + ".loudness")
+	}
+	return processed, nil
+}
+
 // 验证专辑结构的辅助函数
 func isValidAlbumStructure(albumPath string) error {
 	// 检查目录名是否符合 nanoid 格式
@@ -289,97 +391,81 @@ func validateAlbumMeta(zipReader *zip.ReadCloser) error {
 	return nil
 }
 
-// 处理导入文件的通用函数
+// 处理导入文件的通用函数: 将 src 中的加密数据流式解密到临时 zip 文件
 func processImportedFile(src io.Reader, header KeytoneFileHeader, tempZipPath string) error {
-	// 读取加密的数据
-	encryptedData := make([]byte, header.DataSize)
-	if _, err := io.ReadFull(src, encryptedData); err != nil {
-		return &ImportError{Message: "读取文件数据失败:" + err.Error()}
-	}
-
 	// 解密数据（支持多版本密钥）
-	zipData, usedVersion, err := decryptAlbumData(encryptedData, header)
+	usedVersion, err := decryptAlbumToFile(src, header, tempZipPath)
 	if err != nil {
-		return &ImportError{Message: err.Error()}
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		return &ImportError{Reason: ImportReasonDecryptFailed, Message: err.Error()}
 	}
 	logger.Info("专辑数据解密成功", "file_version", header.Version, "used_key_version", usedVersion)
 
-	// 保存解密后的数据到临时zip文件
-	if err := os.WriteFile(tempZipPath, zipData, 0644); err != nil {
-		return &ImportError{Message: "保存临时文件失败:" + err.Error()}
-	}
-
 	return nil
 }
 
 // 解压并验证专辑结构的通用函数
-func extractAndValidateAlbum(zipReader *zip.ReadCloser, tempDir string) (string, error) {
-	// 解压到临时目录
-	for _, file := range zipReader.File {
-		// 构建完整的目标路径
-		targetPath := filepath.Join(tempDir, file.Name)
-
-		// 确保目标目录存在
-		if file.FileInfo().IsDir() {
-			os.MkdirAll(targetPath, 0755)
-			continue
-		}
-
-		// 创建目标文件的父目录
-		os.MkdirAll(filepath.Dir(targetPath), 0755)
-
-		// 创建目标文件
-		outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-		if err != nil {
-			return "", fmt.Errorf("创建目标文件失败: %v", err)
-		}
-
-		// 打开源文件
-		inFile, err := file.Open()
-		if err != nil {
-			outFile.Close()
-			return "", fmt.Errorf("打开源文件失败: %v", err)
-		}
+func extractAndValidateAlbum(transfer *albumTransfer, zipReader *zip.ReadCloser, archiveSize int64, tempDir string) (string, error) {
+	transfer.setStage(AlbumTransferStageExtracting, zipUncompressedSize(zipReader))
 
-		// 复制文件内容
-		_, err = io.Copy(outFile, inFile)
-		outFile.Close()
-		inFile.Close()
-		if err != nil {
-			return "", fmt.Errorf("复制文件内容失败: %v", err)
-		}
+	// 安全解压到临时目录(路径、文件类型与资源限制校验见 album_extract.go)
+	if err := extractZipSafely(&zipReader.Reader, archiveSize, tempDir, albumExtractLimitsFromConfig(), transfer); err != nil {
+		return "", err
 	}
 
 	// 获取解压后的专辑目录
 	files, err := os.ReadDir(tempDir)
 	if err != nil || len(files) == 0 {
-		return "", fmt.Errorf("读取解压目录失败或目录为空: %v", err)
+		return "", &ImportError{Reason: ImportReasonInvalidStructure, Message: fmt.Sprintf("读取解压目录失败或目录为空: %v", err)}
 	}
 
 	var albumDir os.DirEntry
 	for _, f := range files {
 		if f.IsDir() {
 			if albumDir != nil {
-				return "", fmt.Errorf("zip 文件中包含多个目录")
+				return "", &ImportError{Reason: ImportReasonInvalidStructure, Message: "zip 文件中包含多个目录"}
 			}
 			albumDir = f
 		}
 	}
 
 	if albumDir == nil {
-		return "", fmt.Errorf("zip 文件中未找到专辑目录")
+		return "", &ImportError{Reason: ImportReasonInvalidStructure, Message: "zip 文件中未找到专辑目录"}
 	}
 
 	albumPath := filepath.Join(tempDir, albumDir.Name())
 	if err := isValidAlbumStructure(albumPath); err != nil {
-		return "", fmt.Errorf("无效的专辑格式: %v", err)
+		return "", &ImportError{Reason: ImportReasonInvalidStructure, Message: fmt.Sprintf("无效的专辑格式: %v", err)}
 	}
 
 	return albumPath, nil
 }
 
+// ImportErrorReason 为导入失败原因的分类, 随错误响应一并返回, 便于前端给出具体提示。
+type ImportErrorReason string
+
+const (
+	ImportReasonUploadFailed     ImportErrorReason = "upload_failed"     // 上传内容读取失败
+	ImportReasonInvalidFormat    ImportErrorReason = "invalid_format"    // 不是 .ktalbum 文件
+	ImportReasonDecryptFailed    ImportErrorReason = "decrypt_failed"    // 解密或校验失败
+	ImportReasonInvalidArchive   ImportErrorReason = "invalid_archive"   // zip 格式错误或已损坏
+	ImportReasonAbsolutePath     ImportErrorReason = "absolute_path"     // 条目为绝对路径
+	ImportReasonPathTraversal    ImportErrorReason = "path_traversal"    // 条目包含 ".." 越界路径
+	ImportReasonUnsafePath       ImportErrorReason = "unsafe_path"       // 条目路径为空或非法
+	ImportReasonSymlink          ImportErrorReason = "symlink"           // 条目为符号链接
+	ImportReasonSpecialFile      ImportErrorReason = "special_file"      // 条目为设备、管道等特殊文件
+	ImportReasonDuplicateEntry   ImportErrorReason = "duplicate_entry"   // 条目重复或与临时文件冲突
+	ImportReasonTooManyFiles     ImportErrorReason = "too_many_files"    // 条目数量超出限制
+	ImportReasonTooLarge         ImportErrorReason = "too_large"         // 解压总大小超出限制
+	ImportReasonCompressionRatio ImportErrorReason = "compression_ratio" // 压缩比超出限制(疑似 zip 炸弹)
+	ImportReasonInvalidStructure ImportErrorReason = "invalid_structure" // 专辑目录结构不符合规范
+)
+
 // 导入错误类型
 type ImportError struct {
+	Reason  ImportErrorReason
 	Message string
 }
 
@@ -409,6 +495,8 @@ func getEncryptKeyByVersion(version uint8) string {
 		return getPlainEncryptKey(KeytoneEncryptKeyV1, DefaultKeytoneEncryptKeyV1)
 	case 2:
 		return getPlainEncryptKey(KeytoneEncryptKeyV2, DefaultKeytoneEncryptKeyV2)
+	case 3:
+		return getPlainEncryptKey(KeytoneEncryptKeyV3, DefaultKeytoneEncryptKeyV3)
 	default:
 		// 未知版本，返回当前密钥
 		logger.Warn("未知的文件版本号，使用当前密钥", "version", version)
@@ -417,6 +505,7 @@ func getEncryptKeyByVersion(version uint8) string {
 }
 
 // decryptAlbumData 解密专辑数据，支持多版本密钥回退
+// v3 文件使用带认证的分块加密（见 album_container.go），v1/v2 文件沿用 XOR + 校验和。
 // 参数:
 //   - encryptedData: 加密的数据
 //   - header: 文件头结构
@@ -426,6 +515,14 @@ func getEncryptKeyByVersion(version uint8) string {
 //   - uint8: 实际使用的密钥版本
 //   - error: 错误信息
 func decryptAlbumData(encryptedData []byte, header KeytoneFileHeader) ([]byte, uint8, error) {
+	if header.Version == KeytoneFileVersionV3 {
+		zipData, err := decryptAlbumDataV3(encryptedData, header)
+		if err != nil {
+			return nil, 0, err
+		}
+		return zipData, header.Version, nil
+	}
+
 	// 首先根据版本号选择密钥
 	decryptKey := getEncryptKeyByVersion(header.Version)
 	zipData := xorCrypt(encryptedData, decryptKey)
@@ -461,9 +558,18 @@ func ServerRun() {
 		}
 	}()
 
+	// 生成本次启动使用的 API 令牌(见 auth.go)
+	token, err := newAPIToken()
+	if err != nil {
+		logger.Error("生成API令牌失败", "error", err.Error())
+		fmt.Println("SDK的本地server模块启动失败")
+		return
+	}
+
 	// 启动gin
 	r := gin.Default()
-	r.Use(cors.Default())
+	r.Use(corsMiddleware(allowedOrigins()))
+	r.Use(authMiddleware(token))
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"message": "pong",
@@ -488,11 +594,13 @@ func ServerRun() {
 		config.Clients_sse_stores.Store(clientStoresChan, serverStoresChan)
 		audioPackageConfig.Clients_sse_stores.Store(clientAudioPackageStoresChan, serverAudioPackageStoresChan)
 		keyEvent.Clients_sse_stores.Store(clientKeyEventStoresChan, serverKeyEventStoresChan)
+		albumTransferClient := subscribeAlbumTransfer()
 
 		defer func() {
 			config.Clients_sse_stores.Delete(clientStoresChan)
 			audioPackageConfig.Clients_sse_stores.Delete(clientAudioPackageStoresChan)
 			keyEvent.Clients_sse_stores.Delete(clientKeyEventStoresChan)
+			AlbumTransfer_sse_stores.Delete(albumTransferClient)
 
 			logger.Logger.Debug("一个线程退出了............................")
 			logger.Debug("一个线程退出了............................")
@@ -510,6 +618,14 @@ func ServerRun() {
 
 					return false
 
+				case <-albumTransferClient.lagging:
+					// 传输进度积压过多, 断开连接(前端会自动重连)
+					serverStoresChan <- false
+					serverAudioPackageStoresChan <- false
+					serverKeyEventStoresChan <- false
+
+					return false
+
 				case message, ok := <-clientStoresChan:
 					if !ok {
 						logger.Error("通道clientStoresChan非正常关闭")
@@ -531,6 +647,9 @@ func ServerRun() {
 					}
 					c.SSEvent("messageKeyEvent", messageKeyEvent)
 					return true
+				case messageAlbumTransfer := <-albumTransferClient.progress:
+					c.SSEvent("messageAlbumTransfer", messageAlbumTransfer)
+					return true
 				}
 			})
 
@@ -543,6 +662,7 @@ func ServerRun() {
 
 	keytonePkgRouters(r)
 	signatureRouters(r)
+	inputRouters(r)
 
 	// 尝试在指定端口启动服务
 	listener, err := net.Listen("tcp", "localhost:38888")
@@ -598,7 +718,8 @@ func ServerRun() {
 		fmt.Println("SDK的本地server模块启动失败")
 		return
 	}
-	// 输出端口信息，让Electron主进程可以捕获
+	// 输出令牌与端口信息，让Electron主进程可以捕获(令牌先于端口输出, 以确保壳程序在得知端口时已持有令牌)
+	fmt.Printf("KEYTONE_TOKEN=%s\n", token)
 	fmt.Printf("KEYTONE_PORT=%d\n", port)
 }
 
@@ -687,12 +808,66 @@ func keytonePkgRouters(r *gin.Engine) {
 		// 加载键音包配置文件
 		audioPackageConfig.LoadConfig(audioPkgPath, arg.IsCreate)
 
+		// 后台预填充解码缓存, 使编辑器中的试听与按键回放不必在首次触发时才解码。
+		go keySound.WarmPCMCacheForEditor()
+
 		ctx.JSON(200, gin.H{
 			"message":      "ok",
 			"audioPkgPath": audioPkgPath,
 		})
 	})
 
+	// 获取解码缓存(PCM cache)的命中与内存统计
+	keytonePkgRouters.GET("/get_pcm_cache_stats", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{
+			"message": "ok",
+			"stats":   keySound.GetPCMCacheStats(),
+		})
+	})
+
+	// 获取复音(voice)管理统计: 当前发声数与淘汰/切断次数
+	keytonePkgRouters.GET("/get_voice_stats", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{
+			"message": "ok",
+			"stats":   keySound.GetVoiceStats(),
+		})
+	})
+
+	// 获取至臻键音 loop 模式在各播放来源(编辑器/路由快照)中的当前播放位置
+	keytonePkgRouters.GET("/get_loop_state", func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{
+			"message": "ok",
+			"state":   keySound.GetLoopState(),
+		})
+	})
+
+	// 重置至臻键音 loop 模式的播放位置; source 为空时重置全部来源
+	keytonePkgRouters.POST("/reset_loop_state", func(ctx *gin.Context) {
+		type Arg struct {
+			Source string `json:"source"`
+		}
+
+		var arg Arg
+		if err := ctx.ShouldBindJSON(&arg); err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值, 不符合接口规定格式:" + err.Error(),
+			})
+			return
+		}
+
+		if err := keySound.ResetLoopPositions(arg.Source); err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+
+		ctx.JSON(200, gin.H{
+			"message": "ok",
+			"state":   keySound.GetLoopState(),
+		})
+	})
+
 	// 应用播放路由（只读快照加载）
 	keytonePkgRouters.POST("/apply_playback_routing", func(ctx *gin.Context) {
 		type Arg struct {
@@ -776,6 +951,23 @@ func keytonePkgRouters(r *gin.Engine) {
 			return
 		}
 
+		// 可选的导入时处理(裁剪静音/缩混/重采样/响度测量), 以 JSON 形式放在 processing 表单字段中
+		var processingOptions keySound.ProcessingOptions
+		if raw := ctx.PostForm("processing"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &processingOptions); err != nil {
+				ctx.JSON(http.StatusNotAcceptable, gin.H{
+					"message": "error: 参数接收--processing 不符合接口规定格式:" + err.Error(),
+				})
+				return
+			}
+			if err := processingOptions.Validate(); err != nil {
+				ctx.JSON(http.StatusNotAcceptable, gin.H{
+					"message": "error: 参数接收--processing 不符合接口规定格式:" + err.Error(),
+				})
+				return
+			}
+		}
+
 		// 打开上传的文件
 		src, err := file.Open()
 		if err != nil {
@@ -797,8 +989,23 @@ func keytonePkgRouters(r *gin.Engine) {
 		hashSum := hash.Sum(nil)
 		hashString := fmt.Sprintf("%x", hashSum)
 
-		// 获取文件扩展名
-		ext := filepath.Ext(file.Filename)
+		// 按文件内容识别格式并完整解码一次, 不信任上传文件的扩展名; 无法解码的文件不会被保存
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 无法读取上传的文件:" + err.Error(),
+			})
+			return
+		}
+		audioInfo, err := keySound.ProbeAudio(src)
+		if err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 文件添加失败, 无法识别或解码的音频文件(支持 WAV、MP3、OGG、OPUS、FLAC、AIFF):" + err.Error(),
+			})
+			return
+		}
+
+		// 获取文件扩展名(以识别出的格式为准)
+		ext := audioInfo.Format
 
 		// 使用哈希值作为文件名
 		newFileName := hashString + ext
@@ -816,14 +1023,38 @@ func keytonePkgRouters(r *gin.Engine) {
 				return
 			}
 
+			// 已存在的文件沿用其保存时的扩展名, 以免旧文件(如以 .ogg 保存的 Opus)的路径失效
+			if existingType, ok := audioPackageConfig.GetValue("audio_files." + hashString + ".type").(string); ok && existingType != "" {
+				ext = existingType
+				newFileName = hashString + ext
+			}
 			audioPackageConfig.SetValue("audio_files."+hashString+".name."+nameID, strings.TrimSuffix(filepath.Base(file.Filename), filepath.Ext(file.Filename)))
 			audioPackageConfig.SetValue("audio_files."+hashString+".type", ext)
 
+			response := gin.H{
+				"message":    "ok",
+				"fileName":   newFileName,
+				"durationMs": audioInfo.DurationMS,
+				"sampleRate": audioInfo.SampleRate,
+				"channels":   audioInfo.Channels,
+			}
+			// 重复导入时若请求了处理, 则按新的选项重新处理(原始文件不变); 失败时与首次导入一样仅在返回中说明。
+			// 已有声音裁剪自该音频时不再处理, 以免存储的音频改变后已有的裁剪区间错位
+			if processingOptions.Enabled() && audioFileReferencedBySounds(hashString) {
+				response["processingError"] = "该音频已被声音引用, 重新处理会使已有的裁剪错位, 因此未重新处理"
+			} else if processingOptions.Enabled() {
+				processed, err := processImportedAudioFile(hashString, ext, processingOptions)
+				if err != nil {
+					logger.Error("message", "error: 音频处理失败:"+err.Error())
+					response["processingError"] = err.Error()
+				} else {
+					response["processed"] = processed.Variant
+					response["loudness"] = processed.Loudness
+				}
+			}
+
 			// 因文件已存在与文件系统中, 故无需继续进行真实的文件保存。 这里直接将正确完成的消息返回给前端, 并退出此次请求的处理即可。
-			ctx.JSON(200, gin.H{
-				"message":  "ok",
-				"fileName": newFileName,
-			})
+			ctx.JSON(200, response)
 
 			// 退出此次请求的处理 (TIPS: 单纯的向前端返回消息, 并不能自动return。 此处我们需要主动退出, 防止执行后续步骤造成画蛇添足。)
 			return
@@ -864,11 +1095,27 @@ func keytonePkgRouters(r *gin.Engine) {
 		audioPackageConfig.SetValue("audio_files."+hashString+".name."+nameID, strings.TrimSuffix(filepath.Base(file.Filename), filepath.Ext(file.Filename)))
 		audioPackageConfig.SetValue("audio_files."+hashString+".type", ext)
 
-		// 全部处理完毕后, 将正确完成的消息返回给前端
-		ctx.JSON(200, gin.H{
-			"message":  "ok",
-			"fileName": newFileName,
-		})
+		response := gin.H{
+			"message":    "ok",
+			"fileName":   newFileName,
+			"durationMs": audioInfo.DurationMS,
+			"sampleRate": audioInfo.SampleRate,
+			"channels":   audioInfo.Channels,
+		}
+		// 处理失败不影响原始文件的导入, 仅记录错误并在返回中说明, 用户可稍后重新导入以再次处理
+		if processingOptions.Enabled() {
+			processed, err := processImportedAudioFile(hashString, ext, processingOptions)
+			if err != nil {
+				logger.Error("message", "error: 音频处理失败:"+err.Error())
+				response["processingError"] = err.Error()
+			} else {
+				response["processed"] = processed.Variant
+				response["loudness"] = processed.Loudness
+			}
+		}
+
+		// 全部处理完毕后, 将正确完成的消息与识别出的音频信息返回给前端
+		ctx.JSON(200, response)
 	})
 
 	keytonePkgRouters.GET("/get", func(ctx *gin.Context) {
@@ -1008,6 +1255,13 @@ func keytonePkgRouters(r *gin.Engine) {
 					logger.Error("message", "error: 删除音频文件失败:"+err.Error())
 					return
 				}
+				// 一并删除导入时生成的处理后文件(可能不存在)
+				if err := os.Remove(filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", keySound.ProcessedAudioFileName(arg.Sha256))); err != nil && !os.IsNotExist(err) {
+					logger.Error("message", "error: 删除处理后的音频文件失败:"+err.Error())
+				}
+				// 波形峰值与频谱图的磁盘缓存也不再需要
+				keySound.RemoveAnalysisCache(arg.Sha256 + arg.Type)
+				keySound.RemoveAnalysisCache(keySound.ProcessedAudioFileName(arg.Sha256))
 
 				// 音频源文件删除成功后，删除配置项中的音频文件配置项(此时不需要管具体的NameID的删除, 因为我们已经从父级删除了)
 				audioPackageConfig.DeleteValue("audio_files." + arg.Sha256)
@@ -1043,6 +1297,10 @@ func keytonePkgRouters(r *gin.Engine) {
 			EndTime       float64 `json:"endTime"`
 			Volume        float64 `json:"volume"`
 			IsPreviewMode bool    `json:"isPreviewMode"`
+			// 可选的淡入/淡出包络, 与 sounds.<uuid>.cut 中的 fade_in_ms / fade_out_ms / fade_curve 对应
+			FadeInMS  float64 `json:"fadeInMs"`
+			FadeOutMS float64 `json:"fadeOutMs"`
+			FadeCurve string  `json:"fadeCurve"`
 		}
 
 		var arg Arg
@@ -1079,10 +1337,17 @@ func keytonePkgRouters(r *gin.Engine) {
 			return
 		}
 
-		go keySound.PlayKeySound(&keySound.AudioFilePath{Part: filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", arg.Sha256+arg.Type)}, &keySound.Cut{
-			StartMS: int64(arg.StartTime),
-			EndMS:   int64(arg.EndTime),
-			Volume:  arg.Volume,
+		// 与按键播放一致: 存在处理后的文件时使用处理后的文件, 并应用导入时测得的响度增益
+		go keySound.PlayKeySound(&keySound.AudioFilePath{
+			Part:           filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", keySound.PlaybackAudioFileName(audioPackageConfig.GetValue, arg.Sha256, arg.Type)),
+			LoudnessGainDB: keySound.LoudnessGainDB(audioPackageConfig.GetValue, arg.Sha256),
+		}, &keySound.Cut{
+			StartMS:   int64(arg.StartTime),
+			EndMS:     int64(arg.EndTime),
+			Volume:    arg.Volume,
+			FadeInMS:  int64(arg.FadeInMS),
+			FadeOutMS: int64(arg.FadeOutMS),
+			FadeCurve: arg.FadeCurve,
 		}, "", "", arg.IsPreviewMode)
 
 		ctx.JSON(200, gin.H{
@@ -1140,7 +1405,8 @@ func keytonePkgRouters(r *gin.Engine) {
 			return
 		}
 
-		fileName := arg.Sha256 + arg.Type
+		// 存在处理后的文件时返回处理后的文件, 使编辑器中的波形与裁剪时间轴与实际播放的文件一致
+		fileName := keySound.PlaybackAudioFileName(audioPackageConfig.GetValue, arg.Sha256, arg.Type)
 		filePath := filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID, "audioFiles", fileName)
 
 		f, err := os.Open(filePath)
@@ -1157,7 +1423,7 @@ func keytonePkgRouters(r *gin.Engine) {
 		}
 
 		// content-type：根据扩展名推断
-		contentType := mime.TypeByExtension(arg.Type)
+		contentType := mime.TypeByExtension(filepath.Ext(fileName))
 		if contentType == "" {
 			contentType = "application/octet-stream"
 		}
@@ -1168,104 +1434,332 @@ func keytonePkgRouters(r *gin.Engine) {
 	})
 
 	// ============================================================================
-	// GET /get_audio_package_list - 获取专辑列表（含签名摘要）
+	// GET /get_audio_peaks - 服务端计算的波形峰值（替代前端下载整个文件后解码绘制）
 	//
-	// 功能说明：
-	//   - 获取所有专辑的路径列表
-	//   - 同时获取每个专辑的签名摘要信息（直接导出作者名称、图片）
-	//   - 签名摘要用于前端列表展示，避免逐个请求签名详情
+	// 请求参数：
+	//   - sha256: audio_files 中的音频文件
+	//   - samplesPerPixel: 每像素样本数(原始采样率下); 为 32 的整数倍时由磁盘缓存聚合, 速度最快
+	//   - startMs / endMs: 时间范围, endMs 省略或为 0 表示到文件结尾
 	//
 	// 返回格式：
-	//   {
-	//     "message": "ok",
-	//     "list": ["path1", "path2", ...],
-	//     "signatureInfo": {
-	//       "path1": { "hasSignature": true, "directExportAuthorName": "...", "directExportAuthorImage": "..." },
-	//       "path2": { "hasSignature": false }
-	//     }
-	//   }
+	//   { "message": "ok", "sampleRate", "channels", "length", "samplesPerPixel", "start", "end", "peaks": [[min, max, ...], ...] }
+	//   start/end 为实际覆盖的样本区间, 前端应以它们定位像素, 而不是请求的毫秒值。
 	// ============================================================================
-	keytonePkgRouters.GET("/get_audio_package_list", func(ctx *gin.Context) {
-		list, err := audioPackageList.GetAudioPackageList(audioPackageConfig.AudioPackagePath)
-		if err != nil {
+	keytonePkgRouters.GET("/get_audio_peaks", func(ctx *gin.Context) {
+		type Arg struct {
+			Sha256          string `form:"sha256"`
+			SamplesPerPixel int    `form:"samplesPerPixel"`
+			StartMS         int64  `form:"startMs"`
+			EndMS           int64  `form:"endMs"`
+		}
+
+		var arg Arg
+		if err := ctx.ShouldBindQuery(&arg); err != nil || arg.Sha256 == "" || arg.SamplesPerPixel < 1 || arg.StartMS < 0 || arg.EndMS < 0 {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收失败",
+			})
+			return
+		}
+
+		audioPkgUUID, ok := audioPackageConfig.GetValue("audio_pkg_uuid").(string)
+		if !ok || audioPkgUUID == "" {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 获取音频包列表失败:" + err.Error(),
+				"message": "error: 获取音频包UUID失败",
 			})
 			return
 		}
 
-		// 获取每个专辑的签名摘要信息
-		// TIPS: 遍历过程中为每个专辑独立创建和释放 Viper 实例，避免内存泄漏
-		signatureInfo := make(map[string]*audioPackageList.AlbumSignatureSummary)
-		for _, albumPath := range list {
-			summary, err := audioPackageList.GetAlbumSignatureSummary(albumPath)
-			if err != nil {
-				// 获取签名摘要失败时，使用默认值（无签名）
-				logger.Warn("获取专辑签名摘要失败", "albumPath", albumPath, "err", err.Error())
-				signatureInfo[albumPath] = &audioPackageList.AlbumSignatureSummary{HasSignature: false}
-			} else {
-				signatureInfo[albumPath] = summary
-			}
+		peaks, err := keySound.AudioFilePeaks(audioPackageConfig.GetValue, filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID), arg.Sha256, arg.SamplesPerPixel, keySound.AnalysisRange{StartMS: arg.StartMS, EndMS: arg.EndMS})
+		if err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 计算波形峰值失败:" + err.Error(),
+			})
+			return
 		}
 
 		ctx.JSON(200, gin.H{
-			"message":       "ok",
-			"list":          list,
-			"signatureInfo": signatureInfo,
+			"message":         "ok",
+			"sampleRate":      peaks.SampleRate,
+			"channels":        peaks.Channels,
+			"length":          peaks.Length,
+			"samplesPerPixel": peaks.SamplesPerPixel,
+			"start":           peaks.Start,
+			"end":             peaks.End,
+			"peaks":           peaks.Peaks,
 		})
 	})
 
-	keytonePkgRouters.GET("/get_audio_package_name", func(ctx *gin.Context) {
+	// ============================================================================
+	// GET /get_audio_spectrogram - 服务端计算的降采样幅度频谱图
+	//
+	// 请求参数：
+	//   - sha256: audio_files 中的音频文件
+	//   - startMs / endMs: 时间范围, endMs 省略或为 0 表示到文件结尾
+	//   - columns / bins / fftSize: 时间列数(默认 512)、频率分组数(默认 128)、FFT 长度(默认 1024)
+	//
+	// 返回格式：
+	//   { "message": "ok", "spectrogram": { sampleRate, start, end, columns, bins, fftSize, maxFrequency, minDb, data: [[dB, ...], ...] } }
+	// ============================================================================
+	keytonePkgRouters.GET("/get_audio_spectrogram", func(ctx *gin.Context) {
+		type Arg struct {
+			Sha256  string `form:"sha256"`
+			StartMS int64  `form:"startMs"`
+			EndMS   int64  `form:"endMs"`
+			Columns int    `form:"columns"`
+			Bins    int    `form:"bins"`
+			FFTSize int    `form:"fftSize"`
+		}
 
-		path := ctx.DefaultQuery("path", "unknown")
-		if path == "unknown" || path == "" {
+		var arg Arg
+		if err := ctx.ShouldBindQuery(&arg); err != nil || arg.Sha256 == "" || arg.StartMS < 0 || arg.EndMS < 0 {
 			ctx.JSON(http.StatusNotAcceptable, gin.H{
-				"message": "error: 参数接收--收到的前端数据内容path值, 不符合接口规定格式:",
+				"message": "error: 参数接收失败",
 			})
 			return
 		}
-		albumName := audioPackageList.GetAudioPackageName(path)
-		retryCount := 0
-		for albumName == nil {
-			albumName = audioPackageList.GetAudioPackageName(path)
-			// 添加一个计数器和最大重试次数(防止死循环造成的资源占用问题)
-			if retryCount >= 6 {
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"message": "error: 获取专辑名称失败: 超过最大重试次数",
-				})
-				return
-			}
-			retryCount++
-			fmt.Println("GetAudioPackageName为nil, 尝试重新获取。 获取次数=", retryCount)
-			time.Sleep(100 * time.Millisecond) // 添加短暂延迟
+		options := keySound.SpectrogramOptions{Columns: arg.Columns, Bins: arg.Bins, FFTSize: arg.FFTSize}
+		if err := options.Validate(); err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收失败:" + err.Error(),
+			})
+			return
 		}
-		albumName, ok := albumName.(string)
-		if !ok {
+
+		audioPkgUUID, ok := audioPackageConfig.GetValue("audio_pkg_uuid").(string)
+		if !ok || audioPkgUUID == "" {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 获取专辑名称失败: 类型转换错误",
+				"message": "error: 获取音频包UUID失败",
+			})
+			return
+		}
+
+		spectrogram, err := keySound.AudioFileSpectrogram(audioPackageConfig.GetValue, filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID), arg.Sha256, options, keySound.AnalysisRange{StartMS: arg.StartMS, EndMS: arg.EndMS})
+		if err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 计算频谱图失败:" + err.Error(),
 			})
 			return
 		}
+
 		ctx.JSON(200, gin.H{
-			"message": "ok",
-			"name":    albumName,
+			"message":     "ok",
+			"spectrogram": spectrogram,
 		})
 	})
 
-	// 独立加密 API：前端在选择"需要签名"时调用，根据传入的专辑路径加密配置文件
-	keytonePkgRouters.POST("/encrypt_album_config", func(ctx *gin.Context) {
+	// ============================================================================
+	// POST /detect_onsets - 对当前编辑专辑中的音频文件执行起音检测, 给出候选裁剪范围
+	//
+	// 请求参数：
+	//   - sha256: audio_files 中的音频文件
+	//   - options: 检测参数(见 keySound.OnsetOptions), 可省略
+	//   - createSounds: 为 true 时直接为每个范围创建 sounds 条目
+	//   - nameID: 创建声音时引用的音频源别名, 省略时使用该文件的第一个别名
+	//
+	// 返回格式：
+	//   { "message": "ok", "ranges": [{onsetMs, startMs, endMs, strength}, ...], "sounds": [soundUUID, ...] }
+	//   其中 sounds 仅在 createSounds 为 true 时返回, 与 ranges 一一对应。
+	// ============================================================================
+	keytonePkgRouters.POST("/detect_onsets", func(ctx *gin.Context) {
 		type Arg struct {
-			AlbumPath string `json:"albumPath"`
+			Sha256       string                `json:"sha256"`
+			NameID       string                `json:"nameID"`
+			Options      keySound.OnsetOptions `json:"options"`
+			CreateSounds bool                  `json:"createSounds"`
 		}
 
 		var arg Arg
-		if err := ctx.ShouldBindJSON(&arg); err != nil || strings.TrimSpace(arg.AlbumPath) == "" {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 请求体必须包含 albumPath",
+		if err := ctx.ShouldBind(&arg); err != nil || arg.Sha256 == "" {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值, 不符合接口规定格式",
 			})
 			return
 		}
-
+		if err := arg.Options.Validate(); err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--options 不符合接口规定格式:" + err.Error(),
+			})
+			return
+		}
+		fileType, ok := audioPackageConfig.GetValue("audio_files." + arg.Sha256 + ".type").(string)
+		if !ok {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 音频文件不存在",
+			})
+			return
+		}
+
+		// 创建声音前先确定引用的别名, 避免检测完成后才发现无法创建
+		nameID := arg.NameID
+		names, _ := audioPackageConfig.GetValue("audio_files." + arg.Sha256 + ".name").(map[string]any)
+		if arg.CreateSounds {
+			if nameID == "" {
+				for id := range names {
+					if nameID == "" || id < nameID {
+						nameID = id
+					}
+				}
+			}
+			if _, ok := names[nameID]; !ok {
+				ctx.JSON(http.StatusNotAcceptable, gin.H{
+					"message": "error: 音频源别名不存在",
+				})
+				return
+			}
+		}
+
+		audioPkgUUID, ok := audioPackageConfig.GetValue("audio_pkg_uuid").(string)
+		if !ok {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 获取音频包UUID失败",
+			})
+			return
+		}
+
+		ranges, err := keySound.DetectAudioFileOnsets(audioPackageConfig.GetValue, filepath.Join(audioPackageConfig.AudioPackagePath, audioPkgUUID), arg.Sha256, arg.Options)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 起音检测失败:" + err.Error(),
+			})
+			logger.Error("message", "error: 起音检测失败:"+err.Error())
+			return
+		}
+
+		response := gin.H{
+			"message": "ok",
+			"ranges":  ranges,
+		}
+		if arg.CreateSounds {
+			baseName, _ := names[nameID].(string)
+			soundIDs := make([]string, 0, len(ranges))
+			for index, r := range ranges {
+				soundID, err := generateAudioSourceNameID()
+				if err != nil {
+					ctx.JSON(http.StatusInternalServerError, gin.H{
+						"message": "error: 生成声音ID失败:" + err.Error(),
+					})
+					return
+				}
+				audioPackageConfig.SetValue("sounds."+soundID, map[string]any{
+					"name": fmt.Sprintf("%s %03d", baseName, index+1),
+					"source_file_for_sound": map[string]any{
+						"sha256":  arg.Sha256,
+						"name_id": nameID,
+						"type":    fileType,
+					},
+					"cut": map[string]any{
+						"start_time": r.StartMS,
+						"end_time":   r.EndMS,
+						"volume":     0.0,
+					},
+				})
+				soundIDs = append(soundIDs, soundID)
+			}
+			response["sounds"] = soundIDs
+		}
+
+		ctx.JSON(200, response)
+	})
+
+	// ============================================================================
+	// GET /get_audio_package_list - 获取专辑列表（含签名摘要）
+	//
+	// 功能说明：
+	//   - 获取所有专辑的路径列表
+	//   - 同时获取每个专辑的签名摘要信息（直接导出作者名称、图片）
+	//   - 签名摘要用于前端列表展示，避免逐个请求签名详情
+	//
+	// 返回格式：
+	//   {
+	//     "message": "ok",
+	//     "list": ["path1", "path2", ...],
+	//     "signatureInfo": {
+	//       "path1": { "hasSignature": true, "directExportAuthorName": "...", "directExportAuthorImage": "..." },
+	//       "path2": { "hasSignature": false }
+	//     }
+	//   }
+	// ============================================================================
+	keytonePkgRouters.GET("/get_audio_package_list", func(ctx *gin.Context) {
+		list, err := audioPackageList.GetAudioPackageList(audioPackageConfig.AudioPackagePath)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 获取音频包列表失败:" + err.Error(),
+			})
+			return
+		}
+
+		// 获取每个专辑的签名摘要信息
+		// TIPS: 遍历过程中为每个专辑独立创建和释放 Viper 实例，避免内存泄漏
+		signatureInfo := make(map[string]*audioPackageList.AlbumSignatureSummary)
+		for _, albumPath := range list {
+			summary, err := audioPackageList.GetAlbumSignatureSummary(albumPath)
+			if err != nil {
+				// 获取签名摘要失败时，使用默认值（无签名）
+				logger.Warn("获取专辑签名摘要失败", "albumPath", albumPath, "err", err.Error())
+				signatureInfo[albumPath] = &audioPackageList.AlbumSignatureSummary{HasSignature: false}
+			} else {
+				signatureInfo[albumPath] = summary
+			}
+		}
+
+		ctx.JSON(200, gin.H{
+			"message":       "ok",
+			"list":          list,
+			"signatureInfo": signatureInfo,
+		})
+	})
+
+	keytonePkgRouters.GET("/get_audio_package_name", func(ctx *gin.Context) {
+
+		path := ctx.DefaultQuery("path", "unknown")
+		if path == "unknown" || path == "" {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容path值, 不符合接口规定格式:",
+			})
+			return
+		}
+		albumName := audioPackageList.GetAudioPackageName(path)
+		retryCount := 0
+		for albumName == nil {
+			albumName = audioPackageList.GetAudioPackageName(path)
+			// 添加一个计数器和最大重试次数(防止死循环造成的资源占用问题)
+			if retryCount >= 6 {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"message": "error: 获取专辑名称失败: 超过最大重试次数",
+				})
+				return
+			}
+			retryCount++
+			fmt.Println("GetAudioPackageName为nil, 尝试重新获取。 获取次数=", retryCount)
+			time.Sleep(100 * time.Millisecond) // 添加短暂延迟
+		}
+		albumName, ok := albumName.(string)
+		if !ok {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 获取专辑名称失败: 类型转换错误",
+			})
+			return
+		}
+		ctx.JSON(200, gin.H{
+			"message": "ok",
+			"name":    albumName,
+		})
+	})
+
+	// 独立加密 API：前端在选择"需要签名"时调用，根据传入的专辑路径加密配置文件
+	keytonePkgRouters.POST("/encrypt_album_config", func(ctx *gin.Context) {
+		type Arg struct {
+			AlbumPath string `json:"albumPath"`
+		}
+
+		var arg Arg
+		if err := ctx.ShouldBindJSON(&arg); err != nil || strings.TrimSpace(arg.AlbumPath) == "" {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "error: 请求体必须包含 albumPath",
+			})
+			return
+		}
+
 		albumPath := arg.AlbumPath
 		// 校验目录是否存在
 		info, err := os.Stat(albumPath)
@@ -1631,48 +2125,31 @@ func keytonePkgRouters(r *gin.Engine) {
 			AlbumPath string `json:"albumPath"`
 		}
 
+		// 导出过程以流的形式进行: 打包与加密的进度通过 /stream 广播(taskId 可通过查询参数指定)
+		transfer := startAlbumTransfer(ctx, AlbumTransferExport)
+		defer transfer.end()
+
 		var arg Arg
 		err := ctx.ShouldBind(&arg)
 		if err != nil || arg.AlbumPath == "" {
-			ctx.JSON(http.StatusNotAcceptable, gin.H{
-				"message": "error: 参数接收--收到的前端数据内容值不符合接口规定格式:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusNotAcceptable, "参数接收--收到的前端数据内容值不符合接口规定格式")
 			return
 		}
 
 		// 检查源文件夹是否存在且可访问
 		srcInfo, err := os.Stat(arg.AlbumPath)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 源专辑文件夹不存在或无法访问:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusBadRequest, "源专辑文件夹不存在或无法访问:"+err.Error())
 			return
 		}
 		if !srcInfo.IsDir() {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 源路径不是一个文件夹",
-			})
-			return
-		}
-
-		// 创建 zip buffer
-		buffer := new(bytes.Buffer)
-		zipWriter := zip.NewWriter(buffer)
-
-		// 创建并写入元数据文件
-		metaWriter, err := zipWriter.Create(".keytone-album")
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 创建元数据文件失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusBadRequest, "源路径不是一个文件夹")
 			return
 		}
 
 		albumName, ok := audioPackageList.GetAudioPackageName(arg.AlbumPath).(string)
 		if !ok {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 获取专辑名称失败: 类型转换错误",
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "获取专辑名称失败: 类型转换错误")
 			return
 		}
 
@@ -1684,264 +2161,324 @@ func keytonePkgRouters(r *gin.Engine) {
 			AlbumName:   albumName,
 		}
 
-		metaJson, err := json.Marshal(meta)
+		// zip 先写入临时文件(v3 文件头需要提前知道明文长度), 避免整个专辑驻留内存
+		tempZip, err := os.CreateTemp("", "keytone_export_*.zip")
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 生成元数据失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "创建临时文件失败:"+err.Error())
 			return
 		}
+		defer os.Remove(tempZip.Name())
+		defer tempZip.Close()
 
-		_, err = metaWriter.Write(metaJson)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 写入元数据失败:" + err.Error(),
-			})
+		transfer.setStage(AlbumTransferStagePacking, albumDirSize(arg.AlbumPath))
+		if err := writeAlbumZip(transfer, tempZip, arg.AlbumPath, meta); err != nil {
+			transfer.abort(ctx, http.StatusInternalServerError, "压缩文件失败:"+err.Error())
 			return
 		}
 
-		// 遍历键音专辑文件夹并添加到zip
-		err = filepath.Walk(arg.AlbumPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return fmt.Errorf("遍历文件夹失败: %v", err)
-			}
-
-			// 获取相对路径
-			relPath, err := filepath.Rel(filepath.Dir(arg.AlbumPath), path)
-			if err != nil {
-				return fmt.Errorf("计算相对路径失败: %v", err)
-			}
-
-			// 统一使用正斜杠，确保跨平台兼容性
-			relPath = filepath.ToSlash(relPath)
-
-			// 创建zip文件头信息
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				return fmt.Errorf("创建文件头信息失败: %v", err)
-			}
-
-			// 使用标准化的路径
-			header.Name = relPath
-
-			if info.IsDir() {
-				header.Name += "/" // 确保目录以/结尾
-			} else {
-				header.Method = zip.Deflate // 使用压缩
-			}
-
-			writer, err := zipWriter.CreateHeader(header)
-			if err != nil {
-				return fmt.Errorf("创建zip条目失败: %v", err)
-			}
-
-			if info.IsDir() {
-				return nil
-			}
-
-			// 以只读方式打开源文件
-			file, err := os.OpenFile(path, os.O_RDONLY, 0)
-			if err != nil {
-				return fmt.Errorf("打开源文件失败: %v", err)
-			}
-			defer file.Close()
-
-			// 复制文件内容到zip
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return fmt.Errorf("写入zip文件失败: %v", err)
-			}
-
-			return nil
-		})
-
-		// 检查是否有压缩错误
+		zipSize, err := tempZip.Seek(0, io.SeekCurrent)
+		if err == nil {
+			_, err = tempZip.Seek(0, io.SeekStart)
+		}
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 压缩文件失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "读取临时文件失败:"+err.Error())
 			return
 		}
 
-		// 关闭zip writer
-		if err = zipWriter.Close(); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 关闭zip writer失败:" + err.Error(),
-			})
+		// 使用 v3 格式加密（分块 AES-256-GCM，文件头参与认证），边加密边写入响应
+		header, err := newAlbumHeaderV3(uint64(zipSize))
+		if err != nil {
+			transfer.abort(ctx, http.StatusInternalServerError, "加密专辑数据失败:"+err.Error())
 			return
 		}
+		headerBytes := header.bytes()
 
-		// 获取 zip 数据
-		zipData := buffer.Bytes()
-
-		// 计算校验和
-		checksum := sha256.Sum256(zipData)
-
-		// 加密 zip 数据（使用当前版本密钥 v2）
-		encryptedData := xorCrypt(zipData, getEncryptKeyByVersion(2))
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.ktalbum", filepath.Base(arg.AlbumPath)))
+		ctx.Header("Content-Type", "application/octet-stream")
+		ctx.Header("Content-Length", strconv.FormatUint(uint64(len(headerBytes))+header.DataSize, 10))
+		ctx.Status(http.StatusOK)
 
-		// 创建文件头（使用版本号 2）
-		header := KeytoneFileHeader{
-			Version:  2, // 使用 v2 版本号
-			DataSize: uint64(len(encryptedData)),
-			Checksum: checksum,
+		transfer.setStage(AlbumTransferStageEncrypting, int64(header.DataSize))
+		_, err = ctx.Writer.Write(headerBytes)
+		if err == nil {
+			err = encryptAlbumStreamV3(transfer.writer(ctx.Writer), bufio.NewReader(tempZip), header, getEncryptKeyByVersion(KeytoneFileVersionV3))
 		}
-		copy(header.Signature[:], KeytoneFileSignature)
-
-		// 写入最终文件
-		finalBuffer := new(bytes.Buffer)
-		if err := binary.Write(finalBuffer, binary.LittleEndian, header); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 写入文件头失败:" + err.Error(),
-			})
+		if err != nil {
+			// 响应头已发送, 无法再返回 JSON; 客户端会因内容长度不足而得知导出失败
+			logger.Error("导出专辑失败", "taskId", transfer.ID, "err", err.Error())
+			transfer.fail(err)
 			return
 		}
-		finalBuffer.Write(encryptedData)
-
-		// 设置响应头并发送数据
-		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.ktalbum", filepath.Base(arg.AlbumPath)))
-		ctx.Data(http.StatusOK, "application/octet-stream", finalBuffer.Bytes())
+		transfer.finish()
 	})
 
-	keytonePkgRouters.POST("/delete_album", func(ctx *gin.Context) {
-		type Arg struct {
-			AlbumPath string `json:"albumPath"`
+	// 导出为 Mechvibes 音效包(zip)
+	// 与 .ktalbum 导出相同, 要求授权的专辑只有原始作者或授权列表中的签名才能导出。
+	keytonePkgRouters.POST("/export_mechvibes", func(ctx *gin.Context) {
+		var arg struct {
+			AlbumPath   string `json:"albumPath"`
+			SignatureID string `json:"signatureId"`
+			// Mode 为 single(单个精灵音频, 默认) 或 multi(每个声音一个文件)
+			Mode string `json:"mode"`
 		}
-
-		var arg Arg
-		err := ctx.ShouldBind(&arg)
-		if err != nil || arg.AlbumPath == "" {
+		if err := ctx.ShouldBind(&arg); err != nil || arg.AlbumPath == "" {
 			ctx.JSON(http.StatusNotAcceptable, gin.H{
-				"message": "error: 参数接收--收到的前端数据内容值, 不符合接口规定格式:" + err.Error(),
+				"message": "error: 参数接收--收到的前端数据内容值不符合接口规定格式",
 			})
 			return
 		}
 
-		// * 在正式删除音频源文件之前, 需要先释放所有流的文件句柄, 因为在Win系统中, 不释放的话是没办法成功关闭的。
-		keySound.CloseAllStreams()
-
-		// * 正式删除现有目录
-		err = os.RemoveAll(arg.AlbumPath)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 删除键音专辑失败:" + err.Error(),
+		if srcInfo, err := os.Stat(arg.AlbumPath); err != nil || !srcInfo.IsDir() {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "error: 源专辑文件夹不存在或无法访问",
 			})
 			return
 		}
 
-		// 清除sdk中的已选择键音包
-		// TIPS: 若后续需要实现删除任意键音包, 则需要进行判断, 若删除的键音包中存在当前已选择的键音包, 才需要清除。
-		audioPackageConfig.ClearConfig()
-
-		ctx.JSON(200, gin.H{
-			"message": "ok",
-		})
-	})
-
-	// 修改导入处理函数
-	// 导入为新专辑
-	keytonePkgRouters.POST("/import_album_as_new", func(ctx *gin.Context) {
-		// 获取上传的文件
-		file, err := ctx.FormFile("file")
+		isAuthorized, err := audioPackageConfig.CheckExportAuthorization(arg.AlbumPath, arg.SignatureID)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 文件上传失败:" + err.Error(),
+			logger.Error("检查导出授权失败", "error", err.Error())
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: " + err.Error(),
 			})
 			return
 		}
-
-		// 获取新的专辑ID
-		newAlbumId := ctx.PostForm("newAlbumId")
-		if !isValidNanoID(newAlbumId) {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的专辑ID格式",
+		if !isAuthorized {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "error: 该专辑需要原始作者授权才能导出",
 			})
 			return
 		}
 
-		// 检查文件扩展名
-		if !strings.HasSuffix(strings.ToLower(file.Filename), ".ktalbum") {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的文件格式，请选择 .ktalbum 文件",
+		snapshot, err := keySound.LoadAlbumSnapshot(arg.AlbumPath)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 读取专辑配置失败:" + err.Error(),
 			})
 			return
 		}
 
-		// 读取文件数据
-		src, err := file.Open()
+		tempDir, err := os.MkdirTemp("", "keytone_mechvibes_export_*")
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 打开文件失败:" + err.Error(),
+				"message": "error: 创建临时目录失败:" + err.Error(),
 			})
 			return
 		}
-		defer src.Close()
+		defer os.RemoveAll(tempDir)
 
-		// 读取文件头并验证
-		var header KeytoneFileHeader
-		if err := binary.Read(src, binary.LittleEndian, &header); err != nil {
+		result, err := mechvibes.Export(snapshot.GetValue, tempDir, mechvibes.ExportOptions{
+			Mode:       arg.Mode,
+			SampleRate: keySound.RenderSampleRate(),
+			Render: func(ref mechvibes.SoundRef) ([][2]float64, error) {
+				if ref.Kind == mechvibes.RefSounds {
+					return keySound.RenderSound(snapshot.GetValue, arg.AlbumPath, ref.SoundID)
+				}
+				return keySound.RenderAudioFile(snapshot.GetValue, arg.AlbumPath, ref.Sha256, ref.FileType)
+			},
+		})
+		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 读取文件头失败:" + err.Error(),
+				"message": "error: 导出 Mechvibes 音效包失败:" + err.Error(),
 			})
 			return
 		}
+		if len(result.Skipped) > 0 {
+			logger.Warn("导出 Mechvibes 音效包时跳过了部分按键", "skipped", result.Skipped)
+		}
 
-		// 验证文件签名
-		if string(header.Signature[:]) != KeytoneFileSignature {
+		// 音效包以专辑ID为根目录打包, 与 Mechvibes 自定义音效包的目录结构一致
+		buffer := new(bytes.Buffer)
+		zipWriter := zip.NewWriter(buffer)
+		packRoot := filepath.Base(arg.AlbumPath)
+		entries, err := os.ReadDir(tempDir)
+		if err == nil {
+			for _, entry := range entries {
+				var data []byte
+				data, err = os.ReadFile(filepath.Join(tempDir, entry.Name()))
+				if err != nil {
+					break
+				}
+				var writer io.Writer
+				writer, err = zipWriter.Create(packRoot + "/" + entry.Name())
+				if err != nil {
+					break
+				}
+				if _, err = writer.Write(data); err != nil {
+					break
+				}
+			}
+		}
+		if err == nil {
+			err = zipWriter.Close()
+		}
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 压缩文件失败:" + err.Error(),
+			})
+			return
+		}
+
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-mechvibes.zip", packRoot))
+		ctx.Data(http.StatusOK, "application/zip", buffer.Bytes())
+	})
+
+	// 离线渲染一次按键或一段按键脚本为 WAV(见 keySound/render_sequence.go), 不需要声卡与真实按键。
+	//   - keycode + state: 渲染一次按键(state 为 down/up/hold, modifiers 形如 "ctrl+shift");
+	//   - events: 按时间渲染按键脚本(与输入事件录制文件的格式一致), 提供时优先于 keycode。
+	// 与导出相同, 要求授权的专辑只有原始作者或授权列表中的签名才能渲染。
+	keytonePkgRouters.POST("/render_wav", func(ctx *gin.Context) {
+		var arg struct {
+			AlbumPath   string                 `json:"albumPath"`
+			SignatureID string                 `json:"signatureId"`
+			Keycode     string                 `json:"keycode"`
+			State       string                 `json:"state"`
+			Modifiers   string                 `json:"modifiers"`
+			Events      []keySound.RenderEvent `json:"events"`
+			Seed        int64                  `json:"seed"`
+		}
+		if err := ctx.ShouldBind(&arg); err != nil || arg.AlbumPath == "" || (len(arg.Events) == 0 && arg.Keycode == "") {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值不符合接口规定格式",
+			})
+			return
+		}
+		modifiers, err := keySound.ParseModifiers(arg.Modifiers)
+		if err != nil {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 修饰键格式不正确:" + err.Error(),
+			})
+			return
+		}
+
+		if srcInfo, err := os.Stat(arg.AlbumPath); err != nil || !srcInfo.IsDir() {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的文件格式：不是 KeyTone 专辑文件",
+				"message": "error: 源专辑文件夹不存在或无法访问",
 			})
 			return
 		}
 
-		// 解压和处理文件
-		tempDir, err := os.MkdirTemp("", "keytone_import_*")
+		isAuthorized, err := audioPackageConfig.CheckExportAuthorization(arg.AlbumPath, arg.SignatureID)
 		if err != nil {
+			logger.Error("检查导出授权失败", "error", err.Error())
 			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 创建临时目录失败:" + err.Error(),
+				"message": "error: " + err.Error(),
+			})
+			return
+		}
+		if !isAuthorized {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "error: 该专辑需要原始作者授权才能渲染",
 			})
 			return
 		}
-		defer os.RemoveAll(tempDir)
 
-		tempZipPath := filepath.Join(tempDir, "temp.zip")
-		if err := processImportedFile(src, header, tempZipPath); err != nil {
-			if importErr, ok := err.(*ImportError); ok {
-				ctx.JSON(http.StatusBadRequest, gin.H{
-					"message": "error: " + importErr.Message,
-				})
-				return
+		var samples [][2]float64
+		options := keySound.RenderOptions{Seed: arg.Seed}
+		if len(arg.Events) > 0 {
+			samples, err = keySound.RenderKeyEvents(arg.AlbumPath, arg.Events, options)
+		} else {
+			state := arg.State
+			if state == "" {
+				state = keySound.KeyStateDown
 			}
+			samples, err = keySound.RenderKeyState(arg.AlbumPath, arg.Keycode, state, modifiers, options)
+		}
+		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: " + err.Error(),
+				"message": "error: 渲染失败:" + err.Error(),
 			})
 			return
 		}
 
-		// 打开zip文件进行验证
-		zipReader, err := zip.OpenReader(tempZipPath)
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-render.wav", filepath.Base(arg.AlbumPath)))
+		ctx.Data(http.StatusOK, "audio/wav", mechvibes.EncodeWAV(samples, keySound.RenderSampleRate()))
+	})
+
+	keytonePkgRouters.POST("/delete_album", func(ctx *gin.Context) {
+		type Arg struct {
+			AlbumPath string `json:"albumPath"`
+		}
+
+		var arg Arg
+		err := ctx.ShouldBind(&arg)
+		if err != nil || arg.AlbumPath == "" {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值, 不符合接口规定格式:" + err.Error(),
+			})
+			return
+		}
+
+		// * 在正式删除音频源文件之前, 需要先释放所有流的文件句柄, 因为在Win系统中, 不释放的话是没办法成功关闭的。
+		keySound.CloseAllStreams()
+		// * 专辑删除后, 其解码缓存也不再有效。
+		keySound.InvalidatePCMCacheAlbum(filepath.Base(arg.AlbumPath))
+
+		// * 正式删除现有目录
+		err = os.RemoveAll(arg.AlbumPath)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 打开zip文件失败:" + err.Error(),
+				"message": "error: 删除键音专辑失败:" + err.Error(),
 			})
 			return
 		}
+
+		// 清除sdk中的已选择键音包
+		// TIPS: 若后续需要实现删除任意键音包, 则需要进行判断, 若删除的键音包中存在当前已选择的键音包, 才需要清除。
+		audioPackageConfig.ClearConfig()
+
+		ctx.JSON(200, gin.H{
+			"message": "ok",
+		})
+	})
+
+	// 修改导入处理函数
+	// 导入为新专辑
+	keytonePkgRouters.POST("/import_album_as_new", func(ctx *gin.Context) {
+		// 导入过程以流的形式进行: 接收/解密/解压的进度通过 /stream 广播(taskId 可通过查询参数指定)
+		transfer := startAlbumTransfer(ctx, AlbumTransferImport)
+		defer transfer.end()
+
+		// 创建临时目录
+		tempDir, err := os.MkdirTemp("", "keytone_import_*")
+		if err != nil {
+			transfer.abort(ctx, http.StatusInternalServerError, "创建临时目录失败:"+err.Error())
+			return
+		}
+		defer os.RemoveAll(tempDir)
+
+		// 逐段读取上传内容, 并将文件流式解密到临时zip文件
+		upload, err := receiveAlbumUpload(transfer, ctx.Request, tempDir)
+		if err != nil {
+			transfer.abortImport(ctx, err)
+			return
+		}
+
+		// 获取新的专辑ID
+		newAlbumId := upload.Fields["newAlbumId"]
+		if !isValidNanoID(newAlbumId) {
+			transfer.abort(ctx, http.StatusBadRequest, "无效的专辑ID格式")
+			return
+		}
+
+		// 打开zip文件进行验证
+		zipReader, archiveSize, err := openZipForImport(upload.ZipPath)
+		if err != nil {
+			transfer.abortImport(ctx, err)
+			return
+		}
 		defer zipReader.Close()
 
 		// 验证zip内的元数据
 		if err := validateAlbumMeta(zipReader); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: " + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
 
 		// 解压到临时目录并验证结构
-		albumPath, err := extractAndValidateAlbum(zipReader, tempDir)
+		albumPath, err := extractAndValidateAlbum(transfer, zipReader, archiveSize, tempDir)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": err.Error(),
-			})
+			transfer.abortImport(ctx, err)
 			return
 		}
 
@@ -1951,9 +2488,7 @@ func keytonePkgRouters(r *gin.Engine) {
 
 		// 复制到目标路径
 		if err := copyDir(albumPath, targetPath); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 复制专辑文件夹失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "复制专辑文件夹失败:"+err.Error())
 			return
 		}
 
@@ -1961,20 +2496,18 @@ func keytonePkgRouters(r *gin.Engine) {
 		if err := audioPackageList.UpdateAlbumUUID(targetPath, newAlbumId, originalAlbumID); err != nil {
 			// 如果更新失败，清理已复制的文件夹
 			os.RemoveAll(targetPath)
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 更新专辑配置失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "更新专辑配置失败:"+err.Error())
 			return
 		}
 
+		transfer.finish()
 		ctx.JSON(http.StatusOK, gin.H{
 			"message": "ok",
 		})
 	})
 
-	// 原有的导入专辑路由
-	keytonePkgRouters.POST("/import_album", func(ctx *gin.Context) {
-		// 获取上传的文件
+	// 导入 Mechvibes 音效包(zip), 转换为新的 KeyTone 专辑
+	keytonePkgRouters.POST("/import_mechvibes", func(ctx *gin.Context) {
 		file, err := ctx.FormFile("file")
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{
@@ -1982,195 +2515,133 @@ func keytonePkgRouters(r *gin.Engine) {
 			})
 			return
 		}
+		if !strings.HasSuffix(strings.ToLower(file.Filename), ".zip") {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "error: 无效的文件格式，请选择 Mechvibes 音效包的 .zip 文件",
+			})
+			return
+		}
 
-		// 检查是否是覆盖模式
-		overwrite := ctx.PostForm("overwrite") == "true"
-
-		// 检查文件扩展名
-		if !strings.HasSuffix(strings.ToLower(file.Filename), ".ktalbum") {
+		// 专辑ID可由前端指定(与 import_album_as_new 一致), 未指定时由后端生成
+		newAlbumId := ctx.PostForm("newAlbumId")
+		if newAlbumId == "" {
+			newAlbumId, err = generateNanoID()
+			if err != nil {
+				ctx.JSON(http.StatusInternalServerError, gin.H{
+					"message": "error: 生成专辑ID失败:" + err.Error(),
+				})
+				return
+			}
+		} else if !isValidNanoID(newAlbumId) {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的文件格式，请选择 .ktalbum 文件",
+				"message": "error: 无效的专辑ID格式",
 			})
 			return
 		}
 
-		// 读取文件数据
-		src, err := file.Open()
+		tempDir, err := os.MkdirTemp("", "keytone_mechvibes_*")
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 打开文件失败:" + err.Error(),
+				"message": "error: 创建临时目录失败:" + err.Error(),
 			})
 			return
 		}
-		defer src.Close()
+		defer os.RemoveAll(tempDir)
 
-		// 读取文件头
-		var header KeytoneFileHeader
-		if err := binary.Read(src, binary.LittleEndian, &header); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 读取文件头失败:" + err.Error(),
+		tempZipPath := filepath.Join(tempDir, "pack.zip")
+		if err := ctx.SaveUploadedFile(file, tempZipPath); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "error: 保存上传文件失败:" + err.Error(),
 			})
 			return
 		}
-
-		// 验证文件签名
-		if string(header.Signature[:]) != KeytoneFileSignature {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的文件格式：不是 KeyTone 专辑文件",
-			})
+		packDir := filepath.Join(tempDir, "pack")
+		if err := extractZipToDir(tempZipPath, packDir); err != nil {
+			response := gin.H{
+				"message": "error: " + err.Error(),
+			}
+			if importErr, ok := err.(*ImportError); ok {
+				response["reason"] = importErr.Reason
+			}
+			ctx.JSON(http.StatusBadRequest, response)
 			return
 		}
-
-		// 读取加密的数据
-		encryptedData := make([]byte, header.DataSize)
-		if _, err := io.ReadFull(src, encryptedData); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 读取文件数据失败:" + err.Error(),
+		packRoot, err := mechvibes.FindPackRoot(packDir)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "error: " + err.Error(),
 			})
 			return
 		}
 
-		// 解密数据（支持多版本密钥）
-		zipData, usedVersion, err := decryptAlbumData(encryptedData, header)
+		result, err := mechvibes.Convert(packRoot, filepath.Join(audioPackageConfig.AudioPackagePath, newAlbumId))
 		if err != nil {
+			logger.Error("导入 Mechvibes 音效包失败", "err", err.Error())
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: " + err.Error(),
+				"message": "error: 转换 Mechvibes 音效包失败:" + err.Error(),
 			})
 			return
 		}
-		logger.Info("专辑数据解密成功", "file_version", header.Version, "used_key_version", usedVersion)
 
-		// 创建临时文件保存 zip 数据
+		ctx.JSON(http.StatusOK, gin.H{
+			"message":   "ok",
+			"albumPath": result.AlbumPath,
+			"result":    result,
+		})
+	})
+
+	// 原有的导入专辑路由
+	keytonePkgRouters.POST("/import_album", func(ctx *gin.Context) {
+		// 导入过程以流的形式进行: 接收/解密/解压的进度通过 /stream 广播(taskId 可通过查询参数指定)
+		transfer := startAlbumTransfer(ctx, AlbumTransferImport)
+		defer transfer.end()
+
+		// 创建临时目录
 		tempDir, err := os.MkdirTemp("", "keytone_import_*")
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 创建临时目录失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "创建临时目录失败:"+err.Error())
 			return
 		}
 		defer os.RemoveAll(tempDir)
 
-		tempZipPath := filepath.Join(tempDir, "temp.zip")
-		if err := os.WriteFile(tempZipPath, zipData, 0644); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 保存临时文件失败:" + err.Error(),
-			})
+		// 逐段读取上传内容, 并将文件流式解密到临时zip文件
+		upload, err := receiveAlbumUpload(transfer, ctx.Request, tempDir)
+		if err != nil {
+			transfer.abortImport(ctx, err)
 			return
 		}
 
+		// 检查是否是覆盖模式
+		overwrite := upload.Fields["overwrite"] == "true"
+
 		// 打开zip文件进行验证
-		zipReader, err := zip.OpenReader(tempZipPath)
+		zipReader, archiveSize, err := openZipForImport(upload.ZipPath)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 打开zip文件失败:" + err.Error(),
-			})
+			transfer.abortImport(ctx, err)
 			return
 		}
 		defer zipReader.Close()
 
 		// 验证zip内的元数据
 		if err := validateAlbumMeta(zipReader); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: " + err.Error(),
-			})
-			return
-		}
-
-		// 解压到临时目录
-		for _, file := range zipReader.File {
-			// 构建完整的目标路径
-			targetPath := filepath.Join(tempDir, file.Name)
-
-			// 确保目标目录存在
-			if file.FileInfo().IsDir() {
-				os.MkdirAll(targetPath, 0755)
-				continue
-			}
-
-			// 创建目标文件的父目录
-			os.MkdirAll(filepath.Dir(targetPath), 0755)
-
-			// 创建目标文件
-			outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
-			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"message": "error: 创建目标文件失败:" + err.Error(),
-				})
-				return
-			}
-
-			// 打开源文件
-			inFile, err := file.Open()
-			if err != nil {
-				outFile.Close()
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"message": "error: 打开源文件失败:" + err.Error(),
-				})
-				return
-			}
-
-			// 复制文件内容
-			_, err = io.Copy(outFile, inFile)
-			outFile.Close()
-			inFile.Close()
-			if err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"message": "error: 复制文件内容失败:" + err.Error(),
-				})
-				return
-			}
-		}
-
-		// 获取解压后的专辑目录名
-		files, err := os.ReadDir(tempDir)
-		if err != nil || len(files) == 0 {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 读取解压目录失败或目录为空:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		// 检查是否只有一个目录
-		var albumDir os.DirEntry
-		for _, f := range files {
-			if f.IsDir() {
-				fmt.Println("跨平台一致性校验", f)
-				if albumDir != nil {
-					ctx.JSON(http.StatusBadRequest, gin.H{
-						"message": "error: zip 文件中包含多个目录",
-					})
-					return
-				}
-				albumDir = f
-			}
-		}
-
-		if albumDir == nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: zip 文件中未找到专辑目录",
-			})
-			return
-		}
-
-		albumPath := filepath.Join(tempDir, albumDir.Name())
-		targetPath := filepath.Join(audioPackageConfig.AudioPackagePath, albumDir.Name())
-
-		// 验证专辑结构
-		if err := isValidAlbumStructure(albumPath); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的专辑格式: " + err.Error(),
-			})
+		// 解压到临时目录并验证结构
+		albumPath, err := extractAndValidateAlbum(transfer, zipReader, archiveSize, tempDir)
+		if err != nil {
+			transfer.abortImport(ctx, err)
 			return
 		}
+		targetPath := filepath.Join(audioPackageConfig.AudioPackagePath, filepath.Base(albumPath))
 
 		// 获取新的专辑ID（如果提供）
-		newAlbumId := ctx.PostForm("newAlbumId")
+		newAlbumId := upload.Fields["newAlbumId"]
 		if newAlbumId != "" {
 			// 验证新的专辑ID是否符合nanoid格式
 			if !isValidNanoID(newAlbumId) {
-				ctx.JSON(http.StatusBadRequest, gin.H{
-					"message": "error: 无效的专辑ID格式",
-				})
+				transfer.abort(ctx, http.StatusBadRequest, "无效的专辑ID格式")
 				return
 			}
 			// 使用新的专辑ID更新目标路径
@@ -2182,6 +2653,7 @@ func keytonePkgRouters(r *gin.Engine) {
 			// 目标已存在
 			if !overwrite {
 				// 如果不是覆盖模式，返回特殊状态
+				transfer.finish()
 				ctx.JSON(http.StatusOK, gin.H{
 					"message": "album_exists",
 				})
@@ -2193,24 +2665,23 @@ func keytonePkgRouters(r *gin.Engine) {
 			// time.Sleep(10 * time.Millisecond)
 			// // * 需要调用两次的原因是 -> 前端在单击ui中的删除按钮时的行为本身, 会增加一个额外的正在播放的声音流, 而由于sync.map天然的锁机制, 它并不会包含在上述的关闭流程中。
 			// keySound.CloseAllStreams() // 由于CloseAllStreams()函数内部已通过升级变得足够可靠, 因此无需再进行二次调用。
+			// * 被覆盖专辑的解码缓存不再有效。
+			keySound.InvalidatePCMCacheAlbum(filepath.Base(targetPath))
 
 			// * 正式删除现有目录
 			if err := os.RemoveAll(targetPath); err != nil {
-				ctx.JSON(http.StatusInternalServerError, gin.H{
-					"message": "error: 删除现有专辑失败:" + err.Error(),
-				})
+				transfer.abort(ctx, http.StatusInternalServerError, "删除现有专辑失败:"+err.Error())
 				return
 			}
 		}
 
 		// 使用复制替代移动
 		if err := copyDir(albumPath, targetPath); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 复制专辑文件夹失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "复制专辑文件夹失败:"+err.Error())
 			return
 		}
 
+		transfer.finish()
 		ctx.JSON(http.StatusOK, gin.H{
 			"message": "ok",
 		})
@@ -2218,94 +2689,27 @@ func keytonePkgRouters(r *gin.Engine) {
 
 	// 获取专辑文件的元数据信息
 	keytonePkgRouters.POST("/get_album_meta", func(ctx *gin.Context) {
-		file, err := ctx.FormFile("file")
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 文件上传失败:" + err.Error(),
-			})
-			return
-		}
+		transfer := startAlbumTransfer(ctx, AlbumTransferMeta)
+		defer transfer.end()
 
-		// 检查文件扩展名
-		if !strings.HasSuffix(strings.ToLower(file.Filename), ".ktalbum") {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的文件格式，请选择 .ktalbum 文件",
-			})
-			return
-		}
-
-		src, err := file.Open()
+		tempDir, err := os.MkdirTemp("", "keytone_meta_*")
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 打开文件失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "创建临时目录失败:"+err.Error())
 			return
 		}
-		defer src.Close()
-
-		// 读取文件头
-		var header KeytoneFileHeader
-		if err := binary.Read(src, binary.LittleEndian, &header); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 读取文件头失败:" + err.Error(),
-			})
-			return
-		}
-
-		// 验证文件签名
-		if string(header.Signature[:]) != KeytoneFileSignature {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 无效的文件格式：不是 KeyTone 专辑文件",
-			})
-			return
-		}
-
-		// 读取加密的数据
-		encryptedData := make([]byte, header.DataSize)
-		if _, err := io.ReadFull(src, encryptedData); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 读取文件数据失败:" + err.Error(),
-			})
-			return
-		}
-
-		// 解密数据（支持多版本密钥）
-		zipData, usedVersion, err := decryptAlbumData(encryptedData, header)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: " + err.Error(),
-			})
-			return
-		}
-		logger.Info("专辑数据解密成功", "file_version", header.Version, "used_key_version", usedVersion)
+		defer os.RemoveAll(tempDir)
 
-		// 创建临时zip文件
-		tempFile, err := os.CreateTemp("", "keytone_meta_*.zip")
+		// 逐段读取上传内容, 并将文件流式解密到临时zip文件
+		upload, err := receiveAlbumUpload(transfer, ctx.Request, tempDir)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 创建临时文件失败:" + err.Error(),
-			})
-			return
-		}
-		tempPath := tempFile.Name()
-		defer os.Remove(tempPath)
-		defer tempFile.Close()
-
-		// 写入zip数据
-		if _, err := tempFile.Write(zipData); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 写入临时文件失败:" + err.Error(),
-			})
+			transfer.abortImport(ctx, err)
 			return
 		}
-		tempFile.Close()
 
 		// 打开zip文件
-		zipReader, err := zip.OpenReader(tempPath)
+		zipReader, _, err := openZipForImport(upload.ZipPath)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 打开zip文件失败:" + err.Error(),
-			})
+			transfer.abortImport(ctx, err)
 			return
 		}
 		defer zipReader.Close()
@@ -2320,35 +2724,56 @@ func keytonePkgRouters(r *gin.Engine) {
 		}
 
 		if metaFile == nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "error: 不是有效的 KeyTone 专辑文件：缺少元数据",
-			})
+			transfer.abort(ctx, http.StatusBadRequest, "不是有效的 KeyTone 专辑文件：缺少元数据")
 			return
 		}
 
 		// 读取元数据文件
 		rc, err := metaFile.Open()
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 读取元数据失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "读取元数据失败:"+err.Error())
 			return
 		}
 		defer rc.Close()
 
 		var meta KeytoneAlbumMeta
 		if err := json.NewDecoder(rc).Decode(&meta); err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"message": "error: 解析元数据失败:" + err.Error(),
-			})
+			transfer.abort(ctx, http.StatusInternalServerError, "解析元数据失败:"+err.Error())
 			return
 		}
 
 		// 返回专辑元数据
+		transfer.finish()
 		ctx.JSON(http.StatusOK, gin.H{
 			"message": "ok",
 			"meta":    meta,
 		})
 	})
 
+	// 取消进行中的专辑导入/导出任务(taskId 与发起请求时的查询参数一致)
+	keytonePkgRouters.POST("/cancel_album_transfer", func(ctx *gin.Context) {
+		var arg struct {
+			TaskID string `json:"taskId"`
+		}
+		if err := ctx.ShouldBind(&arg); err != nil || arg.TaskID == "" {
+			ctx.JSON(http.StatusNotAcceptable, gin.H{
+				"message": "error: 参数接收--收到的前端数据内容值不符合接口规定格式",
+			})
+			return
+		}
+
+		task, ok := albumTransferTasks.Load(arg.TaskID)
+		if !ok {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": "error: 未找到进行中的传输任务",
+			})
+			return
+		}
+		task.(*albumTransfer).cancel()
+
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "ok",
+		})
+	})
+
 }
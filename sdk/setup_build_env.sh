@@ -56,6 +56,7 @@ KEYS_TO_PROCESS=(
     "KEY_ALBUM_SIGNATURE_FIELD:KeyTone/signature.KeyToneAlbumSignatureEncryptionKey"
     "KEY_ALBUM_EXPORT_V1:KeyTone/server.KeytoneEncryptKeyV1"
     "KEY_ALBUM_EXPORT_V2:KeyTone/server.KeytoneEncryptKeyV2"
+    "KEY_ALBUM_EXPORT_V3:KeyTone/server.KeytoneEncryptKeyV3"
     "KEY_ALBUM_CONFIG_SECRET:KeyTone/audioPackage/enc.FixedSecret"
     # 示例：新增密钥时，取消注释并修改下行
     # "KEY_NEW:KeyTone/signature.KeyToneNewKey"
@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -35,6 +34,10 @@ func Extract(inputFile, outputFile string, verbose bool) error {
 	if verbose {
 		fmt.Printf("文件版本: %d\n", header.Version)
 		fmt.Printf("数据大小: %d bytes\n", header.DataSize)
+		if header.Version == utils.KeytoneFileVersionV3 {
+			headerV3 := utils.HeaderV3FromLegacy(header)
+			fmt.Printf("加密方式: AES-256-GCM（分块 %d bytes，明文 %d bytes）\n", headerV3.ChunkSize, headerV3.PlainSize)
+		}
 	}
 
 	// 读取加密数据
@@ -43,35 +46,10 @@ func Extract(inputFile, outputFile string, verbose bool) error {
 		return fmt.Errorf("读取加密数据失败: %v", err)
 	}
 
-	// 解密数据（按版本选择候选密钥；私有密钥构建优先注入，回退默认，兼容开源产物）
-	var zipData []byte
-	decrypted := false
-
-	for _, decryptKey := range utils.GetDecryptKeyCandidatesByVersion(header.Version) {
-		candidate := utils.XorCrypt(encryptedData, decryptKey)
-		checksum := sha256.Sum256(candidate)
-		if checksum == header.Checksum {
-			zipData = candidate
-			decrypted = true
-			break
-		}
-	}
-
-	// 与 SDK 一致：若校验失败且版本不是 v1，尝试 v1 候选回退
-	if !decrypted && header.Version != 1 {
-		for _, decryptKey := range utils.GetDecryptKeyCandidatesByVersion(1) {
-			candidate := utils.XorCrypt(encryptedData, decryptKey)
-			checksum := sha256.Sum256(candidate)
-			if checksum == header.Checksum {
-				zipData = candidate
-				decrypted = true
-				break
-			}
-		}
-	}
-
-	if !decrypted {
-		return fmt.Errorf("文件校验失败，文件可能已损坏或密钥不匹配")
+	// 解密数据（v3 认证解密；v1/v2 按版本选择候选密钥；私有密钥构建优先注入，回退默认，兼容开源产物）
+	zipData, err := utils.DecryptAlbumData(encryptedData, header)
+	if err != nil {
+		return err
 	}
 
 	// 写入解密后的zip数据
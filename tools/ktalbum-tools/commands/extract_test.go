@@ -1,8 +1,16 @@
 package commands
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"ktalbum-tools/utils"
 )
 
 func TestExtract(t *testing.T) {
@@ -21,4 +29,88 @@ func TestExtract(t *testing.T) {
 	if err == nil {
 		t.Error("应该返回错误：文件不存在")
 	}
-} 
\ No newline at end of file
+} 
+
+// writeTestAlbumFile 生成包含元数据的 .ktalbum 文件, version 为 2 时使用旧版 XOR 格式。
+func writeTestAlbumFile(t *testing.T, path string, version uint8, tamper bool) []byte {
+	t.Helper()
+	zipBuffer := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(zipBuffer)
+	writer, err := zipWriter.Create(keytoneMetaFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewEncoder(writer).Encode(utils.KeytoneAlbumMeta{
+		MagicNumber: keytoneMagicNumber,
+		Version:     keytoneMetaVersion,
+		ExportTime:  time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		AlbumUUID:   "album-uuid",
+		AlbumName:   "Test Album",
+	})
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zipData := zipBuffer.Bytes()
+
+	var header utils.KeytoneFileHeader
+	var encryptedData []byte
+	if version == utils.KeytoneFileVersionV3 {
+		header, encryptedData, err = utils.EncryptAlbumV3(zipData, utils.GetEncryptKeyByVersion(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		header = utils.KeytoneFileHeader{Version: version, DataSize: uint64(len(zipData)), Checksum: utils.CalculateChecksum(zipData)}
+		copy(header.Signature[:], utils.KeytoneFileSignature)
+		encryptedData = utils.XorCrypt(zipData, utils.GetEncryptKeyByVersion(version))
+	}
+	if tamper {
+		encryptedData[len(encryptedData)/2] ^= 0x01
+	}
+
+	fileBuffer := new(bytes.Buffer)
+	binary.Write(fileBuffer, binary.LittleEndian, header)
+	fileBuffer.Write(encryptedData)
+	if err := os.WriteFile(path, fileBuffer.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return zipData
+}
+
+func TestExtractAndInfoSupportV2AndV3(t *testing.T) {
+	for _, version := range []uint8{2, utils.KeytoneFileVersionV3} {
+		dir := t.TempDir()
+		albumFile := filepath.Join(dir, "album.ktalbum")
+		zipData := writeTestAlbumFile(t, albumFile, version, false)
+
+		outputFile := filepath.Join(dir, "album.zip")
+		if err := Extract(albumFile, outputFile, false); err != nil {
+			t.Fatalf("v%d: Extract returned error: %v", version, err)
+		}
+		extracted, err := os.ReadFile(outputFile)
+		if err != nil || !bytes.Equal(extracted, zipData) {
+			t.Fatalf("v%d: extracted zip does not match original", version)
+		}
+
+		info, err := GetFileInfo(albumFile)
+		if err != nil {
+			t.Fatalf("v%d: GetFileInfo returned error: %v", version, err)
+		}
+		if info.Name != "Test Album" || info.Version != version || info.AlbumUUID != "album-uuid" {
+			t.Fatalf("v%d: unexpected info: %+v", version, info)
+		}
+	}
+}
+
+func TestExtractRejectsTamperedV3(t *testing.T) {
+	dir := t.TempDir()
+	albumFile := filepath.Join(dir, "album.ktalbum")
+	writeTestAlbumFile(t, albumFile, utils.KeytoneFileVersionV3, true)
+
+	if err := Extract(albumFile, filepath.Join(dir, "album.zip"), false); err == nil {
+		t.Fatal("expected tampered v3 file to be rejected")
+	}
+	if _, err := GetFileInfo(albumFile); err == nil {
+		t.Fatal("expected GetFileInfo to reject tampered v3 file")
+	}
+}
@@ -1,7 +1,6 @@
 package commands
 
 import (
-	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -43,35 +42,10 @@ func GetFileInfo(filePath string) (*FileInfo, error) {
 		return nil, fmt.Errorf("读取加密数据失败: %v", err)
 	}
 
-	// 解密数据（按版本选择候选密钥；私有密钥构建优先注入，回退默认，兼容开源产物）
-	var zipData []byte
-	decrypted := false
-
-	for _, decryptKey := range utils.GetDecryptKeyCandidatesByVersion(header.Version) {
-		candidate := utils.XorCrypt(encryptedData, decryptKey)
-		checksum := utils.CalculateChecksum(candidate)
-		if bytes.Equal(checksum[:], header.Checksum[:]) {
-			zipData = candidate
-			decrypted = true
-			break
-		}
-	}
-
-	// 与 SDK 一致：若校验失败且版本不是 v1，尝试 v1 候选回退
-	if !decrypted && header.Version != 1 {
-		for _, decryptKey := range utils.GetDecryptKeyCandidatesByVersion(1) {
-			candidate := utils.XorCrypt(encryptedData, decryptKey)
-			checksum := utils.CalculateChecksum(candidate)
-			if bytes.Equal(checksum[:], header.Checksum[:]) {
-				zipData = candidate
-				decrypted = true
-				break
-			}
-		}
-	}
-
-	if !decrypted {
-		return nil, fmt.Errorf("文件校验失败，文件可能已损坏或密钥不匹配")
+	// 解密数据（v3 认证解密；v1/v2 按版本选择候选密钥；私有密钥构建优先注入，回退默认，兼容开源产物）
+	zipData, err := utils.DecryptAlbumData(encryptedData, header)
+	if err != nil {
+		return nil, err
 	}
 
 	// 从 zip 数据中读取 .keytone-album 文件
@@ -0,0 +1,494 @@
+package commands
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ktalbum-tools/utils"
+
+	"KeyTone/keySound/audio"
+)
+
+// 本文件的转换规则与 SDK 中 audioPackage/mechvibes 保持一致, 修改任一侧时请同步另一侧。
+// 可导入的音频格式直接取自与 KeyTone 共用的格式表(KeyTone/keySound/audio), 无需同步。
+
+const (
+	mechvibesConfigFileName = "config.json"
+	mechvibesUpSuffix       = "-up"
+
+	keytoneMagicNumber  = "KTAF"
+	keytoneMetaVersion  = "1.0.0"
+	keytoneMetaFileName = ".keytone-album"
+	keytoneFileVersion  = utils.KeytoneFileVersionV3
+)
+
+// mechvibesKeycodeTable 为 Mechvibes 与 KeyTone 存在差异的编辑区/方向键键码, 其余键码两者一致。
+var mechvibesKeycodeTable = map[uint16][]uint16{
+	3655:  {60999, 3655},  // Home
+	3657:  {61001, 3657},  // PageUp
+	3663:  {61007, 3663},  // End
+	3665:  {61009, 3665},  // PageDown
+	3666:  {61010, 3666},  // Insert
+	3667:  {61011, 3667},  // Delete
+	57416: {61000, 57416}, // Up
+	57419: {61003, 57419}, // Left
+	57421: {61005, 57421}, // Right
+	57424: {61008, 57424}, // Down
+}
+
+type mechvibesPack struct {
+	ID            string                     `json:"id"`
+	Name          string                     `json:"name"`
+	KeyDefineType string                     `json:"key_define_type"`
+	Sound         string                     `json:"sound"`
+	SoundUp       string                     `json:"soundup"`
+	Defines       map[string]json.RawMessage `json:"defines"`
+}
+
+// MechvibesImportResult 为一次导入的摘要。
+type MechvibesImportResult struct {
+	AlbumUUID  string
+	AlbumPath  string
+	AlbumName  string
+	AudioFiles int
+	Sounds     int
+	Keys       int
+	Skipped    []string
+}
+
+// ImportMechvibes 将 Mechvibes 音效包(目录或 .zip)转换为 outputDir 下的 KeyTone 专辑目录。
+// ktalbumFile 非空时, 额外将专辑打包为可直接在 KeyTone 中导入的 .ktalbum 文件。
+func ImportMechvibes(input string, outputDir string, ktalbumFile string, verbose bool) (*MechvibesImportResult, error) {
+	packDir := input
+	if strings.HasSuffix(strings.ToLower(input), ".zip") {
+		tempDir, err := os.MkdirTemp("", "ktalbum_mechvibes_*")
+		if err != nil {
+			return nil, fmt.Errorf("创建临时目录失败: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+		if err := unzipToDir(input, tempDir); err != nil {
+			return nil, err
+		}
+		packDir = tempDir
+	}
+	packDir, err := findMechvibesPackRoot(packDir)
+	if err != nil {
+		return nil, err
+	}
+
+	albumID, err := newNanoID()
+	if err != nil {
+		return nil, err
+	}
+	albumDir := filepath.Join(outputDir, albumID)
+	if verbose {
+		fmt.Printf("正在转换: %s -> %s\n", packDir, albumDir)
+	}
+
+	result, err := convertMechvibesPack(packDir, albumDir)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		fmt.Printf("专辑名称: %s\n", result.AlbumName)
+		fmt.Printf("音频文件: %d, 声音: %d, 按键: %d\n", result.AudioFiles, result.Sounds, result.Keys)
+		for _, skipped := range result.Skipped {
+			fmt.Printf("已跳过: %s\n", skipped)
+		}
+	}
+
+	if ktalbumFile != "" {
+		if err := packAlbum(albumDir, result.AlbumName, ktalbumFile); err != nil {
+			return nil, err
+		}
+		if verbose {
+			fmt.Printf("已打包: %s\n", ktalbumFile)
+		}
+	}
+	return result, nil
+}
+
+func findMechvibesPackRoot(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, mechvibesConfigFileName)); err == nil {
+		return dir, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("读取音效包目录失败: %v", err)
+	}
+	var candidates []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != "__MACOSX" {
+			candidates = append(candidates, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(candidates) == 1 {
+		if _, err := os.Stat(filepath.Join(candidates[0], mechvibesConfigFileName)); err == nil {
+			return candidates[0], nil
+		}
+	}
+	return "", fmt.Errorf("未找到 Mechvibes 音效包的 %s", mechvibesConfigFileName)
+}
+
+func translateMechvibesKeycode(code uint16) []uint16 {
+	if codes, ok := mechvibesKeycodeTable[code]; ok {
+		return codes
+	}
+	return []uint16{code}
+}
+
+type mechvibesConverter struct {
+	packDir    string
+	albumDir   string
+	audioFiles map[string]any
+	sounds     map[string]any
+	single     map[string]any
+	fileRefs   map[string]map[string]any
+	soundIDs   map[string]string
+	skipped    []string
+}
+
+func convertMechvibesPack(packDir string, albumDir string) (result *MechvibesImportResult, err error) {
+	data, err := os.ReadFile(filepath.Join(packDir, mechvibesConfigFileName))
+	if err != nil {
+		return nil, fmt.Errorf("读取音效包配置失败: %v", err)
+	}
+	var pack mechvibesPack
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("解析音效包配置失败: %v", err)
+	}
+	pack.KeyDefineType = strings.ToLower(strings.TrimSpace(pack.KeyDefineType))
+	if pack.KeyDefineType == "" {
+		pack.KeyDefineType = "single"
+	}
+	if pack.KeyDefineType != "single" && pack.KeyDefineType != "multi" {
+		return nil, fmt.Errorf("不支持的 key_define_type: %s", pack.KeyDefineType)
+	}
+	if pack.KeyDefineType == "single" && strings.TrimSpace(pack.Sound) == "" {
+		return nil, errors.New("single 模式的音效包缺少 sound 文件")
+	}
+
+	if _, err := os.Stat(albumDir); err == nil {
+		return nil, fmt.Errorf("目标专辑目录已存在: %s", albumDir)
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(albumDir)
+		}
+	}()
+	if err := os.MkdirAll(filepath.Join(albumDir, "audioFiles"), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("创建专辑目录失败: %v", err)
+	}
+
+	albumUUID := filepath.Base(albumDir)
+	name := strings.TrimSpace(pack.Name)
+	if name == "" {
+		name = strings.TrimSpace(pack.ID)
+	}
+	if name == "" {
+		name = albumUUID
+	}
+
+	c := &mechvibesConverter{
+		packDir:    packDir,
+		albumDir:   albumDir,
+		audioFiles: map[string]any{},
+		sounds:     map[string]any{},
+		single:     map[string]any{},
+		fileRefs:   map[string]map[string]any{},
+		soundIDs:   map[string]string{},
+		skipped:    []string{},
+	}
+
+	defineKeys := make([]string, 0, len(pack.Defines))
+	for key := range pack.Defines {
+		defineKeys = append(defineKeys, key)
+	}
+	sort.Strings(defineKeys)
+
+	for _, defineKey := range defineKeys {
+		state := "down"
+		codeText := defineKey
+		if strings.HasSuffix(defineKey, mechvibesUpSuffix) {
+			state = "up"
+			codeText = strings.TrimSuffix(defineKey, mechvibesUpSuffix)
+		}
+		code, err := strconv.ParseUint(codeText, 10, 16)
+		if err != nil || code == 0 {
+			c.skipped = append(c.skipped, defineKey+": unrecognized keycode")
+			continue
+		}
+		effect, err := c.convertDefine(&pack, state, pack.Defines[defineKey])
+		if err != nil {
+			c.skipped = append(c.skipped, defineKey+": "+err.Error())
+			continue
+		}
+		if effect == nil {
+			continue
+		}
+		for _, keycode := range translateMechvibesKeycode(uint16(code)) {
+			keyEntry, ok := c.single[strconv.Itoa(int(keycode))].(map[string]any)
+			if !ok {
+				keyEntry = map[string]any{}
+				c.single[strconv.Itoa(int(keycode))] = keyEntry
+			}
+			keyEntry[state] = effect
+		}
+	}
+
+	if len(c.single) == 0 {
+		return nil, errors.New("音效包中没有可播放的按键定义")
+	}
+
+	config := map[string]any{
+		"package_name":   name,
+		"audio_pkg_uuid": albumUUID,
+		"audio_files":    c.audioFiles,
+		"sounds":         c.sounds,
+		"key_tone": map[string]any{
+			"is_enable_embedded_test_sound": map[string]any{"down": false, "up": false},
+			"single":                        c.single,
+		},
+	}
+	configData, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(albumDir, "package.json"), configData, 0644); err != nil {
+		return nil, fmt.Errorf("写入专辑配置失败: %v", err)
+	}
+
+	return &MechvibesImportResult{
+		AlbumUUID:  albumUUID,
+		AlbumPath:  albumDir,
+		AlbumName:  name,
+		AudioFiles: len(c.audioFiles),
+		Sounds:     len(c.sounds),
+		Keys:       len(c.single),
+		Skipped:    c.skipped,
+	}, nil
+}
+
+func (c *mechvibesConverter) convertDefine(pack *mechvibesPack, state string, raw json.RawMessage) (map[string]any, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	if pack.KeyDefineType == "multi" {
+		var fileName string
+		if err := json.Unmarshal(raw, &fileName); err != nil {
+			return nil, errors.New("multi define must be a file name")
+		}
+		if strings.TrimSpace(fileName) == "" {
+			return nil, nil
+		}
+		ref, err := c.importAudioFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "audio_files", "value": copyRef(ref)}, nil
+	}
+
+	var slice []float64
+	if err := json.Unmarshal(raw, &slice); err != nil || len(slice) != 2 {
+		return nil, errors.New("single define must be [start, duration]")
+	}
+	start, duration := slice[0], slice[1]
+	if start < 0 || duration <= 0 {
+		return nil, errors.New("invalid slice range")
+	}
+
+	sprite := pack.Sound
+	if state == "up" && strings.TrimSpace(pack.SoundUp) != "" {
+		sprite = pack.SoundUp
+	}
+	ref, err := c.importAudioFile(sprite)
+	if err != nil {
+		return nil, err
+	}
+
+	sliceKey := fmt.Sprintf("%s|%v|%v", sprite, start, duration)
+	soundID, ok := c.soundIDs[sliceKey]
+	if !ok {
+		soundID, err = newUUID()
+		if err != nil {
+			return nil, err
+		}
+		c.soundIDs[sliceKey] = soundID
+		c.sounds[soundID] = map[string]any{
+			"name":                  fmt.Sprintf("%s %v-%vms", strings.TrimSuffix(filepath.Base(sprite), filepath.Ext(sprite)), start, start+duration),
+			"source_file_for_sound": copyRef(ref),
+			"cut": map[string]any{
+				"start_time": start,
+				"end_time":   start + duration,
+				"volume":     0.0,
+			},
+		}
+	}
+	return map[string]any{"type": "sounds", "value": soundID}, nil
+}
+
+func (c *mechvibesConverter) importAudioFile(fileName string) (map[string]any, error) {
+	if ref, ok := c.fileRefs[fileName]; ok {
+		return ref, nil
+	}
+
+	cleanName := filepath.FromSlash(strings.TrimSpace(fileName))
+	if !filepath.IsLocal(cleanName) {
+		return nil, fmt.Errorf("invalid sound file path: %s", fileName)
+	}
+	ext := filepath.Ext(cleanName)
+	if !audio.IsSupported(ext) {
+		return nil, fmt.Errorf("unsupported audio type: %s", fileName)
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.packDir, cleanName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sound file %s: %v", fileName, err)
+	}
+	hashString := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	destPath := filepath.Join(c.albumDir, "audioFiles", hashString+ext)
+	if _, err := os.Stat(destPath); os.IsNotExist(err) {
+		if err := os.WriteFile(destPath, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to copy sound file %s: %v", fileName, err)
+		}
+	}
+
+	nameID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := c.audioFiles[hashString].(map[string]any)
+	if !ok {
+		entry = map[string]any{"name": map[string]any{}, "type": ext}
+		c.audioFiles[hashString] = entry
+	}
+	entry["name"].(map[string]any)[nameID] = strings.TrimSuffix(filepath.Base(cleanName), ext)
+
+	ref := map[string]any{"sha256": hashString, "name_id": nameID, "type": ext}
+	c.fileRefs[fileName] = ref
+	return ref, nil
+}
+
+func copyRef(source map[string]any) map[string]any {
+	target := make(map[string]any, len(source))
+	for key, value := range source {
+		target[key] = value
+	}
+	return target
+}
+
+// packAlbum 按 SDK 导出格式(.keytone-album 元数据 + <uuid>/ 目录, XOR 加密, KTALBUM 文件头)打包专辑。
+func packAlbum(albumDir string, albumName string, outputFile string) error {
+	albumUUID := filepath.Base(albumDir)
+
+	var zipBuffer bytes.Buffer
+	zipWriter := zip.NewWriter(&zipBuffer)
+
+	meta := utils.KeytoneAlbumMeta{
+		MagicNumber: keytoneMagicNumber,
+		Version:     keytoneMetaVersion,
+		ExportTime:  time.Now(),
+		AlbumUUID:   albumUUID,
+		AlbumName:   albumName,
+	}
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	metaWriter, err := zipWriter.Create(keytoneMetaFileName)
+	if err != nil {
+		return fmt.Errorf("写入元数据失败: %v", err)
+	}
+	if _, err := metaWriter.Write(metaData); err != nil {
+		return fmt.Errorf("写入元数据失败: %v", err)
+	}
+
+	err = filepath.Walk(albumDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(albumDir, path)
+		if err != nil {
+			return err
+		}
+		writer, err := zipWriter.Create(filepath.ToSlash(filepath.Join(albumUUID, relPath)))
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(writer, file)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("打包专辑文件失败: %v", err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("打包专辑文件失败: %v", err)
+	}
+
+	header, encryptedData, err := utils.EncryptAlbumV3(zipBuffer.Bytes(), utils.GetEncryptKeyByVersion(keytoneFileVersion))
+	if err != nil {
+		return fmt.Errorf("加密专辑数据失败: %v", err)
+	}
+
+	outFile, err := os.Create(outputFile)
+	if err != nil {
+		return fmt.Errorf("创建输出文件失败: %v", err)
+	}
+	defer outFile.Close()
+	if err := binary.Write(outFile, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("写入文件头失败: %v", err)
+	}
+	if _, err := outFile.Write(encryptedData); err != nil {
+		return fmt.Errorf("写入加密数据失败: %v", err)
+	}
+	return nil
+}
+
+func unzipToDir(zipPath string, dir string) error {
+	return utils.ExtractZipSafely(zipPath, dir, utils.DefaultExtractLimits)
+}
+
+const nanoidAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-"
+
+// newNanoID 生成与 KeyTone 前端一致的 21 位专辑ID。
+func newNanoID() (string, error) {
+	b := make([]byte, 21)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = nanoidAlphabet[b[i]&63]
+	}
+	return string(b), nil
+}
+
+// newUUID 生成 UUID v4 字符串, 用作 name_id 与 sound ID。
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
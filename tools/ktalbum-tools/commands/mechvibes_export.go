@@ -0,0 +1,444 @@
+package commands
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"KeyTone/keySound/audio"
+
+	"github.com/gopxl/beep/v2"
+)
+
+// 本文件的导出规则与 SDK 中 audioPackage/mechvibes/export.go 保持一致, 修改任一侧时请同步另一侧。
+// 声音的渲染直接使用与 KeyTone 共用的 KeyTone/keySound/audio, 无需同步。
+
+const (
+	// mechvibesExportSampleRate 与 SDK 播放端的全局采样率一致。
+	mechvibesExportSampleRate = beep.SampleRate(44100)
+	mechvibesSpriteGapMS      = 10
+	maxKeySoundDepth          = 1000
+	// maxRenderDuration 为单个声音渲染的最长时长, 与 SDK 一致。
+	maxRenderDuration = time.Minute
+)
+
+// mechvibesStandardKeycodes 为 Mechvibes 默认音效包覆盖的按键, 用于展开专辑的全局键音。
+var mechvibesStandardKeycodes = []uint16{
+	1, 59, 60, 61, 62, 63, 64, 65, 66, 67, 68, 87, 88,
+	41, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14,
+	15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 43,
+	58, 30, 31, 32, 33, 34, 35, 36, 37, 38, 39, 40, 28,
+	42, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 54,
+	29, 3675, 56, 57, 3640, 3676, 3677, 3613,
+	3639, 70, 3653,
+	3655, 3657, 3663, 3665, 3666, 3667,
+	57416, 57419, 57421, 57424,
+	69, 3637, 55, 3658, 3662, 3612,
+	71, 72, 73, 75, 76, 77, 79, 80, 81, 82, 83,
+}
+
+// MechvibesExportResult 为一次导出的摘要。
+type MechvibesExportResult struct {
+	Name    string
+	Mode    string
+	Sounds  int
+	Keys    int
+	Skipped []string
+}
+
+type mechvibesSoundRef struct {
+	kind     string
+	soundID  string
+	sha256   string
+	fileType string
+}
+
+func (r mechvibesSoundRef) key() string {
+	if r.kind == "sounds" {
+		return "sounds:" + r.soundID
+	}
+	return "audio_files:" + r.sha256 + r.fileType
+}
+
+type mechvibesAssignment struct {
+	ref      mechvibesSoundRef
+	extended bool
+}
+
+// ExportMechvibes 将专辑(目录或 .ktalbum 文件)导出为 outputDir 下的 Mechvibes 音效包。
+//   - mode: single(单个精灵音频, 默认) 或 multi(每个声音一个文件)
+//
+// 本工具无法确认导出者的签名身份, 因此带有签名的专辑一律拒绝导出, 请在 KeyTone 中使用已授权的签名导出。
+func ExportMechvibes(input string, outputDir string, mode string, verbose bool) (*MechvibesExportResult, error) {
+	albumDir := input
+	if strings.HasSuffix(strings.ToLower(input), ".ktalbum") {
+		tempDir, err := os.MkdirTemp("", "ktalbum_export_*")
+		if err != nil {
+			return nil, fmt.Errorf("创建临时目录失败: %v", err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		zipFile := filepath.Join(tempDir, "album.zip")
+		if err := Extract(input, zipFile, verbose); err != nil {
+			return nil, err
+		}
+		extractDir := filepath.Join(tempDir, "album")
+		if err := unzipToDir(zipFile, extractDir); err != nil {
+			return nil, err
+		}
+		albumDir, err = findAlbumDir(extractDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := readPlainAlbumConfig(albumDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := config["signature"]; ok {
+		return nil, errors.New("专辑包含签名, 无法确认导出授权, 请在 KeyTone 中使用已授权的签名导出")
+	}
+
+	mode = strings.ToLower(strings.TrimSpace(mode))
+	if mode == "" {
+		mode = "single"
+	}
+	if mode != "single" && mode != "multi" {
+		return nil, fmt.Errorf("不支持的导出模式: %s", mode)
+	}
+
+	get := configGetter(config)
+	name, _ := get("package_name").(string)
+	albumUUID, _ := get("audio_pkg_uuid").(string)
+	if albumUUID == "" {
+		albumUUID = filepath.Base(albumDir)
+	}
+	if strings.TrimSpace(name) == "" {
+		name = albumUUID
+	}
+	result := &MechvibesExportResult{Name: name, Mode: mode, Skipped: []string{}}
+	skip := func(key string, reason string) {
+		result.Skipped = append(result.Skipped, key+": "+reason)
+	}
+
+	assignments := collectMechvibesAssignments(get, skip)
+	defineKeys := make([]string, 0, len(assignments))
+	for defineKey := range assignments {
+		defineKeys = append(defineKeys, defineKey)
+	}
+	sort.Strings(defineKeys)
+
+	rendered := map[string][][2]float64{}
+	failed := map[string]bool{}
+	order := make([]string, 0)
+	for _, defineKey := range defineKeys {
+		ref := assignments[defineKey].ref
+		refKey := ref.key()
+		if _, ok := rendered[refKey]; ok || failed[refKey] {
+			continue
+		}
+		samples, err := renderMechvibesRef(get, albumDir, ref)
+		if err != nil || len(samples) == 0 {
+			failed[refKey] = true
+			reason := "empty sound"
+			if err != nil {
+				reason = err.Error()
+			}
+			skip(refKey, reason)
+			continue
+		}
+		rendered[refKey] = samples
+		order = append(order, refKey)
+	}
+	if len(order) == 0 {
+		return nil, errors.New("专辑中没有可导出的键音")
+	}
+
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("创建输出目录失败: %v", err)
+	}
+
+	sampleRate := int(mechvibesExportSampleRate)
+	defines := map[string]any{}
+	pack := map[string]any{
+		"id":              albumUUID,
+		"name":            name,
+		"key_define_type": mode,
+		"defines":         defines,
+	}
+
+	if mode == "single" {
+		const spriteName = "sound.wav"
+		var sprite [][2]float64
+		slices := map[string][2]int64{}
+		gap := sampleRate * mechvibesSpriteGapMS / 1000
+		for _, refKey := range order {
+			startMS := int64(math.Ceil(float64(len(sprite)) * 1000 / float64(sampleRate)))
+			startFrame := int(startMS * int64(sampleRate) / 1000)
+			for len(sprite) < startFrame {
+				sprite = append(sprite, [2]float64{})
+			}
+			samples := rendered[refKey]
+			sprite = append(sprite, samples...)
+			durationMS := int64(math.Ceil(float64(len(samples)) * 1000 / float64(sampleRate)))
+			slices[refKey] = [2]int64{startMS, durationMS}
+			sprite = append(sprite, make([][2]float64, gap)...)
+		}
+		if err := writeWAV(filepath.Join(outputDir, spriteName), sprite, sampleRate); err != nil {
+			return nil, err
+		}
+		pack["sound"] = spriteName
+		for _, defineKey := range defineKeys {
+			if slice, ok := slices[assignments[defineKey].ref.key()]; ok {
+				defines[defineKey] = []int64{slice[0], slice[1]}
+			}
+		}
+	} else {
+		fileNames := map[string]string{}
+		for index, refKey := range order {
+			fileName := fmt.Sprintf("%03d.wav", index+1)
+			if err := writeWAV(filepath.Join(outputDir, fileName), rendered[refKey], sampleRate); err != nil {
+				return nil, err
+			}
+			fileNames[refKey] = fileName
+		}
+		for _, defineKey := range defineKeys {
+			if fileName, ok := fileNames[assignments[defineKey].ref.key()]; ok {
+				defines[defineKey] = fileName
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(pack, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, mechvibesConfigFileName), data, 0644); err != nil {
+		return nil, fmt.Errorf("写入音效包配置失败: %v", err)
+	}
+
+	result.Sounds = len(order)
+	result.Keys = len(defines)
+	if verbose {
+		fmt.Printf("专辑名称: %s\n", result.Name)
+		fmt.Printf("导出模式: %s, 声音: %d, 按键: %d\n", result.Mode, result.Sounds, result.Keys)
+		for _, skipped := range result.Skipped {
+			fmt.Printf("已跳过: %s\n", skipped)
+		}
+	}
+	return result, nil
+}
+
+// findAlbumDir 在解压目录中找到唯一的专辑目录(与 SDK 导入时的判定一致)。
+func findAlbumDir(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("读取解压目录失败: %v", err)
+	}
+	var albumDir string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if albumDir != "" {
+				return "", errors.New("zip 文件中包含多个目录")
+			}
+			albumDir = filepath.Join(dir, entry.Name())
+		}
+	}
+	if albumDir == "" {
+		return "", errors.New("zip 文件中未找到专辑目录")
+	}
+	return albumDir, nil
+}
+
+// readPlainAlbumConfig 读取明文 package.json; 加密的专辑配置需要 KeyTone 客户端才能读取。
+func readPlainAlbumConfig(albumDir string) (map[string]any, error) {
+	data, err := os.ReadFile(filepath.Join(albumDir, "package.json"))
+	if err != nil {
+		return nil, fmt.Errorf("读取专辑配置失败: %v", err)
+	}
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.New("专辑配置不是明文 JSON(可能已加密), 请在 KeyTone 中导出")
+	}
+	if _, ok := config["core"]; ok && config["key_tone"] == nil {
+		return nil, errors.New("专辑配置已加密, 请在 KeyTone 中导出")
+	}
+	return config, nil
+}
+
+// configGetter 以 "a.b.c" 形式的键读取嵌套配置(与 viper 的 Get 语义一致)。
+func configGetter(config map[string]any) func(string) any {
+	return func(key string) any {
+		var current any = config
+		for _, part := range strings.Split(key, ".") {
+			node, ok := current.(map[string]any)
+			if !ok {
+				return nil
+			}
+			current = node[part]
+		}
+		return current
+	}
+}
+
+func reverseMechvibesKeycode(keycode int) (uint16, bool, bool) {
+	for mechvibesCode, codes := range mechvibesKeycodeTable {
+		if int(codes[0]) == keycode {
+			return mechvibesCode, true, true
+		}
+	}
+	if keycode <= 0 || keycode > math.MaxUint16 {
+		return 0, false, false
+	}
+	return uint16(keycode), true, false
+}
+
+func resolveMechvibesEffect(get func(string) any, effectKey string, state string) (mechvibesSoundRef, bool) {
+	effectType, _ := get(effectKey + ".type").(string)
+	return resolveMechvibesValue(get, effectType, get(effectKey+".value"), state, 0)
+}
+
+func resolveMechvibesValue(get func(string) any, effectType string, value any, state string, depth int) (mechvibesSoundRef, bool) {
+	if depth > maxKeySoundDepth {
+		return mechvibesSoundRef{}, false
+	}
+	switch effectType {
+	case "sounds":
+		soundID, ok := value.(string)
+		if !ok || soundID == "" {
+			return mechvibesSoundRef{}, false
+		}
+		return mechvibesSoundRef{kind: "sounds", soundID: soundID}, true
+	case "audio_files":
+		valueMap, ok := value.(map[string]any)
+		if !ok {
+			return mechvibesSoundRef{}, false
+		}
+		sha, shaOK := valueMap["sha256"].(string)
+		fileType, typeOK := valueMap["type"].(string)
+		if !shaOK || !typeOK || sha == "" {
+			return mechvibesSoundRef{}, false
+		}
+		return mechvibesSoundRef{kind: "audio_files", sha256: sha, fileType: fileType}, true
+	case "key_sounds":
+		keySoundID, ok := value.(string)
+		if !ok || keySoundID == "" {
+			return mechvibesSoundRef{}, false
+		}
+		members, _ := get("key_sounds." + keySoundID + "." + state + ".value").([]any)
+		for _, member := range members {
+			memberMap, ok := member.(map[string]any)
+			if !ok {
+				continue
+			}
+			memberType, _ := memberMap["type"].(string)
+			if ref, ok := resolveMechvibesValue(get, memberType, memberMap["value"], state, depth+1); ok {
+				return ref, true
+			}
+		}
+	}
+	return mechvibesSoundRef{}, false
+}
+
+func collectMechvibesAssignments(get func(string) any, skip func(string, string)) map[string]mechvibesAssignment {
+	assignments := map[string]mechvibesAssignment{}
+
+	single, _ := get("key_tone.single").(map[string]any)
+	keycodes := make([]string, 0, len(single))
+	for keycode := range single {
+		keycodes = append(keycodes, keycode)
+	}
+	sort.Strings(keycodes)
+
+	for _, state := range []string{"down", "up"} {
+		suffix := ""
+		if state == "up" {
+			suffix = mechvibesUpSuffix
+		}
+
+		if globalRef, ok := resolveMechvibesEffect(get, "key_tone.global."+state, state); ok {
+			for _, code := range mechvibesStandardKeycodes {
+				assignments[strconv.Itoa(int(code))+suffix] = mechvibesAssignment{ref: globalRef}
+			}
+		}
+
+		for _, keycode := range keycodes {
+			if get("key_tone.single."+keycode+"."+state) == nil {
+				continue
+			}
+			ref, ok := resolveMechvibesEffect(get, "key_tone.single."+keycode+"."+state, state)
+			if !ok {
+				skip(keycode+"."+state, "unresolvable sound effect")
+				continue
+			}
+			code, err := strconv.Atoi(keycode)
+			if err != nil {
+				skip(keycode+"."+state, "unrecognized keycode")
+				continue
+			}
+			mechvibesCode, ok, extended := reverseMechvibesKeycode(code)
+			if !ok {
+				skip(keycode+"."+state, "keycode not supported by mechvibes")
+				continue
+			}
+			defineKey := strconv.Itoa(int(mechvibesCode)) + suffix
+			if existing, ok := assignments[defineKey]; ok && existing.extended && !extended {
+				continue
+			}
+			assignments[defineKey] = mechvibesAssignment{ref: ref, extended: extended}
+		}
+	}
+	return assignments
+}
+
+// renderMechvibesRef 渲染代表声音, 与 KeyTone 试听共用同一渲染流程(KeyTone/keySound/audio):
+// 解码 -> 裁剪与淡入/淡出 -> 重采样 -> 声音自身音量与响度增益, 存在处理后的文件时使用处理后的文件。
+func renderMechvibesRef(get func(string) any, albumDir string, ref mechvibesSoundRef) ([][2]float64, error) {
+	if ref.kind == "audio_files" {
+		return audio.RenderAudioFile(get, albumDir, ref.sha256, ref.fileType, mechvibesExportSampleRate, maxRenderDuration)
+	}
+	return audio.RenderSound(get, albumDir, ref.soundID, mechvibesExportSampleRate, maxRenderDuration)
+}
+
+// writeWAV 将双声道样本以 16-bit PCM WAV 格式写入 path。
+func writeWAV(path string, samples [][2]float64, sampleRate int) error {
+	const channels, bytesPerSample = 2, 2
+	dataSize := len(samples) * channels * bytesPerSample
+
+	buffer := make([]byte, 44+dataSize)
+	copy(buffer[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buffer[4:], uint32(36+dataSize))
+	copy(buffer[8:], "WAVE")
+	copy(buffer[12:], "fmt ")
+	binary.LittleEndian.PutUint32(buffer[16:], 16)
+	binary.LittleEndian.PutUint16(buffer[20:], 1)
+	binary.LittleEndian.PutUint16(buffer[22:], channels)
+	binary.LittleEndian.PutUint32(buffer[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buffer[28:], uint32(sampleRate*channels*bytesPerSample))
+	binary.LittleEndian.PutUint16(buffer[32:], channels*bytesPerSample)
+	binary.LittleEndian.PutUint16(buffer[34:], bytesPerSample*8)
+	copy(buffer[36:], "data")
+	binary.LittleEndian.PutUint32(buffer[40:], uint32(dataSize))
+
+	offset := 44
+	for _, frame := range samples {
+		for _, value := range frame {
+			value = math.Max(-1, math.Min(1, value))
+			binary.LittleEndian.PutUint16(buffer[offset:], uint16(int16(math.Round(value*math.MaxInt16))))
+			offset += bytesPerSample
+		}
+	}
+	if err := os.WriteFile(path, buffer, 0644); err != nil {
+		return fmt.Errorf("写入 wav 文件失败: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestAlbum 写入一个包含 100ms 音频与一个裁剪声音的明文专辑。
+func writeTestAlbum(t *testing.T, extra map[string]any) string {
+	t.Helper()
+	albumDir := filepath.Join(t.TempDir(), "album-id")
+	os.MkdirAll(filepath.Join(albumDir, "audioFiles"), 0755)
+
+	samples := make([][2]float64, 4410)
+	for i := range samples {
+		samples[i] = [2]float64{0.25, 0.25}
+	}
+	if err := writeWAV(filepath.Join(albumDir, "audioFiles", "abc.wav"), samples, 44100); err != nil {
+		t.Fatal(err)
+	}
+
+	config := map[string]any{
+		"package_name":   "Album",
+		"audio_pkg_uuid": "album-id",
+		"audio_files":    map[string]any{"abc": map[string]any{"type": ".wav", "name": map[string]any{"n1": "abc"}}},
+		"sounds": map[string]any{"snd": map[string]any{
+			"source_file_for_sound": map[string]any{"sha256": "abc", "name_id": "n1", "type": ".wav"},
+			"cut":                   map[string]any{"start_time": 20.0, "end_time": 70.0, "volume": 0.0},
+		}},
+		"key_tone": map[string]any{"single": map[string]any{
+			"30":    map[string]any{"down": map[string]any{"type": "sounds", "value": "snd"}},
+			"61000": map[string]any{"up": map[string]any{"type": "audio_files", "value": map[string]any{"sha256": "abc", "name_id": "n1", "type": ".wav"}}},
+		}},
+	}
+	for key, value := range extra {
+		config[key] = value
+	}
+	data, _ := json.Marshal(config)
+	os.WriteFile(filepath.Join(albumDir, "package.json"), data, 0644)
+	return albumDir
+}
+
+func TestExportMechvibes(t *testing.T) {
+	albumDir := writeTestAlbum(t, nil)
+	outputDir := t.TempDir()
+
+	result, err := ExportMechvibes(albumDir, outputDir, "single", false)
+	if err != nil {
+		t.Fatalf("导出失败: %v", err)
+	}
+	if result.Sounds != 2 || result.Keys != 2 {
+		t.Fatalf("导出结果不符合预期: %+v", result)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pack map[string]any
+	json.Unmarshal(data, &pack)
+	defines := pack["defines"].(map[string]any)
+	if slice, ok := defines["30"].([]any); !ok || slice[1] != 50.0 {
+		t.Errorf("按键 30 的切片应为 50ms: %v", defines)
+	}
+	if slice, ok := defines["57416-up"].([]any); !ok || slice[1] != 100.0 {
+		t.Errorf("方向键 Up 应以 Mechvibes 键码导出抬起音: %v", defines)
+	}
+
+	// 导出的音效包应能被 import-mechvibes 重新导入
+	if _, err := ImportMechvibes(outputDir, t.TempDir(), "", false); err != nil {
+		t.Errorf("重新导入失败: %v", err)
+	}
+}
+
+// TestRenderMechvibesRefMatchesKeyTone 验证导出与 KeyTone 使用同一渲染流程: 处理后的文件、响度增益与淡入均生效。
+func TestRenderMechvibesRefMatchesKeyTone(t *testing.T) {
+	albumDir := writeTestAlbum(t, map[string]any{
+		"audio_files": map[string]any{"abc": map[string]any{
+			"type":      ".wav",
+			"name":      map[string]any{"n1": "abc"},
+			"processed": map[string]any{"type": ".wav"},
+			"loudness":  map[string]any{"gain_db": 6.0},
+		}},
+		"sounds": map[string]any{"snd": map[string]any{
+			"source_file_for_sound": map[string]any{"sha256": "abc", "name_id": "n1", "type": ".wav"},
+			"cut":                   map[string]any{"start_time": 20.0, "end_time": 70.0, "volume": 0.0, "fade_in_ms": 10.0},
+		}},
+	})
+	processed := make([][2]float64, 4410)
+	for i := range processed {
+		processed[i] = [2]float64{0.125, 0.125}
+	}
+	if err := writeWAV(filepath.Join(albumDir, "audioFiles", "abc.processed.wav"), processed, 44100); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(albumDir, "package.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	json.Unmarshal(data, &config)
+
+	samples, err := renderMechvibesRef(configGetter(config), albumDir, mechvibesSoundRef{kind: "sounds", soundID: "snd"})
+	if err != nil {
+		t.Fatalf("渲染失败: %v", err)
+	}
+	if len(samples) != 2205 {
+		t.Fatalf("裁剪后应为 50ms(2205 个样本), 实际 %d", len(samples))
+	}
+	if math.Abs(samples[0][0]) > 1e-3 {
+		t.Errorf("淡入的首个样本应接近 0, 实际 %v", samples[0][0])
+	}
+	// 0.125 的处理后文件叠加 +6dB 响度增益
+	if want := 0.125 * math.Pow(10, 6.0/20); math.Abs(samples[1000][0]-want) > 1e-3 {
+		t.Errorf("应使用处理后的文件并叠加响度增益: 实际 %v, 期望 %v", samples[1000][0], want)
+	}
+}
+
+func TestExportMechvibesRefusesSignedAlbum(t *testing.T) {
+	albumDir := writeTestAlbum(t, map[string]any{"signature": "encrypted"})
+	if _, err := ExportMechvibes(albumDir, t.TempDir(), "single", false); err == nil {
+		t.Error("带签名的专辑应拒绝导出")
+	}
+}
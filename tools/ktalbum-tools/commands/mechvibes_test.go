@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportMechvibes(t *testing.T) {
+	packDir := t.TempDir()
+	config := `{"name": "Test Pack", "key_define_type": "single", "sound": "sprite.ogg",
+		"defines": {"30": [100, 50], "57416": [200, 40], "30-up": [300, 20]}}`
+	os.WriteFile(filepath.Join(packDir, "config.json"), []byte(config), 0644)
+	os.WriteFile(filepath.Join(packDir, "sprite.ogg"), []byte("sprite-bytes"), 0644)
+
+	outputDir := t.TempDir()
+	ktalbumFile := filepath.Join(t.TempDir(), "pack.ktalbum")
+	result, err := ImportMechvibes(packDir, outputDir, ktalbumFile, false)
+	if err != nil {
+		t.Fatalf("导入失败: %v", err)
+	}
+	if len(result.AlbumUUID) != 21 || result.Sounds != 3 || result.Keys != 3 {
+		t.Fatalf("导入结果不符合预期: %+v", result)
+	}
+
+	data, err := os.ReadFile(filepath.Join(result.AlbumPath, "package.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var album map[string]any
+	json.Unmarshal(data, &album)
+	single := album["key_tone"].(map[string]any)["single"].(map[string]any)
+	if _, ok := single["61000"]; !ok {
+		t.Error("方向键 Up 应写入扩展键码 61000")
+	}
+
+	// 打包结果应能被 extract 正常解包, 且包含元数据与专辑目录
+	zipFile := filepath.Join(t.TempDir(), "pack.zip")
+	if err := Extract(ktalbumFile, zipFile, false); err != nil {
+		t.Fatalf("解包失败: %v", err)
+	}
+	reader, err := zip.OpenReader(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	names := map[string]bool{}
+	for _, file := range reader.File {
+		names[file.Name] = true
+	}
+	if !names[".keytone-album"] || !names[result.AlbumUUID+"/package.json"] {
+		t.Errorf("打包内容不完整: %v", names)
+	}
+}
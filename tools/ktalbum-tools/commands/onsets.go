@@ -0,0 +1,109 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"KeyTone/keySound/audio"
+)
+
+// 起音检测算法与 KeyTone 共用 KeyTone/keySound/audio 中的实现(算法说明见该包的 onsets.go)。
+
+// OnsetOptions 为起音检测的可调参数, 零值字段使用默认值。
+type OnsetOptions = audio.OnsetOptions
+
+// OnsetRange 为一个候选裁剪范围, 时间单位均为毫秒, 可直接写入 sounds.<uuid>.cut。
+type OnsetRange = audio.OnsetRange
+
+// OnsetsResult 为一次起音检测的结果。Sounds 仅在创建声音时填写, 与 Ranges 一一对应。
+type OnsetsResult struct {
+	Ranges []OnsetRange
+	Sounds []string
+}
+
+// DetectAlbumOnsets 对专辑目录中的音频文件(audio_files.<sha256>)执行起音检测。
+// createSounds 为 true 时为每个范围创建 sounds 条目并写回 package.json; nameID 为空时使用该文件的第一个别名。
+// 需要写回配置, 因此只支持明文的专辑目录, 不支持 .ktalbum 文件。
+func DetectAlbumOnsets(albumDir string, sha256 string, opts OnsetOptions, createSounds bool, nameID string, verbose bool) (*OnsetsResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	config, err := readPlainAlbumConfig(albumDir)
+	if err != nil {
+		return nil, err
+	}
+	get := configGetter(config)
+	fileType, _ := get("audio_files." + sha256 + ".type").(string)
+	if fileType == "" {
+		return nil, fmt.Errorf("音频文件不存在: %s", sha256)
+	}
+	names, _ := get("audio_files." + sha256 + ".name").(map[string]any)
+	if createSounds {
+		if nameID == "" {
+			ids := make([]string, 0, len(names))
+			for id := range names {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			if len(ids) > 0 {
+				nameID = ids[0]
+			}
+		}
+		if _, ok := names[nameID]; !ok {
+			return nil, fmt.Errorf("音频源别名不存在: %s", nameID)
+		}
+	}
+
+	// 与 KeyTone 一致: 存在导入时处理后的文件时使用处理后的文件
+	samples, format, err := audio.ReadFile(audio.AlbumAudioFile(get, albumDir, sha256, fileType))
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := audio.DetectOnsets(samples, format.SampleRate, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &OnsetsResult{Ranges: ranges}
+
+	if createSounds && len(ranges) > 0 {
+		sounds, _ := config["sounds"].(map[string]any)
+		if sounds == nil {
+			sounds = map[string]any{}
+			config["sounds"] = sounds
+		}
+		baseName, _ := names[nameID].(string)
+		for index, r := range ranges {
+			soundID, err := newUUID()
+			if err != nil {
+				return nil, err
+			}
+			sounds[soundID] = map[string]any{
+				"name":                  fmt.Sprintf("%s %03d", baseName, index+1),
+				"source_file_for_sound": map[string]any{"sha256": sha256, "name_id": nameID, "type": fileType},
+				"cut":                   map[string]any{"start_time": r.StartMS, "end_time": r.EndMS, "volume": 0.0},
+			}
+			result.Sounds = append(result.Sounds, soundID)
+		}
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(albumDir, "package.json"), data, 0644); err != nil {
+			return nil, fmt.Errorf("写入专辑配置失败: %v", err)
+		}
+	}
+
+	if verbose {
+		for index, r := range ranges {
+			fmt.Printf("%3d  onset %8.0fms  cut %8.0f - %8.0fms  strength %.2f\n", index+1, r.OnsetMS, r.StartMS, r.EndMS, r.Strength)
+		}
+		if len(result.Sounds) > 0 {
+			fmt.Printf("已创建 %d 个声音\n", len(result.Sounds))
+		}
+	}
+	return result, nil
+}
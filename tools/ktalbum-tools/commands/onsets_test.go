@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectAlbumOnsets(t *testing.T) {
+	albumDir := writeTestAlbum(t, nil)
+
+	// 以 3 个指数衰减的噪声脉冲覆盖测试专辑中的音频
+	onsetsMS := []int{100, 400, 700}
+	random := rand.New(rand.NewSource(1))
+	samples := make([][2]float64, 44100)
+	for _, onsetMS := range onsetsMS {
+		start := 44100 * onsetMS / 1000
+		for i := start; i < start+44100/5; i++ {
+			v := (random.Float64()*2 - 1) * 0.5 * math.Exp(-float64(i-start)/441)
+			samples[i] = [2]float64{v, v}
+		}
+	}
+	if err := writeWAV(filepath.Join(albumDir, "audioFiles", "abc.wav"), samples, 44100); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := DetectAlbumOnsets(albumDir, "abc", OnsetOptions{}, true, "", false)
+	if err != nil {
+		t.Fatalf("检测失败: %v", err)
+	}
+	if len(result.Ranges) != len(onsetsMS) || len(result.Sounds) != len(onsetsMS) {
+		t.Fatalf("检测结果不符合预期: %+v", result)
+	}
+	for i, r := range result.Ranges {
+		if math.Abs(r.OnsetMS-float64(onsetsMS[i])) > 3 {
+			t.Errorf("起音 %d 位于 %vms, 应为 %dms", i, r.OnsetMS, onsetsMS[i])
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(albumDir, "package.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]any
+	json.Unmarshal(data, &config)
+	get := configGetter(config)
+	for i, soundID := range result.Sounds {
+		if get("sounds."+soundID+".source_file_for_sound.name_id") != "n1" || get("sounds."+soundID+".cut.start_time") != result.Ranges[i].StartMS {
+			t.Errorf("声音 %s 未正确写入: %v", soundID, get("sounds."+soundID))
+		}
+	}
+	// 原有的声音应保留
+	if get("sounds.snd") == nil {
+		t.Error("原有的声音被覆盖")
+	}
+
+	if _, err := DetectAlbumOnsets(albumDir, "missing", OnsetOptions{}, false, "", false); err == nil {
+		t.Error("不存在的音频文件应返回错误")
+	}
+}
@@ -22,6 +22,33 @@ func main() {
 	extractOutput := extractCmd.String("out", "", "输出的 .zip 文件 (可选)")
 	extractVerbose := extractCmd.Bool("v", false, "显示详细信息")
 
+	// import-mechvibes 命令的参数
+	mechvibesCmd := flag.NewFlagSet("import-mechvibes", flag.ExitOnError)
+	mechvibesInput := mechvibesCmd.String("in", "", "输入的 Mechvibes 音效包目录或 .zip 文件")
+	mechvibesOutput := mechvibesCmd.String("out", ".", "输出目录, 专辑将生成在其下以专辑ID命名的子目录中")
+	mechvibesKtalbum := mechvibesCmd.String("ktalbum", "", "同时打包为 .ktalbum 文件 (可选)")
+	mechvibesVerbose := mechvibesCmd.Bool("v", false, "显示详细信息")
+
+	// export-mechvibes 命令的参数
+	exportMechvibesCmd := flag.NewFlagSet("export-mechvibes", flag.ExitOnError)
+	exportMechvibesInput := exportMechvibesCmd.String("in", "", "输入的专辑目录或 .ktalbum 文件")
+	exportMechvibesOutput := exportMechvibesCmd.String("out", "", "输出的 Mechvibes 音效包目录")
+	exportMechvibesMode := exportMechvibesCmd.String("mode", "single", "导出模式: single(单个精灵音频) 或 multi(每个声音一个文件)")
+	exportMechvibesVerbose := exportMechvibesCmd.Bool("v", false, "显示详细信息")
+
+	// detect-onsets 命令的参数
+	onsetsCmd := flag.NewFlagSet("detect-onsets", flag.ExitOnError)
+	onsetsInput := onsetsCmd.String("in", "", "输入的专辑目录")
+	onsetsSha256 := onsetsCmd.String("sha256", "", "要检测的音频文件(audio_files 中的 sha256)")
+	onsetsMethod := onsetsCmd.String("method", "flux", "检测方法: flux(谱通量) 或 energy(能量)")
+	onsetsSensitivity := onsetsCmd.Float64("sensitivity", 0.5, "灵敏度 (0, 1], 越大越容易判定为起音")
+	onsetsMinGap := onsetsCmd.Float64("min-gap", 60, "相邻起音的最小间隔(ms)")
+	onsetsPreRoll := onsetsCmd.Float64("pre-roll", 5, "裁剪起点相对起音提前的时长(ms)")
+	onsetsMaxLength := onsetsCmd.Float64("max-length", 300, "单个裁剪范围的最大时长(ms)")
+	onsetsDecay := onsetsCmd.Float64("decay", 40, "判定声音结束的衰减量(dB)")
+	onsetsCreate := onsetsCmd.Bool("create", false, "为每个范围创建声音并写回专辑配置")
+	onsetsNameID := onsetsCmd.String("name-id", "", "创建声音时引用的音频源别名 (可选, 默认使用第一个别名)")
+
 	// 添加 web 命令
 	webCmd := flag.NewFlagSet("web", flag.ExitOnError)
 	webPort := webCmd.Int("port", 8080, "Web 服务端口")
@@ -32,6 +59,9 @@ func main() {
 		fmt.Println("  ktalbum-tools extract -in <ktalbum文件> [-out <zip文件>] [-v]")
 		fmt.Println("  ktalbum-tools pack -in <zip文件> [-out <ktalbum文件>] [-v]")
 		fmt.Println("  ktalbum-tools info -in <ktalbum文件>")
+		fmt.Println("  ktalbum-tools import-mechvibes -in <音效包目录|zip文件> [-out <输出目录>] [-ktalbum <ktalbum文件>] [-v]")
+		fmt.Println("  ktalbum-tools export-mechvibes -in <专辑目录|ktalbum文件> -out <输出目录> [-mode single|multi] [-v]")
+		fmt.Println("  ktalbum-tools detect-onsets -in <专辑目录> -sha256 <音频文件> [-method flux|energy] [-sensitivity 0.5] [-create] [-name-id <别名>]")
 		fmt.Println("  ktalbum-tools web -port <端口>")
 		os.Exit(1)
 	}
@@ -52,6 +82,51 @@ func main() {
 			os.Exit(1)
 		}
 
+	case "import-mechvibes":
+		mechvibesCmd.Parse(os.Args[2:])
+		if *mechvibesInput == "" {
+			fmt.Println("请指定输入: -in <音效包目录|zip文件>")
+			os.Exit(1)
+		}
+		result, err := commands.ImportMechvibes(*mechvibesInput, *mechvibesOutput, *mechvibesKtalbum, *mechvibesVerbose)
+		if err != nil {
+			fmt.Printf("错误: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已生成专辑: %s\n", result.AlbumPath)
+
+	case "export-mechvibes":
+		exportMechvibesCmd.Parse(os.Args[2:])
+		if *exportMechvibesInput == "" || *exportMechvibesOutput == "" {
+			fmt.Println("请指定输入与输出: -in <专辑目录|ktalbum文件> -out <输出目录>")
+			os.Exit(1)
+		}
+		if _, err := commands.ExportMechvibes(*exportMechvibesInput, *exportMechvibesOutput, *exportMechvibesMode, *exportMechvibesVerbose); err != nil {
+			fmt.Printf("错误: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已导出 Mechvibes 音效包: %s\n", *exportMechvibesOutput)
+
+	case "detect-onsets":
+		onsetsCmd.Parse(os.Args[2:])
+		if *onsetsInput == "" || *onsetsSha256 == "" {
+			fmt.Println("请指定专辑目录与音频文件: -in <专辑目录> -sha256 <音频文件>")
+			os.Exit(1)
+		}
+		result, err := commands.DetectAlbumOnsets(*onsetsInput, *onsetsSha256, commands.OnsetOptions{
+			Method:      *onsetsMethod,
+			Sensitivity: *onsetsSensitivity,
+			MinGapMS:    *onsetsMinGap,
+			PreRollMS:   *onsetsPreRoll,
+			MaxLengthMS: *onsetsMaxLength,
+			DecayDB:     *onsetsDecay,
+		}, *onsetsCreate, *onsetsNameID, true)
+		if err != nil {
+			fmt.Printf("错误: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("检测到 %d 个起音\n", len(result.Ranges))
+
 	case "web":
 		webCmd.Parse(os.Args[2:])
 		fmt.Printf("启动 Web 服务在端口 %d...\n", *webPort)
@@ -38,11 +38,12 @@ fi
 OBFUSCATOR_TOOL="../key-obfuscator/main.go"
 
 # 3. 定义需要处理的密钥列表
-# ktalbum-tools 只需要专辑导出文件 XOR key（v1/v2）即可解密 .ktalbum
+# ktalbum-tools 只需要专辑导出文件 key（v1/v2 XOR，v3 AES-GCM）即可解密 .ktalbum
 # 格式： "环境变量中的键名:Go代码中的变量全路径"
 KEYS_TO_PROCESS=(
   "KEY_ALBUM_EXPORT_V1:ktalbum-tools/utils.KeytoneEncryptKeyV1"
   "KEY_ALBUM_EXPORT_V2:ktalbum-tools/utils.KeytoneEncryptKeyV2"
+  "KEY_ALBUM_EXPORT_V3:ktalbum-tools/utils.KeytoneEncryptKeyV3"
 )
 
 # =================逻辑区域=================
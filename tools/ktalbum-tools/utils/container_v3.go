@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// KTALBUM v3: 分块 AES-256-GCM + 认证文件头。
+// 格式与 SDK sdk/server/album_container.go 完全一致（请保持同步）:
+//   - 文件头 48 字节, 与 KeytoneFileHeader 等长, Checksum 区域重新解释为
+//     Cipher(1) + Reserved(3) + ChunkSize(4) + PlainSize(8) + Salt(16);
+//   - 文件密钥 = HKDF-SHA256(v3 密钥, Salt, "KTALBUM v3 AES-256-GCM");
+//   - 分块 nonce = 8 字节大端序号 + 3 字节 0 + 1 字节结束标记, AAD 为完整文件头。
+
+const (
+	KeytoneFileVersionV3 = 3
+
+	albumCipherAES256GCM = 1
+	albumChunkSizeV3     = 64 * 1024
+	albumMaxChunkSizeV3  = 16 * 1024 * 1024
+	albumHKDFInfoV3      = "KTALBUM v3 AES-256-GCM"
+)
+
+// KeytoneFileHeaderV3 为 v3 文件头。
+type KeytoneFileHeaderV3 struct {
+	Signature [7]byte
+	Version   uint8
+	DataSize  uint64
+	Cipher    uint8
+	Reserved  [3]byte
+	ChunkSize uint32
+	PlainSize uint64
+	Salt      [16]byte
+}
+
+func (h KeytoneFileHeaderV3) bytes() []byte {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, h)
+	return buffer.Bytes()
+}
+
+// HeaderV3FromLegacy 将按 KeytoneFileHeader 读取的文件头重新解释为 v3 文件头。
+func HeaderV3FromLegacy(header KeytoneFileHeader) KeytoneFileHeaderV3 {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, header)
+	var headerV3 KeytoneFileHeaderV3
+	binary.Read(buffer, binary.LittleEndian, &headerV3)
+	return headerV3
+}
+
+func legacyHeaderFromV3(headerV3 KeytoneFileHeaderV3) KeytoneFileHeader {
+	var header KeytoneFileHeader
+	binary.Read(bytes.NewReader(headerV3.bytes()), binary.LittleEndian, &header)
+	return header
+}
+
+func albumChunkCountV3(plainSize uint64, chunkSize uint32) uint64 {
+	if plainSize == 0 {
+		return 1
+	}
+	return (plainSize + uint64(chunkSize) - 1) / uint64(chunkSize)
+}
+
+// hkdfSHA256 为 RFC 5869 HKDF(SHA-256) 的最小实现（go 1.21 标准库尚无 crypto/hkdf）。
+func hkdfSHA256(secret, salt []byte, info string, length int) []byte {
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+
+	var okm, previous []byte
+	for counter := byte(1); len(okm) < length; counter++ {
+		expander := hmac.New(sha256.New, prk)
+		expander.Write(previous)
+		expander.Write([]byte(info))
+		expander.Write([]byte{counter})
+		previous = expander.Sum(nil)
+		okm = append(okm, previous...)
+	}
+	return okm[:length]
+}
+
+func newAlbumAEADV3(header KeytoneFileHeaderV3, secret string) (cipher.AEAD, error) {
+	if header.Cipher != albumCipherAES256GCM {
+		return nil, fmt.Errorf("不支持的加密算法: %d", header.Cipher)
+	}
+	block, err := aes.NewCipher(hkdfSHA256([]byte(secret), header.Salt[:], albumHKDFInfoV3, 32))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func albumNonceV3(index uint64, isFinal bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[:8], index)
+	if isFinal {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// ValidateHeaderV3 检查 v3 文件头各字段的一致性。
+func ValidateHeaderV3(header KeytoneFileHeaderV3) error {
+	if header.Version != KeytoneFileVersionV3 {
+		return fmt.Errorf("不是 v3 文件头: %d", header.Version)
+	}
+	if header.Reserved != [3]byte{} {
+		return errors.New("文件头保留字段无效")
+	}
+	if header.ChunkSize == 0 || header.ChunkSize > albumMaxChunkSizeV3 {
+		return fmt.Errorf("文件头分块大小无效: %d", header.ChunkSize)
+	}
+	if header.DataSize != header.PlainSize+albumChunkCountV3(header.PlainSize, header.ChunkSize)*16 {
+		return errors.New("文件头数据长度不一致")
+	}
+	return nil
+}
+
+// EncryptAlbumV3 使用 secret 将 zip 数据加密为 v3 格式, 返回文件头与数据区。
+func EncryptAlbumV3(zipData []byte, secret string) (KeytoneFileHeader, []byte, error) {
+	headerV3 := KeytoneFileHeaderV3{
+		Version:   KeytoneFileVersionV3,
+		Cipher:    albumCipherAES256GCM,
+		ChunkSize: albumChunkSizeV3,
+		PlainSize: uint64(len(zipData)),
+	}
+	copy(headerV3.Signature[:], KeytoneFileSignature)
+	if _, err := rand.Read(headerV3.Salt[:]); err != nil {
+		return KeytoneFileHeader{}, nil, fmt.Errorf("生成随机盐失败: %v", err)
+	}
+	chunkCount := albumChunkCountV3(headerV3.PlainSize, headerV3.ChunkSize)
+	headerV3.DataSize = headerV3.PlainSize + chunkCount*16
+
+	aead, err := newAlbumAEADV3(headerV3, secret)
+	if err != nil {
+		return KeytoneFileHeader{}, nil, err
+	}
+	aad := headerV3.bytes()
+	encrypted := make([]byte, 0, headerV3.DataSize)
+	for index := uint64(0); index < chunkCount; index++ {
+		start := index * albumChunkSizeV3
+		end := start + albumChunkSizeV3
+		if end > headerV3.PlainSize {
+			end = headerV3.PlainSize
+		}
+		encrypted = aead.Seal(encrypted, albumNonceV3(index, index == chunkCount-1), zipData[start:end], aad)
+	}
+	return legacyHeaderFromV3(headerV3), encrypted, nil
+}
+
+// DecryptAlbumV3 使用 secret 解密并逐块认证 v3 数据区。
+func DecryptAlbumV3(encryptedData []byte, header KeytoneFileHeader, secret string) ([]byte, error) {
+	headerV3 := HeaderV3FromLegacy(header)
+	if err := ValidateHeaderV3(headerV3); err != nil {
+		return nil, err
+	}
+	if uint64(len(encryptedData)) != headerV3.DataSize {
+		return nil, errors.New("文件数据长度与文件头不一致")
+	}
+	aead, err := newAlbumAEADV3(headerV3, secret)
+	if err != nil {
+		return nil, err
+	}
+	aad := headerV3.bytes()
+	chunkCount := albumChunkCountV3(headerV3.PlainSize, headerV3.ChunkSize)
+	sealedChunk := uint64(headerV3.ChunkSize) + uint64(aead.Overhead())
+	plain := make([]byte, 0, headerV3.PlainSize)
+	for index := uint64(0); index < chunkCount; index++ {
+		start := index * sealedChunk
+		end := start + sealedChunk
+		if end > uint64(len(encryptedData)) {
+			end = uint64(len(encryptedData))
+		}
+		plain, err = aead.Open(plain, albumNonceV3(index, index == chunkCount-1), encryptedData[start:end], aad)
+		if err != nil {
+			return nil, fmt.Errorf("文件校验失败，文件可能已被篡改或密钥不匹配(分块 %d)", index)
+		}
+	}
+	return plain, nil
+}
+
+// DecryptAlbumData 按文件头版本解密专辑数据（v3 认证解密；v1/v2 XOR + 校验和，失败时回退 v1 候选密钥）。
+// 私有密钥构建优先使用注入密钥，并回退尝试默认密钥，兼容开源产物。
+func DecryptAlbumData(encryptedData []byte, header KeytoneFileHeader) ([]byte, error) {
+	if header.Version == KeytoneFileVersionV3 {
+		var lastErr error
+		for _, decryptKey := range GetDecryptKeyCandidatesByVersion(KeytoneFileVersionV3) {
+			zipData, err := DecryptAlbumV3(encryptedData, header, decryptKey)
+			if err == nil {
+				return zipData, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+
+	for _, decryptKey := range GetDecryptKeyCandidatesByVersion(header.Version) {
+		candidate := XorCrypt(encryptedData, decryptKey)
+		if CalculateChecksum(candidate) == header.Checksum {
+			return candidate, nil
+		}
+	}
+
+	// 与 SDK 一致：若校验失败且版本不是 v1，尝试 v1 候选回退
+	if header.Version != 1 {
+		for _, decryptKey := range GetDecryptKeyCandidatesByVersion(1) {
+			candidate := XorCrypt(encryptedData, decryptKey)
+			if CalculateChecksum(candidate) == header.Checksum {
+				return candidate, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("文件校验失败，文件可能已损坏或密钥不匹配")
+}
@@ -22,12 +22,14 @@ var xorMask = []byte{0x55, 0xAA, 0x33, 0xCC, 0x99, 0x66, 0x11, 0xEE, 0x77, 0xBB,
 const (
 	DefaultKeytoneEncryptKeyV1 = "KeyTone2024SecretKey"                  // v1
 	DefaultKeytoneEncryptKeyV2 = "KeyTone2025AlbumSecureEncryptionKeyV2" // v2
+	DefaultKeytoneEncryptKeyV3 = "KeyTone2026AlbumAuthenticatedKeyV3"    // v3（经 HKDF 派生为每个文件的 AES-256-GCM 密钥）
 )
 
 // 版本化加密密钥（可注入）
 var (
 	KeytoneEncryptKeyV1 = DefaultKeytoneEncryptKeyV1
 	KeytoneEncryptKeyV2 = DefaultKeytoneEncryptKeyV2
+	KeytoneEncryptKeyV3 = DefaultKeytoneEncryptKeyV3
 	// 向后兼容：旧变量名仍保留（等价于 v1）
 	KeytoneEncryptKey = KeytoneEncryptKeyV1
 )
@@ -72,6 +74,8 @@ func GetEncryptKeyByVersion(version uint8) string {
 		return getPlainEncryptKey(KeytoneEncryptKeyV1, DefaultKeytoneEncryptKeyV1)
 	case 2:
 		return getPlainEncryptKey(KeytoneEncryptKeyV2, DefaultKeytoneEncryptKeyV2)
+	case 3:
+		return getPlainEncryptKey(KeytoneEncryptKeyV3, DefaultKeytoneEncryptKeyV3)
 	default:
 		// 未知版本：保守回退到 v2（与 SDK 保持一致的“当前版本”语义）
 		return getPlainEncryptKey(KeytoneEncryptKeyV2, DefaultKeytoneEncryptKeyV2)
@@ -88,6 +92,8 @@ func GetDecryptKeyCandidatesByVersion(version uint8) []string {
 		return getDecryptKeyCandidates(KeytoneEncryptKeyV1, DefaultKeytoneEncryptKeyV1)
 	case 2:
 		return getDecryptKeyCandidates(KeytoneEncryptKeyV2, DefaultKeytoneEncryptKeyV2)
+	case 3:
+		return getDecryptKeyCandidates(KeytoneEncryptKeyV3, DefaultKeytoneEncryptKeyV3)
 	default:
 		return getDecryptKeyCandidates(KeytoneEncryptKeyV2, DefaultKeytoneEncryptKeyV2)
 	}
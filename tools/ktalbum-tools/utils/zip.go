@@ -3,8 +3,206 @@ package utils
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 func ReadZipData(data []byte) (*zip.Reader, error) {
 	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
-} 
\ No newline at end of file
+}
+
+// ==============================
+// 安全解压（与 sdk/server/album_extract.go 保持同步）
+// ==============================
+
+// ImportErrorReason 为导入被拒绝的原因
+type ImportErrorReason string
+
+const (
+	ImportReasonInvalidArchive   ImportErrorReason = "invalid_archive"
+	ImportReasonAbsolutePath     ImportErrorReason = "absolute_path"
+	ImportReasonPathTraversal    ImportErrorReason = "path_traversal"
+	ImportReasonUnsafePath       ImportErrorReason = "unsafe_path"
+	ImportReasonSymlink          ImportErrorReason = "symlink"
+	ImportReasonSpecialFile      ImportErrorReason = "special_file"
+	ImportReasonDuplicateEntry   ImportErrorReason = "duplicate_entry"
+	ImportReasonTooManyFiles     ImportErrorReason = "too_many_files"
+	ImportReasonTooLarge         ImportErrorReason = "too_large"
+	ImportReasonCompressionRatio ImportErrorReason = "compression_ratio"
+)
+
+// ImportError 为带有拒绝原因的导入错误
+type ImportError struct {
+	Reason  ImportErrorReason
+	Message string
+}
+
+func (e *ImportError) Error() string {
+	return e.Message
+}
+
+// ExtractLimits 为解压时的资源限制, 0 表示不限制。
+type ExtractLimits struct {
+	MaxTotalSize        int64
+	MaxFiles            int
+	MaxCompressionRatio float64
+}
+
+// DefaultExtractLimits 与 KeyTone 默认设置一致(4096 MB / 10000 个条目 / 压缩比 200)。
+var DefaultExtractLimits = ExtractLimits{
+	MaxTotalSize:        4096 << 20,
+	MaxFiles:            10000,
+	MaxCompressionRatio: 200,
+}
+
+// ratioCheckMinSize 为压缩比检查的起始大小, 体积很小的文本类文件压缩比天然较高, 不做限制。
+const ratioCheckMinSize = 1 << 20
+
+// zipEntryPath 校验 zip 条目名并返回以 "/" 分隔的安全相对路径。
+func zipEntryPath(name string) (string, error) {
+	normalized := strings.ReplaceAll(name, `\`, "/")
+	if normalized == "" || strings.ContainsRune(normalized, 0) {
+		return "", &ImportError{Reason: ImportReasonUnsafePath, Message: fmt.Sprintf("zip 文件中包含非法路径: %q", name)}
+	}
+	if strings.HasPrefix(normalized, "/") || (len(normalized) >= 2 && normalized[1] == ':') {
+		return "", &ImportError{Reason: ImportReasonAbsolutePath, Message: fmt.Sprintf("zip 文件中包含绝对路径: %q", name)}
+	}
+	for _, segment := range strings.Split(normalized, "/") {
+		if segment == ".." {
+			return "", &ImportError{Reason: ImportReasonPathTraversal, Message: fmt.Sprintf("zip 文件中包含越界路径: %q", name)}
+		}
+	}
+	cleaned := path.Clean(normalized)
+	if cleaned == "." || !filepath.IsLocal(filepath.FromSlash(cleaned)) {
+		return "", &ImportError{Reason: ImportReasonUnsafePath, Message: fmt.Sprintf("zip 文件中包含非法路径: %q", name)}
+	}
+	return cleaned, nil
+}
+
+// checkZipEntries 在写入任何文件之前, 依据 zip 目录中的声明信息做整体校验。
+func checkZipEntries(files []*zip.File, archiveSize int64, limits ExtractLimits) error {
+	if limits.MaxFiles > 0 && len(files) > limits.MaxFiles {
+		return &ImportError{Reason: ImportReasonTooManyFiles, Message: fmt.Sprintf("zip 文件条目过多: %d (上限 %d)", len(files), limits.MaxFiles)}
+	}
+
+	var totalSize uint64
+	for _, file := range files {
+		if _, err := zipEntryPath(file.Name); err != nil {
+			return err
+		}
+
+		mode := file.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return &ImportError{Reason: ImportReasonSymlink, Message: fmt.Sprintf("zip 文件中包含符号链接: %q", file.Name)}
+		}
+		if !mode.IsRegular() && !mode.IsDir() {
+			return &ImportError{Reason: ImportReasonSpecialFile, Message: fmt.Sprintf("zip 文件中包含不支持的文件类型: %q", file.Name)}
+		}
+
+		if limits.MaxCompressionRatio > 0 && file.UncompressedSize64 > ratioCheckMinSize &&
+			float64(file.UncompressedSize64) > float64(max(file.CompressedSize64, 1))*limits.MaxCompressionRatio {
+			return &ImportError{Reason: ImportReasonCompressionRatio, Message: fmt.Sprintf("zip 文件压缩比异常: %q", file.Name)}
+		}
+
+		totalSize += file.UncompressedSize64
+		if limits.MaxTotalSize > 0 && totalSize > uint64(limits.MaxTotalSize) {
+			return &ImportError{Reason: ImportReasonTooLarge, Message: fmt.Sprintf("zip 文件解压后过大 (上限 %d 字节)", limits.MaxTotalSize)}
+		}
+	}
+
+	if limits.MaxCompressionRatio > 0 && archiveSize > 0 && totalSize > ratioCheckMinSize &&
+		float64(totalSize) > float64(archiveSize)*limits.MaxCompressionRatio {
+		return &ImportError{Reason: ImportReasonCompressionRatio, Message: "zip 文件整体压缩比异常"}
+	}
+	return nil
+}
+
+// ExtractZipReader 将 zipReader 中的条目安全地解压到 destDir。
+// archiveSize 为 zip 文件自身大小, 用于整体压缩比检查。
+func ExtractZipReader(zipReader *zip.Reader, archiveSize int64, destDir string, limits ExtractLimits) error {
+	if err := checkZipEntries(zipReader.File, archiveSize, limits); err != nil {
+		return err
+	}
+
+	destDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	for _, file := range zipReader.File {
+		entryPath, _ := zipEntryPath(file.Name)
+		targetPath := filepath.Join(destDir, filepath.FromSlash(entryPath))
+		if relPath, err := filepath.Rel(destDir, targetPath); err != nil || !filepath.IsLocal(relPath) {
+			return &ImportError{Reason: ImportReasonPathTraversal, Message: fmt.Sprintf("zip 文件中包含越界路径: %q", file.Name)}
+		}
+
+		if file.Mode().IsDir() {
+			if err := os.MkdirAll(targetPath, 0755); err != nil {
+				return fmt.Errorf("创建目录失败: %v", err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+			return fmt.Errorf("创建目录失败: %v", err)
+		}
+
+		outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return &ImportError{Reason: ImportReasonDuplicateEntry, Message: fmt.Sprintf("zip 文件中包含重复条目: %q", file.Name)}
+			}
+			return fmt.Errorf("创建目标文件失败: %v", err)
+		}
+
+		inFile, err := file.Open()
+		if err != nil {
+			outFile.Close()
+			return &ImportError{Reason: ImportReasonInvalidArchive, Message: fmt.Sprintf("打开源文件失败: %v", err)}
+		}
+
+		var src io.Reader = inFile
+		if limits.MaxTotalSize > 0 {
+			src = io.LimitReader(src, limits.MaxTotalSize-written+1)
+		}
+		n, err := io.Copy(outFile, src)
+		outFile.Close()
+		inFile.Close()
+		written += n
+		if err != nil {
+			if errors.Is(err, zip.ErrFormat) || errors.Is(err, zip.ErrChecksum) {
+				return &ImportError{Reason: ImportReasonInvalidArchive, Message: fmt.Sprintf("zip 文件已损坏: %q", file.Name)}
+			}
+			return fmt.Errorf("复制文件内容失败: %v", err)
+		}
+		if limits.MaxTotalSize > 0 && written > limits.MaxTotalSize {
+			return &ImportError{Reason: ImportReasonTooLarge, Message: fmt.Sprintf("zip 文件解压后过大 (上限 %d 字节)", limits.MaxTotalSize)}
+		}
+	}
+	return nil
+}
+
+// ExtractZipSafely 打开 zipPath 并安全地解压到 destDir。
+func ExtractZipSafely(zipPath string, destDir string, limits ExtractLimits) error {
+	info, err := os.Stat(zipPath)
+	if err != nil {
+		return err
+	}
+	zipReader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		if errors.Is(err, zip.ErrInsecurePath) {
+			if zipReader != nil {
+				zipReader.Close()
+			}
+			return &ImportError{Reason: ImportReasonUnsafePath, Message: "zip 文件中包含非法路径"}
+		}
+		return &ImportError{Reason: ImportReasonInvalidArchive, Message: fmt.Sprintf("打开zip文件失败: %v", err)}
+	}
+	defer zipReader.Close()
+	return ExtractZipReader(&zipReader.Reader, info.Size(), destDir, limits)
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testZipEntry struct {
+	Name string
+	Body []byte
+	Mode os.FileMode
+	// Raw 为 true 时按原样写入 Body(已压缩数据), 并使用 DeclaredSize 作为声明的解压大小
+	Raw          bool
+	DeclaredSize uint64
+}
+
+func buildTestZip(t testing.TB, entries ...testZipEntry) []byte {
+	t.Helper()
+	buffer := new(bytes.Buffer)
+	writer := zip.NewWriter(buffer)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+		if entry.Mode != 0 {
+			header.SetMode(entry.Mode)
+		}
+		if entry.Raw {
+			compressed := new(bytes.Buffer)
+			flateWriter, _ := flate.NewWriter(compressed, flate.BestCompression)
+			flateWriter.Write(entry.Body)
+			flateWriter.Close()
+			header.CRC32 = crc32.ChecksumIEEE(entry.Body)
+			header.CompressedSize64 = uint64(compressed.Len())
+			header.UncompressedSize64 = entry.DeclaredSize
+			raw, err := writer.CreateRaw(header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw.Write(compressed.Bytes())
+			continue
+		}
+		file, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(entry.Body)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// testExtractLimits 为测试使用的解压限制(比默认值小, 便于构造超限用例)。
+var testExtractLimits = ExtractLimits{MaxTotalSize: 32 << 20, MaxFiles: 64, MaxCompressionRatio: 200}
+
+type maliciousArchive struct {
+	name   string
+	data   []byte
+	limits ExtractLimits
+	reason ImportErrorReason
+}
+
+// maliciousArchives 为恶意 zip 语料, 同时用作模糊测试的种子。
+func maliciousArchives(t testing.TB) []maliciousArchive {
+	manyFiles := make([]testZipEntry, 0, testExtractLimits.MaxFiles+1)
+	for i := 0; i <= testExtractLimits.MaxFiles; i++ {
+		manyFiles = append(manyFiles, testZipEntry{Name: "album/" + strings.Repeat("f", i+1)})
+	}
+	return []maliciousArchive{
+		{"parent traversal", buildTestZip(t, testZipEntry{Name: "../evil.txt", Body: []byte("x")}), testExtractLimits, ImportReasonPathTraversal},
+		{"nested traversal", buildTestZip(t, testZipEntry{Name: "album/../../evil.txt", Body: []byte("x")}), testExtractLimits, ImportReasonPathTraversal},
+		{"backslash traversal", buildTestZip(t, testZipEntry{Name: `album\..\..\evil.txt`, Body: []byte("x")}), testExtractLimits, ImportReasonPathTraversal},
+		{"absolute path", buildTestZip(t, testZipEntry{Name: "/tmp/evil.txt", Body: []byte("x")}), testExtractLimits, ImportReasonAbsolutePath},
+		{"windows drive", buildTestZip(t, testZipEntry{Name: `C:\Windows\evil.txt`, Body: []byte("x")}), testExtractLimits, ImportReasonAbsolutePath},
+		{"unc path", buildTestZip(t, testZipEntry{Name: `\\server\share\evil.txt`, Body: []byte("x")}), testExtractLimits, ImportReasonAbsolutePath},
+		{"symlink", buildTestZip(t, testZipEntry{Name: "album/link", Body: []byte("/etc/passwd"), Mode: os.ModeSymlink | 0777}), testExtractLimits, ImportReasonSymlink},
+		{"named pipe", buildTestZip(t, testZipEntry{Name: "album/pipe", Mode: os.ModeNamedPipe | 0644}), testExtractLimits, ImportReasonSpecialFile},
+		{"duplicate entry", buildTestZip(t, testZipEntry{Name: "album/a.txt", Body: []byte("1")}, testZipEntry{Name: "album/a.txt", Body: []byte("2")}), testExtractLimits, ImportReasonDuplicateEntry},
+		{"too many files", buildTestZip(t, manyFiles...), testExtractLimits, ImportReasonTooManyFiles},
+		{"too large", buildTestZip(t, testZipEntry{Name: "album/a.wav", Body: bytes.Repeat([]byte{1, 2, 3, 4}, 1024)}), ExtractLimits{MaxTotalSize: 1024}, ImportReasonTooLarge},
+		{"zip bomb", buildTestZip(t, testZipEntry{Name: "album/bomb.wav", Body: make([]byte, 8<<20)}), testExtractLimits, ImportReasonCompressionRatio},
+		{"understated size", buildTestZip(t, testZipEntry{Name: "album/a.wav", Body: bytes.Repeat([]byte("abc"), 4096), Raw: true, DeclaredSize: 16}), testExtractLimits, ImportReasonInvalidArchive},
+	}
+}
+
+// assertNothingOutside 确认 root 下除 dest 目录外没有任何文件被创建, 且没有创建符号链接。
+func assertNothingOutside(t testing.TB, root, dest string) {
+	t.Helper()
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			t.Fatalf("symlink created: %s", path)
+		}
+		if relPath, _ := filepath.Rel(dest, path); path != dest && !filepath.IsLocal(relPath) {
+			t.Fatalf("file written outside destination: %s", path)
+		}
+		return nil
+	})
+}
+
+func TestExtractZipReaderRejectsMaliciousArchives(t *testing.T) {
+	for _, archive := range maliciousArchives(t) {
+		root := t.TempDir()
+		dest := filepath.Join(root, "dest")
+		os.Mkdir(dest, 0755)
+
+		zipReader, err := zip.NewReader(bytes.NewReader(archive.data), int64(len(archive.data)))
+		if err != nil {
+			t.Fatalf("%s: invalid test archive: %v", archive.name, err)
+		}
+		err = ExtractZipReader(zipReader, int64(len(archive.data)), dest, archive.limits)
+		var importErr *ImportError
+		if !errors.As(err, &importErr) || importErr.Reason != archive.reason {
+			t.Fatalf("%s: expected reason %s, got %v", archive.name, archive.reason, err)
+		}
+		assertNothingOutside(t, root, dest)
+	}
+}
+
+func TestExtractZipReaderExtractsValidAlbum(t *testing.T) {
+	data := buildTestZip(t,
+		testZipEntry{Name: ".keytone-album", Body: []byte(`{"magicNumber":"KTAF"}`)},
+		testZipEntry{Name: "album/", Mode: os.ModeDir | 0755},
+		testZipEntry{Name: "album/package.json", Body: []byte(`{}`)},
+		testZipEntry{Name: `album\audioFiles\a.wav`, Body: bytes.Repeat([]byte{1, 2, 3}, 1000)},
+	)
+	zipReader, _ := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	dest := t.TempDir()
+	if err := ExtractZipReader(zipReader, int64(len(data)), dest, testExtractLimits); err != nil {
+		t.Fatalf("ExtractZipReader returned error: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(dest, "album", "audioFiles", "a.wav")); err != nil || len(content) != 3000 {
+		t.Fatalf("expected backslash entry extracted under album/audioFiles, err=%v", err)
+	}
+}
+
+// FuzzExtractZipReader 以恶意 zip 语料为种子, 验证任意输入都不会在目标目录之外写入文件。
+func FuzzExtractZipReader(f *testing.F) {
+	for _, archive := range maliciousArchives(f) {
+		f.Add(archive.data)
+	}
+	f.Add(buildTestZip(f, testZipEntry{Name: "album/package.json", Body: []byte(`{}`)}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		zipReader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		root := t.TempDir()
+		dest := filepath.Join(root, "dest")
+		os.Mkdir(dest, 0755)
+		ExtractZipReader(zipReader, int64(len(data)), dest, testExtractLimits)
+		assertNothingOutside(t, root, dest)
+	})
+}